@@ -6,9 +6,7 @@ import (
 	"log"
 	"log/slog"
 	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
+	"strings"
 	"time"
 
 	"github.com/Neruzzz/acai-travel-challenge/internal/chat"
@@ -19,27 +17,62 @@ import (
 	"github.com/Neruzzz/acai-travel-challenge/internal/pb"
 	"github.com/gorilla/mux"
 	"github.com/twitchtv/twirp"
+	"google.golang.org/protobuf/reflect/protoreflect"
 
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
+// Set at build time with -ldflags "-X main.version=...", and otherwise read
+// from what Go embeds.
+var version, commit, date string
+
 func main() {
 	ctx := context.Background()
+	httpx.SetBuildInfo(version, commit, date)
+
+	cfg := httpx.DefaultConfig("acai-server")
+	// Only these baggage members from callers are kept and forwarded, unless
+	// BAGGAGE_KEYS lists others.
+	cfg.BaggageKeys = []string{"tenant_id"}
+	if err := cfg.LoadFromEnv(); err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
 
-	shutdown, err := httpx.InitTelemetry(ctx, "acai-server")
+	snapshot := httpx.NewMetricsSnapshot()
+	telemetryOpts := []httpx.TelemetryOption{httpx.WithMetricsSnapshot(snapshot)}
+	// In development, DEBUG_TRACES=true keeps recent traces for the admin
+	// server to show without a collector.
+	var traces *httpx.TraceBuffer
+	if cfg.DebugTraces {
+		traces = httpx.NewTraceBuffer(0)
+		telemetryOpts = append(telemetryOpts, httpx.WithTraceBuffer(traces))
+	}
+	shutdown, err := httpx.InitTelemetryFromConfig(ctx, cfg, telemetryOpts...)
 	if err != nil {
 		log.Fatalf("telemetry init error: %v", err)
 	}
-	defer func() { _ = shutdown(context.Background()) }()
 
 	mongo := mongox.MustConnect()
 	repo := model.New(mongo)
 	assist := assistant.New()
 	server := chat.NewServer(repo, assist)
 
+	health := httpx.NewHealth()
+	health.Register("mongo", func(ctx context.Context) error {
+		return mongo.Client().Ping(ctx, nil)
+	})
+
+	accessLogOpts := []httpx.AccessLogOption{
+		httpx.WithAccessLogIgnoredPaths(cfg.IgnoredPaths...),
+		httpx.WithAccessLogSlowThreshold(cfg.SlowThreshold),
+	}
+	if cfg.AccessLogSample < 1 {
+		accessLogOpts = append(accessLogOpts, httpx.WithAccessLogSampling(cfg.AccessLogSample))
+	}
+
 	r := mux.NewRouter()
 	r.Use(
-		httpx.Logger(),
+		httpx.AccessLogMiddleware(nil, accessLogOpts...),
 		httpx.Recovery(),
 	)
 
@@ -47,30 +80,87 @@ func main() {
 		_, _ = fmt.Fprint(w, "Hi, my name is Clippy!")
 	})
 
+	// The detector runs inside the server span, to flag it and log its trace.
+	slow := httpx.NewSlowRequestDetector(cfg.SlowThreshold)
+
 	twirpHandler := pb.NewChatServiceServer(server, twirp.WithServerJSONSkipDefaults(true))
 	instrumentedTwirp := otelhttp.NewHandler(
-		httpx.MetricsMiddleware(twirpHandler),
+		httpx.ChainNamedTiming(cfg.MiddlewareTiming,
+			httpx.Named("trace_header", httpx.TraceHeaderMiddleware),
+			httpx.Named("slow", slow.Middleware),
+			httpx.Named("baggage", httpx.BaggageMiddleware(cfg.BaggageKeys...)),
+			httpx.Named("metrics", httpx.NewMetricsMiddleware(
+				httpx.WithRouteResolver(twirpRoute),
+				httpx.WithIgnoredPaths(cfg.IgnoredPaths...),
+				httpx.WithApdex(cfg.ApdexTarget),
+				httpx.WithClientKinds(httpx.DefaultClientRules()...),
+			)),
+		)(twirpHandler),
 		"twirp.chatservice",
 	)
 	r.PathPrefix("/twirp/").Handler(instrumentedTwirp)
 
-	httpServer := &http.Server{
-		Addr:    ":8080",
-		Handler: r,
+	maintenance := httpx.NewMaintenance()
+	middlewares := []httpx.NamedMiddleware{
+		httpx.Named("secure_headers", httpx.SecureHeadersMiddleware(httpx.WithHSTSBehindProxy(cfg.TrustedProxies...))),
+		httpx.Named("client_ip", httpx.ClientIPMiddleware(cfg.TrustedProxies...)),
+	}
+	// Any Host is served unless ALLOWED_HOSTS lists them.
+	if len(cfg.AllowedHosts) > 0 {
+		middlewares = append(middlewares, httpx.Named("allowed_hosts", httpx.AllowedHostsMiddleware(cfg.AllowedHosts)))
 	}
+	middlewares = append(middlewares,
+		httpx.Named("maintenance", maintenance.Middleware),
+		httpx.Named("debug_trace", httpx.DebugTraceMiddleware(httpx.DebugTraceSecret(cfg.DebugSecret))),
+	)
+	// With CAPTURE_FILE, traffic can be captured there from the admin server.
+	var capture *httpx.Capture
+	if cfg.CaptureFile != "" {
+		capture = httpx.NewCapture(cfg.CaptureFile)
+		middlewares = append(middlewares, httpx.Named("capture", capture.Middleware))
+	}
+	// MIDDLEWARE_TIMING times them, and the Twirp ones above.
+	handler := httpx.ChainNamedTiming(cfg.MiddlewareTiming, middlewares...)(r)
+
+	adminOpts := []httpx.AdminOption{
+		httpx.WithAdminHealth(health),
+		httpx.WithAdminMaintenance(maintenance),
+		httpx.WithAdminSlowRequests(slow),
+		httpx.WithAdminMetricsSnapshot(snapshot),
+		httpx.WithAdminTelemetryControls(cfg.AdminToken),
+		httpx.WithPprof(cfg.AdminPprof),
+	}
+	if capture != nil {
+		adminOpts = append(adminOpts, httpx.WithAdminCapture(capture))
+	}
+	if traces != nil {
+		adminOpts = append(adminOpts, httpx.WithAdminTraces(traces))
+	}
+	admin := httpx.AdminServer(cfg.AdminAddr, adminOpts...)
 
 	slog.Info("Starting the server...")
-	go func() {
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("http server error: %v", err)
-		}
-	}()
-
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
-	<-stop
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	_ = httpServer.Shutdown(ctx)
+	// The HTTP_*_TIMEOUT settings bound client connections, TLS_CERT_FILE and
+	// the other TLS settings make the API HTTPS, and HTTP_H2C serves it over
+	// cleartext HTTP/2 too.
+	runOpts := append(cfg.RunOptions(),
+		httpx.WithGracePeriod(5*time.Second),
+		httpx.WithTelemetryShutdown(httpx.CombineShutdowns(mongo.Client().Disconnect, shutdown)),
+		httpx.WithHealth(health),
+		httpx.WithAdmin(admin),
+	)
+	if err := httpx.Run(ctx, cfg.ListenAddr, handler, runOpts...); err != nil {
+		log.Fatalf("http server error: %v", err)
+	}
+}
+
+var chatMethods = pb.File_rpc_chat_proto.Services().ByName("ChatService").Methods()
+
+// twirpRoute labels Twirp requests by method path, collapsing unknown paths
+// so they don't create new metric series.
+func twirpRoute(r *http.Request) string {
+	method := strings.TrimPrefix(r.URL.Path, pb.ChatServicePathPrefix)
+	if method == r.URL.Path || chatMethods.ByName(protoreflect.Name(method)) == nil {
+		return httpx.UnmatchedRoute
+	}
+	return r.URL.Path
 }
@@ -0,0 +1,187 @@
+package httpx
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"slices"
+)
+
+// AdminOption configures AdminServer.
+type AdminOption func(*Admin)
+
+// Admin is a second listener for operational endpoints, kept off the public
+// port. Pass it to Run with WithAdmin.
+type Admin struct {
+	addr     string
+	health   *Health
+	maint    *Maintenance
+	tenants  *TrackedTenants
+	slow     *SlowRequestDetector
+	capture  *Capture
+	token    string
+	metrics  http.Handler
+	snapshot *MetricsSnapshot
+	traces   *TraceBuffer
+	pprof    bool
+	routes   []adminRoute
+	onListen func(net.Addr)
+
+	listeners []net.Listener
+}
+
+type adminRoute struct {
+	pattern string
+	handler http.Handler
+}
+
+// WithAdminHealth serves h's liveness and readiness at /healthz and /readyz.
+func WithAdminHealth(h *Health) AdminOption {
+	return func(a *Admin) { a.health = h }
+}
+
+// WithAdminMaintenance serves m's Handler at /maintenance, to toggle
+// maintenance mode with PUT and DELETE.
+func WithAdminMaintenance(m *Maintenance) AdminOption {
+	return func(a *Admin) { a.maint = m }
+}
+
+// WithAdminTenants serves t's Handler at /tenants, to change the tenants
+// tracked in metrics.
+func WithAdminTenants(t *TrackedTenants) AdminOption {
+	return func(a *Admin) { a.tenants = t }
+}
+
+// WithAdminSlowRequests serves d's Handler at /slow, to change the slow
+// request threshold.
+func WithAdminSlowRequests(d *SlowRequestDetector) AdminOption {
+	return func(a *Admin) { a.slow = d }
+}
+
+// WithAdminCapture serves c's Handler at /capture, to capture traffic for a
+// while. Like the telemetry controls, it is only served with a token.
+func WithAdminCapture(c *Capture) AdminOption {
+	return func(a *Admin) { a.capture = c }
+}
+
+// WithAdminTelemetryControls serves the log level at /loglevel and the trace
+// sample ratio at /sampling, to change them with PUT, optionally for a while
+// only. Requests must bear token in an Authorization header; without a token
+// the endpoints are not served.
+func WithAdminTelemetryControls(token string) AdminOption {
+	return func(a *Admin) { a.token = token }
+}
+
+// WithAdminMetrics serves handler, such as the one from InitPrometheus, at
+// /metrics.
+func WithAdminMetrics(handler http.Handler) AdminOption {
+	return func(a *Admin) { a.metrics = handler }
+}
+
+// WithAdminMetricsSnapshot serves the metrics of s as JSON at
+// /debug/metrics.
+func WithAdminMetricsSnapshot(s *MetricsSnapshot) AdminOption {
+	return func(a *Admin) { a.snapshot = s }
+}
+
+// WithAdminTraces serves the traces kept by b at /debug/traces.
+func WithAdminTraces(b *TraceBuffer) AdminOption {
+	return func(a *Admin) { a.traces = b }
+}
+
+// WithPprof enables or disables the net/http/pprof endpoints under
+// /debug/pprof/. They are enabled by default.
+func WithPprof(enabled bool) AdminOption {
+	return func(a *Admin) { a.pprof = enabled }
+}
+
+// WithAdminHandler serves handler at pattern, for other debug endpoints.
+func WithAdminHandler(pattern string, handler http.Handler) AdminOption {
+	return func(a *Admin) { a.routes = append(a.routes, adminRoute{pattern, handler}) }
+}
+
+// WithAdminOnListen calls fn with the address the admin server bound.
+func WithAdminOnListen(fn func(net.Addr)) AdminOption {
+	return func(a *Admin) { a.onListen = fn }
+}
+
+// WithAdminListener serves the admin endpoints on ln too, or on ln alone
+// when the address given to AdminServer is empty. Run closes it on shutdown.
+func WithAdminListener(ln net.Listener) AdminOption {
+	return func(a *Admin) { a.listeners = append(a.listeners, ln) }
+}
+
+// AdminServer returns an admin server for addr serving health checks,
+// metrics, pprof, the build running at /version and an index of its
+// endpoints at /.
+func AdminServer(addr string, opts ...AdminOption) *Admin {
+	a := &Admin{addr: addr, pprof: true}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Handler returns the admin endpoints.
+func (a *Admin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	var paths []string
+	handle := func(pattern, path string, h http.Handler) {
+		mux.Handle(pattern, h)
+		paths = append(paths, path)
+	}
+
+	handle("GET /version", "/version", VersionHandler())
+	if a.health != nil {
+		handle("GET /healthz", "/healthz", a.health.LivenessHandler())
+		handle("GET /readyz", "/readyz", a.health.ReadinessHandler())
+	}
+	if a.maint != nil {
+		handle("/maintenance", "/maintenance", a.maint.Handler())
+	}
+	if a.tenants != nil {
+		handle("/tenants", "/tenants", a.tenants.Handler())
+	}
+	if a.slow != nil {
+		handle("/slow", "/slow", a.slow.Handler())
+	}
+	if a.token != "" {
+		auth := AuthMiddleware(tokenAuthenticator(a.token))
+		handle("/loglevel", "/loglevel", auth(logLevelHandler()))
+		handle("/sampling", "/sampling", auth(sampleRatioHandler()))
+		if a.capture != nil {
+			handle("/capture", "/capture", auth(a.capture.Handler()))
+		}
+	}
+	if a.metrics != nil {
+		handle("GET /metrics", "/metrics", a.metrics)
+	}
+	if a.snapshot != nil {
+		handle("GET /debug/metrics", "/debug/metrics", a.snapshot.Handler())
+	}
+	if a.traces != nil {
+		h := a.traces.Handler()
+		handle("GET /debug/traces", "/debug/traces", h)
+		mux.Handle("GET /debug/traces/{id}", h)
+	}
+	if a.pprof {
+		handle("/debug/pprof/", "/debug/pprof/", http.HandlerFunc(pprof.Index))
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	for _, r := range a.routes {
+		handle(r.pattern, routeFromPattern(r.pattern), r.handler)
+	}
+
+	slices.Sort(paths)
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, p := range paths {
+			_, _ = fmt.Fprintln(w, p)
+		}
+	})
+	return mux
+}
@@ -0,0 +1,116 @@
+package httpx
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun_Admin(t *testing.T) {
+	tests := []struct {
+		name      string
+		pprof     bool
+		wantPprof int
+	}{
+		{name: "with pprof", pprof: true, wantPprof: http.StatusOK},
+		{name: "without pprof", pprof: false, wantPprof: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			health := NewHealth()
+			metrics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, "http_server_requests_total 1\n")
+			})
+			app := http.NewServeMux()
+			app.HandleFunc("GET /trips", func(w http.ResponseWriter, r *http.Request) {})
+
+			mainAddr, adminAddr := make(chan string, 1), make(chan string, 1)
+			runErr := make(chan error, 1)
+			go func() {
+				runErr <- Run(ctx, "127.0.0.1:0", app,
+					WithHealth(health),
+					WithOnListen(func(a net.Addr) { mainAddr <- a.String() }),
+					WithAdmin(AdminServer("127.0.0.1:0",
+						WithAdminHealth(health),
+						WithAdminMetrics(metrics),
+						WithPprof(tt.pprof),
+						WithAdminOnListen(func(a net.Addr) { adminAddr <- a.String() }),
+					)),
+				)
+			}()
+			public, admin := <-mainAddr, <-adminAddr
+
+			get := func(addr, path string) (int, string) {
+				t.Helper()
+				resp, err := http.Get("http://" + addr + path)
+				if err != nil {
+					t.Fatalf("GET %s%s: %v", addr, path, err)
+				}
+				defer resp.Body.Close()
+				body, _ := io.ReadAll(resp.Body)
+				return resp.StatusCode, string(body)
+			}
+
+			for path, want := range map[string]int{
+				"/healthz":      http.StatusOK,
+				"/readyz":       http.StatusOK,
+				"/metrics":      http.StatusOK,
+				"/debug/pprof/": tt.wantPprof,
+			} {
+				if got, _ := get(admin, path); got != want {
+					t.Errorf("admin %s: got %d, want %d", path, got, want)
+				}
+				if got, _ := get(public, path); got != http.StatusNotFound {
+					t.Errorf("admin endpoint %s leaked onto the main port: %d", path, got)
+				}
+			}
+			if got, _ := get(admin, "/trips"); got != http.StatusNotFound {
+				t.Errorf("main route served on the admin port: %d", got)
+			}
+			_, index := get(admin, "/")
+			if !strings.Contains(index, "/metrics") || strings.Contains(index, "/debug/pprof/") != tt.pprof {
+				t.Errorf("unexpected index:\n%s", index)
+			}
+
+			cancel()
+			if err := <-runErr; err != nil {
+				t.Fatalf("Run() unexpected error: %v", err)
+			}
+			for _, addr := range []string{public, admin} {
+				if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+					conn.Close()
+					t.Errorf("%s still accepting connections after Run returned", addr)
+				}
+			}
+		})
+	}
+}
+
+func TestRun_AdminBindFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() unexpected error: %v", err)
+	}
+	defer ln.Close()
+
+	var mainAddr string
+	err = Run(context.Background(), "127.0.0.1:0", http.NotFoundHandler(),
+		WithOnListen(func(a net.Addr) { mainAddr = a.String() }),
+		WithAdmin(AdminServer(ln.Addr().String())),
+	)
+	if err == nil {
+		t.Fatal("Run() expected an error for an admin address in use")
+	}
+	if conn, err := net.DialTimeout("tcp", mainAddr, time.Second); err == nil {
+		conn.Close()
+		t.Error("main listener left open after the admin bind failure")
+	}
+}
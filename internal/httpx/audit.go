@@ -0,0 +1,173 @@
+package httpx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// AuditRecord describes one audited request.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal,omitempty"`
+	Method    string    `json:"method"`
+	Route     string    `json:"route"`
+	Status    int       `json:"status"`
+	RequestID string    `json:"request_id,omitempty"`
+	TraceID   string    `json:"trace_id,omitempty"`
+	// BodySHA256 is the hex SHA-256 of the BodyBytes bytes of the request
+	// body the handler read.
+	BodySHA256 string `json:"body_sha256"`
+	BodyBytes  int64  `json:"body_bytes"`
+}
+
+// AuditSink stores audit records, e.g. in a log, a file or a remote service.
+type AuditSink interface {
+	Audit(ctx context.Context, rec AuditRecord) error
+}
+
+// AuditSinkFunc adapts a function to AuditSink.
+type AuditSinkFunc func(ctx context.Context, rec AuditRecord) error
+
+func (f AuditSinkFunc) Audit(ctx context.Context, rec AuditRecord) error { return f(ctx, rec) }
+
+// SlogAuditSink writes audit records as "HTTP audit" lines to logger, or
+// slog.Default() when nil.
+func SlogAuditSink(logger *slog.Logger) AuditSink {
+	return AuditSinkFunc(func(ctx context.Context, rec AuditRecord) error {
+		l := logger
+		if l == nil {
+			l = slog.Default()
+		}
+		l.LogAttrs(ctx, slog.LevelInfo, "HTTP audit",
+			slog.Time("time", rec.Time),
+			slog.String("principal", rec.Principal),
+			slog.String("http_method", rec.Method),
+			slog.String("http_route", rec.Route),
+			slog.Int("http_status", rec.Status),
+			slog.String("request_id", rec.RequestID),
+			slog.String("trace_id", rec.TraceID),
+			slog.String("body_sha256", rec.BodySHA256),
+			slog.Int64("body_bytes", rec.BodyBytes),
+		)
+		return nil
+	})
+}
+
+// AuditOption configures AuditMiddleware.
+type AuditOption func(*auditConfig)
+
+type auditConfig struct {
+	methods []string
+}
+
+// WithAuditedMethods sets the methods audited. Defaults to POST, PUT, PATCH
+// and DELETE.
+func WithAuditedMethods(methods ...string) AuditOption {
+	return func(c *auditConfig) { c.methods = methods }
+}
+
+// AuditMiddleware records every mutating request in sink once the handler
+// has returned, panicking handlers included: who sent it, to which route,
+// when, the resulting status and a hash of the body the handler read, which
+// the handler still gets whole. Sink failures are logged and counted in
+// http.server.audit.failures; they never fail the request. The sink is
+// called before the response completes, so it should be fast.
+func AuditMiddleware(sink AuditSink, opts ...AuditOption) func(http.Handler) http.Handler {
+	cfg := auditConfig{methods: []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !slices.Contains(cfg.methods, r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			sw, w := captureStatus(w)
+			r, st := withRequestState(r)
+			body := &hashingBody{hash: sha256.New()}
+			if r.Body != nil {
+				body.ReadCloser = r.Body
+				r.Body = body
+			}
+
+			completed := false
+			defer func() {
+				if completed {
+					return
+				}
+				v := recover()
+				if v != nil && v != http.ErrAbortHandler {
+					st.notePanic(v)
+				}
+				audit(sink, r, st, sw, start, body)
+				if v != nil {
+					panic(v)
+				}
+			}()
+
+			next.ServeHTTP(w, r)
+			completed = true
+			audit(sink, r, st, sw, start, body)
+		})
+	}
+}
+
+func audit(sink AuditSink, r *http.Request, st *requestState, sw *statusCapturingWriter, start time.Time, body *hashingBody) {
+	st.notePattern(r)
+	ctx := r.Context()
+
+	status := sw.status
+	if _, _, panicked := st.panicInfo(); panicked && !sw.wroteHeader {
+		status = http.StatusInternalServerError
+	}
+	principal, _ := PrincipalFromContext(ctx)
+	rec := AuditRecord{
+		Time:       start.UTC(),
+		Principal:  principal.ID,
+		Method:     r.Method,
+		Route:      PatternRoute(r),
+		Status:     status,
+		RequestID:  RequestIDFromContext(ctx),
+		BodySHA256: hex.EncodeToString(body.hash.Sum(nil)),
+		BodyBytes:  body.n,
+	}
+	if sc := st.serverSpanContext(ctx); sc.IsValid() {
+		rec.TraceID = sc.TraceID().String()
+	}
+
+	if err := sink.Audit(ctx, rec); err != nil {
+		slog.ErrorContext(ctx, "Failed to record audit entry", "error", err, "request_id", rec.RequestID)
+		loadServerMetrics().auditFailures.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("http.method", metricMethod(r.Method)),
+			attribute.String("http.route", rec.Route),
+		))
+	}
+}
+
+// hashingBody hashes the request body as the handler reads it.
+type hashingBody struct {
+	io.ReadCloser
+	hash hash.Hash
+	n    int64
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	b.n += int64(n)
+	return n, err
+}
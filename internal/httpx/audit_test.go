@@ -0,0 +1,113 @@
+package httpx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestAuditMiddleware(t *testing.T) {
+	otelt.Install(t)
+
+	var records []AuditRecord
+	sink := AuditSinkFunc(func(ctx context.Context, rec AuditRecord) error {
+		records = append(records, rec)
+		return nil
+	})
+
+	var got string
+	rt := NewRouter()
+	rt.HandleFunc("POST /bookings", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+		w.WriteHeader(http.StatusCreated)
+	})
+	rt.HandleFunc("GET /bookings", func(w http.ResponseWriter, r *http.Request) {})
+	authenticate := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(ContextWithPrincipal(r.Context(), Principal{ID: "agent-7"})))
+		})
+	}
+	handler := Chain(RequestIDMiddleware, TracingMiddleware, authenticate, AuditMiddleware(sink))(rt)
+
+	const body = `{"flight":"AC123"}`
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/bookings", strings.NewReader(body)))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/bookings", nil))
+
+	if got != body {
+		t.Errorf("handler read %q, want %q", got, body)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d audit records, want 1 for the POST only", len(records))
+	}
+	rec := records[0]
+	sum := sha256.Sum256([]byte(body))
+	if rec.Principal != "agent-7" || rec.Method != http.MethodPost || rec.Route != "/bookings" || rec.Status != http.StatusCreated {
+		t.Errorf("record = %+v, want agent-7 POST /bookings 201", rec)
+	}
+	if rec.BodySHA256 != hex.EncodeToString(sum[:]) || rec.BodyBytes != int64(len(body)) {
+		t.Errorf("body hash %s over %d bytes, want the hash of %q", rec.BodySHA256, rec.BodyBytes, body)
+	}
+	if rec.RequestID == "" || rec.TraceID == "" || rec.Time.IsZero() {
+		t.Errorf("record = %+v, want request ID, trace ID and time", rec)
+	}
+}
+
+func TestAuditMiddleware_AuditedMethods(t *testing.T) {
+	var methods []string
+	sink := AuditSinkFunc(func(ctx context.Context, rec AuditRecord) error {
+		methods = append(methods, rec.Method)
+		return nil
+	})
+	handler := AuditMiddleware(sink, WithAuditedMethods(http.MethodGet))(http.NotFoundHandler())
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/", nil))
+	}
+	if len(methods) != 1 || methods[0] != http.MethodGet {
+		t.Errorf("audited %v, want only GET", methods)
+	}
+}
+
+func TestAuditMiddleware_SinkFailure(t *testing.T) {
+	otelt.InstallMetrics(t)
+	captureLogs(t)
+
+	sink := AuditSinkFunc(func(ctx context.Context, rec AuditRecord) error {
+		return errors.New("audit store unavailable")
+	})
+	handler := AuditMiddleware(sink)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/bookings/1", nil))
+
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("status = %d, want %d despite the sink failure", rec.Code, http.StatusAccepted)
+	}
+	otelt.RequireCounterValue(t, "http.server.audit.failures", []attribute.KeyValue{attribute.String("http.method", http.MethodDelete)}, 1)
+}
+
+func TestAuditMiddleware_Panic(t *testing.T) {
+	captureLogs(t)
+
+	var status int
+	sink := AuditSinkFunc(func(ctx context.Context, rec AuditRecord) error {
+		status = rec.Status
+		return nil
+	})
+	handler := RecoverMiddleware(AuditMiddleware(sink)(http.HandlerFunc(panickingHandler)))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+
+	if status != http.StatusInternalServerError {
+		t.Errorf("audited status = %d, want %d", status, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,163 @@
+package httpx
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Errors answered by AuthMiddleware. Authenticators return them, possibly
+// wrapped, to tell a request without credentials from one with bad ones.
+var (
+	ErrCredentialsMissing = &StatusError{Status: http.StatusUnauthorized, Type: "credentials_missing", Err: errors.New("credentials required")}
+	ErrCredentialsInvalid = &StatusError{Status: http.StatusUnauthorized, Type: "credentials_invalid", Err: errors.New("invalid credentials")}
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	ID     string
+	Scheme string // how it authenticated, such as "bearer" or "api_key"
+	Tenant string // the tenant it acts for, if any
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns ctx carrying the authenticated principal.
+// AuthMiddleware sets it; AuditMiddleware records it.
+func ContextWithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal set by ContextWithPrincipal,
+// and false if the request is anonymous.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// Authenticator identifies the caller of a request. It returns
+// ErrCredentialsMissing if the request carries no credentials it handles,
+// and any other error if they are not valid.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator.
+type AuthenticatorFunc func(r *http.Request) (Principal, error)
+
+// Authenticate implements Authenticator.
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (Principal, error) { return f(r) }
+
+// BearerAuthenticator authenticates requests with an "Authorization: Bearer"
+// token, which verify turns into a principal. The principal's scheme
+// defaults to "bearer".
+func BearerAuthenticator(verify func(ctx context.Context, token string) (Principal, error)) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (Principal, error) {
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			return Principal{}, ErrCredentialsMissing
+		}
+		p, err := verify(r.Context(), strings.TrimSpace(token))
+		if err != nil {
+			return Principal{}, err
+		}
+		if p.Scheme == "" {
+			p.Scheme = "bearer"
+		}
+		return p, nil
+	})
+}
+
+// APIKeyAuthenticator authenticates requests with one of keys, mapped to
+// the ID of the principal owning them, in the header. The principal's
+// scheme is "api_key".
+func APIKeyAuthenticator(header string, keys map[string]string) Authenticator {
+	// Looking up hashes keeps the lookup time independent of how much of a
+	// guessed key is right.
+	owners := make(map[[sha256.Size]byte]string, len(keys))
+	for key, id := range keys {
+		owners[sha256.Sum256([]byte(key))] = id
+	}
+	return AuthenticatorFunc(func(r *http.Request) (Principal, error) {
+		key := r.Header.Get(header)
+		if key == "" {
+			return Principal{}, ErrCredentialsMissing
+		}
+		id, ok := owners[sha256.Sum256([]byte(key))]
+		if !ok {
+			return Principal{}, ErrCredentialsInvalid
+		}
+		return Principal{ID: id, Scheme: "api_key"}, nil
+	})
+}
+
+// AuthOption configures AuthMiddleware.
+type AuthOption func(*authConfig)
+
+type authConfig struct {
+	routes *Router
+}
+
+// WithPublicRoutes lets requests to the routes registered on rt with
+// RoutePublic through without credentials.
+func WithPublicRoutes(rt *Router) AuthOption {
+	return func(c *authConfig) { c.routes = rt }
+}
+
+// AuthMiddleware authenticates every request, answering a 401 with code
+// credentials_missing or credentials_invalid when it can't, and hands the
+// principal to the handler through the context. The principal ID and tenant
+// are set on the span as enduser.id and tenant.id; metrics only get the
+// outcome, as auth.result: success, missing or invalid, and the tenant with
+// WithTenantAttribute.
+func AuthMiddleware(authenticator Authenticator, opts ...AuthOption) func(http.Handler) http.Handler {
+	var cfg authConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if routeConfig(r, cfg.routes).Public {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			r, st := withRequestState(r)
+			p, err := authenticator.Authenticate(r)
+			result := "success"
+			switch {
+			case errors.Is(err, ErrCredentialsMissing):
+				result, err = "missing", ErrCredentialsMissing
+			case err != nil:
+				// The details of why stay on our side.
+				result, err = "invalid", ErrCredentialsInvalid
+			}
+			st.mu.Lock()
+			st.authResult = result
+			st.tenant = p.Tenant
+			if err == nil {
+				st.principal = p
+			}
+			st.mu.Unlock()
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if span := trace.SpanFromContext(r.Context()); span.IsRecording() {
+				span.SetAttributes(semconv.EnduserID(p.ID))
+				if p.Tenant != "" {
+					span.SetAttributes(attribute.String("tenant.id", p.Tenant))
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(ContextWithPrincipal(r.Context(), p)))
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	reader, _ := otelt.Install(t)
+	captureLogs(t)
+
+	verify := func(ctx context.Context, token string) (Principal, error) {
+		if token != "s3cret" {
+			return Principal{}, errors.New("token expired at 10:00 for agent-7")
+		}
+		return Principal{ID: "agent-7"}, nil
+	}
+	var got Principal
+	rt := NewRouter()
+	rt.HandleFunc("GET /trips", func(w http.ResponseWriter, r *http.Request) {
+		got, _ = PrincipalFromContext(r.Context())
+	})
+	rt.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {}, RoutePublic())
+	handler := Chain(TracingMiddleware, MetricsMiddleware, AuthMiddleware(BearerAuthenticator(verify), WithPublicRoutes(rt)))(rt)
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		wantStatus    int
+		wantCode      string
+	}{
+		{name: "valid", path: "/trips", authorization: "Bearer s3cret", wantStatus: http.StatusOK},
+		{name: "missing", path: "/trips", wantStatus: http.StatusUnauthorized, wantCode: "credentials_missing"},
+		{name: "other scheme", path: "/trips", authorization: "Basic YWdlbnQ6cHc=", wantStatus: http.StatusUnauthorized, wantCode: "credentials_missing"},
+		{name: "invalid", path: "/trips", authorization: "Bearer guessed", wantStatus: http.StatusUnauthorized, wantCode: "credentials_invalid"},
+		{name: "public", path: "/status", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			var body errorBody
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != tt.wantCode {
+				t.Errorf("body = %s, want code %s", rec.Body.String(), tt.wantCode)
+			}
+			if body.Error == "token expired at 10:00 for agent-7" {
+				t.Error("verifier error leaked to the client")
+			}
+		})
+	}
+
+	if got != (Principal{ID: "agent-7", Scheme: "bearer"}) {
+		t.Errorf("handler got principal %+v", got)
+	}
+	for result, want := range map[string]int64{"success": 1, "missing": 2, "invalid": 1} {
+		otelt.RequireCounterValue(t, "http.server.requests", []attribute.KeyValue{attribute.String("auth.result", result)}, want)
+	}
+	for _, dp := range collectSum(t, reader, "http.server.requests") {
+		if _, ok := dp.Attributes.Value(semconv.EnduserIDKey); ok {
+			t.Error("principal ID recorded as a metric attribute")
+		}
+		if route, _ := dp.Attributes.Value("http.route"); route.AsString() == "/status" {
+			if _, ok := dp.Attributes.Value("auth.result"); ok {
+				t.Error("public route labeled with auth.result")
+			}
+		}
+	}
+
+	var enduser []string
+	for _, span := range otelt.Spans(t) {
+		for _, kv := range span.Attributes() {
+			if kv.Key == semconv.EnduserIDKey {
+				enduser = append(enduser, kv.Value.AsString())
+			}
+		}
+	}
+	if len(enduser) != 1 || enduser[0] != "agent-7" {
+		t.Errorf("enduser.id on spans = %v, want agent-7 on the authenticated request only", enduser)
+	}
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	auth := APIKeyAuthenticator("X-API-Key", map[string]string{"k-123": "partner-1"})
+
+	tests := []struct {
+		name    string
+		key     string
+		want    Principal
+		wantErr error
+	}{
+		{name: "valid", key: "k-123", want: Principal{ID: "partner-1", Scheme: "api_key"}},
+		{name: "missing", wantErr: ErrCredentialsMissing},
+		{name: "unknown", key: "k-124", wantErr: ErrCredentialsInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			got, err := auth.Authenticate(req)
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("Authenticate() = %+v, %v, want %+v, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package httpx
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxBaggageValue is the longest baggage value BaggageMiddleware keeps;
+// longer ones are dropped rather than stored on every span.
+const maxBaggageValue = 256
+
+// BaggageMiddleware keeps only the baggage members whose keys are listed,
+// such as tenant_id, in the request context, so handlers read them with
+// BaggageValue and Transport forwards them downstream, and adds them to the
+// span in the context as baggage.<key> attributes. Other members are
+// dropped: baggage comes from the caller and may be anything. Place it inside
+// TracingMiddleware.
+func BaggageMiddleware(keys ...string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		allowed[k] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			// The header is read again in case the context holds no baggage,
+			// when the span was started by other instrumentation.
+			incoming := baggage.FromContext(propagation.Baggage{}.Extract(ctx, propagation.HeaderCarrier(r.Header)))
+
+			var members []baggage.Member
+			var attrs []attribute.KeyValue
+			for _, m := range incoming.Members() {
+				if !allowed[m.Key()] || len(m.Value()) > maxBaggageValue {
+					continue
+				}
+				members = append(members, m)
+				attrs = append(attrs, attribute.String("baggage."+m.Key(), m.Value()))
+			}
+
+			kept, err := baggage.New(members...)
+			if err != nil {
+				slog.WarnContext(ctx, "Dropping request baggage", "error", err)
+			}
+			if len(attrs) > 0 {
+				trace.SpanFromContext(ctx).SetAttributes(attrs...)
+			}
+			next.ServeHTTP(w, r.WithContext(baggage.ContextWithBaggage(ctx, kept)))
+		})
+	}
+}
+
+// BaggageValue returns the value of the baggage member key in ctx, or "" if
+// there is none.
+func BaggageValue(ctx context.Context, key string) string {
+	return baggage.FromContext(ctx).Member(key).Value()
+}
@@ -0,0 +1,64 @@
+package httpx
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestBaggageMiddleware_ForwardsAllowedMembers(t *testing.T) {
+	otelt.InstallTracing(t)
+
+	var forwarded string
+	supplier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get("Baggage")
+	}))
+	defer supplier.Close()
+
+	client := &http.Client{Transport: NewTransport(nil)}
+	var tenant, session string
+	handler := TracingMiddleware(BaggageMiddleware("tenant_id", "big")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, session = BaggageValue(r.Context(), "tenant_id"), BaggageValue(r.Context(), "session")
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, supplier.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("supplier call: %v", err)
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/trips", nil)
+	req.Header.Set("Baggage", "tenant_id=acme,session=secret,big="+strings.Repeat("x", maxBaggageValue+1))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if tenant != "acme" || session != "" {
+		t.Errorf("handler saw tenant_id=%q session=%q, want acme and nothing", tenant, session)
+	}
+	if forwarded != "tenant_id=acme" {
+		t.Errorf("supplier got baggage %q, want tenant_id=acme", forwarded)
+	}
+
+	var server []attribute.KeyValue
+	for _, span := range otelt.Spans(t) {
+		if span.SpanKind() == trace.SpanKindServer {
+			server = span.Attributes()
+		}
+	}
+	if !slices.Contains(server, attribute.String("baggage.tenant_id", "acme")) {
+		t.Errorf("server span attributes %v, want baggage.tenant_id=acme", server)
+	}
+	for _, kv := range server {
+		if kv.Key == "baggage.session" || kv.Key == "baggage.big" {
+			t.Errorf("server span has disallowed attribute %v", kv)
+		}
+	}
+}
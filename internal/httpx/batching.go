@@ -0,0 +1,138 @@
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Export defaults. Metrics are exported more often than the SDK's every
+// minute, for dashboards to keep up with incidents; spans are batched as
+// the SDK does.
+const (
+	defaultMetricInterval    = 10 * time.Second
+	defaultMetricTimeout     = 5 * time.Second
+	defaultSpanBatchTimeout  = 5 * time.Second
+	defaultSpanExportTimeout = 30 * time.Second
+	defaultSpanQueueSize     = 2048
+	defaultSpanMaxBatch      = 512
+)
+
+// exportTuning is how often and how much telemetry is exported at once.
+type exportTuning struct {
+	metricInterval    time.Duration
+	metricTimeout     time.Duration
+	spanBatchTimeout  time.Duration
+	spanExportTimeout time.Duration
+	spanQueueSize     int
+	spanMaxBatch      int
+}
+
+func defaultExportTuning() exportTuning {
+	return exportTuning{
+		metricInterval:    defaultMetricInterval,
+		metricTimeout:     defaultMetricTimeout,
+		spanBatchTimeout:  defaultSpanBatchTimeout,
+		spanExportTimeout: defaultSpanExportTimeout,
+		spanQueueSize:     defaultSpanQueueSize,
+		spanMaxBatch:      defaultSpanMaxBatch,
+	}
+}
+
+// WithMetricExportInterval exports metrics every d, 10 seconds by default.
+// It has no effect with WithMetricReader.
+func WithMetricExportInterval(d time.Duration) TelemetryOption {
+	return func(c *telemetryConfig) { c.export.metricInterval = d }
+}
+
+// WithMetricExportTimeout gives up on a metric export after d, 5 seconds by
+// default. It must be shorter than the export interval.
+func WithMetricExportTimeout(d time.Duration) TelemetryOption {
+	return func(c *telemetryConfig) { c.export.metricTimeout = d }
+}
+
+// WithSpanBatchTimeout exports the spans queued at least every d, 5 seconds
+// by default. Short-lived jobs want it shorter.
+func WithSpanBatchTimeout(d time.Duration) TelemetryOption {
+	return func(c *telemetryConfig) { c.export.spanBatchTimeout = d }
+}
+
+// WithSpanExportTimeout gives up on a span export after d, 30 seconds by
+// default.
+func WithSpanExportTimeout(d time.Duration) TelemetryOption {
+	return func(c *telemetryConfig) { c.export.spanExportTimeout = d }
+}
+
+// WithSpanQueueSize queues up to n spans per exporter, 2048 by default;
+// spans ended while the queue is full are dropped. High-volume services
+// want it bigger.
+func WithSpanQueueSize(n int) TelemetryOption {
+	return func(c *telemetryConfig) { c.export.spanQueueSize = n }
+}
+
+// WithSpanMaxExportBatch exports up to n spans at once, 512 by default. It
+// can't exceed the queue size.
+func WithSpanMaxExportBatch(n int) TelemetryOption {
+	return func(c *telemetryConfig) { c.export.spanMaxBatch = n }
+}
+
+// validate reports every setting out of range or contradicting another.
+func (t exportTuning) validate() error {
+	var errs []error
+	fail := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+
+	for _, d := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"metric export interval", t.metricInterval},
+		{"metric export timeout", t.metricTimeout},
+		{"span batch timeout", t.spanBatchTimeout},
+		{"span export timeout", t.spanExportTimeout},
+	} {
+		if d.d <= 0 {
+			fail("%s %v is not positive", d.name, d.d)
+		}
+	}
+	if t.metricTimeout >= t.metricInterval {
+		fail("metric export timeout %v must be shorter than the interval %v", t.metricTimeout, t.metricInterval)
+	}
+	if t.spanQueueSize <= 0 {
+		fail("span queue size %d is not positive", t.spanQueueSize)
+	}
+	if t.spanMaxBatch <= 0 || t.spanMaxBatch > t.spanQueueSize {
+		fail("span max export batch %d is not between 1 and the queue size %d", t.spanMaxBatch, t.spanQueueSize)
+	}
+	return errors.Join(errs...)
+}
+
+func (t exportTuning) readerOptions() []sdkmetric.PeriodicReaderOption {
+	return []sdkmetric.PeriodicReaderOption{
+		sdkmetric.WithInterval(t.metricInterval),
+		sdkmetric.WithTimeout(t.metricTimeout),
+	}
+}
+
+func (t exportTuning) batcherOptions() []sdktrace.BatchSpanProcessorOption {
+	return []sdktrace.BatchSpanProcessorOption{
+		sdktrace.WithBatchTimeout(t.spanBatchTimeout),
+		sdktrace.WithExportTimeout(t.spanExportTimeout),
+		sdktrace.WithMaxQueueSize(t.spanQueueSize),
+		sdktrace.WithMaxExportBatchSize(t.spanMaxBatch),
+	}
+}
+
+func (t exportTuning) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Duration("metric_interval", t.metricInterval),
+		slog.Duration("metric_timeout", t.metricTimeout),
+		slog.Duration("span_batch_timeout", t.spanBatchTimeout),
+		slog.Duration("span_export_timeout", t.spanExportTimeout),
+		slog.Int("span_queue_size", t.spanQueueSize),
+		slog.Int("span_max_batch", t.spanMaxBatch),
+	)
+}
@@ -0,0 +1,147 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// batchRecorder records the size of every batch of spans exported.
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches []int
+}
+
+func (r *batchRecorder) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, len(spans))
+	return nil
+}
+
+func (r *batchRecorder) Shutdown(context.Context) error { return nil }
+
+func (r *batchRecorder) Batches() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.batches...)
+}
+
+// lockedBuffer is a bytes.Buffer safe to read while exporters write to it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestInitTelemetry_SpanBatching(t *testing.T) {
+	restoreGlobals(t)
+	captureLogs(t)
+
+	exp := &batchRecorder{}
+	shutdown, err := InitTelemetry(context.Background(), "test",
+		WithMetricReader(sdkmetric.NewManualReader()),
+		WithSpanExporter(exp),
+		WithSpanBatchTimeout(20*time.Millisecond),
+		WithSpanQueueSize(10),
+		WithSpanMaxExportBatch(3),
+	)
+	if err != nil {
+		t.Fatalf("InitTelemetry() unexpected error: %v", err)
+	}
+	defer func() { _ = shutdown(context.Background()) }()
+
+	for range 7 {
+		_, span := otel.Tracer("test").Start(context.Background(), "search")
+		span.End()
+	}
+	// Well before the default 5s batch timeout.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var total int
+		for _, n := range exp.Batches() {
+			if n > 3 {
+				t.Fatalf("exported a batch of %d spans, want at most 3", n)
+			}
+			total += n
+		}
+		if total == 7 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("exported %v by the deadline, want the 7 spans without Shutdown", exp.Batches())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestInitTelemetry_MetricExportInterval(t *testing.T) {
+	restoreGlobals(t)
+	logs := captureLogs(t)
+
+	var out lockedBuffer
+	shutdown, err := InitTelemetry(context.Background(), "test",
+		WithStdoutWriter(&out),
+		WithMetricExportInterval(20*time.Millisecond),
+		WithMetricExportTimeout(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("InitTelemetry() unexpected error: %v", err)
+	}
+	defer func() { _ = shutdown(context.Background()) }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for strings.Count(out.String(), `"ScopeMetrics"`) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d metric exports by the deadline, want 3 well before the default 10s interval", strings.Count(out.String(), `"ScopeMetrics"`))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for _, line := range decodeLogLines(t, logs) {
+		if line["msg"] == "OpenTelemetry initialized with stdout exporters" {
+			export := line["export"].(map[string]any)
+			if export["metric_interval"] != float64(20*time.Millisecond) || export["span_queue_size"] != float64(defaultSpanQueueSize) {
+				t.Errorf("logged export settings %v", export)
+			}
+			return
+		}
+	}
+	t.Error("initialization not logged")
+}
+
+func TestInitTelemetry_InvalidExportSettings(t *testing.T) {
+	_, err := InitTelemetry(context.Background(), "test",
+		WithMetricExportInterval(time.Second),
+		WithMetricExportTimeout(2*time.Second),
+		WithSpanBatchTimeout(0),
+		WithSpanQueueSize(100),
+		WithSpanMaxExportBatch(200),
+	)
+	want := strings.Join([]string{
+		"invalid export settings: span batch timeout 0s is not positive",
+		"metric export timeout 2s must be shorter than the interval 1s",
+		"span max export batch 200 is not between 1 and the queue size 100",
+	}, "\n")
+	if err == nil || err.Error() != want {
+		t.Errorf("InitTelemetry() error:\n got %v\nwant %s", err, want)
+	}
+}
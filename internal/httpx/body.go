@@ -0,0 +1,60 @@
+package httpx
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxDrainBytes bounds how much of an unread request body is consumed to
+// learn its size, matching what net/http discards after a handler returns.
+const maxDrainBytes = 256 << 10
+
+// countingBody counts the bytes read from a request body.
+type countingBody struct {
+	io.ReadCloser
+	n    int64
+	done bool
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err != nil {
+		b.done = true
+	}
+	return n, err
+}
+
+// size returns the number of body bytes the client sent. Bodies the handler
+// did not consume are drained up to maxDrainBytes; beyond that the declared
+// Content-Length is trusted.
+func (b *countingBody) size(r *http.Request) int64 {
+	// Reading the rest of a body whose client waits for 100 Continue could
+	// stall, as the response has already gone out.
+	if !b.done && !expectsContinue(r) {
+		_, _ = io.CopyN(io.Discard, b, maxDrainBytes)
+	}
+	if !b.done && r.ContentLength > b.n {
+		return r.ContentLength
+	}
+	return b.n
+}
+
+func expectsContinue(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+}
+
+// mediaType normalizes a Content-Type header to its lowercase media type
+// without parameters, e.g. "application/json".
+func mediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "invalid"
+	}
+	return mt
+}
@@ -0,0 +1,218 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// ErrCircuitOpen is returned by BreakerTransport without sending the request
+// while the breaker for the host is open. Handlers usually answer it with a
+// 503.
+var ErrCircuitOpen = errors.New("httpx: circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerOption configures NewBreakerTransport.
+type BreakerOption func(*BreakerTransport)
+
+// WithFailureThreshold sets how many consecutive failures open the breaker.
+// Defaults to 5.
+func WithFailureThreshold(n int) BreakerOption {
+	return func(t *BreakerTransport) { t.threshold = max(n, 1) }
+}
+
+// WithOpenDuration sets how long the breaker stays open before letting probe
+// requests through. Defaults to 30s.
+func WithOpenDuration(d time.Duration) BreakerOption {
+	return func(t *BreakerTransport) { t.openFor = d }
+}
+
+// WithHalfOpenProbes sets how many probe requests may be in flight while half
+// open, and how many of them must succeed to close the breaker. Defaults to 1.
+func WithHalfOpenProbes(n int) BreakerOption {
+	return func(t *BreakerTransport) { t.probes = max(n, 1) }
+}
+
+// BreakerTransport fails fast with ErrCircuitOpen for hosts that keep
+// failing, instead of letting requests wait on a dependency that is down.
+// Transport errors and 5xx responses count as failures. Each host has its
+// own breaker.
+//
+// State changes are counted in http.client.breaker.transitions and the
+// current state (0 closed, 1 open, 2 half open) is reported by the
+// http.client.breaker.state gauge.
+type BreakerTransport struct {
+	base      http.RoundTripper
+	threshold int
+	openFor   time.Duration
+	probes    int
+	now       func() time.Time
+
+	mu    sync.Mutex
+	hosts map[string]*breaker
+}
+
+type breaker struct {
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+	inFlight  int
+	successes int
+	// generation counts the transitions, so that the results of requests
+	// admitted in an earlier state are told apart and ignored.
+	generation uint64
+}
+
+// NewBreakerTransport returns a BreakerTransport sending requests through
+// base. A nil base means http.DefaultTransport.
+func NewBreakerTransport(base http.RoundTripper, opts ...BreakerOption) *BreakerTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &BreakerTransport{
+		base:      base,
+		threshold: 5,
+		openFor:   30 * time.Second,
+		probes:    1,
+		now:       time.Now,
+		hosts:     map[string]*breaker{},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *BreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	gen, ok := t.allow(req.Context(), host)
+	if !ok {
+		return nil, ErrCircuitOpen
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		// The caller gave up; that says nothing about the host.
+		t.release(host, gen)
+	case err != nil || resp.StatusCode >= 500:
+		t.done(req.Context(), host, gen, false)
+	default:
+		t.done(req.Context(), host, gen, true)
+	}
+	return resp, err
+}
+
+// allow admits a request to host, returning the generation of the breaker
+// it was admitted in.
+func (t *BreakerTransport) allow(ctx context.Context, host string) (uint64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.hosts[host]
+	if b == nil {
+		b = &breaker{}
+		t.hosts[host] = b
+	}
+
+	if b.state == breakerOpen {
+		if t.now().Sub(b.openedAt) < t.openFor {
+			return 0, false
+		}
+		t.transition(ctx, host, b, breakerHalfOpen)
+	}
+	if b.state == breakerHalfOpen {
+		if b.inFlight >= t.probes {
+			return 0, false
+		}
+		b.inFlight++
+	}
+	return b.generation, true
+}
+
+func (t *BreakerTransport) release(host string, gen uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if b := t.hosts[host]; b.generation == gen && b.state == breakerHalfOpen && b.inFlight > 0 {
+		b.inFlight--
+	}
+}
+
+// done records the result of a request admitted in generation gen. Results
+// of an earlier generation are stale: a request sent while closed may end
+// after the breaker turned half open, and must not count as a probe.
+func (t *BreakerTransport) done(ctx context.Context, host string, gen uint64, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.hosts[host]
+	if b.generation != gen {
+		return
+	}
+	switch b.state {
+	case breakerClosed:
+		if ok {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= t.threshold {
+			t.transition(ctx, host, b, breakerOpen)
+		}
+	case breakerHalfOpen:
+		b.inFlight--
+		if !ok {
+			t.transition(ctx, host, b, breakerOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= t.probes {
+			t.transition(ctx, host, b, breakerClosed)
+		}
+	}
+}
+
+// transition moves b to state and records the change. Callers hold t.mu.
+func (t *BreakerTransport) transition(ctx context.Context, host string, b *breaker, to breakerState) {
+	from := b.state
+	b.state = to
+	b.failures, b.successes, b.inFlight = 0, 0, 0
+	b.generation++
+	if to == breakerOpen {
+		b.openedAt = t.now()
+	}
+
+	cm := loadClientMetrics()
+	peer := attribute.String("net.peer.name", host)
+	cm.breakerTransitions.Add(ctx, 1, metric.WithAttributes(
+		peer,
+		attribute.String("breaker.from", from.String()),
+		attribute.String("breaker.to", to.String()),
+	))
+	cm.breakerState.Record(ctx, int64(to), metric.WithAttributes(peer))
+}
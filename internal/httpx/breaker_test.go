@@ -0,0 +1,158 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestBreakerTransport(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	status := http.StatusServiceUnavailable
+	sent := 0
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: status, Body: http.NoBody, Request: r}, nil
+	})
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	bt := NewBreakerTransport(base, WithFailureThreshold(3), WithOpenDuration(10*time.Second), WithHalfOpenProbes(2))
+	bt.now = func() time.Time { return now }
+
+	do := func(host string) error {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+host+"/offers", nil)
+		_, err := bt.RoundTrip(req)
+		return err
+	}
+
+	// Closed: failures below the threshold still reach the host.
+	for i := range 3 {
+		if err := do("supplier.test"); err != nil {
+			t.Fatalf("request %d: RoundTrip() unexpected error: %v", i, err)
+		}
+	}
+
+	// Open: fail fast without sending, while other hosts are unaffected.
+	if err := do("supplier.test"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("RoundTrip() error mismatch: got %v, want %v", err, ErrCircuitOpen)
+	}
+	if sent != 3 {
+		t.Errorf("sent mismatch: got %d, want 3", sent)
+	}
+	if err := do("other.test"); err != nil {
+		t.Errorf("other host: RoundTrip() unexpected error: %v", err)
+	}
+
+	// Half open after the open duration: a failing probe reopens.
+	now = now.Add(10 * time.Second)
+	if err := do("supplier.test"); err != nil {
+		t.Fatalf("probe: RoundTrip() unexpected error: %v", err)
+	}
+	if err := do("supplier.test"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("after failed probe: RoundTrip() error mismatch: got %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// Half open again: enough successful probes close the breaker.
+	now = now.Add(10 * time.Second)
+	status = http.StatusOK
+	for i := range 2 {
+		if err := do("supplier.test"); err != nil {
+			t.Fatalf("probe %d: RoundTrip() unexpected error: %v", i, err)
+		}
+	}
+	if got := bt.hosts["supplier.test"].state; got != breakerClosed {
+		t.Errorf("state mismatch: got %v, want %v", got, breakerClosed)
+	}
+
+	transitions := map[string]int64{}
+	for _, dp := range collectSum(t, reader, "http.client.breaker.transitions") {
+		from, _ := dp.Attributes.Value("breaker.from")
+		to, _ := dp.Attributes.Value("breaker.to")
+		transitions[from.AsString()+"->"+to.AsString()] += dp.Value
+	}
+	want := map[string]int64{"closed->open": 1, "open->half_open": 2, "half_open->open": 1, "half_open->closed": 1}
+	for k, v := range want {
+		if transitions[k] != v {
+			t.Errorf("transition %s mismatch: got %d, want %d", k, transitions[k], v)
+		}
+	}
+
+	m, ok := collectMetric(t, reader, "http.client.breaker.state")
+	if !ok {
+		t.Fatal("http.client.breaker.state not recorded")
+	}
+	for _, dp := range m.Data.(metricdata.Gauge[int64]).DataPoints {
+		if dp.Value != int64(breakerClosed) {
+			t.Errorf("state gauge mismatch: got %d, want %d", dp.Value, breakerClosed)
+		}
+	}
+}
+
+func TestBreakerTransport_StaleResults(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	slow := make(chan struct{})
+	started := make(chan struct{})
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-slow
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+		}
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: http.NoBody, Request: r}, nil
+	})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	bt := NewBreakerTransport(base, WithFailureThreshold(1), WithOpenDuration(10*time.Second))
+	bt.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	do := func(path string) error {
+		req, _ := http.NewRequest(http.MethodGet, "http://supplier.test"+path, nil)
+		_, err := bt.RoundTrip(req)
+		return err
+	}
+
+	// Sent while closed, answered once the breaker went through open to half
+	// open and admitted a probe.
+	slowDone := make(chan error, 1)
+	go func() { slowDone <- do("/slow") }()
+	<-started
+	_ = do("/offers")
+	mu.Lock()
+	now = now.Add(10 * time.Second)
+	mu.Unlock()
+	probe, _ := bt.allow(context.Background(), "supplier.test")
+	close(slow)
+	if err := <-slowDone; err != nil {
+		t.Fatalf("slow request: RoundTrip() unexpected error: %v", err)
+	}
+
+	bt.mu.Lock()
+	b := *bt.hosts["supplier.test"]
+	bt.mu.Unlock()
+	if b.state != breakerHalfOpen || b.inFlight != 1 {
+		t.Errorf("after a stale success: %v with %d probes in flight, want half_open with 1", b.state, b.inFlight)
+	}
+	if err := do("/offers"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("second probe: RoundTrip() error mismatch: got %v, want %v", err, ErrCircuitOpen)
+	}
+
+	bt.done(context.Background(), "supplier.test", probe, true)
+	if got := bt.hosts["supplier.test"].state; got != breakerClosed {
+		t.Errorf("state after the probe succeeded: got %v, want %v", got, breakerClosed)
+	}
+}
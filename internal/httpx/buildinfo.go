@@ -0,0 +1,109 @@
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// BuildInfo identifies the build running.
+type BuildInfo struct {
+	Version   string `json:"version,omitempty"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+var build struct {
+	mu   sync.Mutex
+	info *BuildInfo // set with SetBuildInfo
+}
+
+// SetBuildInfo records the build running, typically from values set with
+// -ldflags -X at build time. Empty values are read from the module and VCS
+// information Go embeds in the binary, when there is some.
+func SetBuildInfo(version, commit, date string) {
+	info := readBuildInfo()
+	if version != "" {
+		info.Version = version
+	}
+	if commit != "" {
+		info.Commit = commit
+	}
+	if date != "" {
+		info.Date = date
+	}
+	build.mu.Lock()
+	build.info = &info
+	build.mu.Unlock()
+}
+
+// CurrentBuildInfo returns the build running, as set with SetBuildInfo or
+// else embedded by Go.
+func CurrentBuildInfo() BuildInfo {
+	build.mu.Lock()
+	defer build.mu.Unlock()
+	if build.info != nil {
+		return *build.info
+	}
+	return readBuildInfo()
+}
+
+func readBuildInfo() BuildInfo {
+	info := BuildInfo{GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if v := bi.Main.Version; v != "" && v != "(devel)" {
+		info.Version = v
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Commit = s.Value
+		case "vcs.time":
+			info.Date = s.Value
+		}
+	}
+	return info
+}
+
+// registerBuildInfo reports the build running as a service.build_info gauge
+// always 1, to tell rollouts apart on dashboards.
+func registerBuildInfo(mp metric.MeterProvider) error {
+	m := mp.Meter(instrumentationName)
+
+	gauge, err := m.Int64ObservableGauge("service.build_info",
+		metric.WithDescription("Always 1, with the version, commit, date and Go version of the build as attributes"))
+	if err != nil {
+		return fmt.Errorf("create build info instrument: %w", err)
+	}
+	_, err = m.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		info := CurrentBuildInfo()
+		o.ObserveInt64(gauge, 1, metric.WithAttributes(
+			attribute.String("version", info.Version),
+			attribute.String("commit", info.Commit),
+			attribute.String("date", info.Date),
+			attribute.String("go_version", info.GoVersion),
+		))
+		return nil
+	}, gauge)
+	if err != nil {
+		return fmt.Errorf("register build info callback: %w", err)
+	}
+	return nil
+}
+
+// VersionHandler serves the build running as JSON.
+func VersionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = WriteJSON(w, http.StatusOK, CurrentBuildInfo())
+	})
+}
@@ -0,0 +1,90 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// setBuildInfo calls SetBuildInfo until t is done.
+func setBuildInfo(t *testing.T, version, commit, date string) {
+	t.Helper()
+	SetBuildInfo(version, commit, date)
+	t.Cleanup(func() {
+		build.mu.Lock()
+		build.info = nil
+		build.mu.Unlock()
+	})
+}
+
+func TestInitTelemetry_BuildInfo(t *testing.T) {
+	restoreGlobals(t)
+	captureLogs(t)
+	setBuildInfo(t, "1.4.2", "9f1c2e7", "2026-10-01T12:00:00Z")
+
+	reader := sdkmetric.NewManualReader()
+	shutdown, err := InitTelemetry(context.Background(), "test", WithMetricReader(reader), WithSpanExporter(tracetest.NewInMemoryExporter()))
+	if err != nil {
+		t.Fatalf("InitTelemetry() unexpected error: %v", err)
+	}
+	defer func() { _ = shutdown(context.Background()) }()
+
+	m, ok := collectMetric(t, reader, "service.build_info")
+	if !ok {
+		t.Fatal("service.build_info not reported")
+	}
+	points := m.Data.(metricdata.Gauge[int64]).DataPoints
+	if len(points) != 1 || points[0].Value != 1 {
+		t.Fatalf("service.build_info = %+v, want one point of 1", points)
+	}
+	want := attribute.NewSet(
+		attribute.String("version", "1.4.2"),
+		attribute.String("commit", "9f1c2e7"),
+		attribute.String("date", "2026-10-01T12:00:00Z"),
+		attribute.String("go_version", runtime.Version()),
+	)
+	if !points[0].Attributes.Equals(&want) {
+		t.Errorf("attributes = %v, want %v", points[0].Attributes.ToSlice(), want.ToSlice())
+	}
+
+	res, err := newResource(context.Background(), "test", newTelemetryConfig(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resourceAttrs(res)["service.version"]; got != "1.4.2" {
+		t.Errorf("service.version = %q, want the build's", got)
+	}
+	res, err = newResource(context.Background(), "test", newTelemetryConfig([]TelemetryOption{WithServiceVersion("2.0.0")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resourceAttrs(res)["service.version"]; got != "2.0.0" {
+		t.Errorf("service.version = %q, want WithServiceVersion's", got)
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	setBuildInfo(t, "1.4.2", "", "")
+
+	rec := httptest.NewRecorder()
+	AdminServer("").Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var got BuildInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	// Test binaries embed no VCS information.
+	if want := (BuildInfo{Version: "1.4.2", GoVersion: runtime.Version()}); got != want {
+		t.Errorf("version = %+v, want %+v", got, want)
+	}
+}
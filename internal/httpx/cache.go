@@ -0,0 +1,274 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheHeader tells clients whether a response came from the cache.
+const CacheHeader = "X-Cache"
+
+// CachedResponse is a response stored by CacheMiddleware.
+type CachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	Stored time.Time // when the handler produced it, for the Age header
+}
+
+// ResponseCache stores responses until their TTL runs out. Implementations
+// must be safe for concurrent use.
+type ResponseCache interface {
+	Get(ctx context.Context, key string) (CachedResponse, bool, error)
+	Set(ctx context.Context, key string, resp CachedResponse, ttl time.Duration) error
+}
+
+// CacheOption configures CacheMiddleware.
+type CacheOption func(*responseCache)
+
+// WithCacheTTL sets how long responses are served from the cache, unless
+// the RouteConfig of the route sets a CacheTTL. Defaults to 30s.
+func WithCacheTTL(d time.Duration) CacheOption {
+	return func(c *responseCache) { c.ttl = d }
+}
+
+// WithCacheMaxBody sets the largest response body cached. Defaults to 1MiB.
+func WithCacheMaxBody(n int) CacheOption {
+	return func(c *responseCache) { c.maxBody = n }
+}
+
+// WithCacheCredentialHeaders names the headers, besides Authorization,
+// Cookie and X-API-Key, carrying credentials such as the API keys of an
+// APIKeyAuthenticator. Requests sending any of them bypass the cache.
+func WithCacheCredentialHeaders(names ...string) CacheOption {
+	return func(c *responseCache) { c.credentials = append(c.credentials, names...) }
+}
+
+// CacheMiddleware serves GET requests from cache, keyed by path and query
+// string with its parameters sorted, and runs the handler only once for
+// concurrent identical requests, sharing its response. Responses say whether
+// they were cached in X-Cache, HIT or MISS, and those from the cache how
+// long ago they were stored in Age.
+//
+// Only 200 responses are cached, and never those setting cookies or marked
+// private or no-store. Clients sending Cache-Control: no-cache get a fresh
+// response, which is cached, and no-store bypasses the cache altogether, as
+// do requests sending credentials, cookies included, or authenticated by
+// AuthMiddleware: their responses are the principal's, whatever headers they
+// end up with. Requests waiting for an identical one stop when their client
+// goes away, and run the handler themselves should it panic. Lookups are
+// counted in http.server.cache by route and result: hit, miss or coalesced.
+// Place it inside CompressMiddleware, so the cache holds uncompressed
+// bodies, and register it on the routes to cache, so their route is known.
+func CacheMiddleware(cache ResponseCache, opts ...CacheOption) func(http.Handler) http.Handler {
+	c := &responseCache{
+		cache:       cache,
+		ttl:         30 * time.Second,
+		maxBody:     1 << 20,
+		credentials: []string{"Authorization", "Cookie", "X-API-Key"},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+			c.serveHTTP(next, w, r)
+		})
+	}
+}
+
+type responseCache struct {
+	cache       ResponseCache
+	ttl         time.Duration
+	maxBody     int
+	credentials []string // headers whose requests are never cached
+	flight      singleflight.Group
+}
+
+// flightResult is what the request running the handler shares with the
+// identical requests waiting for it.
+type flightResult struct {
+	resp   CachedResponse
+	shared bool // the response may go to other clients
+}
+
+func (c *responseCache) serveHTTP(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	directives := r.Header.Get("Cache-Control")
+	if hasDirective(directives, "no-store") || c.authenticated(r) {
+		next.ServeHTTP(w, r)
+		return
+	}
+	key := r.URL.Path + "?" + r.URL.Query().Encode()
+	route := PatternRoute(r)
+
+	if !hasDirective(directives, "no-cache") {
+		resp, found, err := c.cache.Get(ctx, key)
+		if err != nil {
+			slog.WarnContext(ctx, "Response cache lookup failed", "error", err)
+		}
+		if found {
+			c.count(ctx, route, "hit")
+			if !resp.Stored.IsZero() {
+				w.Header().Set("Age", strconv.Itoa(int(max(time.Since(resp.Stored), 0)/time.Second)))
+			}
+			writeCached(w, resp, "HIT")
+			return
+		}
+	}
+
+	// The first request for key runs the handler in its own goroutine, so
+	// that its panics stay there, and hands the response to the identical
+	// requests waiting for it in the meantime.
+	leader := make(chan struct{})
+	finished := make(chan flightResult, 1)
+	ch := c.flight.DoChan(key, func() (any, error) {
+		close(leader)
+		res, ok := <-finished
+		if !ok {
+			return nil, errFlightAbandoned
+		}
+		return res, nil
+	})
+	select {
+	case <-leader:
+		c.lead(next, w, r, key, finished)
+		// Done once the key is forgotten, so later requests start anew.
+		<-ch
+		c.count(ctx, route, "miss")
+		return
+	case res := <-ch:
+		// Another request ran the handler for us.
+		if fr, ok := res.Val.(flightResult); ok && res.Shared && fr.shared {
+			c.count(ctx, route, "coalesced")
+			writeCached(w, fr.resp, "HIT")
+			return
+		}
+	case <-ctx.Done():
+		// Our client went away; should we lead the flight, the requests
+		// waiting for it run the handler themselves.
+		close(finished)
+		return
+	}
+	c.count(ctx, route, "miss")
+	w.Header().Set(CacheHeader, "MISS")
+	next.ServeHTTP(w, r)
+}
+
+// errFlightAbandoned is what the requests waiting for a response get from a
+// leading request that panicked or whose client went away.
+var errFlightAbandoned = errors.New("cache flight abandoned")
+
+// lead runs the handler for the requests of key, caching its response, and
+// sends the response on finished. finished is closed without one should the
+// handler panic.
+func (c *responseCache) lead(next http.Handler, w http.ResponseWriter, r *http.Request, key string, finished chan<- flightResult) {
+	defer close(finished)
+	ctx := r.Context()
+	rw := newRecordingWriter(w, c.maxBody)
+	w.Header().Set(CacheHeader, "MISS")
+	next.ServeHTTP(rw, r)
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	res := flightResult{
+		resp:   CachedResponse{Status: rw.status, Header: rw.header, Body: rw.body.Bytes(), Stored: time.Now()},
+		shared: !rw.overflow && ctx.Err() == nil && rw.header.Get("Set-Cookie") == "" && !hasDirective(rw.header.Get("Cache-Control"), "private"),
+	}
+	if res.shared && res.resp.Status == http.StatusOK && !hasDirective(rw.header.Get("Cache-Control"), "no-store") {
+		ttl := c.ttl
+		if override := routeConfig(r, nil).CacheTTL; override > 0 {
+			ttl = override
+		}
+		if err := c.cache.Set(ctx, key, res.resp, ttl); err != nil {
+			slog.WarnContext(ctx, "Failed to cache response", "error", err)
+		}
+	}
+	finished <- res
+}
+
+// authenticated reports whether r sends credentials or was authenticated,
+// so that its response may not go to other callers.
+func (c *responseCache) authenticated(r *http.Request) bool {
+	for _, name := range c.credentials {
+		if r.Header.Get(name) != "" {
+			return true
+		}
+	}
+	if st := stateFromContext(r.Context()); st != nil {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		return st.authResult != ""
+	}
+	return false
+}
+
+func (c *responseCache) count(ctx context.Context, route, result string) {
+	loadServerMetrics().cache.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("http.route", route),
+		attribute.String("cache.result", result),
+	))
+}
+
+// writeCached writes a cached or shared response, keeping the headers
+// already set for this request.
+func writeCached(w http.ResponseWriter, resp CachedResponse, cacheStatus string) {
+	h := w.Header()
+	for k, vv := range resp.Header {
+		if _, ok := h[k]; !ok {
+			h[k] = vv
+		}
+	}
+	h.Set(CacheHeader, cacheStatus)
+	w.WriteHeader(resp.Status)
+	_, _ = w.Write(resp.Body)
+}
+
+// hasDirective reports whether a Cache-Control header has the directive.
+func hasDirective(header, directive string) bool {
+	for _, d := range strings.Split(header, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(d), "=")
+		if strings.EqualFold(name, directive) {
+			return true
+		}
+	}
+	return false
+}
+
+// MemoryResponseCache is an in-process ResponseCache forgetting the least
+// recently used responses beyond its capacity.
+type MemoryResponseCache struct {
+	cache *ttlCache[CachedResponse]
+}
+
+// NewMemoryResponseCache returns a cache holding up to capacity responses.
+func NewMemoryResponseCache(capacity int) *MemoryResponseCache {
+	return &MemoryResponseCache{cache: newTTLCache[CachedResponse](capacity)}
+}
+
+// Get implements ResponseCache.
+func (c *MemoryResponseCache) Get(_ context.Context, key string) (CachedResponse, bool, error) {
+	resp, ok := c.cache.get(key)
+	return resp, ok, nil
+}
+
+// Set implements ResponseCache.
+func (c *MemoryResponseCache) Set(_ context.Context, key string, resp CachedResponse, ttl time.Duration) error {
+	c.cache.set(key, resp, ttl)
+	return nil
+}
@@ -0,0 +1,256 @@
+package httpx
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestCacheMiddleware(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	var searches atomic.Int64
+	mux := http.NewServeMux()
+	cached := CacheMiddleware(NewMemoryResponseCache(10))
+	mux.Handle("GET /flights", cached(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		searches.Add(1)
+		switch r.URL.Query().Get("to") {
+		case "nowhere":
+			w.WriteHeader(http.StatusNotFound)
+		case "cookie":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		}
+		_, _ = io.WriteString(w, "flights to "+r.URL.Query().Get("to"))
+	})))
+
+	get := func(target string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get("/flights?to=BCN&from=MAD")
+	second := get("/flights?from=MAD&to=BCN")
+	if searches.Load() != 1 {
+		t.Fatalf("handler ran %d times, want once for the same normalized query", searches.Load())
+	}
+	if first.Header().Get(CacheHeader) != "MISS" || second.Header().Get(CacheHeader) != "HIT" {
+		t.Errorf("X-Cache = %q then %q, want MISS then HIT", first.Header().Get(CacheHeader), second.Header().Get(CacheHeader))
+	}
+	if second.Body.String() != "flights to BCN" {
+		t.Errorf("cached body = %q", second.Body.String())
+	}
+	if first.Header().Get("Age") != "" || second.Header().Get("Age") != "0" {
+		t.Errorf("Age = %q then %q, want none then 0", first.Header().Get("Age"), second.Header().Get("Age"))
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		header []string
+	}{
+		{name: "no-cache", target: "/flights?to=BCN&from=MAD", header: []string{"Cache-Control", "no-cache"}},
+		{name: "not found", target: "/flights?to=nowhere"},
+		{name: "set cookie", target: "/flights?to=cookie"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := searches.Load()
+			get(tt.target, tt.header...)
+			get(tt.target, tt.header...)
+			if ran := searches.Load() - before; ran != 2 {
+				t.Errorf("handler ran %d times, want every request to reach it", ran)
+			}
+		})
+	}
+
+	route := attribute.String("http.route", "/flights")
+	otelt.RequireCounterValue(t, "http.server.cache", []attribute.KeyValue{route, attribute.String("cache.result", "hit")}, 1)
+	otelt.RequireCounterValue(t, "http.server.cache", []attribute.KeyValue{route, attribute.String("cache.result", "miss")}, 7)
+}
+
+func TestCacheMiddleware_Authenticated(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	whoami := func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			p.ID = r.Header.Get("Authorization") + r.Header.Get("X-Partner-Key") + r.Header.Get("Cookie")
+		}
+		_, _ = io.WriteString(w, "trips of "+p.ID)
+	}
+	verify := func(_ context.Context, token string) (Principal, error) { return Principal{ID: token}, nil }
+	tests := map[string]struct {
+		handler http.Handler
+		header  string
+	}{
+		// CacheControlMiddleware only marks the responses no-store after the
+		// cache has seen them.
+		"inside AuthMiddleware": {
+			handler: CacheControlMiddleware()(AuthMiddleware(BearerAuthenticator(verify))(
+				CacheMiddleware(NewMemoryResponseCache(10))(http.HandlerFunc(whoami)))),
+			header: "Authorization",
+		},
+		"bearer token": {
+			handler: CacheMiddleware(NewMemoryResponseCache(10))(http.HandlerFunc(whoami)),
+			header:  "Authorization",
+		},
+		"cookie": {
+			handler: CacheMiddleware(NewMemoryResponseCache(10))(http.HandlerFunc(whoami)),
+			header:  "Cookie",
+		},
+		"credential header": {
+			handler: CacheMiddleware(NewMemoryResponseCache(10), WithCacheCredentialHeaders("X-Partner-Key"))(http.HandlerFunc(whoami)),
+			header:  "X-Partner-Key",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			for _, token := range []string{"alice", "bob"} {
+				req := httptest.NewRequest(http.MethodGet, "/trips", nil)
+				if tt.header == "Authorization" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				} else {
+					req.Header.Set(tt.header, token)
+				}
+				rec := httptest.NewRecorder()
+				tt.handler.ServeHTTP(rec, req)
+				if !strings.HasSuffix(rec.Body.String(), token) || rec.Header().Get(CacheHeader) != "" {
+					t.Errorf("%s got %q with X-Cache %q, want their own uncached trips", token, rec.Body.String(), rec.Header().Get(CacheHeader))
+				}
+			}
+		})
+	}
+}
+
+func TestCacheMiddleware_Coalesces(t *testing.T) {
+	otelt.InstallMetrics(t)
+	const n = 10
+
+	var calls atomic.Int64
+	release := make(chan struct{})
+	handler := CacheMiddleware(NewMemoryResponseCache(10))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		_, _ = io.WriteString(w, "flights")
+	}))
+
+	var wg sync.WaitGroup
+	recs := make([]*httptest.ResponseRecorder, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recs[i] = httptest.NewRecorder()
+			handler.ServeHTTP(recs[i], httptest.NewRequest(http.MethodGet, "/flights?to=BCN", nil))
+		}()
+	}
+	// Give the requests time to pile up behind the first one.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("handler ran %d times, want once", calls.Load())
+	}
+	for i, rec := range recs {
+		if rec.Code != http.StatusOK || rec.Body.String() != "flights" {
+			t.Errorf("request %d got %d %q", i, rec.Code, rec.Body.String())
+		}
+		// The shared response is fresh, not read from the cache.
+		if age := rec.Header().Get("Age"); age != "" {
+			t.Errorf("request %d got Age %s", i, age)
+		}
+	}
+	otelt.RequireCounterValue(t, "http.server.cache", []attribute.KeyValue{attribute.String("cache.result", "coalesced")}, n-1)
+}
+
+func TestCacheMiddleware_LeaderPanics(t *testing.T) {
+	otelt.InstallMetrics(t)
+	const n = 5
+
+	var calls atomic.Int64
+	release := make(chan struct{})
+	handler := CacheMiddleware(NewMemoryResponseCache(10))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			<-release
+			panic("supplier client bug")
+		}
+		_, _ = io.WriteString(w, "flights")
+	}))
+
+	leaderPanic := make(chan any, 1)
+	go func() {
+		defer func() { leaderPanic <- recover() }()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/flights", nil))
+	}()
+	eventually(t, "the first request to run the handler", func() bool { return calls.Load() == 1 })
+
+	var wg sync.WaitGroup
+	recs := make([]*httptest.ResponseRecorder, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recs[i] = httptest.NewRecorder()
+			handler.ServeHTTP(recs[i], httptest.NewRequest(http.MethodGet, "/flights", nil))
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	// A panic in a waiting request would bring the test down.
+	wg.Wait()
+
+	if v := <-leaderPanic; v != "supplier client bug" {
+		t.Errorf("first request panicked with %v, want its handler's panic", v)
+	}
+	for i, rec := range recs {
+		if rec.Code != http.StatusOK || rec.Body.String() != "flights" || rec.Header().Get(CacheHeader) != "MISS" {
+			t.Errorf("request %d got %d %q with X-Cache %q, want its own flights", i, rec.Code, rec.Body.String(), rec.Header().Get(CacheHeader))
+		}
+	}
+}
+
+func TestCacheMiddleware_WaiterGone(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	release := make(chan struct{})
+	defer close(release)
+	entered := make(chan struct{})
+	handler := CacheMiddleware(NewMemoryResponseCache(10))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	}))
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/flights", nil))
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	returned := make(chan struct{})
+	rec := httptest.NewRecorder()
+	go func() {
+		defer close(returned)
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/flights", nil).WithContext(ctx))
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("a request whose client went away kept waiting for the handler")
+	}
+	if rec.Body.Len() > 0 {
+		t.Errorf("abandoned request got %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,160 @@
+package httpx
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CachePolicy is the Cache-Control of the responses of a route, set by
+// CacheControlMiddleware.
+type CachePolicy struct {
+	MaxAge  time.Duration // max-age, how long clients may reuse a response
+	SMaxAge time.Duration // s-maxage, the same for shared caches such as the CDN
+	Private bool          // only the client may store responses, not shared caches
+	NoStore bool          // nobody may store responses, the other fields aside
+
+	// Authenticated applies the policy to responses to authenticated
+	// requests too, which otherwise get no-store.
+	Authenticated bool
+}
+
+// RouteCachePolicy sets the Cache-Control CacheControlMiddleware gives the
+// responses of the route.
+func RouteCachePolicy(p CachePolicy) RouteOption {
+	return func(c *RouteConfig) { c.Cache = &p }
+}
+
+// header returns the Cache-Control header of the policy.
+func (p CachePolicy) header() string {
+	if p.NoStore {
+		return "no-store"
+	}
+	directives := []string{"public"}
+	if p.Private {
+		directives[0] = "private"
+	}
+	directives = append(directives, "max-age="+strconv.Itoa(int(p.MaxAge/time.Second)))
+	if p.SMaxAge > 0 && !p.Private {
+		directives = append(directives, "s-maxage="+strconv.Itoa(int(p.SMaxAge/time.Second)))
+	}
+	return strings.Join(directives, ", ")
+}
+
+// CacheControlOption configures CacheControlMiddleware.
+type CacheControlOption func(*cacheControlConfig)
+
+type cacheControlConfig struct {
+	routes *Router
+}
+
+// WithRouteCachePolicies applies the policies registered on rt with
+// RouteCachePolicy to the requests it routes.
+func WithRouteCachePolicies(rt *Router) CacheControlOption {
+	return func(c *cacheControlConfig) { c.routes = rt }
+}
+
+// CacheControlMiddleware sets the Cache-Control of responses the handler
+// left without one. Successful GET and HEAD responses get the CachePolicy of
+// their route, if any. Responses to requests AuthMiddleware authenticated,
+// or turned away, get no-store instead, so a shared cache never hands one
+// client's data to another, unless the policy of the route is marked
+// Authenticated.
+//
+// Place it outside AuthMiddleware and CacheMiddleware, so it sees how the
+// request was authenticated and covers cached responses too.
+func CacheControlMiddleware(opts ...CacheControlOption) func(http.Handler) http.Handler {
+	var cfg cacheControlConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, st := withRequestState(r)
+			cw := &cacheControlWriter{ResponseWriter: w, r: r, st: st, routes: cfg.routes}
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// cacheControlWriter sets the Cache-Control header once the handler
+// answers, when the route and how the request was authenticated are known.
+type cacheControlWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	st          *requestState
+	routes      *Router
+	wroteHeader bool
+}
+
+func (w *cacheControlWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code >= 200 {
+		w.wroteHeader = true
+		w.setCacheControl(code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheControlWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming handlers working.
+func (w *cacheControlWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *cacheControlWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *cacheControlWriter) setCacheControl(code int) {
+	h := w.Header()
+	if h.Get("Cache-Control") != "" {
+		return
+	}
+	w.st.mu.Lock()
+	authenticated := w.st.authResult != ""
+	w.st.mu.Unlock()
+
+	policy := routeConfig(w.r, w.routes).Cache
+	cacheable := (w.r.Method == http.MethodGet || w.r.Method == http.MethodHead) && code < 400
+	switch {
+	case authenticated && (policy == nil || !policy.Authenticated || !cacheable):
+		h.Set("Cache-Control", "no-store")
+	case policy != nil && cacheable:
+		h.Set("Cache-Control", policy.header())
+	}
+}
+
+// CheckCachePolicies warns, in the log, of the GET routes of rt registered
+// without a CachePolicy, which CacheControlMiddleware leaves for clients and
+// proxies to cache as they see fit, and returns their patterns. Call it once
+// the routes are registered.
+func (rt *Router) CheckCachePolicies() []string {
+	rt.routes.mu.RLock()
+	var missing []string
+	for pattern, cfg := range rt.routes.options {
+		if cfg.Cache == nil && strings.HasPrefix(pattern, http.MethodGet+" ") {
+			missing = append(missing, pattern)
+		}
+	}
+	rt.routes.mu.RUnlock()
+
+	slices.Sort(missing)
+	for _, pattern := range missing {
+		slog.Warn("GET route without a cache policy", "route", pattern)
+	}
+	return missing
+}
@@ -0,0 +1,133 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// storedCache is a ResponseCache holding one response stored a while ago.
+type storedCache struct{ resp CachedResponse }
+
+func (c *storedCache) Get(context.Context, string) (CachedResponse, bool, error) {
+	return c.resp, !c.resp.Stored.IsZero(), nil
+}
+
+func (c *storedCache) Set(context.Context, string, CachedResponse, time.Duration) error { return nil }
+
+func TestCacheControlMiddleware(t *testing.T) {
+	captureLogs(t)
+	status := func(code int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(code) }
+	}
+	reference := CachePolicy{MaxAge: time.Hour, SMaxAge: 24 * time.Hour}
+	rt := NewRouter()
+	rt.HandleFunc("GET /airports", status(http.StatusOK), RouteCachePolicy(reference), RoutePublic())
+	rt.HandleFunc("GET /airports/{code}", status(http.StatusNotFound), RouteCachePolicy(reference), RoutePublic())
+	rt.HandleFunc("GET /session", status(http.StatusOK), RouteCachePolicy(CachePolicy{NoStore: true}), RoutePublic())
+	rt.HandleFunc("GET /status", status(http.StatusOK), RoutePublic())
+	rt.HandleFunc("GET /manual", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=5")
+	}, RoutePublic())
+	rt.HandleFunc("GET /trips", status(http.StatusOK), RouteCachePolicy(CachePolicy{MaxAge: time.Minute, Private: true}))
+	rt.HandleFunc("GET /fares", status(http.StatusOK), RouteCachePolicy(CachePolicy{MaxAge: time.Minute, SMaxAge: time.Hour, Private: true, Authenticated: true}))
+	rt.HandleFunc("GET /fares/{id}", status(http.StatusInternalServerError), RouteCachePolicy(CachePolicy{MaxAge: time.Minute, Authenticated: true}))
+	rt.HandleFunc("GET /bookings", status(http.StatusOK))
+
+	auth := AuthenticatorFunc(func(r *http.Request) (Principal, error) {
+		if r.Header.Get("X-Agent") == "" {
+			return Principal{}, ErrCredentialsMissing
+		}
+		return Principal{ID: r.Header.Get("X-Agent")}, nil
+	})
+	handler := CacheControlMiddleware(WithRouteCachePolicies(rt))(AuthMiddleware(auth, WithPublicRoutes(rt))(rt))
+
+	tests := []struct {
+		name   string
+		path   string
+		agent  string
+		status int
+		want   string
+	}{
+		{name: "reference data", path: "/airports", status: http.StatusOK, want: "public, max-age=3600, s-maxage=86400"},
+		{name: "error response", path: "/airports/XXX", status: http.StatusNotFound},
+		{name: "no-store policy", path: "/session", status: http.StatusOK, want: "no-store"},
+		{name: "no policy", path: "/status", status: http.StatusOK},
+		{name: "set by the handler", path: "/manual", status: http.StatusOK, want: "max-age=5"},
+		{name: "authenticated", path: "/trips", agent: "ana", status: http.StatusOK, want: "no-store"},
+		{name: "authentication failed", path: "/trips", status: http.StatusUnauthorized, want: "no-store"},
+		{name: "authenticated without a policy", path: "/bookings", agent: "ana", status: http.StatusOK, want: "no-store"},
+		{name: "policy for authenticated requests", path: "/fares", agent: "ana", status: http.StatusOK, want: "private, max-age=60"},
+		{name: "authenticated error", path: "/fares/1", agent: "ana", status: http.StatusInternalServerError, want: "no-store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.agent != "" {
+				req.Header.Set("X-Agent", tt.agent)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != tt.want {
+				t.Errorf("Cache-Control %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheControlMiddleware_CachedResponses(t *testing.T) {
+	cache := &storedCache{}
+	rt := NewRouter()
+	rt.Handle("GET /countries", CacheMiddleware(cache)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`["PT","ES"]`))
+	})), RouteCachePolicy(CachePolicy{MaxAge: 10 * time.Minute}))
+	handler := CacheControlMiddleware()(RouteConfigMiddleware(rt)(rt))
+
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/countries", nil))
+		return rec
+	}
+	miss := get()
+	if miss.Header().Get(CacheHeader) != "MISS" || miss.Header().Get("Age") != "" {
+		t.Errorf("first response: X-Cache %q, Age %q; want a MISS without Age", miss.Header().Get(CacheHeader), miss.Header().Get("Age"))
+	}
+
+	cache.resp = CachedResponse{Status: http.StatusOK, Body: []byte(`["PT"]`), Stored: time.Now().Add(-90 * time.Second)}
+	hit := get()
+	if hit.Header().Get(CacheHeader) != "HIT" || hit.Header().Get("Age") != "90" {
+		t.Errorf("cached response: X-Cache %q, Age %q; want a HIT 90 seconds old", hit.Header().Get(CacheHeader), hit.Header().Get("Age"))
+	}
+	for _, rec := range []*httptest.ResponseRecorder{miss, hit} {
+		if got := rec.Header().Get("Cache-Control"); got != "public, max-age=600" {
+			t.Errorf("Cache-Control %q, want the route policy", got)
+		}
+	}
+}
+
+func TestRouter_CheckCachePolicies(t *testing.T) {
+	buf := captureLogs(t)
+	ok := func(http.ResponseWriter, *http.Request) {}
+	rt := NewRouter()
+	api := rt.Group("/api")
+	api.HandleFunc("GET /trips", ok)
+	api.HandleFunc("GET /airports", ok, RouteCachePolicy(CachePolicy{MaxAge: time.Hour}))
+	api.HandleFunc("POST /trips", ok)
+	rt.HandleFunc("GET /quotes", ok)
+
+	got := rt.CheckCachePolicies()
+	if want := []string{"GET /api/trips", "GET /quotes"}; !slices.Equal(got, want) {
+		t.Errorf("CheckCachePolicies() = %q, want %q", got, want)
+	}
+	lines := decodeLogLines(t, buf)
+	if len(lines) != 2 || lines[0]["route"] != "GET /api/trips" || lines[0]["level"] != "WARN" {
+		t.Errorf("logged %v, want a warning per route", lines)
+	}
+}
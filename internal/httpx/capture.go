@@ -0,0 +1,432 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RedactedValue replaces the values of the headers Capture redacts.
+const RedactedValue = "REDACTED"
+
+// defaultCaptureDuration is how long the admin endpoint captures for when
+// the request doesn't say.
+const defaultCaptureDuration = 10 * time.Minute
+
+// CapturedExchange is a request and the response it got, as recorded by
+// Capture. Bodies are kept up to the WithCaptureMaxBody limit.
+type CapturedExchange struct {
+	Time      time.Time     `json:"time"`
+	Duration  time.Duration `json:"duration"`
+	Method    string        `json:"method"`
+	Host      string        `json:"host,omitempty"`
+	URL       string        `json:"url"` // the path and query
+	Route     string        `json:"route,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+	TraceID   string        `json:"trace_id,omitempty"`
+
+	RequestHeader    http.Header `json:"request_header,omitempty"`
+	RequestBody      []byte      `json:"request_body,omitempty"`
+	RequestTruncated bool        `json:"request_truncated,omitempty"`
+
+	Status            int         `json:"status"`
+	ResponseHeader    http.Header `json:"response_header,omitempty"`
+	ResponseBody      []byte      `json:"response_body,omitempty"`
+	ResponseTruncated bool        `json:"response_truncated,omitempty"`
+}
+
+// Request returns the captured request, to replay it against a handler.
+// Redacted headers carry RedactedValue, and truncated bodies what was kept.
+func (e CapturedExchange) Request(ctx context.Context) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, e.Method, e.URL, bytes.NewReader(e.RequestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = e.RequestHeader.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	req.Host = e.Host
+	return req, nil
+}
+
+// ReadCapture reads the exchanges a Capture wrote to r, in order.
+func ReadCapture(r io.Reader) ([]CapturedExchange, error) {
+	var exchanges []CapturedExchange
+	dec := json.NewDecoder(r)
+	for {
+		var e CapturedExchange
+		if err := dec.Decode(&e); err == io.EOF {
+			return exchanges, nil
+		} else if err != nil {
+			return exchanges, fmt.Errorf("capture record %d: %w", len(exchanges)+1, err)
+		}
+		exchanges = append(exchanges, e)
+	}
+}
+
+// CaptureOption configures NewCapture.
+type CaptureOption func(*Capture)
+
+// WithCaptureMaxBody keeps up to n bytes of each request and response body,
+// 16KiB by default. Longer bodies are marked truncated.
+func WithCaptureMaxBody(n int) CaptureOption {
+	return func(c *Capture) { c.maxBody = max(n, 0) }
+}
+
+// WithCaptureMaxFileSize rotates the file once it would exceed n bytes,
+// 10MiB by default, keeping the previous one with a .1 suffix.
+func WithCaptureMaxFileSize(n int64) CaptureOption {
+	return func(c *Capture) { c.maxFileSize = n }
+}
+
+// WithCaptureMaxBytes stops capturing once n bytes were written since it was
+// enabled, 50MiB by default.
+func WithCaptureMaxBytes(n int64) CaptureOption {
+	return func(c *Capture) { c.maxBytes = n }
+}
+
+// WithCaptureRedactedHeaders redacts the given request and response headers
+// too, in addition to Authorization, Proxy-Authorization, Cookie, Set-Cookie
+// and X-Api-Key.
+func WithCaptureRedactedHeaders(names ...string) CaptureOption {
+	return func(c *Capture) {
+		for _, name := range names {
+			c.redacted[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+}
+
+// WithCaptureFilter captures the requests for which match returns true
+// only. It is called before the handler runs, so routes are only known from
+// MuxRoute.
+func WithCaptureFilter(match func(r *http.Request) bool) CaptureOption {
+	return func(c *Capture) { c.match = match }
+}
+
+// Capture records requests and their responses as newline-delimited JSON
+// in a file while enabled, to debug integrations with real traffic and
+// replay it with ReadCapture. It is disabled until Enable, usually through
+// its Handler on the admin server, and turns itself off after the given
+// duration or once the WithCaptureMaxBytes limit is reached.
+type Capture struct {
+	path        string
+	maxBody     int
+	maxFileSize int64
+	maxBytes    int64
+	redacted    map[string]bool
+	match       func(r *http.Request) bool
+
+	enabled atomic.Bool
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64 // of the file
+	written int64 // since enabled
+	until   time.Time
+	gen     uint64
+	timer   *time.Timer
+}
+
+// NewCapture returns a disabled capture writing to the file at path.
+func NewCapture(path string, opts ...CaptureOption) *Capture {
+	c := &Capture{
+		path:        path,
+		maxBody:     16 << 10,
+		maxFileSize: 10 << 20,
+		maxBytes:    50 << 20,
+		redacted: map[string]bool{
+			"Authorization":       true,
+			"Proxy-Authorization": true,
+			"Cookie":              true,
+			"Set-Cookie":          true,
+			"X-Api-Key":           true,
+		},
+		match: func(*http.Request) bool { return true },
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Enable starts capturing, for d when positive and otherwise until Disable.
+func (c *Capture) Enable(d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		if err := c.open(); err != nil {
+			return err
+		}
+	}
+	c.written = 0
+	c.stopTimer()
+	c.until = time.Time{}
+	if d > 0 {
+		c.until = time.Now().Add(d)
+		gen := c.gen
+		c.timer = time.AfterFunc(d, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.gen == gen {
+				c.disable("duration elapsed")
+			}
+		})
+	}
+	c.enabled.Store(true)
+	slog.Warn("Traffic capture enabled", "path", c.path, "duration", d)
+	return nil
+}
+
+// Disable stops capturing and closes the file.
+func (c *Capture) Disable() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.disable("disabled")
+}
+
+// Enabled reports whether requests are being captured.
+func (c *Capture) Enabled() bool { return c.enabled.Load() }
+
+func (c *Capture) disable(reason string) {
+	c.stopTimer()
+	if c.file == nil {
+		return
+	}
+	c.enabled.Store(false)
+	if err := c.file.Close(); err != nil {
+		slog.Error("Failed to close the traffic capture", "path", c.path, "error", err)
+	}
+	c.file = nil
+	slog.Info("Traffic capture stopped", "path", c.path, "reason", reason, "bytes", c.written)
+}
+
+func (c *Capture) stopTimer() {
+	c.gen++
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
+func (c *Capture) open() error {
+	f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	c.file, c.size = f, info.Size()
+	return nil
+}
+
+// Middleware captures the requests served while enabled.
+func (c *Capture) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.enabled.Load() || r.Header.Get(MirrorHeader) != "" || !c.match(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		r, st := withRequestState(r)
+		e := CapturedExchange{
+			Time:          start,
+			Method:        r.Method,
+			Host:          r.Host,
+			URL:           r.URL.RequestURI(),
+			RequestHeader: c.redact(r.Header),
+		}
+		e.RequestBody, e.RequestTruncated = c.peekBody(r)
+
+		sw, wrapped := captureStatus(w)
+		cw := &captureWriter{ResponseWriter: wrapped, max: c.maxBody}
+		next.ServeHTTP(cw, r)
+
+		e.Duration = time.Since(start)
+		e.Route = st.matchedRoute()
+		e.RequestID = RequestIDFromContext(r.Context())
+		if sc := st.serverSpanContext(r.Context()); sc.HasTraceID() {
+			e.TraceID = sc.TraceID().String()
+		}
+		e.Status = sw.status
+		e.ResponseHeader = c.redact(w.Header())
+		e.ResponseBody, e.ResponseTruncated = cw.body.Bytes(), cw.truncated
+		c.write(r.Context(), e)
+	})
+}
+
+// peekBody reads up to maxBody bytes of the body, putting them back in
+// front of the rest for the handler. It reports whether there was more.
+func (c *Capture) peekBody(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, false
+	}
+	buf, _ := io.ReadAll(io.LimitReader(r.Body, int64(c.maxBody)+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+	if len(buf) > c.maxBody {
+		return bytes.Clone(buf[:c.maxBody]), true
+	}
+	return buf, false
+}
+
+func (c *Capture) redact(h http.Header) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	out := make(http.Header, len(h))
+	for k, vv := range h {
+		if c.redacted[http.CanonicalHeaderKey(k)] {
+			out[k] = []string{RedactedValue}
+			continue
+		}
+		out[k] = append([]string(nil), vv...)
+	}
+	return out
+}
+
+func (c *Capture) write(ctx context.Context, e CapturedExchange) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode a captured request", "error", err)
+		return
+	}
+	line = append(line, '\n')
+	n := int64(len(line))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return // disabled while the request was served
+	}
+	if c.written+n > c.maxBytes {
+		c.disable("byte limit reached")
+		return
+	}
+	if c.size > 0 && c.size+n > c.maxFileSize {
+		if err := c.rotate(); err != nil {
+			slog.ErrorContext(ctx, "Failed to rotate the traffic capture", "path", c.path, "error", err)
+			c.disable("rotation failed")
+			return
+		}
+	}
+	if _, err := c.file.Write(line); err != nil {
+		slog.ErrorContext(ctx, "Failed to write the traffic capture", "path", c.path, "error", err)
+		c.disable("write failed")
+		return
+	}
+	c.size += n
+	c.written += n
+}
+
+// rotate moves the file aside to path.1, replacing any previous one, and
+// starts a new one.
+func (c *Capture) rotate() error {
+	if err := c.file.Close(); err != nil {
+		return err
+	}
+	c.file = nil
+	if err := os.Rename(c.path, c.path+".1"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return c.open()
+}
+
+// captureStatusBody is served by the capture admin endpoint.
+type captureStatusBody struct {
+	Enabled  bool       `json:"enabled"`
+	Path     string     `json:"path"`
+	Until    *time.Time `json:"until,omitempty"`
+	Bytes    int64      `json:"bytes"`
+	MaxBytes int64      `json:"max_bytes"`
+}
+
+type captureEnableBody struct {
+	Duration string `json:"duration,omitempty"`
+}
+
+// Handler serves the capture state: GET reports it, PUT enables capture for
+// the duration in an optional {"duration": "5m"} body, ten minutes by
+// default, and DELETE disables it.
+func (c *Capture) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut:
+			var body captureEnableBody
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&body); err != nil && err != io.EOF {
+				http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+				return
+			}
+			d := defaultCaptureDuration
+			if body.Duration != "" {
+				var err error
+				if d, err = time.ParseDuration(body.Duration); err != nil || d <= 0 {
+					http.Error(w, fmt.Sprintf("invalid duration %q", body.Duration), http.StatusBadRequest)
+					return
+				}
+			}
+			if err := c.Enable(d); err != nil {
+				http.Error(w, fmt.Sprintf("cannot capture: %v", err), http.StatusInternalServerError)
+				return
+			}
+		case http.MethodDelete:
+			c.Disable()
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		_ = WriteJSON(w, http.StatusOK, c.status())
+	})
+}
+
+func (c *Capture) status() captureStatusBody {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := captureStatusBody{Enabled: c.file != nil, Path: c.path, Bytes: c.written, MaxBytes: c.maxBytes}
+	if s.Enabled && !c.until.IsZero() {
+		until := c.until
+		s.Until = &until
+	}
+	return s
+}
+
+// captureWriter keeps a copy of up to max bytes of the response body.
+type captureWriter struct {
+	http.ResponseWriter
+	max       int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if room := w.max - w.body.Len(); len(b) > room {
+		w.body.Write(b[:max(room, 0)])
+		w.truncated = true
+	} else {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,201 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readCaptureFile(t *testing.T, path string) []CapturedExchange {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	exchanges, err := ReadCapture(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exchanges
+}
+
+func TestCapture(t *testing.T) {
+	captureLogs(t)
+	path := filepath.Join(t.TempDir(), "capture.ndjson")
+	c := NewCapture(path, WithCaptureMaxBody(8), WithCaptureRedactedHeaders("X-Supplier-Token"))
+
+	rt := NewRouter()
+	rt.HandleFunc("POST /bookings/{id}", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(body)
+	})
+	handler := c.Middleware(rt)
+	serve := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/bookings/42?dry_run=1", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("X-Supplier-Token", "secret")
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	serve("before")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("file created while disabled: %v", err)
+	}
+
+	if err := c.Enable(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	serve("short")
+	if rec := serve("a longer body"); rec.Body.String() != "a longer body" {
+		t.Errorf("handler got body %q, want it whole", rec.Body.String())
+	}
+	c.Disable()
+	serve("after")
+
+	exchanges := readCaptureFile(t, path)
+	if len(exchanges) != 2 {
+		t.Fatalf("captured %d exchanges, want 2", len(exchanges))
+	}
+	e := exchanges[0]
+	if e.Method != http.MethodPost || e.URL != "/bookings/42?dry_run=1" || e.Route != "/bookings/{id}" || e.Status != http.StatusCreated {
+		t.Errorf("captured %s %s (route %q) %d, want POST /bookings/42?dry_run=1 (/bookings/{id}) 201", e.Method, e.URL, e.Route, e.Status)
+	}
+	for _, h := range []string{"Authorization", "X-Supplier-Token"} {
+		if got := e.RequestHeader.Get(h); got != RedactedValue {
+			t.Errorf("request %s captured as %q, want it redacted", h, got)
+		}
+	}
+	if got := e.ResponseHeader.Get("Set-Cookie"); got != RedactedValue {
+		t.Errorf("Set-Cookie captured as %q, want it redacted", got)
+	}
+	if got := e.RequestHeader.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type captured as %q, want it kept", got)
+	}
+	if string(e.RequestBody) != "short" || e.RequestTruncated || string(e.ResponseBody) != "short" || e.ResponseTruncated {
+		t.Errorf("short bodies captured as %q/%q (truncated %v/%v)", e.RequestBody, e.ResponseBody, e.RequestTruncated, e.ResponseTruncated)
+	}
+	e = exchanges[1]
+	if string(e.RequestBody) != "a longer" || !e.RequestTruncated || string(e.ResponseBody) != "a longer" || !e.ResponseTruncated {
+		t.Errorf("long bodies captured as %q/%q (truncated %v/%v), want the first 8 bytes, truncated", e.RequestBody, e.ResponseBody, e.RequestTruncated, e.ResponseTruncated)
+	}
+
+	// The captured requests replay.
+	req, err := exchanges[0].Request(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated || rec.Body.String() != "short" {
+		t.Errorf("replay: got %d %q, want 201 %q", rec.Code, rec.Body.String(), "short")
+	}
+}
+
+func TestCapture_Limits(t *testing.T) {
+	captureLogs(t)
+	handler := func(c *Capture) http.Handler {
+		return c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "ok")
+		}))
+	}
+	serve := func(h http.Handler, n int) {
+		for range n {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/trips", nil))
+		}
+	}
+
+	t.Run("max bytes", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "capture.ndjson")
+		c := NewCapture(path, WithCaptureMaxBytes(1000))
+		if err := c.Enable(0); err != nil {
+			t.Fatal(err)
+		}
+		serve(handler(c), 50)
+		if c.Enabled() {
+			t.Error("still enabled past the byte limit")
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 1000 || info.Size() == 0 {
+			t.Errorf("wrote %d bytes, want some up to 1000", info.Size())
+		}
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "capture.ndjson")
+		c := NewCapture(path, WithCaptureMaxFileSize(1000))
+		if err := c.Enable(0); err != nil {
+			t.Fatal(err)
+		}
+		serve(handler(c), 20)
+		c.Disable()
+		current, previous := readCaptureFile(t, path), readCaptureFile(t, path+".1")
+		if len(current) == 0 || len(previous) == 0 {
+			t.Fatalf("got %d and %d exchanges in the files, want both written", len(current), len(previous))
+		}
+		for _, p := range []string{path, path + ".1"} {
+			if info, _ := os.Stat(p); info.Size() > 1000 {
+				t.Errorf("%s has %d bytes, want up to 1000", filepath.Base(p), info.Size())
+			}
+		}
+	})
+
+	t.Run("duration", func(t *testing.T) {
+		c := NewCapture(filepath.Join(t.TempDir(), "capture.ndjson"))
+		if err := c.Enable(10 * time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(time.Second)
+		for c.Enabled() && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if c.Enabled() {
+			t.Error("still enabled after the duration")
+		}
+	})
+}
+
+func TestCapture_Handler(t *testing.T) {
+	captureLogs(t)
+	c := NewCapture(filepath.Join(t.TempDir(), "capture.ndjson"))
+	h := c.Handler()
+	do := func(method, body string) (int, captureStatusBody) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/capture", strings.NewReader(body)))
+		var status captureStatusBody
+		_ = json.NewDecoder(rec.Body).Decode(&status)
+		return rec.Code, status
+	}
+
+	if code, status := do(http.MethodPut, ""); code != http.StatusOK || !status.Enabled || status.Until == nil ||
+		time.Until(*status.Until) > defaultCaptureDuration || time.Until(*status.Until) < defaultCaptureDuration-time.Minute {
+		t.Errorf("PUT without a body: %d %+v, want enabled for the default duration", code, status)
+	}
+	if code, status := do(http.MethodPut, `{"duration":"1m"}`); code != http.StatusOK || status.Until == nil || time.Until(*status.Until) > time.Minute {
+		t.Errorf("PUT for 1m: %d %+v, want enabled for a minute", code, status)
+	}
+	if code, _ := do(http.MethodPut, `{"duration":"soon"}`); code != http.StatusBadRequest {
+		t.Errorf("PUT with a bad duration: got %d, want 400", code)
+	}
+	if code, status := do(http.MethodDelete, ""); code != http.StatusOK || status.Enabled {
+		t.Errorf("DELETE: %d %+v, want disabled", code, status)
+	}
+	if code, _ := do(http.MethodPost, ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got %d, want 405", code)
+	}
+}
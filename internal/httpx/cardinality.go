@@ -0,0 +1,104 @@
+package httpx
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// OverflowValue replaces attribute values beyond the limit set with
+// WithAttributeValueLimit.
+const OverflowValue = "__overflow__"
+
+// attributeGuard drops attributes that aren't allowed and caps the distinct
+// values each key may take. A value admitted once stays admitted, and one
+// refused stays refused, so up/down counters always see matching attributes.
+type attributeGuard struct {
+	allow map[attribute.Key]bool // nil allows every key
+	limit int64                  // 0 means no cap
+	keys  sync.Map               // attribute.Key to *keyValues
+
+	telemetry *Telemetry // counting overflows, nil for the globals
+}
+
+// maxRefusedValues bounds the refused values remembered per key, so that
+// each is counted once in http.server.attribute.overflow. Past it, refused
+// values are counted every time they are seen.
+const maxRefusedValues = 10000
+
+// keyValues is the set of values admitted for one key, and of those it
+// refused.
+type keyValues struct {
+	values   sync.Map // string to struct{}
+	n        atomic.Int64
+	refused  sync.Map // string to struct{}
+	nRefused atomic.Int64
+}
+
+func newAttributeGuard(allow []attribute.Key, limit int) *attributeGuard {
+	g := &attributeGuard{limit: int64(max(limit, 0))}
+	if allow != nil {
+		g.allow = make(map[attribute.Key]bool, len(allow))
+		for _, k := range allow {
+			g.allow[k] = true
+		}
+	}
+	return g
+}
+
+// filter returns attrs without the keys that aren't allowed and with
+// values over the cap replaced by OverflowValue. It may modify attrs.
+func (g *attributeGuard) filter(ctx context.Context, attrs []attribute.KeyValue) []attribute.KeyValue {
+	if g == nil {
+		return attrs
+	}
+	kept := attrs[:0]
+	for _, kv := range attrs {
+		if g.allow != nil && !g.allow[kv.Key] {
+			continue
+		}
+		if !g.admit(ctx, kv) {
+			kv = kv.Key.String(OverflowValue)
+		}
+		kept = append(kept, kv)
+	}
+	return kept
+}
+
+// admit reports whether kv may be recorded as is, counting the values it
+// refuses the first time they are.
+func (g *attributeGuard) admit(ctx context.Context, kv attribute.KeyValue) bool {
+	if g.limit == 0 {
+		return true
+	}
+	v, _ := g.keys.LoadOrStore(kv.Key, &keyValues{})
+	kvs := v.(*keyValues)
+
+	value := kv.Value.Emit()
+	if _, ok := kvs.values.Load(value); ok {
+		return true
+	}
+	if _, ok := kvs.refused.Load(value); ok {
+		return false
+	}
+	if kvs.n.Add(1) > g.limit {
+		kvs.n.Add(-1)
+		if kvs.nRefused.Load() < maxRefusedValues {
+			if _, loaded := kvs.refused.LoadOrStore(value, struct{}{}); loaded {
+				return false
+			}
+			kvs.nRefused.Add(1)
+		}
+		g.telemetry.serverMetrics().attrOverflow.Add(ctx, 1,
+			metric.WithAttributes(attribute.String("attribute.key", string(kv.Key))))
+		return false
+	}
+	if _, loaded := kvs.values.LoadOrStore(value, struct{}{}); loaded {
+		// Another request admitted the same value first.
+		kvs.n.Add(-1)
+	}
+	return true
+}
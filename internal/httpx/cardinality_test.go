@@ -0,0 +1,105 @@
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestMetricsMiddleware_AttributeValueLimit(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	const limit, requests = 50, 5000
+	handler := NewMetricsMiddleware(
+		WithRouteResolver(func(r *http.Request) string { return r.URL.Path }),
+		WithAttributeValueLimit(limit),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var wg sync.WaitGroup
+	for worker := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := worker; i < requests; i += 8 {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, fmt.Sprintf("/trips/%d", i), nil))
+			}
+		}()
+	}
+	wg.Wait()
+	// Values refused already are counted once.
+	for i := requests - 10; i < requests; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, fmt.Sprintf("/trips/%d", i), nil))
+	}
+
+	dps := collectSum(t, reader, "http.server.requests")
+	if len(dps) > limit+1 {
+		t.Errorf("expected at most %d series, got %d", limit+1, len(dps))
+	}
+	var total, overflowed int64
+	for _, dp := range dps {
+		total += dp.Value
+		if route, _ := dp.Attributes.Value("http.route"); route.AsString() == OverflowValue {
+			overflowed = dp.Value
+		}
+	}
+	if total != requests+10 {
+		t.Errorf("request count mismatch: got %d, want %d", total, requests+10)
+	}
+	if overflowed != requests-limit+10 {
+		t.Errorf("overflow series count mismatch: got %d, want %d", overflowed, requests-limit+10)
+	}
+
+	overflow := collectSum(t, reader, "http.server.attribute.overflow")
+	if len(overflow) != 1 {
+		t.Fatalf("expected overflows for a single key, got %+v", overflow)
+	}
+	if key, _ := overflow[0].Attributes.Value("attribute.key"); key.AsString() != "http.route" {
+		t.Errorf("overflow key mismatch: got %q", key.AsString())
+	}
+	if overflow[0].Value != requests-limit {
+		t.Errorf("expected %d refused values, got %d", requests-limit, overflow[0].Value)
+	}
+
+	for _, dp := range collectSum(t, reader, "http.server.active_requests") {
+		if dp.Value != 0 {
+			t.Errorf("active requests did not return to zero for %v: %d", dp.Attributes.ToSlice(), dp.Value)
+		}
+	}
+}
+
+func TestMetricsMiddleware_AttributeAllowlist(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	handler := NewMetricsMiddleware(
+		WithMetricAttributes(func(r *http.Request) []attribute.KeyValue {
+			return []attribute.KeyValue{
+				attribute.String("tenant", r.Header.Get("X-Tenant")),
+				attribute.String("user_agent", r.UserAgent()),
+			}
+		}),
+		WithAttributeAllowlist("http.method", "http.status_code", "tenant"),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/trips", nil)
+	req.Header.Set("X-Tenant", "acme")
+	req.Header.Set("User-Agent", "curl/8.0")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	dps := collectSum(t, reader, "http.server.requests")
+	if len(dps) != 1 {
+		t.Fatalf("expected one data point, got %d", len(dps))
+	}
+	got := map[attribute.Key]string{}
+	for _, kv := range dps[0].Attributes.ToSlice() {
+		got[kv.Key] = kv.Value.Emit()
+	}
+	want := map[attribute.Key]string{"http.method": "GET", "http.status_code": "200", "tenant": "acme"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("attributes mismatch: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,38 @@
+package httpx
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// Chain composes middlewares into one. The first middleware is the outermost:
+// Chain(a, b)(h) is a(b(h)).
+func Chain(middlewares ...func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// DefaultStack returns the standard middleware stack, outermost first:
+//
+//   - RecoverMiddleware, so a panic anywhere below still becomes a 500;
+//   - RequestIDMiddleware, so the ID is known before the span starts;
+//   - TracingMiddleware, so everything below runs inside the server span;
+//   - MetricsMiddleware, counting panics and attaching trace exemplars;
+//   - AccessLogMiddleware, so each line carries the request and trace IDs.
+//
+// The middlewares share one response writer wrapper and per-request state,
+// so the route, status and panic seen by each of them agree. A nil logger
+// means slog.Default().
+func DefaultStack(logger *slog.Logger) func(http.Handler) http.Handler {
+	return Chain(
+		RecoverMiddleware,
+		RequestIDMiddleware,
+		TracingMiddleware,
+		MetricsMiddleware,
+		AccessLogMiddleware(logger),
+	)
+}
@@ -0,0 +1,71 @@
+package httpx
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+)
+
+func TestChain_Order(t *testing.T) {
+	var calls []string
+	mark := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	Chain(mark("a"), mark("b"), mark("c"))(http.NotFoundHandler()).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got, want := strings.Join(calls, ","), "a,b,c"; got != want {
+		t.Errorf("call order mismatch: got %q, want %q", got, want)
+	}
+}
+
+func TestDefaultStack(t *testing.T) {
+	captureLogs(t)
+	reader := otelt.InstallMetrics(t)
+	exp := otelt.InstallTracing(t)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	rec := httptest.NewRecorder()
+	DefaultStack(logger)(http.HandlerFunc(panickingHandler)).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status mismatch: got %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+
+	dps := collectSum(t, reader, "http.server.errors")
+	if len(dps) != 1 || dps[0].Value != 1 {
+		t.Fatalf("error data points mismatch: got %+v, want a single point with value 1", dps)
+	}
+	if errType, _ := dps[0].Attributes.Value("error.type"); errType.AsString() != "panic" {
+		t.Errorf("error.type mismatch: got %q, want %q", errType.AsString(), "panic")
+	}
+
+	spans := exp.GetSpans()
+	lines := decodeLogLines(t, &buf)
+	if len(spans) != 1 || len(lines) != 1 {
+		t.Fatalf("expected 1 span and 1 log line, got %d and %d", len(spans), len(lines))
+	}
+	if got, want := lines[0]["trace_id"], spans[0].SpanContext.TraceID().String(); got != want {
+		t.Errorf("trace_id mismatch: got %v, want %v", got, want)
+	}
+	if got, want := lines[0]["request_id"], rec.Header().Get(RequestIDHeader); got != want || want == "" {
+		t.Errorf("request_id mismatch: got %v, want %q", got, want)
+	}
+	if got := lines[0]["http_status"]; got != float64(http.StatusInternalServerError) {
+		t.Errorf("http_status mismatch: got %v, want %d", got, http.StatusInternalServerError)
+	}
+}
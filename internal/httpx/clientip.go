@@ -0,0 +1,226 @@
+package httpx
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ClientIP returns the IP of the client that made r, as resolved by
+// ClientIPMiddleware, or else the IP of the direct peer.
+func ClientIP(r *http.Request) string {
+	if st := stateFromContext(r.Context()); st != nil {
+		st.mu.Lock()
+		ip := st.clientIP
+		st.mu.Unlock()
+		if ip != "" {
+			return ip
+		}
+	}
+	return remoteIP(r)
+}
+
+// ExternalURL returns the URL the client requested r at, with the scheme and
+// host it used as resolved by ClientIPMiddleware, for building absolute URLs
+// such as Location headers.
+func ExternalURL(r *http.Request) *url.URL {
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if st := stateFromContext(r.Context()); st != nil {
+		st.mu.Lock()
+		if st.scheme != "" {
+			scheme = st.scheme
+		}
+		if st.host != "" {
+			host = st.host
+		}
+		st.mu.Unlock()
+	}
+	return &url.URL{Scheme: scheme, Host: host, Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+}
+
+// ClientIPMiddleware resolves the client IP from the Forwarded,
+// X-Forwarded-For or X-Real-IP headers, in that order of preference, for
+// requests coming through one of the trusted proxy networks. Hops are read
+// right to left and the first address not in a trusted network is the
+// client, so a client cannot spoof its IP by sending the headers itself.
+// Requests from other peers are attributed to the peer, headers ignored.
+//
+// The scheme and host the client used are resolved the same way, taking
+// those the nearest proxy reported in the Forwarded header, or else in the
+// X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Port headers. They are
+// available through ExternalURL.
+//
+// The IP is available through ClientIP and is recorded as client.address on
+// the server span, along with url.scheme, server.address and server.port. It
+// is deliberately not a metric attribute.
+func ClientIPMiddleware(trusted ...netip.Prefix) func(http.Handler) http.Handler {
+	isTrusted := func(addr netip.Addr) bool { return inNetworks(trusted, addr) }
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := remoteIP(r)
+			var scheme, host string
+			if peer, err := netip.ParseAddr(ip); err == nil && isTrusted(peer.Unmap()) {
+				ip = forwardedClient(r.Header, peer.Unmap(), isTrusted).String()
+				scheme, host = forwardedOrigin(r.Header)
+			}
+
+			r, st := withRequestState(r)
+			st.mu.Lock()
+			st.clientIP = ip
+			st.scheme, st.host = scheme, host
+			st.mu.Unlock()
+			if span := trace.SpanFromContext(r.Context()); span.IsRecording() {
+				span.SetAttributes(semconv.ClientAddress(ip))
+				span.SetAttributes(originAttributes(ExternalURL(r))...)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// inNetworks reports whether addr is in one of the networks.
+func inNetworks(networks []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range networks {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedOrigin returns the scheme and host, with a port unless it is the
+// scheme's default, the nearest proxy received the request for. Either is
+// empty if not reported or not valid.
+func forwardedOrigin(h http.Header) (scheme, host string) {
+	scheme = strings.ToLower(forwardedValue(h, "proto", "X-Forwarded-Proto"))
+	if scheme != "http" && scheme != "https" {
+		scheme = ""
+	}
+	host = forwardedValue(h, "host", "X-Forwarded-Host")
+	if host == "" || strings.ContainsAny(host, " /\\@?#") {
+		return scheme, ""
+	}
+	if _, port, err := net.SplitHostPort(host); err == nil {
+		if !validPort(port) {
+			return scheme, ""
+		}
+	} else if port := h.Get("X-Forwarded-Port"); validPort(port) && port != defaultPort(scheme) {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+	return scheme, host
+}
+
+func validPort(port string) bool {
+	n, err := strconv.ParseUint(port, 10, 16)
+	return err == nil && n > 0
+}
+
+func defaultPort(scheme string) string {
+	if scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// originAttributes returns the span attributes describing the scheme, host
+// and port of u.
+func originAttributes(u *url.URL) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{semconv.URLScheme(u.Scheme), semconv.ServerAddress(u.Hostname())}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort(u.Scheme)
+	}
+	if n, err := strconv.Atoi(port); err == nil {
+		attrs = append(attrs, semconv.ServerPort(n))
+	}
+	return attrs
+}
+
+// forwardedValue returns what the nearest proxy reported for param in the
+// Forwarded header, or else in the xHeader header.
+func forwardedValue(h http.Header, param, xHeader string) string {
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		elements := strings.Split(values[len(values)-1], ",")
+		for _, pair := range strings.Split(elements[len(elements)-1], ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			if strings.EqualFold(key, param) {
+				return strings.Trim(value, `"`)
+			}
+		}
+		return ""
+	}
+	values := strings.Split(h.Get(xHeader), ",")
+	return strings.TrimSpace(values[len(values)-1])
+}
+
+// forwardedClient walks the forwarding chain back from the trusted peer
+// and returns the first untrusted hop. A malformed hop ends the walk at the
+// last valid one, which a trusted proxy vouched for.
+func forwardedClient(h http.Header, peer netip.Addr, isTrusted func(netip.Addr) bool) netip.Addr {
+	client := peer
+	hops := forwardedHops(h)
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			break
+		}
+		client = addr
+		if !isTrusted(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedHops returns the client addresses listed by the forwarding
+// headers, nearest to the client first.
+func forwardedHops(h http.Header) []string {
+	var hops []string
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		for _, v := range values {
+			for _, element := range strings.Split(v, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+					if strings.EqualFold(key, "for") {
+						hops = append(hops, strings.Trim(value, `"`))
+					}
+				}
+			}
+		}
+		return hops
+	}
+	if values := h.Values("X-Forwarded-For"); len(values) > 0 {
+		for _, v := range values {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+		return hops
+	}
+	if v := h.Get("X-Real-IP"); v != "" {
+		return []string{v}
+	}
+	return nil
+}
+
+// parseHop parses an address from a forwarding header, which may carry a
+// port and, for IPv6 in Forwarded, brackets.
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.TrimSpace(hop)
+	if addr, err := netip.ParseAddr(strings.Trim(hop, "[]")); err == nil {
+		return addr.Unmap(), true
+	}
+	if ap, err := netip.ParseAddrPort(hop); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	return netip.Addr{}, false
+}
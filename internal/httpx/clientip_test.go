@@ -0,0 +1,267 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+func TestClientIPMiddleware(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{name: "no proxy", peer: "203.0.113.7:5000", want: "203.0.113.7"},
+		{
+			name:    "spoofed header from an untrusted peer",
+			peer:    "203.0.113.7:5000",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "1.2.3.4"},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "single trusted proxy",
+			peer:    "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.9"},
+			want:    "198.51.100.9",
+		},
+		{
+			name:    "multi-hop chain through trusted proxies",
+			peer:    "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.9, 10.1.2.3, 10.4.5.6"},
+			want:    "198.51.100.9",
+		},
+		{
+			name:    "client prepending a spoofed hop",
+			peer:    "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.9, 10.1.2.3"},
+			want:    "198.51.100.9",
+		},
+		{
+			name:    "malformed hop stops at the last vouched address",
+			peer:    "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.9, garbage, 10.1.2.3"},
+			want:    "10.1.2.3",
+		},
+		{
+			name:    "all hops trusted",
+			peer:    "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-For": "10.9.9.9, 10.1.2.3"},
+			want:    "10.9.9.9",
+		},
+		{
+			name: "Forwarded is preferred",
+			peer: "10.0.0.2:5000",
+			headers: map[string]string{
+				"Forwarded":       `for=192.0.2.60;proto=https, for="[2001:db8::1]:4711", for=10.1.2.3`,
+				"X-Forwarded-For": "198.51.100.9",
+			},
+			want: "2001:db8::1",
+		},
+		{
+			name:    "X-Real-IP",
+			peer:    "[fd00::1]:5000",
+			headers: map[string]string{"X-Real-IP": "198.51.100.9"},
+			want:    "198.51.100.9",
+		},
+		{
+			name: "trusted proxy without headers",
+			peer: "10.0.0.2:5000",
+			want: "10.0.0.2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := ClientIPMiddleware(trusted...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.peer
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("client IP mismatch: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPMiddleware_Observability(t *testing.T) {
+	otelt.InstallTracing(t)
+	reader := otelt.InstallMetrics(t)
+	logs := captureLogs(t)
+
+	stack := Chain(
+		ClientIPMiddleware(netip.MustParsePrefix("10.0.0.0/8")),
+		TracingMiddleware,
+		MetricsMiddleware,
+		AccessLogMiddleware(nil),
+	)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.2:5000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	stack(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := otelt.Spans(t)
+	if len(spans) != 1 {
+		t.Fatalf("expected one span, got %d", len(spans))
+	}
+	var spanIP string
+	for _, kv := range spans[0].Attributes() {
+		if kv.Key == semconv.ClientAddressKey {
+			spanIP = kv.Value.AsString()
+		}
+	}
+	if spanIP != "198.51.100.9" {
+		t.Errorf("client.address mismatch: got %q", spanIP)
+	}
+
+	if lines := decodeLogLines(t, logs); len(lines) != 1 || lines[0]["client_ip"] != "198.51.100.9" {
+		t.Errorf("access log client_ip mismatch: %v", lines)
+	}
+
+	for _, dp := range collectSum(t, reader, "http.server.requests") {
+		if _, ok := dp.Attributes.Value(semconv.ClientAddressKey); ok {
+			t.Error("client IP recorded as a metric attribute")
+		}
+	}
+}
+
+func TestRateLimitMiddleware_UsesClientIP(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	handler := ClientIPMiddleware(netip.MustParsePrefix("10.0.0.0/8"))(
+		RateLimitMiddleware(0, 1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	for _, tt := range []struct {
+		client string
+		want   int
+	}{
+		{client: "198.51.100.1", want: http.StatusOK},
+		{client: "198.51.100.2", want: http.StatusOK},
+		{client: "198.51.100.1", want: http.StatusTooManyRequests},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.2:5000"
+		req.Header.Set("X-Forwarded-For", tt.client)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status mismatch: got %d, want %d", tt.client, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestExternalURL(t *testing.T) {
+	trusted := netip.MustParsePrefix("10.0.0.0/8")
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{name: "direct", peer: "203.0.113.7:5000", want: "http://internal:8080/trips?page=2"},
+		{
+			name:    "spoofed headers from an untrusted peer",
+			peer:    "203.0.113.7:5000",
+			headers: map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example", "Forwarded": "proto=https;host=evil.example"},
+			want:    "http://internal:8080/trips?page=2",
+		},
+		{
+			name:    "X-Forwarded headers",
+			peer:    "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "api.acai.travel", "X-Forwarded-Port": "443"},
+			want:    "https://api.acai.travel/trips?page=2",
+		},
+		{
+			name:    "non-default port",
+			peer:    "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "api.acai.travel", "X-Forwarded-Port": "8443"},
+			want:    "https://api.acai.travel:8443/trips?page=2",
+		},
+		{
+			name:    "nearest proxy wins",
+			peer:    "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-Proto": "http, https", "X-Forwarded-Host": "evil.example, api.acai.travel"},
+			want:    "https://api.acai.travel/trips?page=2",
+		},
+		{
+			name: "Forwarded is preferred",
+			peer: "10.0.0.2:5000",
+			headers: map[string]string{
+				"Forwarded":         `for=198.51.100.9;proto=http;host=evil.example, for=10.1.2.3;proto=https;host="api.acai.travel:8443"`,
+				"X-Forwarded-Proto": "http",
+				"X-Forwarded-Host":  "other.example",
+			},
+			want: "https://api.acai.travel:8443/trips?page=2",
+		},
+		{
+			name:    "invalid values ignored",
+			peer:    "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-Proto": "javascript", "X-Forwarded-Host": "evil.example/phish"},
+			want:    "http://internal:8080/trips?page=2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := ClientIPMiddleware(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ExternalURL(r).String()
+			}))
+			req := httptest.NewRequest(http.MethodGet, "http://internal:8080/trips?page=2", nil)
+			req.RemoteAddr = tt.peer
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("ExternalURL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPMiddleware_OriginObservability(t *testing.T) {
+	otelt.InstallTracing(t)
+	logs := captureLogs(t)
+
+	stack := Chain(ClientIPMiddleware(netip.MustParsePrefix("10.0.0.0/8")), TracingMiddleware, AccessLogMiddleware(nil))
+	req := httptest.NewRequest(http.MethodGet, "http://internal:8080/trips", nil)
+	req.RemoteAddr = "10.0.0.2:5000"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "api.acai.travel")
+	stack(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := otelt.Spans(t)
+	if len(spans) != 1 {
+		t.Fatalf("expected one span, got %d", len(spans))
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range spans[0].Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs[semconv.URLSchemeKey].AsString() != "https" || attrs[semconv.ServerAddressKey].AsString() != "api.acai.travel" || attrs[semconv.ServerPortKey].AsInt64() != 443 {
+		t.Errorf("span origin = %v %v %v, want https api.acai.travel 443",
+			attrs[semconv.URLSchemeKey].Emit(), attrs[semconv.ServerAddressKey].Emit(), attrs[semconv.ServerPortKey].Emit())
+	}
+
+	if lines := decodeLogLines(t, logs); len(lines) != 1 || lines[0]["http_scheme"] != "https" || lines[0]["http_host"] != "api.acai.travel" {
+		t.Errorf("access log origin mismatch: %v", lines)
+	}
+}
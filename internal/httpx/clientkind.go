@@ -0,0 +1,105 @@
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// OtherClientKind is the client.kind of the user agents no ClientRule
+// matches, including requests without one.
+const OtherClientKind = "other"
+
+// maxClientKinds bounds the distinct client.kind values, other included.
+const maxClientKinds = 16
+
+var clientKindRE = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// ClientRule maps the User-Agent headers it matches to a kind of client,
+// such as ios or partner. Give it either a Prefix or a Pattern.
+type ClientRule struct {
+	Kind    string // lowercase letters, digits and underscores
+	Prefix  string // matched at the start of the User-Agent
+	Pattern string // a regular expression, matched anywhere within it
+}
+
+// DefaultClientRules tells the apps from browsers and integrations by the
+// HTTP libraries in their user agents: crawlers are bot, URLSession and
+// Alamofire are ios, OkHttp and Dalvik are android, browsers are web, and
+// the usual server-side libraries are partner. Apps setting their own user
+// agent are told apart by the OS they name, as in
+// "AcaiTravel/3.2 (Android 14; Pixel 8)".
+func DefaultClientRules() []ClientRule {
+	return []ClientRule{
+		{Kind: "bot", Pattern: `(?i)(bot|crawler|spider)\b`},
+		{Kind: "ios", Pattern: `CFNetwork/|Alamofire/|[(;] ?iOS \d`},
+		{Kind: "android", Pattern: `\bokhttp/|^Dalvik/|\(Android \d`},
+		{Kind: "web", Prefix: "Mozilla/"},
+		{Kind: "partner", Pattern: `^(Go-http-client|python-requests|python-httpx|aiohttp|axios|node-fetch|undici|Java|Apache-HttpClient|Faraday|RestSharp)\b`},
+	}
+}
+
+// WithClientKinds records the kind of client of each request as client.kind
+// on the request metrics and the span: that of the first of rules matching
+// its User-Agent, or OtherClientKind. The raw user agent stays on the span
+// only. Like Router.Handle, NewMetricsMiddleware panics on invalid rules.
+func WithClientKinds(rules ...ClientRule) MetricsOption {
+	return func(c *metricsConfig) { c.clientRules = rules }
+}
+
+type clientClassifier struct {
+	rules []compiledClientRule
+}
+
+type compiledClientRule struct {
+	kind   string
+	prefix string
+	re     *regexp.Regexp
+}
+
+func newClientClassifier(rules []ClientRule) (*clientClassifier, error) {
+	c := &clientClassifier{}
+	kinds := map[string]bool{OtherClientKind: true}
+	var errs []error
+	for i, rule := range rules {
+		fail := func(format string, args ...any) {
+			errs = append(errs, fmt.Errorf("client rule %d (%s): %s", i, rule.Kind, fmt.Sprintf(format, args...)))
+		}
+		if !clientKindRE.MatchString(rule.Kind) {
+			fail("kind %q is not lowercase letters, digits and underscores", rule.Kind)
+		}
+		if (rule.Prefix == "") == (rule.Pattern == "") {
+			fail("want either a prefix or a pattern")
+			continue
+		}
+		compiled := compiledClientRule{kind: rule.Kind, prefix: rule.Prefix}
+		if rule.Pattern != "" {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				fail("%v", err)
+				continue
+			}
+			compiled.re = re
+		}
+		kinds[rule.Kind] = true
+		c.rules = append(c.rules, compiled)
+	}
+	if len(kinds) > maxClientKinds {
+		errs = append(errs, fmt.Errorf("%d client kinds, want at most %d", len(kinds), maxClientKinds))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// classify returns the kind of client sending userAgent.
+func (c *clientClassifier) classify(userAgent string) string {
+	for _, rule := range c.rules {
+		if rule.re != nil && rule.re.MatchString(userAgent) || rule.re == nil && strings.HasPrefix(userAgent, rule.prefix) {
+			return rule.kind
+		}
+	}
+	return OtherClientKind
+}
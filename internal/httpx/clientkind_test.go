@@ -0,0 +1,127 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+)
+
+func TestDefaultClientRules(t *testing.T) {
+	clients, err := newClientClassifier(DefaultClientRules())
+	if err != nil {
+		t.Fatalf("newClientClassifier() unexpected error: %v", err)
+	}
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{"AcaiTravel/3.2.1 CFNetwork/1494.0.7 Darwin/23.4.0", "ios"},
+		{"AcaiTravel/3.2.1 (travel.acai.app; build:321; iOS 17.4.1) Alamofire/5.8.1", "ios"},
+		{"AcaiTravel/3.2 (iPhone15,2; iOS 17.4)", "ios"},
+		{"okhttp/4.12.0", "android"},
+		{"Dalvik/2.1.0 (Linux; U; Android 14; Pixel 8 Build/UQ1A.240205.004)", "android"},
+		{"AcaiTravel/3.2 (Android 14; Pixel 8)", "android"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15", "web"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Safari/537.36", "web"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1", "web"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/123.0.6312.52 Mobile/15E148 Safari/604.1", "web"},
+		{"Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Mobile Safari/537.36", "web"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:124.0) Gecko/20100101 Firefox/124.0", "web"},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "bot"},
+		{"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm) Chrome/116.0.1938.76 Safari/537.36", "bot"},
+		{"Go-http-client/2.0", "partner"},
+		{"python-requests/2.31.0", "partner"},
+		{"axios/1.6.8", "partner"},
+		{"Java/17.0.10", "partner"},
+		{"Apache-HttpClient/4.5.14 (Java/17.0.10)", "partner"},
+		{"curl/8.6.0", OtherClientKind},
+		{"PostmanRuntime/7.37.0", OtherClientKind},
+		{"Wget/1.21.4", OtherClientKind},
+		{"", OtherClientKind},
+	}
+	for _, tt := range tests {
+		if got := clients.classify(tt.userAgent); got != tt.want {
+			t.Errorf("classify(%q) = %q, want %q", tt.userAgent, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsMiddleware_ClientKinds(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+	exp := otelt.InstallTracing(t)
+
+	handler := TracingMiddleware(NewMetricsMiddleware(WithClientKinds(
+		ClientRule{Kind: "partner", Prefix: "AcmeTravelAgency/"},
+		ClientRule{Kind: "web", Pattern: `^Mozilla/`},
+	))(http.NotFoundHandler()))
+	for _, ua := range []string{
+		"AcmeTravelAgency/1.0", "Mozilla/5.0 (X11; Linux x86_64)", "curl/8.6.0", "Wget/1.21.4", "",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/trips", nil)
+		req.Header.Set("User-Agent", ua)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	counts := map[string]int64{}
+	for _, dp := range collectSum(t, reader, "http.server.requests") {
+		kind, _ := dp.Attributes.Value("client.kind")
+		counts[kind.AsString()] += dp.Value
+		if _, ok := dp.Attributes.Value("user_agent.original"); ok {
+			t.Errorf("raw user agent recorded in metrics: %v", dp.Attributes)
+		}
+	}
+	if len(counts) != 3 || counts["partner"] != 1 || counts["web"] != 1 || counts[OtherClientKind] != 3 {
+		t.Errorf("requests by client.kind %v, want 1 partner, 1 web and 3 other", counts)
+	}
+
+	spans := exp.GetSpans()
+	if len(spans) != 5 {
+		t.Fatalf("got %d spans, want 5", len(spans))
+	}
+	var kind, ua string
+	for _, kv := range spans[0].Attributes {
+		switch kv.Key {
+		case "client.kind":
+			kind = kv.Value.AsString()
+		case "user_agent.original":
+			ua = kv.Value.AsString()
+		}
+	}
+	if kind != "partner" || ua != "AcmeTravelAgency/1.0" {
+		t.Errorf("span client.kind %q and user agent %q, want partner and the raw one", kind, ua)
+	}
+}
+
+func TestWithClientKinds_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []ClientRule
+		want  string
+	}{
+		{name: "bad kind", rules: []ClientRule{{Kind: "iOS App", Prefix: "Acai/"}}, want: `kind "iOS App" is not lowercase`},
+		{name: "no matcher", rules: []ClientRule{{Kind: "ios"}}, want: "want either a prefix or a pattern"},
+		{name: "both matchers", rules: []ClientRule{{Kind: "ios", Prefix: "Acai/", Pattern: "iOS"}}, want: "want either a prefix or a pattern"},
+		{name: "bad pattern", rules: []ClientRule{{Kind: "ios", Pattern: "(iOS"}}, want: "missing closing )"},
+		{name: "too many kinds", rules: func() []ClientRule {
+			var rules []ClientRule
+			for _, c := range "abcdefghijklmnop" {
+				rules = append(rules, ClientRule{Kind: string(c), Prefix: string(c)})
+			}
+			return rules
+		}(), want: "17 client kinds, want at most 16"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				v := recover()
+				if msg, _ := v.(string); !strings.Contains(msg, tt.want) {
+					t.Errorf("panic %v, want one mentioning %q", v, tt.want)
+				}
+			}()
+			NewMetricsMiddleware(WithClientKinds(tt.rules...))
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package httpx
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithConnectionTrace records where the time of each request goes: DNS
+// lookups, connects and TLS handshakes as child spans of the client span,
+// and getting a connection, writing the request and the first response
+// byte as its events. Whether the connection was reused from the pool, and
+// for how long it had been idle, are attributes of the client span; a pool
+// too small for the load shows as few reused connections. Off by default,
+// since most requests are served by a pooled connection and the spans add
+// little but noise.
+func WithConnectionTrace() TransportOption {
+	return func(t *Transport) { t.connTrace = true }
+}
+
+// connTrace records the connection phases of a request on its client span.
+// The hooks of a ClientTrace may be called concurrently, e.g. when dialing
+// several addresses of a host at once, or after the round trip for a dial
+// it no longer waits for.
+type connTrace struct {
+	ctx  context.Context // of the client span
+	span trace.Span
+
+	mu      sync.Mutex
+	dns     trace.Span
+	connect map[string]trace.Span // by network address
+	tls     trace.Span
+}
+
+func newConnTrace(ctx context.Context, span trace.Span) *httptrace.ClientTrace {
+	c := &connTrace{ctx: ctx, span: span, connect: map[string]trace.Span{}}
+	return &httptrace.ClientTrace{
+		DNSStart:             c.dnsStart,
+		DNSDone:              c.dnsDone,
+		ConnectStart:         c.connectStart,
+		ConnectDone:          c.connectDone,
+		TLSHandshakeStart:    c.tlsStart,
+		TLSHandshakeDone:     c.tlsDone,
+		GotConn:              c.gotConn,
+		WroteRequest:         c.wroteRequest,
+		GotFirstResponseByte: c.gotFirstByte,
+	}
+}
+
+func (c *connTrace) start(name string, attrs ...attribute.KeyValue) trace.Span {
+	_, span := Tracer().Start(c.ctx, name, trace.WithAttributes(attrs...))
+	return span
+}
+
+// endPhase ends span, failed with err if not nil. span may be nil, for the
+// hooks of a phase that was never started.
+func endPhase(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (c *connTrace) dnsStart(info httptrace.DNSStartInfo) {
+	span := c.start("dns", semconv.ServerAddress(info.Host))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dns = span
+}
+
+func (c *connTrace) dnsDone(info httptrace.DNSDoneInfo) {
+	c.mu.Lock()
+	span := c.dns
+	c.dns = nil
+	c.mu.Unlock()
+	if span != nil {
+		span.SetAttributes(attribute.Int("dns.addresses", len(info.Addrs)))
+	}
+	endPhase(span, info.Err)
+}
+
+func (c *connTrace) connectStart(network, addr string) {
+	span := c.start("connect", semconv.NetworkTransportKey.String(network), semconv.NetworkPeerAddress(addr))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connect[network+" "+addr] = span
+}
+
+func (c *connTrace) connectDone(network, addr string, err error) {
+	c.mu.Lock()
+	span := c.connect[network+" "+addr]
+	delete(c.connect, network+" "+addr)
+	c.mu.Unlock()
+	endPhase(span, err)
+}
+
+func (c *connTrace) tlsStart() {
+	span := c.start("tls")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tls = span
+}
+
+func (c *connTrace) tlsDone(state tls.ConnectionState, err error) {
+	c.mu.Lock()
+	span := c.tls
+	c.tls = nil
+	c.mu.Unlock()
+	if span != nil && err == nil {
+		span.SetAttributes(
+			semconv.TLSProtocolVersion(tls.VersionName(state.Version)),
+			semconv.TLSResumed(state.DidResume),
+		)
+	}
+	endPhase(span, err)
+}
+
+func (c *connTrace) gotConn(info httptrace.GotConnInfo) {
+	attrs := []attribute.KeyValue{
+		attribute.Bool("http.connection.reused", info.Reused),
+		attribute.Bool("http.connection.was_idle", info.WasIdle),
+	}
+	if info.WasIdle {
+		attrs = append(attrs, attribute.Float64("http.connection.idle_time", info.IdleTime.Seconds()))
+	}
+	c.span.SetAttributes(attrs...)
+	c.span.AddEvent("got connection")
+}
+
+func (c *connTrace) wroteRequest(info httptrace.WroteRequestInfo) {
+	if info.Err != nil {
+		c.span.AddEvent("wrote request", trace.WithAttributes(attribute.String("error", info.Err.Error())))
+		return
+	}
+	c.span.AddEvent("wrote request")
+}
+
+func (c *connTrace) gotFirstByte() {
+	c.span.AddEvent("first response byte")
+}
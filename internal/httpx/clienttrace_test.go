@@ -0,0 +1,92 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTransport_ConnectionTrace(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	exp := otelt.InstallTracing(t)
+
+	// localhost for a DNS lookup, verified as the example.com the test
+	// certificate is for.
+	base := srv.Client().Transport.(*http.Transport).Clone()
+	base.TLSClientConfig.ServerName = "example.com"
+	client := &http.Client{Transport: NewTransport(base, WithConnectionTrace())}
+	url := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	for range 2 {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	var clients []tracetest.SpanStub
+	for _, s := range exp.GetSpans() {
+		if s.Name == http.MethodGet {
+			clients = append(clients, s)
+		}
+	}
+	if len(clients) != 2 {
+		t.Fatalf("got %d client spans, want 2", len(clients))
+	}
+	// The second request may dial too, before the first connection is back
+	// in the pool, so only the phases of the first are certain.
+	phases := map[string]int{}
+	for _, s := range exp.GetSpans() {
+		if s.Parent.SpanID() == clients[0].SpanContext.SpanID() {
+			phases[s.Name]++
+		}
+	}
+	if phases["dns"] != 1 || phases["connect"] < 1 || phases["tls"] != 1 {
+		t.Errorf("phase spans %v, want one DNS lookup and TLS handshake for the new connection", phases)
+	}
+
+	for i, wantReused := range []bool{false, true} {
+		if got := attrValue(clients[i].Attributes, "http.connection.reused"); got != attribute.BoolValue(wantReused) {
+			t.Errorf("request %d: http.connection.reused = %v, want %v", i+1, got.Emit(), wantReused)
+		}
+		var events []string
+		for _, e := range clients[i].Events {
+			events = append(events, e.Name)
+		}
+		if got, want := strings.Join(events, ","), "got connection,wrote request,first response byte"; got != want {
+			t.Errorf("request %d: events %q, want %q", i+1, got, want)
+		}
+	}
+}
+
+func TestTransport_NoConnectionTrace(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	exp := otelt.InstallTracing(t)
+
+	resp, err := (&http.Client{Transport: NewTransport(nil)}).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+
+	spans := exp.GetSpans()
+	if len(spans) != 1 || len(spans[0].Events) != 0 || attrValue(spans[0].Attributes, "http.connection.reused").Type() != attribute.INVALID {
+		t.Errorf("spans %+v, want the client span alone without connection details", spans)
+	}
+}
+
+func attrValue(attrs []attribute.KeyValue, key attribute.Key) attribute.Value {
+	for _, a := range attrs {
+		if a.Key == key {
+			return a.Value
+		}
+	}
+	return attribute.Value{}
+}
@@ -0,0 +1,306 @@
+package httpx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// defaultBrotliQuality compresses JSON some 15% smaller than gzip's default
+// level, for several times its CPU; quality 4 is not much better than gzip
+// and 6 not much better than 5. See BenchmarkCompress.
+const defaultBrotliQuality = 5
+
+// CompressOption configures NewCompressMiddleware.
+type CompressOption func(*compressConfig)
+
+type compressConfig struct {
+	minSize    int
+	level      int
+	quality    int
+	brotli     bool
+	gzipPool   *sync.Pool
+	brotliPool *sync.Pool
+}
+
+// WithCompressMinSize leaves responses smaller than n bytes uncompressed,
+// where the overhead of compressing outweighs the savings. Defaults to
+// 1KiB.
+func WithCompressMinSize(n int) CompressOption {
+	return func(c *compressConfig) { c.minSize = n }
+}
+
+// WithCompressLevel sets the gzip level. Defaults to gzip.DefaultCompression.
+func WithCompressLevel(level int) CompressOption {
+	return func(c *compressConfig) { c.level = level }
+}
+
+// WithBrotliQuality sets the brotli quality, from brotli.BestSpeed (0) to
+// brotli.BestCompression (11). Defaults to 5.
+func WithBrotliQuality(quality int) CompressOption {
+	return func(c *compressConfig) { c.quality = quality }
+}
+
+// WithoutBrotli only ever gzips, for clients mishandling br.
+func WithoutBrotli() CompressOption {
+	return func(c *compressConfig) { c.brotli = false }
+}
+
+// CompressMiddleware compresses responses using the default options.
+func CompressMiddleware(next http.Handler) http.Handler {
+	return NewCompressMiddleware()(next)
+}
+
+// NewCompressMiddleware returns a middleware compressing responses with
+// brotli or gzip, whichever the client accepts, preferring brotli, except
+// small ones and content types that are already compressed. Place it inside
+// MetricsMiddleware so response sizes are the bytes actually sent; they are
+// labelled with http.response.compressed and
+// http.response.content_encoding.
+func NewCompressMiddleware(opts ...CompressOption) func(http.Handler) http.Handler {
+	cfg := compressConfig{minSize: 1 << 10, level: gzip.DefaultCompression, quality: defaultBrotliQuality, brotli: true}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if _, err := gzip.NewWriterLevel(nil, cfg.level); err != nil {
+		cfg.level = gzip.DefaultCompression
+	}
+	if cfg.quality < brotli.BestSpeed || cfg.quality > brotli.BestCompression {
+		cfg.quality = defaultBrotliQuality
+	}
+	cfg.gzipPool = &sync.Pool{New: func() any {
+		gz, _ := gzip.NewWriterLevel(nil, cfg.level)
+		return gz
+	}}
+	cfg.brotliPool = &sync.Pool{New: func() any {
+		return brotli.NewWriterLevel(nil, cfg.quality)
+	}}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), cfg.brotli)
+			if r.Method == http.MethodHead || encoding == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			r, st := withRequestState(r)
+			cw := &compressWriter{ResponseWriter: w, cfg: &cfg, st: st, encoding: encoding}
+			completed := false
+			defer func() {
+				// A panicking handler's buffered output is dropped so the
+				// recovery middleware can still send a 500.
+				if completed || cw.decided {
+					cw.close()
+				}
+			}()
+			next.ServeHTTP(cw, r)
+			completed = true
+		})
+	}
+}
+
+// compressWriter holds back the start of the response until it knows
+// whether to compress it: as soon as minSize bytes are buffered, the handler
+// flushes, or the handler returns.
+type compressWriter struct {
+	http.ResponseWriter
+	cfg      *compressConfig
+	st       *requestState
+	encoding string // br or gzip
+
+	status  int
+	buf     bytes.Buffer
+	decided bool
+	enc     encoder
+}
+
+// encoder is a *gzip.Writer or a *brotli.Writer.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// pool returns the pool of encoders of w's encoding.
+func (w *compressWriter) pool() *sync.Pool {
+	if w.encoding == "br" {
+		return w.cfg.brotliPool
+	}
+	return w.cfg.gzipPool
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	if code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+	if !bodyAllowed(code) {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		w.buf.Write(b)
+		if w.buf.Len() < w.cfg.minSize {
+			return len(b), nil
+		}
+		w.decide(true)
+		return len(b), w.flushBuffer()
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends what the handler wrote so far, compressed if eligible,
+// keeping streaming endpoints working.
+func (w *compressWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		w.decide(true)
+		_ = w.flushBuffer()
+	}
+	if w.enc != nil {
+		_ = w.enc.Flush()
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decide writes the headers, compressing when big is set and the response
+// is eligible.
+func (w *compressWriter) decide(big bool) {
+	w.decided = true
+	h := w.Header()
+	if big && bodyAllowed(w.status) && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type"), w.buf.Bytes()) {
+		if h.Get("Content-Type") == "" {
+			// net/http would sniff the compressed bytes instead.
+			h.Set("Content-Type", http.DetectContentType(w.buf.Bytes()))
+		}
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		w.enc = w.pool().Get().(encoder)
+		w.enc.Reset(w.ResponseWriter)
+		w.st.mu.Lock()
+		w.st.encoding = w.encoding
+		w.st.mu.Unlock()
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+func (w *compressWriter) flushBuffer() error {
+	defer w.buf.Reset()
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	if w.enc != nil {
+		_, err := w.enc.Write(w.buf.Bytes())
+		return err
+	}
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	return err
+}
+
+func (w *compressWriter) close() {
+	if w.status == 0 && !w.decided {
+		// Nothing written: leave the implicit 200 to net/http.
+		return
+	}
+	if !w.decided {
+		w.decide(w.buf.Len() >= w.cfg.minSize)
+		_ = w.flushBuffer()
+	}
+	if w.enc != nil {
+		_ = w.enc.Close()
+		w.enc.Reset(nil)
+		w.pool().Put(w.enc)
+		w.enc = nil
+	}
+}
+
+// negotiateEncoding returns the coding to compress with for an
+// Accept-Encoding header: that of the highest quality among br, when
+// allowed, and gzip, preferring br on a tie, or none if neither is
+// acceptable. A * stands for the codings the header doesn't list.
+func negotiateEncoding(header string, allowBrotli bool) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "br" && coding != "gzip" && coding != "*" {
+			continue
+		}
+		v := 1.0
+		if s, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				v = f
+			}
+		}
+		if _, seen := q[coding]; !seen {
+			q[coding] = v
+		}
+	}
+	quality := func(coding string) float64 {
+		if v, ok := q[coding]; ok {
+			return v
+		}
+		return q["*"]
+	}
+	br, gz := quality("br"), quality("gzip")
+	switch {
+	case allowBrotli && br > 0 && br >= gz:
+		return "br"
+	case gz > 0:
+		return "gzip"
+	}
+	return ""
+}
+
+// compressible reports whether a response of the given content type is
+// worth compressing; media that is compressed already is not.
+func compressible(contentType string, sniff []byte) bool {
+	if contentType == "" {
+		contentType = http.DetectContentType(sniff)
+	}
+	switch mt := mediaType(contentType); {
+	case strings.HasPrefix(mt, "image/") && mt != "image/svg+xml",
+		strings.HasPrefix(mt, "video/"),
+		strings.HasPrefix(mt, "audio/"),
+		strings.HasPrefix(mt, "font/woff"):
+		return false
+	case mt == "application/zip", mt == "application/gzip", mt == "application/x-gzip",
+		mt == "application/zstd", mt == "application/x-7z-compressed", mt == "application/pdf",
+		mt == "application/octet-stream":
+		return false
+	}
+	return true
+}
+
+// bodyAllowed reports whether a response with the given status may have a
+// body.
+func bodyAllowed(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified && status >= 200
+}
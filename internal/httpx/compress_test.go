@@ -0,0 +1,241 @@
+package httpx
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"github.com/andybalholm/brotli"
+)
+
+func TestCompressMiddleware(t *testing.T) {
+	large := `{"trips":[` + strings.Repeat(`{"destination":"Lisbon","nights":3},`, 200) + `{}]}`
+
+	tests := []struct {
+		name         string
+		opts         []CompressOption
+		accept       string
+		contentType  string
+		body         string
+		wantEncoding string // empty for uncompressed
+	}{
+		{name: "large JSON", accept: "gzip, deflate, br", contentType: "application/json", body: large, wantEncoding: "br"},
+		{name: "gzip only", accept: "gzip, deflate", contentType: "application/json", body: large, wantEncoding: "gzip"},
+		{name: "gzip preferred", accept: "br;q=0.5, gzip", contentType: "application/json", body: large, wantEncoding: "gzip"},
+		{name: "brotli disabled", opts: []CompressOption{WithoutBrotli()}, accept: "br, gzip", contentType: "application/json", body: large, wantEncoding: "gzip"},
+		{name: "brotli quality", opts: []CompressOption{WithBrotliQuality(11)}, accept: "br", contentType: "application/json", body: large, wantEncoding: "br"},
+		{name: "small response", accept: "br, gzip", contentType: "application/json", body: `{"ok":true}`},
+		{name: "client without compression", accept: "identity, deflate", contentType: "application/json", body: large},
+		{name: "both refused with q=0", accept: "gzip;q=0, br;q=0", contentType: "application/json", body: large},
+		{name: "already compressed media", accept: "br, gzip", contentType: "image/png", body: large},
+		{name: "sniffed content type", accept: "gzip", body: large, wantEncoding: "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := otelt.InstallMetrics(t)
+
+			handler := MetricsMiddleware(NewCompressMiddleware(tt.opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.Header().Set("Content-Length", "123")
+				_, _ = io.WriteString(w, tt.body)
+			})))
+			req := httptest.NewRequest(http.MethodGet, "/trips", nil)
+			req.Header.Set("Accept-Encoding", tt.accept)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary mismatch: got %q, want %q", got, "Accept-Encoding")
+			}
+			body := rec.Body.String()
+			if tt.wantEncoding != "" {
+				if rec.Header().Get("Content-Encoding") != tt.wantEncoding || rec.Header().Get("Content-Length") != "" {
+					t.Fatalf("headers mismatch: %v", rec.Header())
+				}
+				if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") && tt.contentType == "" {
+					t.Errorf("sniffed Content-Type mismatch: got %q", rec.Header().Get("Content-Type"))
+				}
+				if plain := decompress(t, tt.wantEncoding, rec.Body); plain != tt.body {
+					t.Errorf("decompressed body mismatch")
+				}
+			} else {
+				if rec.Header().Get("Content-Encoding") != "" || body != tt.body {
+					t.Errorf("response unexpectedly compressed: %v", rec.Header())
+				}
+			}
+
+			dps := collectIntHistogram(t, reader, "http.server.response.size")
+			if len(dps) != 1 {
+				t.Fatalf("expected 1 series, got %d", len(dps))
+			}
+			if dps[0].Sum != int64(len(body)) {
+				t.Errorf("recorded size mismatch: got %d, want %d bytes sent", dps[0].Sum, len(body))
+			}
+			if compressed, _ := dps[0].Attributes.Value("http.response.compressed"); compressed.AsBool() != (tt.wantEncoding != "") {
+				t.Errorf("http.response.compressed mismatch: got %v, want %v", compressed.AsBool(), tt.wantEncoding != "")
+			}
+			if encoding, _ := dps[0].Attributes.Value("http.response.content_encoding"); encoding.AsString() != tt.wantEncoding {
+				t.Errorf("http.response.content_encoding mismatch: got %q, want %q", encoding.AsString(), tt.wantEncoding)
+			}
+		})
+	}
+}
+
+// decompress returns body decoded from encoding.
+func decompress(t testing.TB, encoding string, body io.Reader) string {
+	t.Helper()
+	var r io.Reader
+	switch encoding {
+	case "br":
+		r = brotli.NewReader(body)
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			t.Fatalf("gzip.NewReader() unexpected error: %v", err)
+		}
+		r = gz
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decompress %s: %v", encoding, err)
+	}
+	return string(plain)
+}
+
+func TestCompressMiddleware_Flush(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(CompressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = io.WriteString(w, "data: second\n\n")
+	})))
+	defer srv.Close()
+	defer close(release)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding mismatch: got %q, want gzip", resp.Header.Get("Content-Encoding"))
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() unexpected error: %v", err)
+	}
+	line, err := bufio.NewReader(gz).ReadString('\n')
+	if err != nil || line != "data: first\n" {
+		t.Errorf("first event mismatch before the handler finished: got %q, %v", line, err)
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                    "",
+		"gzip":                "gzip",
+		"GZIP":                "gzip",
+		"br, gzip;q=0.5":      "br",
+		"gzip, br":            "br",
+		"br;q=0.5, gzip":      "gzip",
+		"br":                  "br",
+		"gzip;q=0":            "",
+		"gzip;q=0, br":        "br",
+		"*":                   "br",
+		"*;q=0.5, gzip":       "gzip",
+		"br;q=0, *":           "gzip",
+		"identity, deflate":   "",
+		"deflate, gzip, br;q": "br",
+	}
+	for header, want := range tests {
+		if got := negotiateEncoding(header, true); got != want {
+			t.Errorf("negotiateEncoding(%q) mismatch: got %q, want %q", header, got, want)
+		}
+	}
+	if got := negotiateEncoding("br, gzip", false); got != "gzip" {
+		t.Errorf("negotiateEncoding without brotli: got %q, want gzip", got)
+	}
+	if got := negotiateEncoding("br", false); got != "" {
+		t.Errorf("negotiateEncoding without brotli for a br only client: got %q, want none", got)
+	}
+}
+
+// BenchmarkCompress compares the CPU cost and output size of the gzip
+// levels and brotli qualities on a typical JSON response, to choose the
+// defaults from.
+func BenchmarkCompress(b *testing.B) {
+	type trip struct {
+		ID          string  `json:"id"`
+		Destination string  `json:"destination"`
+		Departure   string  `json:"departure"`
+		Nights      int     `json:"nights"`
+		Price       float64 `json:"price"`
+		Currency    string  `json:"currency"`
+		Notes       string  `json:"notes"`
+	}
+	cities := []string{"Lisbon", "Porto", "Seville", "Kyoto", "Reykjavik", "Marrakesh", "Lima", "Hanoi"}
+	trips := make([]trip, 150)
+	for i := range trips {
+		trips[i] = trip{
+			ID:          fmt.Sprintf("%08x", uint32(i)*2654435761),
+			Destination: cities[i*7%len(cities)],
+			Departure:   fmt.Sprintf("2024-%02d-%02d", i%12+1, i%28+1),
+			Nights:      i%14 + 1,
+			Price:       float64(i*7919%100000) / 100,
+			Currency:    []string{"EUR", "USD", "JPY"}[i%3],
+			Notes:       fmt.Sprintf("Booked via %s, seat %d%c, %d bags", []string{"web", "ios", "android", "partner"}[i%4], i%40+1, 'A'+rune(i%6), i%3),
+		}
+	}
+	body, err := json.Marshal(map[string]any{"trips": trips})
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarks := []struct {
+		name   string
+		opts   []CompressOption
+		accept string
+	}{
+		{"gzip/1", []CompressOption{WithCompressLevel(gzip.BestSpeed)}, "gzip"},
+		{"gzip/6", []CompressOption{WithCompressLevel(gzip.DefaultCompression)}, "gzip"},
+		{"gzip/9", []CompressOption{WithCompressLevel(gzip.BestCompression)}, "gzip"},
+		{"brotli/0", []CompressOption{WithBrotliQuality(0)}, "br"},
+		{"brotli/4", []CompressOption{WithBrotliQuality(4)}, "br"},
+		{"brotli/5", []CompressOption{WithBrotliQuality(5)}, "br"},
+		{"brotli/6", []CompressOption{WithBrotliQuality(6)}, "br"},
+		{"brotli/11", []CompressOption{WithBrotliQuality(11)}, "br"},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			handler := NewCompressMiddleware(bm.opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write(body)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/trips", nil)
+			req.Header.Set("Accept-Encoding", bm.accept)
+			var size int
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			for b.Loop() {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				size = rec.Body.Len()
+			}
+			b.ReportMetric(float64(size)/float64(len(body)), "ratio")
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package httpx
+
+import (
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// ConcurrencyLimitMiddleware serves at most limit requests at a time. Others
+// wait up to queueTimeout for a slot (not at all when it is zero) and are
+// then shed with a 503 and Retry-After. A request whose client goes away
+// while queued leaves the queue without being served.
+//
+// Queue depth, queue wait and shed requests are reported as
+// http.server.queue.depth, http.server.queue.wait and http.server.shed.
+func ConcurrencyLimitMiddleware(limit int, queueTimeout time.Duration) func(http.Handler) http.Handler {
+	slots := make(chan struct{}, max(limit, 1))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				next.ServeHTTP(w, r)
+				return
+			default:
+			}
+
+			ctx := r.Context()
+			sm := loadServerMetrics()
+			shed := func(reason string) {
+				sm.shed.Add(ctx, 1, metric.WithAttributes(attribute.String("shed.reason", reason)))
+			}
+			if queueTimeout <= 0 {
+				shed("full")
+				writeShed(w)
+				return
+			}
+
+			start := time.Now()
+			sm.queueDepth.Add(ctx, 1)
+			timer := time.NewTimer(queueTimeout)
+			defer timer.Stop()
+
+			var admitted bool
+			select {
+			case slots <- struct{}{}:
+				admitted = true
+			case <-timer.C:
+				shed("queue_timeout")
+				writeShed(w)
+			case <-ctx.Done():
+				shed("canceled")
+			}
+			sm.queueDepth.Add(ctx, -1)
+			sm.queueWait.Record(ctx, time.Since(start).Seconds(),
+				metric.WithAttributes(attribute.Bool("shed", !admitted)))
+
+			if admitted {
+				defer func() { <-slots }()
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func writeShed(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
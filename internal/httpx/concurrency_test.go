@@ -0,0 +1,118 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+)
+
+func TestConcurrencyLimitMiddleware_NeverExceedsLimit(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	const limit = 4
+	var inFlight, peak atomic.Int32
+	handler := ConcurrencyLimitMiddleware(limit, 20*time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		inFlight.Add(-1)
+	}))
+
+	var ok, shed atomic.Int32
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			switch rec.Code {
+			case http.StatusOK:
+				ok.Add(1)
+			case http.StatusServiceUnavailable:
+				if rec.Header().Get("Retry-After") == "" {
+					t.Error("shed response without Retry-After")
+				}
+				shed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if p := peak.Load(); p > limit {
+		t.Errorf("peak concurrency mismatch: got %d, want at most %d", p, limit)
+	}
+	if ok.Load()+shed.Load() != 100 || ok.Load() == 0 || shed.Load() == 0 {
+		t.Errorf("expected a mix of served and shed requests, got %d served and %d shed", ok.Load(), shed.Load())
+	}
+
+	var counted int64
+	for _, dp := range collectSum(t, reader, "http.server.shed") {
+		counted += dp.Value
+	}
+	if counted != int64(shed.Load()) {
+		t.Errorf("http.server.shed mismatch: got %d, want %d", counted, shed.Load())
+	}
+	for _, dp := range collectSum(t, reader, "http.server.queue.depth") {
+		if dp.Value != 0 {
+			t.Errorf("queue depth not back to zero: %d", dp.Value)
+		}
+	}
+	if hs := collectHistogram(t, reader, "http.server.queue.wait"); len(hs) == 0 {
+		t.Error("no queue wait recorded")
+	}
+}
+
+func TestConcurrencyLimitMiddleware_CanceledWhileQueued(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	release := make(chan struct{})
+	served := make(chan struct{}, 2)
+	handler := ConcurrencyLimitMiddleware(1, time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served <- struct{}{}
+		<-release
+	}))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	<-served
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := make(chan struct{})
+	go func() {
+		defer close(queued)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	<-queued
+
+	select {
+	case <-served:
+		t.Fatal("canceled request was served")
+	default:
+	}
+	for _, dp := range collectSum(t, reader, "http.server.queue.depth") {
+		if dp.Value != 0 {
+			t.Errorf("canceled request still queued: depth %d", dp.Value)
+		}
+	}
+
+	// Once the first request finishes, its slot goes to the next one.
+	close(release)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status mismatch: got %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,522 @@
+package httpx
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Trace samplers a Config can name, as in OTEL_TRACES_SAMPLER.
+const (
+	SamplerAlwaysOn     = "always_on"
+	SamplerAlwaysOff    = "always_off"
+	SamplerParentBased  = "parentbased_always_on"
+	SamplerTraceIDRatio = "parentbased_traceidratio"
+)
+
+// Config gathers the telemetry and middleware settings of a service, for
+// InitTelemetryFromConfig and NewStackFromConfig. LoadFromEnv fills it from
+// the environment variable given for each field.
+type Config struct {
+	ServiceName        string            // OTEL_SERVICE_NAME
+	ResourceAttributes map[string]string // OTEL_RESOURCE_ATTRIBUTES, as key=value,...
+	DetectResources    bool              // OTEL_RESOURCE_DETECTION, of host and container attributes
+
+	// OTLP export; telemetry goes to stdout without an endpoint.
+	OTLPEndpoint string            // OTEL_EXPORTER_OTLP_ENDPOINT
+	OTLPInsecure bool              // OTEL_EXPORTER_OTLP_INSECURE
+	OTLPHeaders  map[string]string // OTEL_EXPORTER_OTLP_HEADERS, as key=value,...
+	StdoutFile   string            // TELEMETRY_FILE, written instead of stdout without an endpoint
+	LogExport    bool              // OTEL_LOGS_EXPORTER, otlp or console rather than none
+	FailOpen     bool              // TELEMETRY_FAIL_OPEN, to start even if the exporters can't be built
+
+	Sampler      string     // OTEL_TRACES_SAMPLER, one of the Sampler constants
+	SampleRatio  float64    // OTEL_TRACES_SAMPLER_ARG, for SamplerTraceIDRatio only
+	DebugSecret  string     // DEBUG_TRACE_SECRET, to force sampling with DebugTraceMiddleware
+	LogLevel     slog.Level // LOG_LEVEL
+	TraceLogging bool       // installs the default logger with WithTraceLogging
+
+	RuntimeMetrics     bool             // RUNTIME_METRICS
+	LatencyBuckets     []float64        // HTTP_LATENCY_BUCKETS, in seconds
+	ExponentialLatency bool             // HTTP_LATENCY_EXPONENTIAL
+	IgnoredPaths       []string         // HTTP_IGNORED_PATHS, neither measured nor logged
+	AccessLogSample    float64          // ACCESS_LOG_SAMPLE_RATE, of successful requests logged
+	SlowThreshold      time.Duration    // SLOW_REQUEST_THRESHOLD
+	ApdexTarget        time.Duration    // APDEX_TARGET; no Apdex or availability metrics when zero
+	TrustedProxies     []netip.Prefix   // TRUSTED_PROXIES, as CIDRs
+	AllowedHosts       []string         // ALLOWED_HOSTS; any Host is served when empty
+	MiddlewareTiming   MiddlewareTiming // MIDDLEWARE_TIMING, off, spans or metrics
+
+	// TLS terminated by Run; plain HTTP without a certificate.
+	TLSCertFile     string // TLS_CERT_FILE, reloaded when it changes
+	TLSKeyFile      string // TLS_KEY_FILE
+	TLSClientCAFile string // TLS_CLIENT_CA_FILE, to require client certificates
+	TLSMinVersion   uint16 // TLS_MIN_VERSION, 1.2 or 1.3
+	H2C             bool   // HTTP_H2C, to serve cleartext HTTP/2 as well
+
+	// Limits of the main server; zero durations are unlimited.
+	ReadHeaderTimeout time.Duration // HTTP_READ_HEADER_TIMEOUT
+	ReadTimeout       time.Duration // HTTP_READ_TIMEOUT
+	WriteTimeout      time.Duration // HTTP_WRITE_TIMEOUT
+	IdleTimeout       time.Duration // HTTP_IDLE_TIMEOUT
+	MaxHeaderBytes    int           // HTTP_MAX_HEADER_BYTES; http.DefaultMaxHeaderBytes when zero
+
+	// How long to keep serving, reported not ready, once shutdown starts; as
+	// long as the load balancer takes to deregister the instance.
+	PreDrainDelay time.Duration // SHUTDOWN_PRE_DRAIN_DELAY
+
+	// Addresses of the main and admin servers, either of which may be a unix
+	// socket, as in unix:///var/run/acai.sock.
+	ListenAddr string // LISTEN_ADDR
+	AdminAddr  string // ADMIN_ADDR
+
+	AdminToken  string   // ADMIN_TOKEN, serving the admin telemetry controls
+	AdminPprof  bool     // ADMIN_PPROF, serving pprof on the admin server
+	DebugTraces bool     // DEBUG_TRACES, kept for the admin server to show
+	CaptureFile string   // CAPTURE_FILE, where the admin server captures traffic; needs AdminToken
+	BaggageKeys []string // BAGGAGE_KEYS, of the baggage members kept from callers
+}
+
+// DefaultConfig returns the settings used for whatever the environment
+// leaves unset: stdout export, failing open, host and container resource
+// detection, every new trace sampled, Info logs through a LogHandler,
+// runtime metrics, every request logged, and the API on :8080 with the
+// admin server, without pprof, on the loopback interface only.
+func DefaultConfig(serviceName string) Config {
+	return Config{
+		ServiceName:     serviceName,
+		DetectResources: true,
+		FailOpen:        true,
+		Sampler:         SamplerParentBased,
+		TraceLogging:    true,
+		RuntimeMetrics:  true,
+		AccessLogSample: 1,
+		SlowThreshold:   time.Second,
+
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+
+		ListenAddr: ":8080",
+		AdminAddr:  "127.0.0.1:8081",
+	}
+}
+
+// LoadFromEnv overrides c with the settings found in the environment, then
+// validates it. Every malformed or contradictory setting is reported, not
+// only the first.
+func (c *Config) LoadFromEnv() error {
+	return c.load(os.LookupEnv)
+}
+
+func (c *Config) load(lookup func(string) (string, bool)) error {
+	var errs []error
+	isSet := func(name string) bool {
+		v, ok := lookup(name)
+		return ok && strings.TrimSpace(v) != ""
+	}
+	env := func(name string, parse func(string) error) {
+		if !isSet(name) {
+			return
+		}
+		v, _ := lookup(name)
+		if err := parse(strings.TrimSpace(v)); err != nil {
+			errs = append(errs, fmt.Errorf("%s=%q: %w", name, v, err))
+		}
+	}
+	str := func(dst *string) func(string) error {
+		return func(v string) error { *dst = v; return nil }
+	}
+	boolean := func(dst *bool) func(string) error {
+		return func(v string) (err error) { *dst, err = parseBool(v); return err }
+	}
+	number := func(dst *float64) func(string) error {
+		return func(v string) (err error) { *dst, err = parseFloat(v); return err }
+	}
+	pairs := func(dst *map[string]string) func(string) error {
+		return func(v string) (err error) { *dst, err = parsePairs(v); return err }
+	}
+	duration := func(dst *time.Duration) func(string) error {
+		return func(v string) (err error) { *dst, err = time.ParseDuration(v); return err }
+	}
+
+	env("OTEL_SERVICE_NAME", str(&c.ServiceName))
+	env("OTEL_RESOURCE_ATTRIBUTES", pairs(&c.ResourceAttributes))
+	env("OTEL_RESOURCE_DETECTION", boolean(&c.DetectResources))
+	env("OTEL_EXPORTER_OTLP_ENDPOINT", str(&c.OTLPEndpoint))
+	env("OTEL_EXPORTER_OTLP_INSECURE", boolean(&c.OTLPInsecure))
+	env("OTEL_EXPORTER_OTLP_HEADERS", pairs(&c.OTLPHeaders))
+	env("TELEMETRY_FILE", str(&c.StdoutFile))
+	env("TELEMETRY_FAIL_OPEN", boolean(&c.FailOpen))
+	env("OTEL_LOGS_EXPORTER", func(v string) error {
+		switch v {
+		case "otlp", "console":
+			c.LogExport = true
+		case "none":
+			c.LogExport = false
+		default:
+			return errors.New("want otlp, console or none")
+		}
+		return nil
+	})
+	env("OTEL_EXPORTER_OTLP_PROTOCOL", func(v string) error {
+		if v != "grpc" {
+			return errors.New("only grpc is supported")
+		}
+		return nil
+	})
+
+	env("OTEL_TRACES_SAMPLER", str(&c.Sampler))
+	switch hasSampler, hasArg := isSet("OTEL_TRACES_SAMPLER"), isSet("OTEL_TRACES_SAMPLER_ARG"); {
+	case hasArg && !hasSampler:
+		// A ratio alone means a ratio sampler, as it always has here.
+		c.Sampler = SamplerTraceIDRatio
+	case hasSampler && !hasArg && c.Sampler == SamplerTraceIDRatio:
+		c.SampleRatio = 1 // the spec's default
+	}
+	env("OTEL_TRACES_SAMPLER_ARG", number(&c.SampleRatio))
+
+	env("DEBUG_TRACE_SECRET", str(&c.DebugSecret))
+	env("LOG_LEVEL", func(v string) error { return c.LogLevel.UnmarshalText([]byte(v)) })
+	env("RUNTIME_METRICS", boolean(&c.RuntimeMetrics))
+	env("MIDDLEWARE_TIMING", func(v string) error { return c.MiddlewareTiming.UnmarshalText([]byte(v)) })
+	env("HTTP_LATENCY_BUCKETS", func(v string) error {
+		c.LatencyBuckets = nil
+		for _, s := range splitList(v) {
+			b, err := parseFloat(s)
+			if err != nil {
+				return err
+			}
+			c.LatencyBuckets = append(c.LatencyBuckets, b)
+		}
+		return nil
+	})
+	env("HTTP_LATENCY_EXPONENTIAL", boolean(&c.ExponentialLatency))
+	env("HTTP_IGNORED_PATHS", func(v string) error { c.IgnoredPaths = splitList(v); return nil })
+	env("ACCESS_LOG_SAMPLE_RATE", number(&c.AccessLogSample))
+	env("SLOW_REQUEST_THRESHOLD", func(v string) (err error) { c.SlowThreshold, err = time.ParseDuration(v); return err })
+	env("APDEX_TARGET", func(v string) (err error) { c.ApdexTarget, err = time.ParseDuration(v); return err })
+	env("TRUSTED_PROXIES", func(v string) error {
+		c.TrustedProxies = nil
+		for _, s := range splitList(v) {
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				return fmt.Errorf("invalid network %q", s)
+			}
+			c.TrustedProxies = append(c.TrustedProxies, p)
+		}
+		return nil
+	})
+	env("ALLOWED_HOSTS", func(v string) error { c.AllowedHosts = splitList(v); return nil })
+	env("HTTP_H2C", boolean(&c.H2C))
+	env("HTTP_READ_HEADER_TIMEOUT", duration(&c.ReadHeaderTimeout))
+	env("HTTP_READ_TIMEOUT", duration(&c.ReadTimeout))
+	env("HTTP_WRITE_TIMEOUT", duration(&c.WriteTimeout))
+	env("HTTP_IDLE_TIMEOUT", duration(&c.IdleTimeout))
+	env("HTTP_MAX_HEADER_BYTES", func(v string) (err error) { c.MaxHeaderBytes, err = strconv.Atoi(v); return err })
+	env("SHUTDOWN_PRE_DRAIN_DELAY", duration(&c.PreDrainDelay))
+	env("TLS_CERT_FILE", str(&c.TLSCertFile))
+	env("TLS_KEY_FILE", str(&c.TLSKeyFile))
+	env("TLS_CLIENT_CA_FILE", str(&c.TLSClientCAFile))
+	env("TLS_MIN_VERSION", func(v string) error {
+		switch v {
+		case "1.2":
+			c.TLSMinVersion = tls.VersionTLS12
+		case "1.3":
+			c.TLSMinVersion = tls.VersionTLS13
+		default:
+			return errors.New("want 1.2 or 1.3")
+		}
+		return nil
+	})
+	env("LISTEN_ADDR", str(&c.ListenAddr))
+	env("ADMIN_ADDR", str(&c.AdminAddr))
+	env("ADMIN_TOKEN", str(&c.AdminToken))
+	env("ADMIN_PPROF", boolean(&c.AdminPprof))
+	env("DEBUG_TRACES", boolean(&c.DebugTraces))
+	env("CAPTURE_FILE", str(&c.CaptureFile))
+	env("BAGGAGE_KEYS", func(v string) error { c.BaggageKeys = splitList(v); return nil })
+
+	if errs != nil {
+		return errors.Join(errs...)
+	}
+	return c.Validate()
+}
+
+// Validate reports every setting of c that is out of range or contradicts
+// another.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+
+	if c.ServiceName == "" {
+		fail("service name is required (OTEL_SERVICE_NAME)")
+	}
+	if c.OTLPEndpoint == "" && (c.OTLPInsecure || len(c.OTLPHeaders) > 0) {
+		fail("OTLP insecure or headers set without an OTLP endpoint (OTEL_EXPORTER_OTLP_ENDPOINT)")
+	}
+	if c.OTLPEndpoint != "" && c.StdoutFile != "" {
+		fail("telemetry file (TELEMETRY_FILE) set along with an OTLP endpoint (OTEL_EXPORTER_OTLP_ENDPOINT)")
+	}
+	switch c.Sampler {
+	case SamplerTraceIDRatio:
+		if c.SampleRatio < 0 || c.SampleRatio > 1 {
+			fail("sample ratio %v (OTEL_TRACES_SAMPLER_ARG) is not between 0 and 1", c.SampleRatio)
+		}
+	case "", SamplerAlwaysOn, SamplerAlwaysOff, SamplerParentBased:
+		if c.SampleRatio != 0 {
+			fail("sample ratio %v (OTEL_TRACES_SAMPLER_ARG) has no effect with sampler %q", c.SampleRatio, c.Sampler)
+		}
+	default:
+		fail("unsupported sampler %q (OTEL_TRACES_SAMPLER), want one of %s, %s, %s or %s", c.Sampler,
+			SamplerAlwaysOn, SamplerAlwaysOff, SamplerParentBased, SamplerTraceIDRatio)
+	}
+	if c.DebugSecret != "" && c.Sampler == SamplerAlwaysOn {
+		fail("debug trace sampling (DEBUG_TRACE_SECRET) has no effect with sampler %q", c.Sampler)
+	}
+	if len(c.LatencyBuckets) > 0 && c.ExponentialLatency {
+		fail("latency buckets (HTTP_LATENCY_BUCKETS) and exponential latency (HTTP_LATENCY_EXPONENTIAL) are exclusive")
+	}
+	for i, b := range c.LatencyBuckets {
+		if b <= 0 || (i > 0 && b <= c.LatencyBuckets[i-1]) {
+			fail("latency buckets (HTTP_LATENCY_BUCKETS) must be positive and increasing, got %v", c.LatencyBuckets)
+			break
+		}
+	}
+	for _, p := range c.IgnoredPaths {
+		if !strings.HasPrefix(p, "/") {
+			fail("ignored path %q (HTTP_IGNORED_PATHS) does not start with /", p)
+		}
+	}
+	if c.AccessLogSample < 0 || c.AccessLogSample > 1 {
+		fail("access log sample rate %v (ACCESS_LOG_SAMPLE_RATE) is not between 0 and 1", c.AccessLogSample)
+	}
+	if c.SlowThreshold < 0 {
+		fail("slow request threshold %v (SLOW_REQUEST_THRESHOLD) is negative", c.SlowThreshold)
+	}
+	if c.ApdexTarget < 0 {
+		fail("Apdex target %v (APDEX_TARGET) is negative", c.ApdexTarget)
+	}
+	for _, h := range c.AllowedHosts {
+		if _, ok := parseHostPattern(h); !ok {
+			fail("allowed host %q (ALLOWED_HOSTS) is not a host name or pattern", h)
+		}
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"HTTP_READ_HEADER_TIMEOUT", c.ReadHeaderTimeout},
+		{"HTTP_READ_TIMEOUT", c.ReadTimeout},
+		{"HTTP_WRITE_TIMEOUT", c.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", c.IdleTimeout},
+	} {
+		if d.value < 0 {
+			fail("server timeout %v (%s) is negative", d.value, d.name)
+		}
+	}
+	if c.MaxHeaderBytes < 0 {
+		fail("max header bytes %d (HTTP_MAX_HEADER_BYTES) is negative", c.MaxHeaderBytes)
+	}
+	if c.PreDrainDelay < 0 {
+		fail("pre-drain delay %v (SHUTDOWN_PRE_DRAIN_DELAY) is negative", c.PreDrainDelay)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		fail("TLS certificate (TLS_CERT_FILE) and key (TLS_KEY_FILE) must be set together")
+	}
+	if c.TLSCertFile == "" && (c.TLSClientCAFile != "" || c.TLSMinVersion != 0) {
+		fail("TLS client CAs or minimum version set without a certificate (TLS_CERT_FILE)")
+	}
+	for _, a := range []struct {
+		name  string
+		value string
+	}{
+		{"LISTEN_ADDR", c.ListenAddr},
+		{"ADMIN_ADDR", c.AdminAddr},
+	} {
+		if _, _, err := net.SplitHostPort(a.value); err != nil && a.value != "" && !strings.HasPrefix(a.value, unixScheme) {
+			fail("address %q (%s) is neither host:port nor %s followed by a socket path", a.value, a.name, unixScheme)
+		}
+	}
+	if c.CaptureFile != "" && c.AdminToken == "" {
+		fail("capture file (CAPTURE_FILE) set without an admin token (ADMIN_TOKEN) to start captures with")
+	}
+	return errors.Join(errs...)
+}
+
+// RunOptions returns the Run options c stands for.
+func (c *Config) RunOptions() []RunOption {
+	opts := []RunOption{
+		WithReadHeaderTimeout(c.ReadHeaderTimeout),
+		WithReadTimeout(c.ReadTimeout),
+		WithWriteTimeout(c.WriteTimeout),
+		WithIdleTimeout(c.IdleTimeout),
+		WithMaxHeaderBytes(c.MaxHeaderBytes),
+		WithPreDrainDelay(c.PreDrainDelay),
+	}
+	if c.TLSCertFile != "" {
+		var tlsOpts []TLSOption
+		if c.TLSClientCAFile != "" {
+			tlsOpts = append(tlsOpts, WithClientCAs(c.TLSClientCAFile))
+		}
+		if c.TLSMinVersion != 0 {
+			tlsOpts = append(tlsOpts, WithMinTLSVersion(c.TLSMinVersion))
+		}
+		opts = append(opts, WithTLS(c.TLSCertFile, c.TLSKeyFile, tlsOpts...))
+	}
+	if c.H2C {
+		opts = append(opts, WithH2C())
+	}
+	return opts
+}
+
+// TelemetryOptions returns the InitTelemetry options c stands for.
+func (c *Config) TelemetryOptions() []TelemetryOption {
+	var opts []TelemetryOption
+	if c.OTLPEndpoint != "" {
+		opts = append(opts, WithOTLPEndpoint(c.OTLPEndpoint), WithOTLPInsecure(c.OTLPInsecure))
+		if len(c.OTLPHeaders) > 0 {
+			opts = append(opts, WithOTLPHeaders(c.OTLPHeaders))
+		}
+	}
+	if c.StdoutFile != "" {
+		opts = append(opts, WithStdoutFile(c.StdoutFile))
+	}
+	if c.LogExport {
+		opts = append(opts, WithLogExport())
+	}
+	if c.FailOpen {
+		opts = append(opts, WithFailOpen())
+	}
+	if c.DetectResources {
+		opts = append(opts, WithHostDetection(), WithContainerDetection())
+	}
+	if len(c.ResourceAttributes) > 0 {
+		keys := slices.Sorted(maps.Keys(c.ResourceAttributes))
+		attrs := make([]attribute.KeyValue, 0, len(keys))
+		for _, k := range keys {
+			attrs = append(attrs, attribute.String(k, c.ResourceAttributes[k]))
+		}
+		opts = append(opts, WithResourceAttributes(attrs...))
+	}
+	switch c.Sampler {
+	case SamplerAlwaysOn:
+		opts = append(opts, WithAlwaysSample())
+	case SamplerAlwaysOff:
+		opts = append(opts, WithNeverSample())
+	case SamplerTraceIDRatio:
+		opts = append(opts, WithSampleRatio(c.SampleRatio))
+	}
+	if c.DebugSecret != "" {
+		opts = append(opts, WithDebugSampling())
+	}
+	if c.TraceLogging {
+		opts = append(opts, WithTraceLogging(nil))
+	}
+	if c.RuntimeMetrics {
+		opts = append(opts, WithRuntimeMetrics())
+	}
+	if len(c.LatencyBuckets) > 0 {
+		opts = append(opts, WithHistogramBuckets("http.server.request.duration", c.LatencyBuckets...))
+	}
+	if c.ExponentialLatency {
+		opts = append(opts, WithExponentialLatency(0))
+	}
+	return opts
+}
+
+// InitTelemetryFromConfig validates cfg and initializes telemetry with it,
+// followed by opts, at the configured log level.
+func InitTelemetryFromConfig(ctx context.Context, cfg Config, opts ...TelemetryOption) (Shutdown, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid telemetry config: %w", err)
+	}
+	logLevelOverride.reset(cfg.LogLevel)
+	return InitTelemetry(ctx, cfg.ServiceName, append(cfg.TelemetryOptions(), opts...)...)
+}
+
+// NewStackFromConfig validates cfg and returns the DefaultStack configured
+// with it. Client IPs are resolved behind the trusted proxies once the span
+// has started, requests bearing the debug secret are sampled, and requests
+// for hosts not allowed are rejected innermost, so that they are still
+// measured and logged. The middlewares are timed as cfg.MiddlewareTiming
+// says, leaving SetMiddlewareTiming to the other chains. A nil logger means
+// slog.Default().
+func NewStackFromConfig(cfg Config, logger *slog.Logger) (func(http.Handler) http.Handler, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid middleware config: %w", err)
+	}
+
+	accessLogOpts := []AccessLogOption{
+		WithAccessLogIgnoredPaths(cfg.IgnoredPaths...),
+		WithAccessLogSlowThreshold(cfg.SlowThreshold),
+	}
+	if cfg.AccessLogSample < 1 {
+		accessLogOpts = append(accessLogOpts, WithAccessLogSampling(cfg.AccessLogSample))
+	}
+	middlewares := []NamedMiddleware{Named("recover", RecoverMiddleware), Named("request_id", RequestIDMiddleware)}
+	if cfg.DebugSecret != "" {
+		middlewares = append(middlewares, Named("debug_trace", DebugTraceMiddleware(DebugTraceSecret(cfg.DebugSecret))))
+	}
+	middlewares = append(middlewares,
+		Named("tracing", TracingMiddleware),
+		Named("client_ip", ClientIPMiddleware(cfg.TrustedProxies...)),
+		Named("metrics", NewMetricsMiddleware(WithIgnoredPaths(cfg.IgnoredPaths...), WithApdex(cfg.ApdexTarget))),
+		Named("access_log", AccessLogMiddleware(logger, accessLogOpts...)),
+	)
+	if len(cfg.AllowedHosts) > 0 {
+		middlewares = append(middlewares, Named("allowed_hosts", AllowedHostsMiddleware(cfg.AllowedHosts)))
+	}
+	return ChainNamedTiming(cfg.MiddlewareTiming, middlewares...), nil
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
+}
+
+// parsePairs parses the key=value,... lists of the OTEL_* variables.
+func parsePairs(v string) (map[string]string, error) {
+	pairs := map[string]string{}
+	for _, item := range splitList(v) {
+		key, value, ok := strings.Cut(item, "=")
+		if key = strings.TrimSpace(key); !ok || key == "" {
+			return nil, fmt.Errorf("%q is not key=value", item)
+		}
+		pairs[key] = strings.TrimSpace(value)
+	}
+	return pairs, nil
+}
+
+func parseBool(v string) (bool, error) {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.New("not a boolean")
+	}
+	return b, nil
+}
+
+func parseFloat(v string) (float64, error) {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", v)
+	}
+	return f, nil
+}
@@ -0,0 +1,311 @@
+package httpx
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+)
+
+func TestConfig_LoadFromEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want func(c *Config)
+	}{
+		{
+			name: "defaults",
+			want: func(c *Config) {},
+		},
+		{
+			name: "everything",
+			env: map[string]string{
+				"OTEL_SERVICE_NAME":           "acai-api",
+				"OTEL_RESOURCE_ATTRIBUTES":    "deployment.environment=prod, team = travel",
+				"OTEL_RESOURCE_DETECTION":     "false",
+				"OTEL_EXPORTER_OTLP_ENDPOINT": "collector:4317",
+				"OTEL_EXPORTER_OTLP_INSECURE": "true",
+				"OTEL_EXPORTER_OTLP_HEADERS":  "api-key=s3cret",
+				"OTEL_EXPORTER_OTLP_PROTOCOL": "grpc",
+				"OTEL_LOGS_EXPORTER":          "otlp",
+				"TELEMETRY_FAIL_OPEN":         "false",
+				"OTEL_TRACES_SAMPLER":         "parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG":     "0.25",
+				"DEBUG_TRACE_SECRET":          "debug",
+				"LOG_LEVEL":                   "warn",
+				"RUNTIME_METRICS":             "false",
+				"HTTP_LATENCY_BUCKETS":        "0.1, 0.5,1",
+				"HTTP_IGNORED_PATHS":          "/healthz,/readyz",
+				"ACCESS_LOG_SAMPLE_RATE":      "0.1",
+				"SLOW_REQUEST_THRESHOLD":      "250ms",
+				"TRUSTED_PROXIES":             "10.0.0.0/8, fd00::/8",
+				"ALLOWED_HOSTS":               "api.acai.travel,*.acai.travel",
+				"TLS_CERT_FILE":               "/etc/tls/tls.crt",
+				"TLS_KEY_FILE":                "/etc/tls/tls.key",
+				"TLS_CLIENT_CA_FILE":          "/etc/tls/ca.crt",
+				"TLS_MIN_VERSION":             "1.3",
+				"HTTP_H2C":                    "true",
+				"HTTP_READ_TIMEOUT":           "30s",
+				"HTTP_IDLE_TIMEOUT":           "0s",
+				"HTTP_MAX_HEADER_BYTES":       "65536",
+				"SHUTDOWN_PRE_DRAIN_DELAY":    "10s",
+				"LISTEN_ADDR":                 "unix:///var/run/acai.sock",
+				"ADMIN_ADDR":                  ":9090",
+				"ADMIN_TOKEN":                 "admin",
+				"ADMIN_PPROF":                 "true",
+				"DEBUG_TRACES":                "true",
+				"CAPTURE_FILE":                "/tmp/capture.har",
+				"BAGGAGE_KEYS":                "tenant_id, ,market",
+			},
+			want: func(c *Config) {
+				c.ServiceName = "acai-api"
+				c.ResourceAttributes = map[string]string{"deployment.environment": "prod", "team": "travel"}
+				c.DetectResources = false
+				c.OTLPEndpoint, c.OTLPInsecure = "collector:4317", true
+				c.OTLPHeaders = map[string]string{"api-key": "s3cret"}
+				c.LogExport = true
+				c.FailOpen = false
+				c.Sampler, c.SampleRatio = SamplerTraceIDRatio, 0.25
+				c.DebugSecret = "debug"
+				c.LogLevel = slog.LevelWarn
+				c.RuntimeMetrics = false
+				c.LatencyBuckets = []float64{0.1, 0.5, 1}
+				c.IgnoredPaths = []string{"/healthz", "/readyz"}
+				c.AccessLogSample = 0.1
+				c.SlowThreshold = 250 * time.Millisecond
+				c.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+				c.AllowedHosts = []string{"api.acai.travel", "*.acai.travel"}
+				c.TLSCertFile, c.TLSKeyFile = "/etc/tls/tls.crt", "/etc/tls/tls.key"
+				c.TLSClientCAFile, c.TLSMinVersion = "/etc/tls/ca.crt", tls.VersionTLS13
+				c.H2C = true
+				c.ReadTimeout, c.IdleTimeout, c.MaxHeaderBytes = 30*time.Second, 0, 64<<10
+				c.PreDrainDelay = 10 * time.Second
+				c.ListenAddr, c.AdminAddr = "unix:///var/run/acai.sock", ":9090"
+				c.AdminToken, c.AdminPprof = "admin", true
+				c.DebugTraces, c.CaptureFile = true, "/tmp/capture.har"
+				c.BaggageKeys = []string{"tenant_id", "market"}
+			},
+		},
+		{
+			name: "sampler arg alone",
+			env:  map[string]string{"OTEL_TRACES_SAMPLER_ARG": "0.5"},
+			want: func(c *Config) { c.Sampler, c.SampleRatio = SamplerTraceIDRatio, 0.5 },
+		},
+		{
+			name: "ratio sampler without arg",
+			env:  map[string]string{"OTEL_TRACES_SAMPLER": "parentbased_traceidratio"},
+			want: func(c *Config) { c.Sampler, c.SampleRatio = SamplerTraceIDRatio, 1 },
+		},
+		{
+			name: "blank values are unset",
+			env:  map[string]string{"OTEL_SERVICE_NAME": " ", "SLOW_REQUEST_THRESHOLD": ""},
+			want: func(c *Config) {},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DefaultConfig("acai-server")
+			if err := got.load(lookupIn(tt.env)); err != nil {
+				t.Fatalf("load() unexpected error: %v", err)
+			}
+			want := DefaultConfig("acai-server")
+			tt.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("config mismatch:\n got %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestConfig_LoadFromEnvErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{
+			name: "malformed",
+			env: map[string]string{
+				"OTEL_EXPORTER_OTLP_INSECURE": "yes please",
+				"OTEL_RESOURCE_ATTRIBUTES":    "team",
+				"OTEL_EXPORTER_OTLP_PROTOCOL": "http/protobuf",
+				"OTEL_LOGS_EXPORTER":          "loki",
+				"LOG_LEVEL":                   "loud",
+				"TRUSTED_PROXIES":             "10.0.0.1",
+				"SLOW_REQUEST_THRESHOLD":      "1",
+				"ADMIN_PPROF":                 "off",
+			},
+			want: []string{
+				`OTEL_RESOURCE_ATTRIBUTES="team": "team" is not key=value`,
+				`OTEL_EXPORTER_OTLP_INSECURE="yes please": not a boolean`,
+				`OTEL_LOGS_EXPORTER="loki": want otlp, console or none`,
+				`OTEL_EXPORTER_OTLP_PROTOCOL="http/protobuf": only grpc is supported`,
+				`LOG_LEVEL="loud": slog: level string "loud": unknown name`,
+				`SLOW_REQUEST_THRESHOLD="1": time: missing unit in duration "1"`,
+				`TRUSTED_PROXIES="10.0.0.1": invalid network "10.0.0.1"`,
+				`ADMIN_PPROF="off": not a boolean`,
+			},
+		},
+		{
+			name: "contradictory",
+			env: map[string]string{
+				"OTEL_EXPORTER_OTLP_INSECURE": "true",
+				"OTEL_TRACES_SAMPLER":         "always_on",
+				"OTEL_TRACES_SAMPLER_ARG":     "0.1",
+				"DEBUG_TRACE_SECRET":          "debug",
+				"HTTP_LATENCY_BUCKETS":        "1,0.5",
+				"HTTP_LATENCY_EXPONENTIAL":    "true",
+			},
+			want: []string{
+				"OTLP insecure or headers set without an OTLP endpoint (OTEL_EXPORTER_OTLP_ENDPOINT)",
+				`sample ratio 0.1 (OTEL_TRACES_SAMPLER_ARG) has no effect with sampler "always_on"`,
+				`debug trace sampling (DEBUG_TRACE_SECRET) has no effect with sampler "always_on"`,
+				"latency buckets (HTTP_LATENCY_BUCKETS) and exponential latency (HTTP_LATENCY_EXPONENTIAL) are exclusive",
+				"latency buckets (HTTP_LATENCY_BUCKETS) must be positive and increasing, got [1 0.5]",
+			},
+		},
+		{
+			name: "two destinations",
+			env: map[string]string{
+				"OTEL_EXPORTER_OTLP_ENDPOINT": "collector:4317",
+				"TELEMETRY_FILE":              "/var/log/telemetry.json",
+			},
+			want: []string{"telemetry file (TELEMETRY_FILE) set along with an OTLP endpoint (OTEL_EXPORTER_OTLP_ENDPOINT)"},
+		},
+		{
+			name: "capture without a token",
+			env:  map[string]string{"CAPTURE_FILE": "/tmp/capture.har"},
+			want: []string{"capture file (CAPTURE_FILE) set without an admin token (ADMIN_TOKEN) to start captures with"},
+		},
+		{
+			name: "old TLS version",
+			env:  map[string]string{"TLS_MIN_VERSION": "1.1"},
+			want: []string{`TLS_MIN_VERSION="1.1": want 1.2 or 1.3`},
+		},
+		{
+			name: "TLS settings without a certificate",
+			env: map[string]string{
+				"TLS_KEY_FILE":       "/etc/tls/tls.key",
+				"TLS_CLIENT_CA_FILE": "/etc/tls/ca.crt",
+			},
+			want: []string{
+				"TLS certificate (TLS_CERT_FILE) and key (TLS_KEY_FILE) must be set together",
+				"TLS client CAs or minimum version set without a certificate (TLS_CERT_FILE)",
+			},
+		},
+		{
+			name: "out of range",
+			env: map[string]string{
+				"OTEL_TRACES_SAMPLER":      "traceidratio",
+				"ACCESS_LOG_SAMPLE_RATE":   "2",
+				"HTTP_IGNORED_PATHS":       "healthz",
+				"ALLOWED_HOSTS":            "api.acai.travel:http",
+				"SLOW_REQUEST_THRESHOLD":   "-1s",
+				"HTTP_WRITE_TIMEOUT":       "-5s",
+				"SHUTDOWN_PRE_DRAIN_DELAY": "-1s",
+				"OTEL_TRACES_SAMPLER_ARG":  "",
+				"LISTEN_ADDR":              "8080",
+			},
+			want: []string{
+				`unsupported sampler "traceidratio" (OTEL_TRACES_SAMPLER), want one of always_on, always_off, parentbased_always_on or parentbased_traceidratio`,
+				`ignored path "healthz" (HTTP_IGNORED_PATHS) does not start with /`,
+				"access log sample rate 2 (ACCESS_LOG_SAMPLE_RATE) is not between 0 and 1",
+				"slow request threshold -1s (SLOW_REQUEST_THRESHOLD) is negative",
+				`allowed host "api.acai.travel:http" (ALLOWED_HOSTS) is not a host name or pattern`,
+				"server timeout -5s (HTTP_WRITE_TIMEOUT) is negative",
+				"pre-drain delay -1s (SHUTDOWN_PRE_DRAIN_DELAY) is negative",
+				`address "8080" (LISTEN_ADDR) is neither host:port nor unix:// followed by a socket path`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig("acai-server")
+			err := cfg.load(lookupIn(tt.env))
+			if err == nil {
+				t.Fatal("load() succeeded, want an error")
+			}
+			if got, want := err.Error(), strings.Join(tt.want, "\n"); got != want {
+				t.Errorf("error mismatch:\n got %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	if err := (&Config{}).Validate(); err == nil || err.Error() != "service name is required (OTEL_SERVICE_NAME)" {
+		t.Errorf("Validate() on the zero Config = %v", err)
+	}
+	cfg := DefaultConfig("acai-server")
+	cfg.Sampler, cfg.SampleRatio = SamplerTraceIDRatio, 1.5
+	if _, err := InitTelemetryFromConfig(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "sample ratio 1.5") {
+		t.Errorf("InitTelemetryFromConfig() = %v, want the sample ratio rejected", err)
+	}
+	if _, err := NewStackFromConfig(cfg, nil); err == nil {
+		t.Error("NewStackFromConfig() succeeded with an invalid config")
+	}
+}
+
+func TestNewStackFromConfig(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+	otelt.InstallTracing(t)
+	logs := captureLogs(t)
+
+	cfg := DefaultConfig("acai-server")
+	cfg.IgnoredPaths = []string{"/healthz"}
+	cfg.AllowedHosts = []string{"api.acai.travel"}
+	stack, err := NewStackFromConfig(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewStackFromConfig() unexpected error: %v", err)
+	}
+	handler := stack(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(host, path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := serve("api.acai.travel", "/trips"); code != http.StatusOK {
+		t.Errorf("allowed host got %d, want 200", code)
+	}
+	if code := serve("evil.example", "/trips"); code != http.StatusMisdirectedRequest {
+		t.Errorf("other host got %d, want 421", code)
+	}
+	if code := serve("", "/healthz"); code != http.StatusOK {
+		t.Errorf("health check got %d, want 200", code)
+	}
+
+	var requests int64
+	for _, dp := range collectSum(t, reader, "http.server.requests") {
+		requests += dp.Value
+	}
+	if requests != 2 {
+		t.Errorf("measured %d requests, want 2 without the ignored path", requests)
+	}
+	var lines []string
+	for _, line := range decodeLogLines(t, logs) {
+		if line["msg"] == "HTTP request" {
+			lines = append(lines, line["http_path"].(string))
+		}
+	}
+	if len(lines) != 2 {
+		t.Errorf("logged %v, want the 2 requests outside the ignored path", lines)
+	}
+}
+
+func lookupIn(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
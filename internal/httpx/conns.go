@@ -0,0 +1,80 @@
+package httpx
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var (
+	connActiveAttrs = metric.WithAttributeSet(attribute.NewSet(attribute.String("http.connection.state", "active")))
+	connIdleAttrs   = metric.WithAttributeSet(attribute.NewSet(attribute.String("http.connection.state", "idle")))
+)
+
+// connTracker is the ConnState hook of the main server, recording the
+// connections in the http.server.connections metrics.
+type connTracker struct {
+	headerTimeout time.Duration
+
+	mu    sync.Mutex
+	conns map[net.Conn]*connInfo
+}
+
+type connInfo struct {
+	state    http.ConnState
+	since    time.Time
+	requests int64
+}
+
+func newConnTracker(headerTimeout time.Duration) *connTracker {
+	return &connTracker{headerTimeout: headerTimeout, conns: map[net.Conn]*connInfo{}}
+}
+
+func (t *connTracker) connState(c net.Conn, state http.ConnState) {
+	ctx := context.Background()
+	sm := loadServerMetrics()
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state == http.StateNew {
+		t.conns[c] = &connInfo{state: state, since: now}
+		sm.connsOpened.Add(ctx, 1)
+		return
+	}
+	info, ok := t.conns[c]
+	if !ok {
+		return
+	}
+	t.count(ctx, sm, info.state, -1)
+	switch state {
+	case http.StateActive:
+		info.requests++
+	case http.StateHijacked, http.StateClosed:
+		delete(t.conns, c)
+		sm.connsClosed.Add(ctx, 1)
+		sm.connRequests.Record(ctx, info.requests)
+		// The server closes connections still waiting for their first
+		// request header once the timeout elapses.
+		if state == http.StateClosed && info.state == http.StateNew && t.headerTimeout > 0 && now.Sub(info.since) >= t.headerTimeout {
+			sm.headerTimeouts.Add(ctx, 1)
+		}
+		return
+	}
+	t.count(ctx, sm, state, 1)
+	info.state, info.since = state, now
+}
+
+func (t *connTracker) count(ctx context.Context, sm *serverMetrics, state http.ConnState, n int64) {
+	switch state {
+	case http.StateActive:
+		sm.conns.Add(ctx, n, connActiveAttrs)
+	case http.StateIdle:
+		sm.conns.Add(ctx, n, connIdleAttrs)
+	}
+}
@@ -0,0 +1,93 @@
+package httpx
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// eventually polls cond for up to a second, as ConnState hooks run after
+// the client is done.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRun_ConnectionMetrics(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addrCh := make(chan string, 1)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- Run(ctx, "127.0.0.1:0", http.NotFoundHandler(),
+			WithReadHeaderTimeout(100*time.Millisecond),
+			WithIdleTimeout(time.Minute),
+			WithOnListen(func(a net.Addr) { addrCh <- a.String() }),
+		)
+	}()
+	addr := <-addrCh
+	conns := func(state string) int64 {
+		return otelt.CounterValue(t, "http.server.connections", []attribute.KeyValue{attribute.String("http.connection.state", state)})
+	}
+
+	// Two requests on a kept-alive connection, which then idles.
+	client := &http.Client{Transport: &http.Transport{}}
+	for range 2 {
+		resp, err := client.Get("http://" + addr + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	eventually(t, "an idle connection", func() bool { return conns("idle") == 1 && conns("active") == 0 })
+
+	// A connection never sending anything is closed after the header timeout.
+	start := time.Now()
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	_ = raw.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := raw.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("silent connection: read %v, want the server to close it", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("silent connection closed after %v, want about 100ms", elapsed)
+	}
+	eventually(t, "the header timeout", func() bool {
+		return otelt.CounterValue(t, "http.server.connections.header_timeouts", nil) == 1
+	})
+
+	client.CloseIdleConnections()
+	cancel()
+	if err := <-runErr; err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+	eventually(t, "every connection closed", func() bool {
+		return otelt.CounterValue(t, "http.server.connections.closed", nil) == 2
+	})
+	otelt.RequireCounterValue(t, "http.server.connections.opened", nil, 2)
+	if idle := conns("idle"); idle != 0 {
+		t.Errorf("%d idle connections after shutdown, want 0", idle)
+	}
+	points := collectIntHistogram(t, reader, "http.server.connection.requests")
+	if len(points) != 1 || points[0].Count != 2 || points[0].Sum != 2 {
+		t.Errorf("requests per connection %+v, want 2 and 0", points)
+	}
+}
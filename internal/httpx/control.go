@@ -0,0 +1,265 @@
+package httpx
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// logLevel is the level of the default logger installed by WithTraceLogging.
+var logLevel slog.LevelVar
+
+var logLevelOverride = override[slog.Level]{name: "Log level", apply: func(level slog.Level) {
+	logLevel.Set(level)
+	recordLogLevel(context.Background())
+}}
+
+// LogLevel returns the level changed by SetLogLevel, for the HandlerOptions
+// of loggers that should follow it besides the one WithTraceLogging installs.
+func LogLevel() slog.Leveler { return &logLevel }
+
+// SetLogLevel changes the level of the default logger installed by
+// WithTraceLogging, e.g. to Debug while investigating an incident. A positive
+// revertAfter restores the previous level once elapsed.
+func SetLogLevel(level slog.Level, revertAfter time.Duration) {
+	logLevelOverride.set(level, revertAfter)
+}
+
+func recordLogLevel(ctx context.Context) {
+	loadServerMetrics().logLevel.Record(ctx, int64(logLevel.Level()))
+}
+
+// traceSampling is the sampler installed by InitTelemetry, which defers to
+// the configured one unless SetSampleRatio overrides it.
+var traceSampling dynamicSampler
+
+var sampleRatioOverride = override[samplerState]{name: "Trace sample ratio", apply: func(s samplerState) {
+	traceSampling.current.Store(&s)
+	recordSampleRatio(context.Background())
+}}
+
+// SetSampleRatio samples the given fraction of new traces, following the
+// parent's decision otherwise, in place of the sampler InitTelemetry was
+// configured with, e.g. 1 to trace everything while investigating an
+// incident. A positive revertAfter restores the previous sampling once
+// elapsed.
+func SetSampleRatio(ratio float64, revertAfter time.Duration) {
+	s := samplerState{sampler: sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio)), ratio: ratio}
+	if traceSampling.debug.Load() {
+		s.sampler = debugSampler{base: s.sampler}
+	}
+	sampleRatioOverride.set(s, revertAfter)
+}
+
+// SampleRatio returns the fraction of new traces sampled.
+func SampleRatio() float64 {
+	return traceSampling.state().ratio
+}
+
+func recordSampleRatio(ctx context.Context) {
+	loadServerMetrics().sampleRatio.Record(ctx, SampleRatio())
+}
+
+type samplerState struct {
+	sampler sdktrace.Sampler
+	ratio   float64
+}
+
+func (s samplerState) LogValue() slog.Value { return slog.Float64Value(s.ratio) }
+
+type dynamicSampler struct {
+	current atomic.Pointer[samplerState]
+	debug   atomic.Bool // overrides keep DebugTraceMiddleware working
+}
+
+// install makes the sampler configured for InitTelemetry the one in use,
+// dropping any override.
+func (d *dynamicSampler) install(cfg telemetryConfig) {
+	d.debug.Store(cfg.debugSampling)
+	sampleRatioOverride.reset(samplerState{sampler: cfg.sampler, ratio: cfg.ratio})
+}
+
+func (d *dynamicSampler) state() samplerState {
+	if s := d.current.Load(); s != nil {
+		return *s
+	}
+	return samplerState{sampler: sdktrace.ParentBased(sdktrace.AlwaysSample()), ratio: 1}
+}
+
+func (d *dynamicSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	return d.state().sampler.ShouldSample(p)
+}
+
+func (d *dynamicSampler) Description() string {
+	return "Dynamic{" + d.state().sampler.Description() + "}"
+}
+
+// override is a setting changed at runtime, optionally for a while only.
+type override[T any] struct {
+	name  string
+	apply func(T)
+
+	mu       sync.Mutex
+	baseline T // restored when a temporary change expires
+	gen      uint64
+	timer    *time.Timer
+}
+
+// set applies v. With a positive revertAfter the change is temporary, and
+// the value from before the first of the pending temporary changes comes
+// back once elapsed; otherwise v becomes the baseline.
+func (o *override[T]) set(v T, revertAfter time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.stop()
+	o.apply(v)
+	if revertAfter <= 0 {
+		o.baseline = v
+		slog.Info(o.name+" changed", "value", v)
+		return
+	}
+	slog.Info(o.name+" changed", "value", v, "revert_after", revertAfter)
+
+	gen := o.gen
+	o.timer = time.AfterFunc(revertAfter, func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		if o.gen != gen {
+			return // superseded
+		}
+		o.timer = nil
+		o.apply(o.baseline)
+		slog.Info(o.name+" reverted", "value", o.baseline)
+	})
+}
+
+// reset makes v the baseline and the current value, silently.
+func (o *override[T]) reset(v T) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.stop()
+	o.baseline = v
+	o.apply(v)
+}
+
+func (o *override[T]) stop() {
+	o.gen++
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+	}
+}
+
+// tokenAuthenticator authenticates requests bearing token as the "admin"
+// principal.
+func tokenAuthenticator(token string) Authenticator {
+	want := sha256.Sum256([]byte(token))
+	return BearerAuthenticator(func(ctx context.Context, got string) (Principal, error) {
+		// Comparing hashes keeps the time independent of the token lengths.
+		sum := sha256.Sum256([]byte(got))
+		if subtle.ConstantTimeCompare(sum[:], want[:]) != 1 {
+			return Principal{}, ErrCredentialsInvalid
+		}
+		return Principal{ID: "admin"}, nil
+	})
+}
+
+type logLevelBody struct {
+	Level       string `json:"level"`
+	RevertAfter string `json:"revert_after,omitempty"`
+}
+
+// logLevelHandler serves the log level: GET reports it and PUT changes it
+// to the one in a {"level": "debug", "revert_after": "10m"} body, the
+// revert_after being optional.
+func logLevelHandler() http.Handler {
+	return controlHandler(func(w http.ResponseWriter, r *http.Request) bool {
+		var body logLevelBody
+		revertAfter, ok := decodeControl(w, r, &body, &body.RevertAfter)
+		if !ok {
+			return false
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(body.Level)); err != nil {
+			http.Error(w, fmt.Sprintf("invalid level %q", body.Level), http.StatusBadRequest)
+			return false
+		}
+		SetLogLevel(level, revertAfter)
+		return true
+	}, func() any { return logLevelBody{Level: logLevel.Level().String()} })
+}
+
+type sampleRatioBody struct {
+	Ratio       *float64 `json:"ratio"`
+	RevertAfter string   `json:"revert_after,omitempty"`
+	Sampler     string   `json:"sampler,omitempty"`
+}
+
+// sampleRatioHandler serves the trace sample ratio: GET reports it and PUT
+// changes it to the one in a {"ratio": 1, "revert_after": "10m"} body, the
+// revert_after being optional.
+func sampleRatioHandler() http.Handler {
+	return controlHandler(func(w http.ResponseWriter, r *http.Request) bool {
+		var body sampleRatioBody
+		revertAfter, ok := decodeControl(w, r, &body, &body.RevertAfter)
+		if !ok {
+			return false
+		}
+		if body.Ratio == nil || *body.Ratio < 0 || *body.Ratio > 1 {
+			http.Error(w, "ratio must be between 0 and 1", http.StatusBadRequest)
+			return false
+		}
+		SetSampleRatio(*body.Ratio, revertAfter)
+		return true
+	}, func() any {
+		ratio := SampleRatio()
+		return sampleRatioBody{Ratio: &ratio, Sampler: traceSampling.Description()}
+	})
+}
+
+// controlHandler answers GET and HEAD with current, and PUT with current
+// once put succeeded.
+func controlHandler(put func(http.ResponseWriter, *http.Request) bool, current func() any) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut:
+			if !put(w, r) {
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		_ = WriteJSON(w, http.StatusOK, current())
+	})
+}
+
+// decodeControl decodes a control body into v and parses its revert_after,
+// answering a 400 on failure.
+func decodeControl(w http.ResponseWriter, r *http.Request, v any, revertAfter *string) (time.Duration, bool) {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(v); err != nil {
+		http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+		return 0, false
+	}
+	if *revertAfter == "" {
+		return 0, true
+	}
+	d, err := time.ParseDuration(*revertAfter)
+	if err != nil || d <= 0 {
+		http.Error(w, fmt.Sprintf("invalid revert_after %q", *revertAfter), http.StatusBadRequest)
+		return 0, false
+	}
+	return d, true
+}
@@ -0,0 +1,148 @@
+package httpx
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTelemetryControls(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+	logs := captureLogs(t)
+	traceSampling.install(newTelemetryConfig([]TelemetryOption{WithSampleRatio(0)}))
+	t.Cleanup(func() {
+		traceSampling.install(newTelemetryConfig(nil))
+		logLevelOverride.reset(slog.LevelInfo)
+	})
+	admin := AdminServer("", WithAdminTelemetryControls("s3cret"), WithPprof(false)).Handler()
+
+	put := func(path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		return rec
+	}
+	gauge := func(name string) float64 {
+		m, ok := collectMetric(t, reader, name)
+		if !ok {
+			t.Fatalf("%s not recorded", name)
+		}
+		switch data := m.Data.(type) {
+		case metricdata.Gauge[int64]:
+			return float64(data.DataPoints[0].Value)
+		case metricdata.Gauge[float64]:
+			return data.DataPoints[0].Value
+		}
+		t.Fatalf("%s is a %T", name, m.Data)
+		return 0
+	}
+
+	for _, token := range []string{"", "guess"} {
+		if rec := put("/sampling", token, `{"ratio":1}`); rec.Code != http.StatusUnauthorized {
+			t.Errorf("PUT /sampling with token %q = %d, want 401", token, rec.Code)
+		}
+	}
+	if SampleRatio() != 0 {
+		t.Fatalf("unauthenticated PUT changed the ratio to %v", SampleRatio())
+	}
+
+	if rec := put("/sampling", "s3cret", `{"ratio":1,"revert_after":"1h"}`); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"ratio":1`) {
+		t.Fatalf("PUT /sampling = %d %s", rec.Code, rec.Body.String())
+	}
+	res := traceSampling.ShouldSample(sdktrace.SamplingParameters{ParentContext: context.Background(), TraceID: trace.TraceID{0xff}, Name: "GET /trips"})
+	if res.Decision != sdktrace.RecordAndSample {
+		t.Errorf("decision after raising the ratio = %v, want sampled", res.Decision)
+	}
+	if got := gauge("telemetry.trace.sample_ratio"); got != 1 {
+		t.Errorf("telemetry.trace.sample_ratio = %v, want 1", got)
+	}
+
+	if rec := put("/loglevel", "s3cret", `{"level":"debug"}`); rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"level":"DEBUG"}` {
+		t.Fatalf("PUT /loglevel = %d %s", rec.Code, rec.Body.String())
+	}
+	if LogLevel().Level() != slog.LevelDebug {
+		t.Errorf("log level = %v, want DEBUG", LogLevel().Level())
+	}
+	if got := gauge("telemetry.log.level"); got != float64(slog.LevelDebug) {
+		t.Errorf("telemetry.log.level = %v, want %d", got, slog.LevelDebug)
+	}
+
+	for _, tt := range []struct{ path, body string }{
+		{"/loglevel", `{"level":"loud"}`},
+		{"/sampling", `{"ratio":2}`},
+		{"/sampling", `{}`},
+		{"/sampling", `{"ratio":1,"revert_after":"soon"}`},
+	} {
+		if rec := put(tt.path, "s3cret", tt.body); rec.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s %s = %d, want 400", tt.path, tt.body, rec.Code)
+		}
+	}
+
+	var changes int
+	for _, line := range decodeLogLines(t, logs) {
+		if msg, _ := line["msg"].(string); strings.HasSuffix(msg, " changed") {
+			changes++
+		}
+	}
+	if changes != 2 {
+		t.Errorf("got %d change logs, want 2", changes)
+	}
+}
+
+func TestTelemetryControls_NoToken(t *testing.T) {
+	admin := AdminServer("", WithAdminTelemetryControls(""), WithPprof(false)).Handler()
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/loglevel", strings.NewReader(`{"level":"debug"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("PUT /loglevel without a configured token = %d, want 404", rec.Code)
+	}
+}
+
+func TestOverride_Revert(t *testing.T) {
+	captureLogs(t)
+	var current int
+	o := override[int]{name: "Test", apply: func(v int) { current = v }}
+	o.reset(1)
+
+	waitFor := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for {
+			o.mu.Lock()
+			got := current
+			o.mu.Unlock()
+			if got == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("value = %d, want %d", got, want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Temporary changes stacked on one another go back to the baseline.
+	o.set(2, time.Hour)
+	o.set(3, 10*time.Millisecond)
+	waitFor(1)
+
+	// A permanent change cancels a pending revert and becomes the baseline.
+	o.set(4, 10*time.Millisecond)
+	o.set(5, 0)
+	time.Sleep(30 * time.Millisecond)
+	waitFor(5)
+	o.set(6, 10*time.Millisecond)
+	waitFor(5)
+}
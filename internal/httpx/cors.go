@@ -0,0 +1,157 @@
+package httpx
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOption configures CORSMiddleware.
+type CORSOption func(*corsConfig)
+
+type corsConfig struct {
+	origins     []string
+	methods     []string
+	headers     []string
+	credentials bool
+	maxAge      time.Duration
+}
+
+// WithAllowedOrigins sets the origins allowed to call the API. Patterns are
+// exact origins ("https://app.acai.travel"), a wildcard subdomain
+// ("https://*.acai.travel") or "*" for any origin.
+func WithAllowedOrigins(origins ...string) CORSOption {
+	return func(c *corsConfig) { c.origins = origins }
+}
+
+// WithAllowedMethods sets the methods allowed in cross-origin requests.
+// Defaults to GET, HEAD and POST.
+func WithAllowedMethods(methods ...string) CORSOption {
+	return func(c *corsConfig) { c.methods = methods }
+}
+
+// WithAllowedHeaders sets the request headers allowed in cross-origin
+// requests, or "*" for any. Defaults to Content-Type.
+func WithAllowedHeaders(headers ...string) CORSOption {
+	return func(c *corsConfig) { c.headers = headers }
+}
+
+// WithAllowCredentials lets browsers send cookies and auth headers. Browsers
+// refuse credentials on a "*" origin, so they are only allowed for origins
+// matched by an exact or subdomain pattern.
+func WithAllowCredentials(allow bool) CORSOption {
+	return func(c *corsConfig) { c.credentials = allow }
+}
+
+// WithCORSMaxAge sets how long browsers may cache preflight results.
+func WithCORSMaxAge(d time.Duration) CORSOption {
+	return func(c *corsConfig) { c.maxAge = d }
+}
+
+// CORSMiddleware adds CORS headers for allowed origins and answers their
+// preflight requests with a 204 without calling the handler. Requests from
+// other origins pass through untouched; the browser enforces the policy.
+// Preflights are not counted as errors by an outer MetricsMiddleware.
+func CORSMiddleware(opts ...CORSOption) func(http.Handler) http.Handler {
+	cfg := corsConfig{
+		methods: []string{http.MethodGet, http.MethodHead, http.MethodPost},
+		headers: []string{"Content-Type"},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	methods := strings.Join(cfg.methods, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			h := w.Header()
+			h.Add("Vary", "Origin")
+
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if preflight {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				var st *requestState
+				r, st = withRequestState(r)
+				st.mu.Lock()
+				st.preflight = true
+				st.mu.Unlock()
+			}
+
+			exact, ok := cfg.matchOrigin(origin)
+			if origin == "" || !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if exact {
+				h.Set("Access-Control-Allow-Origin", origin)
+				if cfg.credentials {
+					h.Set("Access-Control-Allow-Credentials", "true")
+				}
+			} else {
+				h.Set("Access-Control-Allow-Origin", "*")
+			}
+
+			if !preflight {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !slices.Contains(cfg.methods, r.Header.Get("Access-Control-Request-Method")) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			h.Set("Access-Control-Allow-Methods", methods)
+			if allowed := cfg.allowHeaders(r.Header.Get("Access-Control-Request-Headers")); allowed != "" {
+				h.Set("Access-Control-Allow-Headers", allowed)
+			}
+			if cfg.maxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.maxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// matchOrigin reports whether origin is allowed, and whether it matched a
+// specific pattern rather than "*".
+func (c *corsConfig) matchOrigin(origin string) (exact, ok bool) {
+	wildcard := false
+	for _, pattern := range c.origins {
+		switch {
+		case pattern == "*":
+			wildcard = true
+		case strings.EqualFold(pattern, origin):
+			return true, true
+		case strings.Contains(pattern, "://*."):
+			scheme, domain, _ := strings.Cut(pattern, "://*")
+			rest, found := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://")
+			if found && strings.HasSuffix(rest, strings.ToLower(domain)) && len(rest) > len(domain) {
+				return true, true
+			}
+		}
+	}
+	return false, wildcard
+}
+
+// allowHeaders returns the requested headers that are allowed.
+func (c *corsConfig) allowHeaders(requested string) string {
+	if requested == "" {
+		return ""
+	}
+	if slices.Contains(c.headers, "*") {
+		return requested
+	}
+	var allowed []string
+	for _, name := range strings.Split(requested, ",") {
+		name = strings.TrimSpace(name)
+		if slices.ContainsFunc(c.headers, func(h string) bool { return strings.EqualFold(h, name) }) {
+			allowed = append(allowed, name)
+		}
+	}
+	return strings.Join(allowed, ", ")
+}
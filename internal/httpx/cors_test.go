@@ -0,0 +1,142 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []CORSOption
+		method      string
+		origin      string
+		reqMethod   string
+		reqHeaders  string
+		wantStatus  int
+		wantOrigin  string
+		wantCreds   string
+		wantMethods string
+		wantHeaders string
+		wantMaxAge  string
+	}{
+		{
+			name:       "exact origin on an actual request",
+			opts:       []CORSOption{WithAllowedOrigins("https://app.acai.travel")},
+			method:     http.MethodGet,
+			origin:     "https://app.acai.travel",
+			wantStatus: http.StatusTeapot,
+			wantOrigin: "https://app.acai.travel",
+		},
+		{
+			name:       "wildcard subdomain",
+			opts:       []CORSOption{WithAllowedOrigins("https://*.acai.travel")},
+			method:     http.MethodGet,
+			origin:     "https://staging.app.acai.travel",
+			wantStatus: http.StatusTeapot,
+			wantOrigin: "https://staging.app.acai.travel",
+		},
+		{
+			name:       "lookalike domain is not a subdomain",
+			opts:       []CORSOption{WithAllowedOrigins("https://*.acai.travel")},
+			method:     http.MethodGet,
+			origin:     "https://evilacai.travel",
+			wantStatus: http.StatusTeapot,
+		},
+		{
+			name:       "disallowed origin passes through without headers",
+			opts:       []CORSOption{WithAllowedOrigins("https://app.acai.travel")},
+			method:     http.MethodPost,
+			origin:     "https://evil.example",
+			wantStatus: http.StatusTeapot,
+		},
+		{
+			name: "preflight",
+			opts: []CORSOption{
+				WithAllowedOrigins("https://app.acai.travel"),
+				WithAllowedMethods(http.MethodGet, http.MethodPut),
+				WithAllowedHeaders("Content-Type", "Authorization"),
+				WithCORSMaxAge(10 * time.Minute),
+			},
+			method:      http.MethodOptions,
+			origin:      "https://app.acai.travel",
+			reqMethod:   http.MethodPut,
+			reqHeaders:  "authorization, x-debug",
+			wantStatus:  http.StatusNoContent,
+			wantOrigin:  "https://app.acai.travel",
+			wantMethods: "GET, PUT",
+			wantHeaders: "authorization",
+			wantMaxAge:  "600",
+		},
+		{
+			name:       "credentials with an exact origin",
+			opts:       []CORSOption{WithAllowedOrigins("https://app.acai.travel", "*"), WithAllowCredentials(true)},
+			method:     http.MethodGet,
+			origin:     "https://app.acai.travel",
+			wantStatus: http.StatusTeapot,
+			wantOrigin: "https://app.acai.travel",
+			wantCreds:  "true",
+		},
+		{
+			name:       "credentials are never combined with the any-origin wildcard",
+			opts:       []CORSOption{WithAllowedOrigins("https://app.acai.travel", "*"), WithAllowCredentials(true)},
+			method:     http.MethodGet,
+			origin:     "https://partner.example",
+			wantStatus: http.StatusTeapot,
+			wantOrigin: "*",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := CORSMiddleware(tt.opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}))
+			req := httptest.NewRequest(tt.method, "/trips", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.reqMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.reqMethod)
+				req.Header.Set("Access-Control-Request-Headers", tt.reqHeaders)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status mismatch: got %d, want %d", rec.Code, tt.wantStatus)
+			}
+			for header, want := range map[string]string{
+				"Access-Control-Allow-Origin":      tt.wantOrigin,
+				"Access-Control-Allow-Credentials": tt.wantCreds,
+				"Access-Control-Allow-Methods":     tt.wantMethods,
+				"Access-Control-Allow-Headers":     tt.wantHeaders,
+				"Access-Control-Max-Age":           tt.wantMaxAge,
+			} {
+				if got := rec.Header().Get(header); got != want {
+					t.Errorf("%s mismatch: got %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSMiddleware_PreflightsAreNotErrors(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	rt := NewRouter()
+	rt.HandleFunc("GET /trips", func(w http.ResponseWriter, r *http.Request) {})
+	handler := MetricsMiddleware(CORSMiddleware(WithAllowedOrigins("https://app.acai.travel"))(rt))
+
+	for _, origin := range []string{"https://app.acai.travel", "https://evil.example"} {
+		req := httptest.NewRequest(http.MethodOptions, "/trips", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	otelt.RequireCounterValue(t, "http.server.requests", nil, 2)
+	otelt.RequireCounterValue(t, "http.server.errors", nil, 0)
+}
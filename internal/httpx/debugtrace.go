@@ -0,0 +1,93 @@
+package httpx
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"net/netip"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// DebugTraceHeader asks for the request to be traced regardless of the
+// sampling ratio. It only takes effect for callers let through by the
+// DebugTraceGate and when InitTelemetry was given WithDebugSampling.
+const DebugTraceHeader = "X-Debug-Trace"
+
+// debugSampledKey marks spans that were sampled because of DebugTraceHeader.
+const debugSampledKey = attribute.Key("debug.forced_sample")
+
+type forceSampleKey struct{}
+
+// DebugTraceGate decides whether a request carrying DebugTraceHeader may
+// force sampling. Without a gate anyone could trace every request.
+type DebugTraceGate func(r *http.Request) bool
+
+// DebugTraceSecret allows callers whose DebugTraceHeader value is the shared
+// secret.
+func DebugTraceSecret(secret string) DebugTraceGate {
+	return func(r *http.Request) bool {
+		got := r.Header.Get(DebugTraceHeader)
+		return secret != "" && subtle.ConstantTimeCompare([]byte(got), []byte(secret)) == 1
+	}
+}
+
+// DebugTraceAllowIPs allows callers from one of the given networks, e.g. the
+// office VPN. Forwarding headers are only trusted as far as
+// ClientIPMiddleware does.
+func DebugTraceAllowIPs(prefixes ...netip.Prefix) DebugTraceGate {
+	return func(r *http.Request) bool {
+		addr, err := netip.ParseAddr(ClientIP(r))
+		if err != nil {
+			return false
+		}
+		addr = addr.Unmap()
+		for _, p := range prefixes {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// DebugTraceMiddleware promotes requests carrying DebugTraceHeader to sampled
+// when allow lets them through. It must run before TracingMiddleware so the
+// decision is made when the server span starts; children and downstream
+// services then follow it through the traceparent sampled flag.
+func DebugTraceMiddleware(allow DebugTraceGate) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(DebugTraceHeader) != "" && allow != nil && allow(r) {
+				r = r.WithContext(context.WithValue(r.Context(), forceSampleKey{}, true))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// debugSampler samples spans started under a DebugTraceMiddleware-approved
+// context and defers to base for everything else.
+type debugSampler struct {
+	base sdktrace.Sampler
+}
+
+func (s debugSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if forced, _ := p.ParentContext.Value(forceSampleKey{}).(bool); forced {
+		res := s.base.ShouldSample(p)
+		if res.Decision == sdktrace.RecordAndSample {
+			return res
+		}
+		return sdktrace.SamplingResult{
+			Decision:   sdktrace.RecordAndSample,
+			Attributes: append(res.Attributes, debugSampledKey.Bool(true)),
+			Tracestate: res.Tracestate,
+		}
+	}
+	return s.base.ShouldSample(p)
+}
+
+func (s debugSampler) Description() string {
+	return "DebugTrace{" + s.base.Description() + "}"
+}
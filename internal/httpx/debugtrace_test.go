@@ -0,0 +1,94 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestDebugTraceMiddleware(t *testing.T) {
+	const (
+		secret = "s3cret"
+		// An unsampled incoming trace, which the ratio sampler would drop.
+		traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"
+	)
+	office := DebugTraceAllowIPs(netip.MustParsePrefix("10.0.0.0/8"))
+
+	tests := []struct {
+		name        string
+		telemetry   []TelemetryOption
+		gate        DebugTraceGate
+		header      string
+		remoteAddr  string
+		wantSampled bool
+	}{
+		{name: "no header", telemetry: []TelemetryOption{WithDebugSampling()}, gate: DebugTraceSecret(secret)},
+		{name: "matching secret", telemetry: []TelemetryOption{WithDebugSampling()}, gate: DebugTraceSecret(secret), header: secret, wantSampled: true},
+		{name: "wrong secret", telemetry: []TelemetryOption{WithDebugSampling()}, gate: DebugTraceSecret(secret), header: "1"},
+		{name: "empty secret never matches", telemetry: []TelemetryOption{WithDebugSampling()}, gate: DebugTraceSecret(""), header: "1"},
+		{name: "allowed network", telemetry: []TelemetryOption{WithDebugSampling()}, gate: office, header: "1", remoteAddr: "10.1.2.3:5000", wantSampled: true},
+		{name: "other network", telemetry: []TelemetryOption{WithDebugSampling()}, gate: office, header: "1", remoteAddr: "203.0.113.7:5000"},
+		{name: "no gate", telemetry: []TelemetryOption{WithDebugSampling()}, header: secret},
+		{name: "debug sampling not enabled", gate: DebugTraceSecret(secret), header: secret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTelemetryConfig(append([]TelemetryOption{WithSampleRatio(0)}, tt.telemetry...))
+			exp := otelt.InstallTracing(t, sdktrace.WithSampler(cfg.sampler))
+
+			var outgoing http.Header
+			handler := DebugTraceMiddleware(tt.gate)(TracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx, child := Tracer().Start(r.Context(), "supplier call")
+				defer child.End()
+				outgoing = http.Header{}
+				otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(outgoing))
+			})))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("traceparent", traceparent)
+			if tt.header != "" {
+				req.Header.Set(DebugTraceHeader, tt.header)
+			}
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := exp.GetSpans()
+			if !tt.wantSampled {
+				if len(spans) != 0 {
+					t.Errorf("expected no sampled spans, got %d", len(spans))
+				}
+				return
+			}
+			if len(spans) != 2 {
+				t.Fatalf("expected server and child spans, got %d", len(spans))
+			}
+			if !strings.HasSuffix(outgoing.Get("traceparent"), "-01") {
+				t.Errorf("outgoing traceparent not sampled: %q", outgoing.Get("traceparent"))
+			}
+			for _, s := range spans {
+				if s.Name == "supplier call" {
+					continue
+				}
+				found := false
+				for _, kv := range s.Attributes {
+					if kv.Key == debugSampledKey && kv.Value.AsBool() {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("server span missing %s attribute: %v", debugSampledKey, s.Attributes)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package httpx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// defaultDecodeLimit is the body size DecodeJSON accepts unless changed.
+const defaultDecodeLimit = 1 << 20
+
+// DecodeOption configures DecodeJSON.
+type DecodeOption func(*decodeConfig)
+
+type decodeConfig struct {
+	limit    int64
+	strict   bool
+	optional bool
+}
+
+// WithDecodeLimit caps the body at n bytes, 1MiB by default. A lower limit
+// of MaxBodyMiddleware still applies.
+func WithDecodeLimit(n int64) DecodeOption {
+	return func(c *decodeConfig) { c.limit = n }
+}
+
+// WithDisallowUnknownFields rejects bodies with fields dst has no place for,
+// instead of ignoring them.
+func WithDisallowUnknownFields() DecodeOption {
+	return func(c *decodeConfig) { c.strict = true }
+}
+
+// WithOptionalBody accepts an empty body, leaving dst as it is.
+func WithOptionalBody() DecodeOption {
+	return func(c *decodeConfig) { c.optional = true }
+}
+
+// FieldError is a client error about one field of the request, whose name
+// WriteError sends in the field member of the error body.
+type FieldError struct {
+	Field string // dotted path from the top of the document, such as "trip.budget"
+	Err   error
+}
+
+func (e *FieldError) Error() string { return fmt.Sprintf("field %q: %v", e.Field, e.Err) }
+
+func (e *FieldError) Unwrap() error { return e.Err }
+
+// Error types of the requests DecodeJSON rejects.
+const (
+	decodeUnsupportedMediaType = "unsupported_media_type"
+	decodeTooLarge             = "body_too_large"
+	decodeEmptyBody            = "empty_body"
+	decodeMalformed            = "malformed_json"
+	decodeInvalidField         = "invalid_field"
+	decodeUnknownField         = "unknown_field"
+	decodeTrailingData         = "trailing_data"
+)
+
+// DecodeJSON decodes the JSON body of r into dst, which must be a pointer.
+// When the body can't be decoded it answers r with WriteError and returns
+// the error, so handlers only have to return:
+//
+//	var req bookingRequest
+//	if err := httpx.DecodeJSON(w, r, &req); err != nil {
+//		return
+//	}
+//
+// Bodies declared as anything but JSON, application/json or a +json type,
+// get a 415; bodies over the limit a 413. Empty bodies, malformed JSON,
+// values of the wrong type, unknown fields with WithDisallowUnknownFields
+// and data after the JSON value each get a 400 with their own error code,
+// naming the field at fault when there is one. A body without a
+// Content-Type is decoded as JSON.
+//
+// Failures are counted in http.server.request.decode_errors by http.route
+// and error.type.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, dst any, opts ...DecodeOption) error {
+	cfg := decodeConfig{limit: defaultDecodeLimit}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	err := decodeJSON(w, r, dst, cfg)
+	if err == nil {
+		return nil
+	}
+	_, typ := classifyError(err)
+	loadServerMetrics().decodeErrors.Add(r.Context(), 1, metric.WithAttributes(
+		attribute.String("http.route", PatternRoute(r)),
+		semconv.ErrorTypeKey.String(typ),
+	))
+	WriteError(w, r, err)
+	return err
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any, cfg decodeConfig) error {
+	if mt := mediaType(r.Header.Get("Content-Type")); mt != "" && mt != "application/json" && !strings.HasSuffix(mt, "+json") {
+		return decodeError(http.StatusUnsupportedMediaType, decodeUnsupportedMediaType,
+			fmt.Errorf("content type %q is not JSON", r.Header.Get("Content-Type")))
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		if cfg.optional {
+			return nil
+		}
+		return decodeError(http.StatusBadRequest, decodeEmptyBody, errors.New("request body is empty"))
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, cfg.limit))
+	if cfg.strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(dst); err != nil {
+		if errors.Is(err, io.EOF) && cfg.optional {
+			return nil
+		}
+		return decodeFailure(err, cfg.limit)
+	}
+	// A second value, or anything else past the first, means the client
+	// sent something other than what it meant to.
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return decodeFailure(err, cfg.limit)
+		}
+		return decodeError(http.StatusBadRequest, decodeTrailingData, errors.New("request body has data after the JSON value"))
+	}
+	return nil
+}
+
+// decodeFailure turns an error of json.Decoder into the StatusError the
+// request is answered with.
+func decodeFailure(err error, limit int64) error {
+	var (
+		tooLarge  *http.MaxBytesError
+		syntax    *json.SyntaxError
+		typeError *json.UnmarshalTypeError
+		invalid   *json.InvalidUnmarshalError
+	)
+	switch {
+	case errors.As(err, &invalid):
+		// dst is not a pointer: a bug of ours, answered with a 500.
+		return err
+	case errors.As(err, &tooLarge):
+		return decodeError(http.StatusRequestEntityTooLarge, decodeTooLarge, fmt.Errorf("request body exceeds %d bytes", limit))
+	case errors.Is(err, io.EOF):
+		return decodeError(http.StatusBadRequest, decodeEmptyBody, errors.New("request body is empty"))
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return decodeError(http.StatusBadRequest, decodeMalformed, errors.New("request body ends in the middle of the JSON value"))
+	case errors.As(err, &syntax):
+		return decodeError(http.StatusBadRequest, decodeMalformed, fmt.Errorf("malformed JSON at offset %d: %s", syntax.Offset, strings.TrimPrefix(syntax.Error(), "json: ")))
+	case errors.As(err, &typeError):
+		wrong := fmt.Errorf("got a JSON %s, want %s", typeError.Value, typeError.Type)
+		if typeError.Field == "" {
+			return decodeError(http.StatusBadRequest, decodeInvalidField, fmt.Errorf("request body: %w", wrong))
+		}
+		return decodeError(http.StatusBadRequest, decodeInvalidField, &FieldError{Field: typeError.Field, Err: wrong})
+	}
+	// DisallowUnknownFields reports its errors only as text.
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return decodeError(http.StatusBadRequest, decodeUnknownField, &FieldError{Field: strings.Trim(field, `"`), Err: errors.New("unknown field")})
+	}
+	return decodeError(http.StatusBadRequest, decodeMalformed, fmt.Errorf("invalid JSON: %s", strings.TrimPrefix(err.Error(), "json: ")))
+}
+
+func decodeError(status int, typ string, err error) error {
+	return &StatusError{Status: status, Type: typ, Err: err}
+}
@@ -0,0 +1,126 @@
+package httpx
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type decodeTrip struct {
+	Destination string `json:"destination"`
+	Budget      struct {
+		Amount   int    `json:"amount"`
+		Currency string `json:"currency"`
+	} `json:"budget"`
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		opts        []DecodeOption
+		wantStatus  int
+		wantCode    string
+		wantField   string
+	}{
+		{name: "valid", contentType: "application/json; charset=utf-8", body: `{"destination":"Lisbon","budget":{"amount":900}}`, wantStatus: http.StatusOK},
+		{name: "no content type", body: `{"destination":"Lisbon"}`, wantStatus: http.StatusOK},
+		{name: "vendor JSON type", contentType: "application/vnd.acai+json", body: `{"destination":"Lisbon"}`, wantStatus: http.StatusOK},
+		{name: "unknown field ignored", body: `{"destination":"Lisbon","pets":2}`, wantStatus: http.StatusOK},
+		{name: "trailing whitespace", body: "{\"destination\":\"Lisbon\"}\n\t ", wantStatus: http.StatusOK},
+		{name: "optional empty body", opts: []DecodeOption{WithOptionalBody()}, wantStatus: http.StatusOK},
+		{name: "not JSON", contentType: "text/plain", body: `{"destination":"Lisbon"}`, wantStatus: http.StatusUnsupportedMediaType, wantCode: "unsupported_media_type"},
+		{name: "form", contentType: "application/x-www-form-urlencoded", body: "destination=Lisbon", wantStatus: http.StatusUnsupportedMediaType, wantCode: "unsupported_media_type"},
+		{name: "too large", body: `{"destination":"` + strings.Repeat("x", 64) + `"}`, opts: []DecodeOption{WithDecodeLimit(32)}, wantStatus: http.StatusRequestEntityTooLarge, wantCode: "body_too_large"},
+		{name: "too large in trailing data", body: `{}` + strings.Repeat(" ", 64) + `{}`, opts: []DecodeOption{WithDecodeLimit(32)}, wantStatus: http.StatusRequestEntityTooLarge, wantCode: "body_too_large"},
+		{name: "empty body", wantStatus: http.StatusBadRequest, wantCode: "empty_body"},
+		{name: "whitespace body", body: "  \n", wantStatus: http.StatusBadRequest, wantCode: "empty_body"},
+		{name: "syntax error", body: `{"destination":Lisbon}`, wantStatus: http.StatusBadRequest, wantCode: "malformed_json"},
+		{name: "truncated", body: `{"destination":"Lis`, wantStatus: http.StatusBadRequest, wantCode: "malformed_json"},
+		{name: "wrong type", body: `{"destination":"Lisbon","budget":{"amount":"900"}}`, wantStatus: http.StatusBadRequest, wantCode: "invalid_field", wantField: "budget.amount"},
+		{name: "wrong top-level type", body: `["Lisbon"]`, wantStatus: http.StatusBadRequest, wantCode: "invalid_field"},
+		{name: "unknown field", body: `{"destination":"Lisbon","pets":2}`, opts: []DecodeOption{WithDisallowUnknownFields()}, wantStatus: http.StatusBadRequest, wantCode: "unknown_field", wantField: "pets"},
+		{name: "second value", body: `{"destination":"Lisbon"}{"destination":"Porto"}`, wantStatus: http.StatusBadRequest, wantCode: "trailing_data"},
+		{name: "trailing garbage", body: `{"destination":"Lisbon"} ok`, wantStatus: http.StatusBadRequest, wantCode: "trailing_data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			otelt.InstallMetrics(t)
+			var got decodeTrip
+			rt := NewRouter()
+			rt.HandleFunc("POST /trips", func(w http.ResponseWriter, r *http.Request) {
+				if err := DecodeJSON(w, r, &got, tt.opts...); err != nil {
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			})
+
+			var body io.Reader = http.NoBody
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/trips", body)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			rt.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantCode == "" {
+				if tt.body != "" && got.Destination != "Lisbon" {
+					t.Errorf("decoded %+v, want the Lisbon trip", got)
+				}
+				return
+			}
+			var resp errorBody
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode error body: %v", err)
+			}
+			if resp.Code != tt.wantCode || resp.Field != tt.wantField || resp.Error == "" {
+				t.Errorf("error body %+v, want code %q and field %q", resp, tt.wantCode, tt.wantField)
+			}
+			otelt.RequireCounterValue(t, "http.server.request.decode_errors", []attribute.KeyValue{
+				attribute.String("http.route", "/trips"),
+				attribute.String("error.type", tt.wantCode),
+			}, 1)
+		})
+	}
+}
+
+func TestDecodeJSON_NotAPointer(t *testing.T) {
+	captureLogs(t)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/trips", strings.NewReader(`{}`))
+	if err := DecodeJSON(rec, req, decodeTrip{}); err == nil {
+		t.Fatal("DecodeJSON() into a value: expected an error")
+	}
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500", rec.Code)
+	}
+}
+
+func TestWriteError_FieldProblem(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/trips", nil)
+	req.Header.Set("Accept", problemJSON)
+	WriteError(rec, req, &StatusError{Status: http.StatusBadRequest, Type: "validation",
+		Err: &FieldError{Field: "budget.currency", Err: io.ErrUnexpectedEOF}})
+
+	var problem problemBody
+	if err := json.NewDecoder(rec.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Field != "budget.currency" || !strings.Contains(problem.Detail, `field "budget.currency"`) {
+		t.Errorf("problem %+v, want the field named", problem)
+	}
+}
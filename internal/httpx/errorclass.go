@@ -0,0 +1,154 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ErrorClass is the broad kind of failure of a request, recorded as
+// error.class next to the finer error.type so dashboards can tell bad
+// requests from failing dependencies.
+type ErrorClass string
+
+// The error classes. SetErrorClass maps any other value to
+// ErrorClassInternal, so the attribute keeps to this set.
+const (
+	ErrorClassValidation ErrorClass = "validation" // the request is malformed or invalid
+	ErrorClassAuth       ErrorClass = "auth"       // the caller is not authenticated or allowed
+	ErrorClassNotFound   ErrorClass = "not_found"
+	ErrorClassConflict   ErrorClass = "conflict"
+	ErrorClassRateLimit  ErrorClass = "rate_limit" // the caller sent too many requests
+	ErrorClassTimeout    ErrorClass = "timeout"    // a deadline ran out, ours or a dependency's
+	ErrorClassUpstream   ErrorClass = "upstream"   // a dependency failed or turned us away
+	ErrorClassDatabase   ErrorClass = "database"
+	ErrorClassCanceled   ErrorClass = "canceled" // the client went away
+	ErrorClassInternal   ErrorClass = "internal"
+)
+
+func (c ErrorClass) valid() bool {
+	switch c {
+	case ErrorClassValidation, ErrorClassAuth, ErrorClassNotFound, ErrorClassConflict, ErrorClassRateLimit,
+		ErrorClassTimeout, ErrorClassUpstream, ErrorClassDatabase, ErrorClassCanceled, ErrorClassInternal:
+		return true
+	}
+	return false
+}
+
+// SetErrorClass sets the error class of the request ctx belongs to,
+// overriding the one WriteError derives from the error. Values outside the
+// ErrorClass constants are recorded as ErrorClassInternal.
+func SetErrorClass(ctx context.Context, class ErrorClass) {
+	st := stateFromContext(ctx)
+	if st == nil {
+		return
+	}
+	if !class.valid() {
+		class = ErrorClassInternal
+	}
+	st.mu.Lock()
+	st.errorClass, st.errorClassSet = class, true
+	st.mu.Unlock()
+}
+
+// WithErrorClass returns err carrying class, for WriteError to classify
+// errors it can't tell apart, such as those of the database:
+//
+//	return httpx.WithErrorClass(fmt.Errorf("load trip: %w", err), httpx.ErrorClassDatabase)
+func WithErrorClass(err error, class ErrorClass) error {
+	if err == nil {
+		return nil
+	}
+	return &classedError{err: err, class: class}
+}
+
+type classedError struct {
+	err   error
+	class ErrorClass
+}
+
+func (e *classedError) Error() string { return e.err.Error() }
+
+func (e *classedError) Unwrap() error { return e.err }
+
+// ErrorClass is how WriteError finds the class of the errors wrapping it;
+// errors of other packages may implement it too.
+func (e *classedError) ErrorClass() ErrorClass { return e.class }
+
+// errorTypeClasses classifies the error types of this package.
+var errorTypeClasses = map[string]ErrorClass{
+	ErrValidation.Type:          ErrorClassValidation,
+	decodeUnsupportedMediaType:  ErrorClassValidation,
+	decodeTooLarge:              ErrorClassValidation,
+	decodeEmptyBody:             ErrorClassValidation,
+	decodeMalformed:             ErrorClassValidation,
+	decodeInvalidField:          ErrorClassValidation,
+	decodeUnknownField:          ErrorClassValidation,
+	decodeTrailingData:          ErrorClassValidation,
+	ErrCredentialsMissing.Type:  ErrorClassAuth,
+	ErrCredentialsInvalid.Type:  ErrorClassAuth,
+	ErrNotFound.Type:            ErrorClassNotFound,
+	ErrConflict.Type:            ErrorClassConflict,
+	ErrUpstreamTimeout.Type:     ErrorClassTimeout,
+	ErrUpstreamRateLimited.Type: ErrorClassUpstream,
+	canceledErrorType:           ErrorClassCanceled,
+}
+
+// classifyErrorClass returns the class of err, answered with status and
+// typ: the one it carries, else the one of its type or of errors of this
+// package it wraps, else the one of its status.
+func classifyErrorClass(err error, status int, typ string) ErrorClass {
+	var classed interface{ ErrorClass() ErrorClass }
+	if errors.As(err, &classed) {
+		if c := classed.ErrorClass(); c.valid() {
+			return c
+		}
+		return ErrorClassInternal
+	}
+	if c, ok := errorTypeClasses[typ]; ok {
+		return c
+	}
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		return ErrorClassUpstream
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	}
+	return statusErrorClass(status)
+}
+
+// statusErrorClass returns the class of an error known by its status only.
+func statusErrorClass(status int) ErrorClass {
+	switch {
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return ErrorClassAuth
+	case status == http.StatusNotFound:
+		return ErrorClassNotFound
+	case status == http.StatusConflict:
+		return ErrorClassConflict
+	case status == http.StatusTooManyRequests:
+		return ErrorClassRateLimit
+	case status == StatusClientClosedRequest:
+		return ErrorClassCanceled
+	case status == http.StatusBadGateway, status == http.StatusServiceUnavailable:
+		return ErrorClassUpstream
+	case status == http.StatusGatewayTimeout:
+		return ErrorClassTimeout
+	case status >= 400 && status < 500:
+		return ErrorClassValidation
+	}
+	return ErrorClassInternal
+}
+
+// errorClassFor returns the error.class of a request: internal if it
+// panicked, else the class set with SetErrorClass or by WriteError. It
+// reports false for requests without one, such as those whose handler wrote
+// an error status itself.
+func (st *requestState) errorClassFor(panicked bool) (ErrorClass, bool) {
+	if panicked {
+		return ErrorClassInternal, true
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.errorClass, st.errorClass != ""
+}
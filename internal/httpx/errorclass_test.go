@@ -0,0 +1,123 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestErrorClass(t *testing.T) {
+	exp := otelt.InstallTracing(t)
+	captureLogs(t)
+
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		wantClass string // empty for none
+	}{
+		{name: "validation", wantClass: "validation", handler: func(w http.ResponseWriter, r *http.Request) {
+			WriteError(w, r, fmt.Errorf("departure after return: %w", ErrValidation))
+		}},
+		{name: "undecodable body", wantClass: "validation", handler: func(w http.ResponseWriter, r *http.Request) {
+			var v struct{}
+			_ = DecodeJSON(w, r, &v)
+		}},
+		{name: "credentials", wantClass: "auth", handler: func(w http.ResponseWriter, r *http.Request) {
+			WriteError(w, r, ErrCredentialsInvalid)
+		}},
+		{name: "not found", wantClass: "not_found", handler: func(w http.ResponseWriter, r *http.Request) {
+			WriteError(w, r, ErrNotFound)
+		}},
+		{name: "conflict", wantClass: "conflict", handler: func(w http.ResponseWriter, r *http.Request) {
+			WriteError(w, r, ErrIdempotencyInProgress)
+		}},
+		{name: "too many requests", wantClass: "rate_limit", handler: func(w http.ResponseWriter, r *http.Request) {
+			WriteError(w, r, &StatusError{Status: http.StatusTooManyRequests, Type: "quota"})
+		}},
+		{name: "deadline", wantClass: "timeout", handler: func(w http.ResponseWriter, r *http.Request) {
+			WriteError(w, r, fmt.Errorf("search flights: %w", context.DeadlineExceeded))
+		}},
+		{name: "circuit open", wantClass: "upstream", handler: func(w http.ResponseWriter, r *http.Request) {
+			WriteError(w, r, fmt.Errorf("get fares: %w", ErrCircuitOpen))
+		}},
+		{name: "upstream rate limit", wantClass: "upstream", handler: func(w http.ResponseWriter, r *http.Request) {
+			WriteError(w, r, fmt.Errorf("supplier.test: %w", ErrUpstreamRateLimited))
+		}},
+		{name: "classed error", wantClass: "database", handler: func(w http.ResponseWriter, r *http.Request) {
+			err := WithErrorClass(errors.New("server selection timeout"), ErrorClassDatabase)
+			WriteError(w, r, fmt.Errorf("load trip: %w", err))
+		}},
+		{name: "set by the handler", wantClass: "database", handler: func(w http.ResponseWriter, r *http.Request) {
+			SetErrorClass(r.Context(), ErrorClassDatabase)
+			WriteError(w, r, fmt.Errorf("load trip: %w", ErrNotFound))
+		}},
+		{name: "unknown class", wantClass: "internal", handler: func(w http.ResponseWriter, r *http.Request) {
+			SetErrorClass(r.Context(), "disk")
+			WriteError(w, r, ErrValidation)
+		}},
+		{name: "unclassified error", wantClass: "internal", handler: func(w http.ResponseWriter, r *http.Request) {
+			WriteError(w, r, errors.New("boom"))
+		}},
+		{name: "success", handler: func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}},
+		{name: "status written by the handler", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := otelt.InstallMetrics(t)
+			exp.Reset()
+			mux := http.NewServeMux()
+			mux.Handle("POST /trips", tt.handler)
+			req := httptest.NewRequest(http.MethodPost, "/trips", strings.NewReader("{"))
+			TracingMiddleware(MetricsMiddleware(mux)).ServeHTTP(httptest.NewRecorder(), req)
+
+			dps := collectSum(t, reader, "http.server.requests")
+			if len(dps) != 1 {
+				t.Fatalf("expected one series, got %+v", dps)
+			}
+			class, ok := dps[0].Attributes.Value("error.class")
+			if class.AsString() != tt.wantClass || ok != (tt.wantClass != "") {
+				t.Errorf("metric error.class %q, want %q", class.AsString(), tt.wantClass)
+			}
+
+			spans := otelt.Spans(t)
+			if len(spans) != 1 {
+				t.Fatalf("expected one span, got %d", len(spans))
+			}
+			var got string
+			for _, kv := range spans[0].Attributes() {
+				if kv.Key == "error.class" {
+					got = kv.Value.AsString()
+				}
+			}
+			if got != tt.wantClass {
+				t.Errorf("span error.class %q, want %q", got, tt.wantClass)
+			}
+		})
+	}
+}
+
+func TestWriteError_ErrorClassWithoutMiddleware(t *testing.T) {
+	otelt.InstallMetrics(t)
+	captureLogs(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /trips/{id}", func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, WithErrorClass(errors.New("connection refused"), ErrorClassDatabase))
+	})
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/trips/42", nil))
+
+	otelt.RequireCounterValue(t, "http.server.errors", []attribute.KeyValue{
+		attribute.String("http.route", "/trips/{id}"),
+		attribute.String("error.class", "database"),
+	}, 1)
+}
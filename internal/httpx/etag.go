@@ -0,0 +1,161 @@
+package httpx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// ETagOption configures NewETagMiddleware.
+type ETagOption func(*etagConfig)
+
+type etagConfig struct {
+	maxSize int
+}
+
+// WithETagMaxSize leaves responses larger than n bytes without an ETag, as
+// they would have to be held in memory whole. Defaults to 64KiB.
+func WithETagMaxSize(n int) ETagOption {
+	return func(c *etagConfig) { c.maxSize = n }
+}
+
+// ETagMiddleware adds ETags using the default options.
+func ETagMiddleware(next http.Handler) http.Handler {
+	return NewETagMiddleware()(next)
+}
+
+// NewETagMiddleware returns a middleware giving 200 responses to GET
+// requests a strong ETag, the hash of their body, and answering requests
+// whose If-None-Match matches it with a 304 and no body. Responses are held
+// back until the handler returns, except large ones and those the handler
+// flushes, which go out as they are written without an ETag. Responses with
+// an ETag or Last-Modified of their own are left alone.
+//
+// Place it outside CompressMiddleware, so that the gzipped and identity
+// representations get different ETags.
+func NewETagMiddleware(opts ...ETagOption) func(http.Handler) http.Handler {
+	cfg := etagConfig{maxSize: 64 << 10}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ew := &etagWriter{ResponseWriter: w, max: cfg.maxSize}
+			completed := false
+			defer func() {
+				// A panicking handler's buffered output is dropped so the
+				// recovery middleware can still send a 500.
+				if completed {
+					ew.finish(r.Header.Get("If-None-Match"))
+				}
+			}()
+			next.ServeHTTP(ew, r)
+			completed = true
+		})
+	}
+}
+
+// etagWriter holds back a response until it can hash all of it, or gives up
+// and passes it through.
+type etagWriter struct {
+	http.ResponseWriter
+	max         int
+	status      int
+	buf         bytes.Buffer
+	passthrough bool
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	if w.passthrough || code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	h := w.Header()
+	if code != http.StatusOK || h.Get("ETag") != "" || h.Get("Last-Modified") != "" {
+		w.bypass()
+	}
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.passthrough && w.buf.Len()+len(b) > w.max {
+		w.bypass()
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.buf.Write(b)
+}
+
+// Flush gives up on the ETag, keeping streaming endpoints working.
+func (w *etagWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.passthrough {
+		w.bypass()
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *etagWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// bypass sends what is held back and passes everything else through.
+func (w *etagWriter) bypass() {
+	w.passthrough = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() > 0 {
+		_, _ = w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+}
+
+// finish sends the held back response with its ETag, or a 304 if the client
+// has it already.
+func (w *etagWriter) finish(ifNoneMatch string) {
+	if w.passthrough || w.status == 0 {
+		// Passed through already, or nothing written: leave the implicit
+		// 200 to net/http.
+		return
+	}
+	sum := sha256.Sum256(w.buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	h := w.Header()
+	h.Set("ETag", etag)
+	if etagMatches(ifNoneMatch, etag) {
+		h.Del("Content-Length")
+		w.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(w.buf.Bytes())
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using
+// the weak comparison RFC 9110 prescribes for it.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package httpx
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+)
+
+func TestETagMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/itineraries/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "itinerary")
+		if r.PathValue("id") == "2" {
+			_, _ = io.WriteString(w, " changed")
+		}
+	})
+	mux.HandleFunc("/own", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = io.WriteString(w, "own")
+	})
+	mux.HandleFunc("/modified", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", "Mon, 12 Oct 2026 10:00:00 GMT")
+		_, _ = io.WriteString(w, "modified")
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such itinerary", http.StatusNotFound)
+	})
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, strings.Repeat("x", 33))
+	})
+	handler := NewETagMiddleware(WithETagMaxSize(32))(mux)
+
+	serve := func(method, path, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The ETag only depends on the body.
+	const etag = `"9844cdc6c5489db0bbee8d7539bd2e7d"`
+	for range 2 {
+		if got := serve(http.MethodGet, "/itineraries/1", "").Header().Get("ETag"); got != etag {
+			t.Fatalf("ETag = %s, want %s", got, etag)
+		}
+	}
+	if got := serve(http.MethodGet, "/itineraries/2", "").Header().Get("ETag"); got == etag || got == "" {
+		t.Errorf("changed body got ETag %s", got)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		ifNoneMatch string
+		wantStatus  int
+		wantETag    string
+		wantNoETag  bool
+	}{
+		{name: "match", path: "/itineraries/1", ifNoneMatch: etag, wantStatus: http.StatusNotModified, wantETag: etag},
+		{name: "weak match", path: "/itineraries/1", ifNoneMatch: `"other", W/` + etag, wantStatus: http.StatusNotModified, wantETag: etag},
+		{name: "wildcard", path: "/itineraries/1", ifNoneMatch: "*", wantStatus: http.StatusNotModified, wantETag: etag},
+		{name: "stale", path: "/itineraries/2", ifNoneMatch: etag, wantStatus: http.StatusOK},
+		{name: "handler etag", path: "/own", ifNoneMatch: `"v1"`, wantStatus: http.StatusOK, wantETag: `"v1"`},
+		{name: "last modified", path: "/modified", ifNoneMatch: "*", wantStatus: http.StatusOK, wantNoETag: true},
+		{name: "not ok", path: "/missing", ifNoneMatch: "*", wantStatus: http.StatusNotFound, wantNoETag: true},
+		{name: "too big", path: "/big", ifNoneMatch: "*", wantStatus: http.StatusOK, wantNoETag: true},
+		{name: "not get", method: http.MethodPost, path: "/itineraries/1", ifNoneMatch: etag, wantStatus: http.StatusOK, wantNoETag: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			rec := serve(method, tt.path, tt.ifNoneMatch)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantETag != "" && rec.Header().Get("ETag") != tt.wantETag {
+				t.Errorf("ETag = %s, want %s", rec.Header().Get("ETag"), tt.wantETag)
+			}
+			if tt.wantNoETag && rec.Header().Get("ETag") != "" {
+				t.Errorf("unexpected ETag %s", rec.Header().Get("ETag"))
+			}
+			if tt.wantStatus == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Errorf("304 with body %q", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestETagMiddleware_Flush(t *testing.T) {
+	handler := ETagMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "event: 1\n\n")
+		_ = http.NewResponseController(w).Flush()
+		_, _ = io.WriteString(w, "event: 2\n\n")
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events", nil))
+
+	if !rec.Flushed {
+		t.Error("flush did not reach the client")
+	}
+	if rec.Header().Get("ETag") != "" {
+		t.Errorf("flushed response got ETag %s", rec.Header().Get("ETag"))
+	}
+	if rec.Body.String() != "event: 1\n\nevent: 2\n\n" {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+}
+
+func TestETagMiddleware_NotModifiedTelemetry(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	handler := MetricsMiddleware(AccessLogMiddleware(logger)(ETagMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "itinerary")
+	}))))
+	req := httptest.NewRequest(http.MethodGet, "/itineraries/1", nil)
+	req.Header.Set("If-None-Match", `"9844cdc6c5489db0bbee8d7539bd2e7d"`)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	dps := collectIntHistogram(t, reader, "http.server.response.size")
+	if len(dps) != 1 || dps[0].Sum != 0 {
+		t.Errorf("response size = %+v, want one 0 byte response", dps)
+	}
+	lines := decodeLogLines(t, &logs)
+	if len(lines) != 1 || lines[0]["http_status"] != float64(http.StatusNotModified) {
+		t.Errorf("access log = %v, want one line with status 304", lines)
+	}
+}
+
+func TestETagMiddleware_Compression(t *testing.T) {
+	body := strings.Repeat("itinerary ", 200)
+	handler := ETagMiddleware(CompressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, body)
+	})))
+	serve := func(acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/itineraries/1", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	gzipped := serve("gzip", "")
+	identity := serve("", "")
+	if gzipped.Header().Get("Content-Encoding") != "gzip" || identity.Body.String() != body {
+		t.Fatalf("unexpected responses: %v, %v", gzipped.Header(), identity.Header())
+	}
+	gzipETag, identityETag := gzipped.Header().Get("ETag"), identity.Header().Get("ETag")
+	if gzipETag == "" || gzipETag == identityETag {
+		t.Errorf("gzip ETag %s, identity ETag %s, want distinct ETags", gzipETag, identityETag)
+	}
+	if again := serve("gzip", "").Header().Get("ETag"); again != gzipETag {
+		t.Errorf("gzip ETag changed from %s to %s", gzipETag, again)
+	}
+
+	if rec := serve("gzip", gzipETag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("gzip revalidation got %d with %d bytes, want an empty 304", rec.Code, rec.Body.Len())
+	}
+	if rec := serve("", gzipETag); rec.Code != http.StatusOK || rec.Body.String() != body {
+		t.Errorf("identity request with the gzip ETag got %d, want the full body", rec.Code)
+	}
+}
@@ -0,0 +1,148 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// exportLogInterval is how often the same kind of telemetry error is logged;
+// a dead collector fails every batch.
+const exportLogInterval = time.Minute
+
+// exportError tells the error handler which signal failed to export.
+type exportError struct {
+	signal string
+	err    error
+}
+
+func (e *exportError) Error() string { return e.signal + " export: " + e.err.Error() }
+
+func (e *exportError) Unwrap() error { return e.err }
+
+// signalSpanExporter marks the errors of a span exporter as trace errors.
+type signalSpanExporter struct {
+	sdktrace.SpanExporter
+}
+
+func (e signalSpanExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if err := e.SpanExporter.ExportSpans(ctx, spans); err != nil {
+		return &exportError{signal: "traces", err: err}
+	}
+	return nil
+}
+
+// spanShutdownErrors collects the errors span exporters fail to shut down
+// with, which batchers only hand to the error handler, for Shutdown to
+// return them along with those of the batchers.
+type spanShutdownErrors struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (s *spanShutdownErrors) wrap(exp sdktrace.SpanExporter) sdktrace.SpanExporter {
+	return shutdownErrorExporter{exp, s}
+}
+
+func (s *spanShutdownErrors) shutdown(tp *sdktrace.TracerProvider) Shutdown {
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return errors.Join(append([]error{err}, s.errs...)...)
+	}
+}
+
+type shutdownErrorExporter struct {
+	sdktrace.SpanExporter
+	errs *spanShutdownErrors
+}
+
+func (e shutdownErrorExporter) Shutdown(ctx context.Context) error {
+	if err := e.SpanExporter.Shutdown(ctx); err != nil {
+		e.errs.mu.Lock()
+		e.errs.errs = append(e.errs.errs, err)
+		e.errs.mu.Unlock()
+	}
+	return nil
+}
+
+// signalMetricExporter marks the errors of a metric exporter as metric
+// errors.
+type signalMetricExporter struct {
+	sdkmetric.Exporter
+}
+
+func (e signalMetricExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	if err := e.Exporter.Export(ctx, rm); err != nil {
+		return &exportError{signal: "metrics", err: err}
+	}
+	return nil
+}
+
+// signalLogExporter marks the errors of a log exporter as log errors.
+type signalLogExporter struct {
+	sdklog.Exporter
+}
+
+func (e signalLogExporter) Export(ctx context.Context, records []sdklog.Record) error {
+	if err := e.Exporter.Export(ctx, records); err != nil {
+		return &exportError{signal: "logs", err: err}
+	}
+	return nil
+}
+
+// telemetryErrorHandler receives the errors the SDK can't return to anyone,
+// such as failed background exports. It counts them in otel.export.failures
+// and logs them at most once per exportLogInterval and signal.
+type telemetryErrorHandler struct {
+	failures metric.Int64Counter
+	now      func() time.Time
+
+	mu         sync.Mutex
+	lastLogged map[string]time.Time
+	suppressed map[string]int
+}
+
+func newTelemetryErrorHandler(mp metric.MeterProvider) (*telemetryErrorHandler, error) {
+	failures, err := mp.Meter(instrumentationName).Int64Counter("otel.export.failures",
+		metric.WithDescription("Total number of telemetry export and SDK errors"))
+	return &telemetryErrorHandler{
+		failures:   failures,
+		now:        time.Now,
+		lastLogged: map[string]time.Time{},
+		suppressed: map[string]int{},
+	}, err
+}
+
+func (h *telemetryErrorHandler) Handle(err error) {
+	signal := "other"
+	var ee *exportError
+	if errors.As(err, &ee) {
+		signal = ee.signal
+	}
+	h.failures.Add(context.Background(), 1, metric.WithAttributes(attribute.String("signal", signal)))
+
+	h.mu.Lock()
+	now := h.now()
+	if last, ok := h.lastLogged[signal]; ok && now.Sub(last) < exportLogInterval {
+		h.suppressed[signal]++
+		h.mu.Unlock()
+		return
+	}
+	suppressed := h.suppressed[signal]
+	h.lastLogged[signal] = now
+	h.suppressed[signal] = 0
+	h.mu.Unlock()
+
+	slog.Warn("OpenTelemetry error", "signal", signal, "error", err, "suppressed", suppressed)
+}
@@ -0,0 +1,108 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// failingSpanExporter fails every export, like one pointed at a dead
+// collector.
+type failingSpanExporter struct{}
+
+func (failingSpanExporter) ExportSpans(context.Context, []sdktrace.ReadOnlySpan) error {
+	return errors.New("connection refused")
+}
+
+func (failingSpanExporter) Shutdown(context.Context) error { return nil }
+
+func TestInitTelemetry_ReportsExportFailures(t *testing.T) {
+	ctx := context.Background()
+	prevMP, prevTP, prevHandler := otel.GetMeterProvider(), otel.GetTracerProvider(), otel.GetErrorHandler()
+	t.Cleanup(func() {
+		otel.SetMeterProvider(prevMP)
+		otel.SetTracerProvider(prevTP)
+		otel.SetErrorHandler(prevHandler)
+	})
+	logs := captureLogs(t)
+
+	reader := sdkmetric.NewManualReader()
+	shutdown, err := InitTelemetry(ctx, "acai-test", WithMetricReader(reader), WithSpanExporter(failingSpanExporter{}))
+	if err != nil {
+		t.Fatalf("InitTelemetry() unexpected error: %v", err)
+	}
+	defer func() { _ = shutdown(ctx) }()
+
+	_, span := Tracer().Start(ctx, "op")
+	span.End()
+	// Stopping the tracer provider alone exports the queued span in the
+	// background, reporting failure to the error handler, while metrics can
+	// still be collected.
+	_ = otel.GetTracerProvider().(*sdktrace.TracerProvider).Shutdown(ctx)
+
+	m, ok := otelt.MetricFrom(t, reader, "otel.export.failures")
+	if !ok {
+		t.Fatal("otel.export.failures not recorded")
+	}
+	dps := m.Data.(metricdata.Sum[int64]).DataPoints
+	if len(dps) != 1 || dps[0].Value != 1 {
+		t.Fatalf("expected one failure, got %+v", dps)
+	}
+	if signal, _ := dps[0].Attributes.Value("signal"); signal.AsString() != "traces" {
+		t.Errorf("signal mismatch: got %q", signal.AsString())
+	}
+
+	var warnings []map[string]any
+	for _, line := range decodeLogLines(t, logs) {
+		if line["level"] == "WARN" {
+			warnings = append(warnings, line)
+		}
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("expected a single warning, got %v", warnings)
+	}
+	if !strings.Contains(warnings[0]["error"].(string), "connection refused") {
+		t.Errorf("unexpected warning: %v", warnings[0])
+	}
+}
+
+func TestTelemetryErrorHandler_RateLimitsLogs(t *testing.T) {
+	otelt.InstallMetrics(t)
+	logs := captureLogs(t)
+
+	h, err := newTelemetryErrorHandler(otel.GetMeterProvider())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(0, 0)
+	h.now = func() time.Time { return now }
+
+	traces := &exportError{signal: "traces", err: errors.New("unavailable")}
+	h.Handle(traces)
+	h.Handle(traces)
+	h.Handle(&exportError{signal: "metrics", err: errors.New("unavailable")})
+	h.Handle(errors.New("duplicate instrument"))
+	now = now.Add(exportLogInterval)
+	h.Handle(traces)
+
+	otelt.RequireCounterValue(t, "otel.export.failures", []attribute.KeyValue{attribute.String("signal", "traces")}, 3)
+	otelt.RequireCounterValue(t, "otel.export.failures", []attribute.KeyValue{attribute.String("signal", "metrics")}, 1)
+	otelt.RequireCounterValue(t, "otel.export.failures", []attribute.KeyValue{attribute.String("signal", "other")}, 1)
+
+	lines := decodeLogLines(t, logs)
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 log lines, got %d: %s", len(lines), logs)
+	}
+	if last := lines[3]; last["signal"] != "traces" || last["suppressed"] != float64(1) {
+		t.Errorf("expected the suppressed count on the next log line, got %v", last)
+	}
+}
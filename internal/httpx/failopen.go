@@ -0,0 +1,186 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// maxFailOpenRetry caps the backoff between attempts at building an
+// exporter that failed.
+const maxFailOpenRetry = time.Minute
+
+// errExporterPending is what pending exporters fail to export with, so that
+// the telemetry dropped meanwhile is counted as export failures.
+var errExporterPending = errors.New("exporter not available yet")
+
+// WithFailOpen keeps InitTelemetry from failing when an exporter can't be
+// built, e.g. as the directory of WithStdoutFile isn't mounted yet: the
+// failure is logged, the signal is dropped meanwhile, and the exporter is
+// built again in the background, backing off up to a minute, until it
+// succeeds. The Shutdown returned stays valid throughout, and stops the
+// retries. An unreachable OTLP endpoint doesn't fail InitTelemetry anyway;
+// its exports do.
+func WithFailOpen() TelemetryOption {
+	return func(c *telemetryConfig) { c.failOpen = true }
+}
+
+// buildExporter returns the exporter newExp builds or, failing that with
+// WithFailOpen, the one pending returns until the retries succeed.
+func buildExporter[E shutdowner](ctx context.Context, cfg telemetryConfig, signal string,
+	newExp func(context.Context, telemetryConfig) (E, error), pending func(*retrying[E]) E,
+) (E, error) {
+	exp, err := newExp(ctx, cfg)
+	if err == nil || !cfg.failOpen {
+		return exp, err
+	}
+	slog.Warn("Telemetry exporter unavailable, retrying in the background", "signal", signal, "error", err)
+	build := func(ctx context.Context) (E, error) { return newExp(ctx, cfg) }
+	return pending(newRetrying(signal, cfg.failOpenRetry, build)), nil
+}
+
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// retrying builds an exporter in the background until it succeeds.
+type retrying[E shutdowner] struct {
+	signal  string
+	current atomic.Pointer[E] // nil until built
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func newRetrying[E shutdowner](signal string, retry time.Duration, build func(context.Context) (E, error)) *retrying[E] {
+	if retry <= 0 {
+		retry = time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &retrying[E]{signal: signal, cancel: cancel, done: make(chan struct{})}
+	go r.run(ctx, retry, build)
+	return r
+}
+
+func (r *retrying[E]) run(ctx context.Context, retry time.Duration, build func(context.Context) (E, error)) {
+	defer close(r.done)
+	for attempt := 2; ; attempt++ {
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return
+		}
+		exp, err := build(ctx)
+		if err == nil {
+			r.current.Store(&exp)
+			slog.Info("Telemetry exporter available", "signal", r.signal, "attempt", attempt)
+			return
+		}
+		slog.Debug("Telemetry exporter still unavailable", "signal", r.signal, "attempt", attempt, "error", err)
+		retry = min(2*retry, maxFailOpenRetry)
+	}
+}
+
+// exporter returns the exporter, once built.
+func (r *retrying[E]) exporter() (E, bool) {
+	if exp := r.current.Load(); exp != nil {
+		return *exp, true
+	}
+	var zero E
+	return zero, false
+}
+
+// shutdown stops the retries, then shuts the exporter down if it was built.
+func (r *retrying[E]) shutdown(ctx context.Context) error {
+	r.cancel()
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if exp, ok := r.exporter(); ok {
+		return exp.Shutdown(ctx)
+	}
+	return nil
+}
+
+type pendingSpanExporter struct {
+	r *retrying[sdktrace.SpanExporter]
+}
+
+func newPendingSpanExporter(r *retrying[sdktrace.SpanExporter]) sdktrace.SpanExporter {
+	return pendingSpanExporter{r}
+}
+
+func (e pendingSpanExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if exp, ok := e.r.exporter(); ok {
+		return exp.ExportSpans(ctx, spans)
+	}
+	return errExporterPending
+}
+
+func (e pendingSpanExporter) Shutdown(ctx context.Context) error { return e.r.shutdown(ctx) }
+
+// pendingMetricExporter selects the default temporality and aggregation,
+// as the OTLP and stdout exporters do here, before it's built.
+type pendingMetricExporter struct {
+	r *retrying[sdkmetric.Exporter]
+}
+
+func newPendingMetricExporter(r *retrying[sdkmetric.Exporter]) sdkmetric.Exporter {
+	return pendingMetricExporter{r}
+}
+
+func (e pendingMetricExporter) Temporality(k sdkmetric.InstrumentKind) metricdata.Temporality {
+	return sdkmetric.DefaultTemporalitySelector(k)
+}
+
+func (e pendingMetricExporter) Aggregation(k sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return sdkmetric.DefaultAggregationSelector(k)
+}
+
+func (e pendingMetricExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	if exp, ok := e.r.exporter(); ok {
+		return exp.Export(ctx, rm)
+	}
+	return errExporterPending
+}
+
+func (e pendingMetricExporter) ForceFlush(ctx context.Context) error {
+	if exp, ok := e.r.exporter(); ok {
+		return exp.ForceFlush(ctx)
+	}
+	return nil
+}
+
+func (e pendingMetricExporter) Shutdown(ctx context.Context) error { return e.r.shutdown(ctx) }
+
+type pendingLogExporter struct {
+	r *retrying[sdklog.Exporter]
+}
+
+func newPendingLogExporter(r *retrying[sdklog.Exporter]) sdklog.Exporter {
+	return pendingLogExporter{r}
+}
+
+func (e pendingLogExporter) Export(ctx context.Context, records []sdklog.Record) error {
+	if exp, ok := e.r.exporter(); ok {
+		return exp.Export(ctx, records)
+	}
+	return errExporterPending
+}
+
+func (e pendingLogExporter) ForceFlush(ctx context.Context) error {
+	if exp, ok := e.r.exporter(); ok {
+		return exp.ForceFlush(ctx)
+	}
+	return nil
+}
+
+func (e pendingLogExporter) Shutdown(ctx context.Context) error { return e.r.shutdown(ctx) }
@@ -0,0 +1,123 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRetrying_Recovers(t *testing.T) {
+	captureLogs(t)
+	var attempts atomic.Int32
+	backend := keptSpans()
+	exp := newPendingSpanExporter(newRetrying("traces", time.Millisecond, func(context.Context) (sdktrace.SpanExporter, error) {
+		if attempts.Add(1) < 3 {
+			return nil, errors.New("no such host")
+		}
+		return backend, nil
+	}))
+
+	spans := tracetest.SpanStubs{{Name: "search"}}.Snapshots()
+	if err := exp.ExportSpans(context.Background(), spans); !errors.Is(err, errExporterPending) {
+		t.Errorf("export while pending = %v, want errExporterPending", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for exp.ExportSpans(context.Background(), spans) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the exporter was never built")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := len(backend.GetSpans()); got != 1 {
+		t.Errorf("backend got %d spans, want the one exported once built", got)
+	}
+
+	backend.err = errFailingShutdown
+	if err := exp.Shutdown(context.Background()); !errors.Is(err, errFailingShutdown) {
+		t.Errorf("shutdown = %v, want the backend's", err)
+	}
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("built %d times, want 3", got)
+	}
+}
+
+func TestRetrying_ShutdownStopsRetries(t *testing.T) {
+	captureLogs(t)
+	var attempts atomic.Int32
+	exp := newPendingSpanExporter(newRetrying("traces", time.Millisecond, func(context.Context) (sdktrace.SpanExporter, error) {
+		attempts.Add(1)
+		return nil, errors.New("no such host")
+	}))
+	time.Sleep(20 * time.Millisecond)
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	n := attempts.Load()
+	time.Sleep(20 * time.Millisecond)
+	if got := attempts.Load(); got != n {
+		t.Errorf("retried %d more times after Shutdown", got-n)
+	}
+}
+
+func TestInitTelemetry_FailOpen(t *testing.T) {
+	restoreGlobals(t)
+	captureLogs(t)
+	dir := filepath.Join(t.TempDir(), "telemetry")
+	path := filepath.Join(dir, "telemetry.json")
+
+	if _, err := InitTelemetry(context.Background(), "test", WithStdoutFile(path)); err == nil {
+		t.Fatal("InitTelemetry() succeeded without the file's directory")
+	}
+	shutdown, err := InitTelemetry(context.Background(), "test",
+		WithStdoutFile(path),
+		WithFailOpen(),
+		func(c *telemetryConfig) { c.failOpenRetry = 10 * time.Millisecond },
+	)
+	if err != nil {
+		t.Fatalf("InitTelemetry() with WithFailOpen unexpected error: %v", err)
+	}
+	mp := otel.GetMeterProvider().(*sdkmetric.MeterProvider)
+	if err := mp.ForceFlush(context.Background()); !errors.Is(err, errExporterPending) {
+		t.Errorf("flush without the file = %v, want errExporterPending", err)
+	}
+
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for mp.ForceFlush(context.Background()) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the metric exporter was never built")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for otel.GetTracerProvider().(*sdktrace.TracerProvider).ForceFlush(context.Background()) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the span exporter was never built")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, span := otel.Tracer("test").Start(context.Background(), "plan-trip")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), `"plan-trip"`) || !strings.Contains(string(out), `"ScopeMetrics"`) {
+		t.Errorf("telemetry missing from the file once it could be created:\n%s", out)
+	}
+}
@@ -0,0 +1,107 @@
+package httpx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// maxErrorFingerprints caps the distinct error.fingerprint values of
+// http.server.error_fingerprints; later ones are recorded as OverflowValue.
+const maxErrorFingerprints = 100
+
+// fingerprintFrames is how many frames of the code that panicked tell
+// panics apart.
+const fingerprintFrames = 3
+
+// errorFingerprints guards the error.fingerprint attribute of
+// http.server.error_fingerprints. Tests replace it to lower the cap.
+var errorFingerprints = newAttributeGuard(nil, maxErrorFingerprints)
+
+// errorFingerprintKey is the attribute of the fingerprint of a server
+// error, on the span and in http.server.error_fingerprints, and the field
+// of the error log lines.
+const errorFingerprintKey = "error.fingerprint"
+
+// panicFingerprint returns the fingerprint of a panic with value v raised
+// with the call stack pcs: a hash of the type of v and of the functions of
+// the innermost frames outside the standard library. Neither the message
+// nor line numbers go into it, so the same bug has the same fingerprint
+// across requests and unrelated edits.
+func panicFingerprint(v any, pcs []uintptr) string {
+	parts := []string{"panic", fmt.Sprintf("%T", v)}
+	frames := runtime.CallersFrames(pcs)
+	// The frames up to runtime.gopanic are those recovering.
+	var panicking bool
+	for n := 0; n < fingerprintFrames; {
+		frame, more := frames.Next()
+		switch {
+		case frame.Function == "runtime.gopanic":
+			panicking = true
+		case panicking && !stdlibFunction(frame.Function):
+			parts = append(parts, frame.Function)
+			n++
+		}
+		if !more {
+			break
+		}
+	}
+	return fingerprint(parts)
+}
+
+// errorFingerprint returns the fingerprint of a server error err of type
+// typ: a hash of typ and of the types of the errors err wraps.
+func errorFingerprint(err error, typ string) string {
+	parts := []string{"error", typ}
+	var walk func(err error)
+	walk = func(err error) {
+		for err != nil {
+			parts = append(parts, fmt.Sprintf("%T", err))
+			switch u := err.(type) {
+			case interface{ Unwrap() []error }:
+				for _, err := range u.Unwrap() {
+					walk(err)
+				}
+				return
+			default:
+				err = errors.Unwrap(err)
+			}
+		}
+	}
+	walk(err)
+	return fingerprint(parts)
+}
+
+func fingerprint(parts []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// stdlibFunction reports whether the fully qualified function name belongs
+// to the standard library, whose import paths have no dot in their first
+// element. Code of package main doesn't either, but is no library.
+func stdlibFunction(fn string) bool {
+	path := fn
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		path = path[:i]
+	} else if i := strings.Index(path, "."); i >= 0 {
+		path = path[:i]
+	}
+	first, _, _ := strings.Cut(path, "/")
+	return !strings.Contains(first, ".") && first != "main"
+}
+
+// recordErrorFingerprint counts a server error with fingerprint fp in
+// http.server.error_fingerprints.
+func recordErrorFingerprint(ctx context.Context, fp, typ string) {
+	attrs := errorFingerprints.filter(ctx, []attribute.KeyValue{attribute.String(errorFingerprintKey, fp)})
+	loadServerMetrics().fingerprints.Add(ctx, 1, metric.WithAttributes(append(attrs, semconv.ErrorTypeKey.String(typ))...))
+}
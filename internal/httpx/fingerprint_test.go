@@ -0,0 +1,149 @@
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type fingerprintTrip struct{ legs []string }
+
+//go:noinline
+func firstLeg(t *fingerprintTrip) string { return t.legs[0] }
+
+//go:noinline
+func lastLeg(t *fingerprintTrip) string { return t.legs[len(t.legs)-1] }
+
+func TestErrorFingerprint_Panics(t *testing.T) {
+	logs := captureLogs(t)
+	exp := otelt.InstallTracing(t)
+	otelt.InstallMetrics(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /trips/{id}/first", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(firstLeg(&fingerprintTrip{})))
+	})
+	mux.HandleFunc("GET /trips/{id}/last", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(lastLeg(nil)))
+	})
+	mux.HandleFunc("GET /trips/{id}/message", func(w http.ResponseWriter, r *http.Request) {
+		panic("no trip " + r.PathValue("id"))
+	})
+	handler := RecoverMiddleware(TracingMiddleware(mux))
+	serve := func(path string) string {
+		exp.Reset()
+		logs.Reset()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		lines := decodeLogLines(t, logs)
+		if len(lines) != 1 {
+			t.Fatalf("GET %s: %d log lines, want the panic", path, len(lines))
+		}
+		fp, _ := lines[0]["error_fingerprint"].(string)
+		var spanFP string
+		for _, kv := range otelt.Spans(t)[0].Attributes() {
+			if kv.Key == errorFingerprintKey {
+				spanFP = kv.Value.AsString()
+			}
+		}
+		if len(fp) != 16 || spanFP != fp {
+			t.Errorf("GET %s: fingerprint %q logged and %q on the span, want the same 16 hex digits", path, fp, spanFP)
+		}
+		return fp
+	}
+
+	first := serve("/trips/1/first")
+	if got := serve("/trips/2/first"); got != first {
+		t.Errorf("the same panic in another request got fingerprint %s, want %s", got, first)
+	}
+	last := serve("/trips/1/last")
+	message := serve("/trips/1/message")
+	if got := serve("/trips/2/message"); got != message {
+		t.Errorf("a panic with another message got fingerprint %s, want %s", got, message)
+	}
+	if first == last || first == message || last == message {
+		t.Errorf("different panics share a fingerprint: %s, %s, %s", first, last, message)
+	}
+
+	otelt.RequireCounterValue(t, "http.server.error_fingerprints", []attribute.KeyValue{
+		attribute.String(errorFingerprintKey, first),
+		attribute.String("error.type", "panic"),
+	}, 2)
+}
+
+func TestErrorFingerprint_Errors(t *testing.T) {
+	logs := captureLogs(t)
+	otelt.InstallMetrics(t)
+
+	fingerprintOf := func(err error) string {
+		t.Helper()
+		logs.Reset()
+		WriteError(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/trips", nil), err)
+		lines := decodeLogLines(t, logs)
+		if len(lines) == 0 {
+			return ""
+		}
+		fp, _ := lines[0]["error_fingerprint"].(string)
+		return fp
+	}
+
+	load := func(id int) error {
+		return fmt.Errorf("load trip %d: %w", id, &fs.PathError{Op: "open", Path: "/data/" + strconv.Itoa(id), Err: fs.ErrNotExist})
+	}
+	notFound := fingerprintOf(load(1))
+	if notFound == "" {
+		t.Fatal("no fingerprint logged for a server error")
+	}
+	if got := fingerprintOf(load(2)); got != notFound {
+		t.Errorf("the same error chain got fingerprint %s, want %s", got, notFound)
+	}
+	tests := map[string]error{
+		"unwrapped":        &fs.PathError{Op: "open", Path: "/data/1", Err: fs.ErrNotExist},
+		"another cause":    fmt.Errorf("load trip: %w", &strconv.NumError{Func: "Atoi", Num: "x", Err: strconv.ErrSyntax}),
+		"another type":     fmt.Errorf("load trip: %w", ErrUpstreamTimeout),
+		"joined":           errors.Join(load(1), errors.New("rollback failed")),
+		"bare":             errors.New("boom"),
+		"double wrap":      fmt.Errorf("get trips: %w", load(1)),
+		"wrapping several": fmt.Errorf("%w and %w", load(1), load(2)),
+	}
+	seen := map[string]string{notFound: "wrapped path error"}
+	for name, err := range tests {
+		fp := fingerprintOf(err)
+		if other, ok := seen[fp]; ok {
+			t.Errorf("%s has the fingerprint of %s: %s", name, other, fp)
+		}
+		seen[fp] = name
+	}
+
+	if got := fingerprintOf(ErrNotFound); got != "" {
+		t.Errorf("client error fingerprinted: %s", got)
+	}
+}
+
+func TestErrorFingerprint_Overflow(t *testing.T) {
+	captureLogs(t)
+	otelt.InstallMetrics(t)
+	prev := errorFingerprints
+	errorFingerprints = newAttributeGuard(nil, 2)
+	t.Cleanup(func() { errorFingerprints = prev })
+
+	for _, err := range []error{
+		errors.New("boom"),
+		fmt.Errorf("wrapped: %w", errors.New("boom")),
+		errors.Join(errors.New("boom")),
+		&fs.PathError{Op: "open", Err: fs.ErrNotExist},
+	} {
+		WriteError(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/trips", nil), err)
+	}
+
+	otelt.RequireCounterValue(t, "http.server.error_fingerprints", []attribute.KeyValue{
+		attribute.String(errorFingerprintKey, OverflowValue),
+		attribute.String("error.type", "internal"),
+	}, 2)
+}
@@ -0,0 +1,169 @@
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// HealthCheck reports whether a dependency is usable. It should return
+// promptly once ctx is done.
+type HealthCheck func(ctx context.Context) error
+
+// HealthOption configures NewHealth.
+type HealthOption func(*Health)
+
+// WithCheckTimeout bounds each check. Defaults to 2s.
+func WithCheckTimeout(d time.Duration) HealthOption {
+	return func(h *Health) { h.timeout = d }
+}
+
+// Health is a registry of named checks backing the liveness and readiness
+// endpoints. It starts not ready; Run flips it when given WithHealth.
+type Health struct {
+	timeout time.Duration
+	ready   atomic.Bool
+
+	mu     sync.RWMutex
+	checks map[string]HealthCheck
+}
+
+// NewHealth returns an empty, not ready registry.
+func NewHealth(opts ...HealthOption) *Health {
+	h := &Health{timeout: 2 * time.Second, checks: map[string]HealthCheck{}}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Register adds a check run by the readiness endpoint, replacing any check
+// with the same name.
+func (h *Health) Register(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = check
+}
+
+// SetReady marks the service as able to take traffic.
+func (h *Health) SetReady() { h.ready.Store(true) }
+
+// SetNotReady takes the service out of rotation, e.g. before draining.
+func (h *Health) SetNotReady() { h.ready.Store(false) }
+
+// Ready reports whether SetReady was called more recently than SetNotReady.
+func (h *Health) Ready() bool { return h.ready.Load() }
+
+// CheckResult is the outcome of one check in the readiness response.
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthReport is the body served by the health endpoints.
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Ready  bool                   `json:"ready"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// LivenessHandler serves /healthz. It answers 200 as long as the process can
+// serve HTTP; dependency failures belong in readiness, not in restarts.
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealthReport(w, http.StatusOK, HealthReport{Status: "ok", Ready: h.Ready()})
+	})
+}
+
+// ReadinessHandler serves /readyz. It runs all checks concurrently and
+// answers 503 if any fails or the service is not ready.
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := HealthReport{Status: "ok", Ready: h.Ready(), Checks: h.run(r.Context())}
+		status := http.StatusOK
+		for _, res := range report.Checks {
+			if res.Status != "ok" {
+				report.Status = "unavailable"
+			}
+		}
+		if !report.Ready {
+			report.Status = "unavailable"
+		}
+		if report.Status != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+		writeHealthReport(w, status, report)
+	})
+}
+
+func (h *Health) run(ctx context.Context) map[string]CheckResult {
+	h.mu.RLock()
+	checks := make(map[string]HealthCheck, len(h.checks))
+	for name, check := range h.checks {
+		checks[name] = check
+	}
+	h.mu.RUnlock()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]CheckResult, len(checks))
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := h.runCheck(ctx, name, check)
+			mu.Lock()
+			results[name] = res
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (h *Health) runCheck(ctx context.Context, name string, check HealthCheck) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	start := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				errCh <- fmt.Errorf("panic: %v", v)
+			}
+		}()
+		errCh <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		// A check ignoring its context is abandoned rather than waited for.
+		err = ctx.Err()
+	}
+
+	res := CheckResult{Status: "ok", LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		res.Status = "failed"
+		res.Error = err.Error()
+		loadServerMetrics().healthFailures.Add(ctx, 1,
+			metric.WithAttributes(attribute.String("health.check", name)))
+	}
+	return res
+}
+
+func writeHealthReport(w http.ResponseWriter, status int, report HealthReport) {
+	w.Header().Set("Cache-Control", "no-store")
+	_ = WriteJSON(w, status, report)
+}
@@ -0,0 +1,106 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+)
+
+func TestHealth_Readiness(t *testing.T) {
+	tests := []struct {
+		name       string
+		ready      bool
+		checks     map[string]HealthCheck
+		wantStatus int
+		wantChecks map[string]string
+	}{
+		{
+			name:       "ready with passing checks",
+			ready:      true,
+			checks:     map[string]HealthCheck{"mongo": func(context.Context) error { return nil }},
+			wantStatus: http.StatusOK,
+			wantChecks: map[string]string{"mongo": "ok"},
+		},
+		{
+			name:       "not ready",
+			checks:     map[string]HealthCheck{"mongo": func(context.Context) error { return nil }},
+			wantStatus: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"mongo": "ok"},
+		},
+		{
+			name:  "failing, panicking and slow checks",
+			ready: true,
+			checks: map[string]HealthCheck{
+				"mongo":    func(context.Context) error { return nil },
+				"supplier": func(context.Context) error { return errors.New("connection refused") },
+				"broken":   func(context.Context) error { panic("boom") },
+				"slow": func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"mongo": "ok", "supplier": "failed", "broken": "failed", "slow": "failed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := otelt.InstallMetrics(t)
+
+			h := NewHealth(WithCheckTimeout(50 * time.Millisecond))
+			for name, check := range tt.checks {
+				h.Register(name, check)
+			}
+			if tt.ready {
+				h.SetReady()
+			}
+
+			rec := httptest.NewRecorder()
+			h.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status mismatch: got %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var report HealthReport
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatalf("Unmarshal() unexpected error: %v", err)
+			}
+			failures := 0
+			for name, want := range tt.wantChecks {
+				if got := report.Checks[name].Status; got != want {
+					t.Errorf("check %s status mismatch: got %q, want %q", name, got, want)
+				}
+				if want != "ok" {
+					failures++
+				}
+			}
+
+			var counted int64
+			for _, dp := range collectSum(t, reader, "health.check.failures") {
+				counted += dp.Value
+			}
+			if counted != int64(failures) {
+				t.Errorf("health.check.failures mismatch: got %d, want %d", counted, failures)
+			}
+		})
+	}
+}
+
+func TestHealth_Liveness(t *testing.T) {
+	h := NewHealth()
+	h.Register("supplier", func(context.Context) error { return errors.New("down") })
+
+	rec := httptest.NewRecorder()
+	h.LivenessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status mismatch: got %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,200 @@
+package httpx
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HedgeOption configures NewHedgeTransport.
+type HedgeOption func(*HedgeTransport)
+
+// WithHedgeDelay sets how long a request waits for its response before a
+// hedge is sent. It should be around the supplier's p95. Defaults to 100ms.
+func WithHedgeDelay(d time.Duration) HedgeOption {
+	return func(t *HedgeTransport) { t.delay = d }
+}
+
+// WithMaxHedges caps the hedges in flight at once, across requests, so that
+// a supplier slowing down doesn't get twice the load. Requests beyond it
+// wait for their response alone. Defaults to 10; 0 disables hedging.
+func WithMaxHedges(n int) HedgeOption {
+	return func(t *HedgeTransport) { t.maxHedges = int64(max(n, 0)) }
+}
+
+// HedgeTransport cuts the tail latency of slow suppliers: when a request
+// got no response within the hedge delay, it sends the request again and
+// returns whichever response comes first, canceling the other attempt. If
+// the first to end fails, the other is waited for. The attempt that won, 1
+// or 2, is the http.hedge.winner attribute of the request's span. Layer it
+// on top of NewTransport so every attempt gets its own client span:
+//
+//	NewHedgeTransport(NewTransport(nil))
+//
+// Only requests sent twice safely are hedged: those RetryTransport would
+// retry whose method is idempotent or that carry an Idempotency-Key header.
+type HedgeTransport struct {
+	base      http.RoundTripper
+	delay     time.Duration
+	maxHedges int64
+	inFlight  atomic.Int64
+	// after is replaced in tests to send hedges on demand.
+	after func(d time.Duration) <-chan time.Time
+}
+
+// NewHedgeTransport returns a HedgeTransport sending attempts through base.
+// A nil base means http.DefaultTransport.
+func NewHedgeTransport(base http.RoundTripper, opts ...HedgeOption) *HedgeTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &HedgeTransport{
+		base:      base,
+		delay:     100 * time.Millisecond,
+		maxHedges: 10,
+		after:     time.After,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+type hedgeResult struct {
+	attempt int
+	resp    *http.Response
+	err     error
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *HedgeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !canHedge(req) || t.maxHedges == 0 {
+		return t.base.RoundTrip(req)
+	}
+	ctx := req.Context()
+
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+	// release, if not nil, is called once the attempt is over: when it
+	// failed or its response body was closed.
+	send := func(r *http.Request, release func()) {
+		actx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		attempt := len(cancels)
+		go func() {
+			resp, err := t.base.RoundTrip(r.WithContext(actx))
+			if release != nil {
+				if err != nil {
+					release()
+				} else {
+					resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: release}
+				}
+			}
+			results <- hedgeResult{attempt, resp, err}
+		}()
+	}
+
+	send(req, nil)
+	select {
+	case r := <-results:
+		return t.deliver(ctx, r, results, cancels, 0)
+	case <-t.after(t.delay):
+	}
+
+	hedge, err := rewind(req)
+	if err != nil || !t.acquire() {
+		r := <-results
+		return t.deliver(ctx, r, results, cancels, 0)
+	}
+	// The attempts must not share a header map, which transports write to.
+	// The hedge keeps its slot until it is over, winner or not.
+	send(hedge.Clone(ctx), sync.OnceFunc(func() { t.inFlight.Add(-1) }))
+	t.record(ctx, req, loadClientMetrics().hedges)
+
+	r := <-results
+	pending := 1
+	if r.err != nil && ctx.Err() == nil {
+		r = <-results
+		pending = 0
+	}
+	if r.attempt == 2 {
+		t.record(ctx, req, loadClientMetrics().hedgeWins)
+	}
+	return t.deliver(ctx, r, results, cancels, pending)
+}
+
+// deliver returns the winner r, canceling the other attempts and closing
+// the responses of the pending ones as they come. The winner's attempt is
+// canceled once its body is closed.
+func (t *HedgeTransport) deliver(ctx context.Context, r hedgeResult, results <-chan hedgeResult, cancels []context.CancelFunc, pending int) (*http.Response, error) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.hedge.winner", r.attempt))
+	for i, cancel := range cancels {
+		if i+1 != r.attempt {
+			cancel()
+		}
+	}
+	if pending > 0 {
+		go func() {
+			for range pending {
+				if loser := <-results; loser.resp != nil {
+					drainAndClose(loser.resp.Body)
+				}
+			}
+		}()
+	}
+	cancel := cancels[r.attempt-1]
+	if r.err != nil {
+		cancel()
+		return nil, r.err
+	}
+	r.resp.Body = &cancelOnClose{ReadCloser: r.resp.Body, cancel: cancel}
+	return r.resp, nil
+}
+
+// acquire reserves a hedge within the cap, reporting false when at it.
+func (t *HedgeTransport) acquire() bool {
+	if t.inFlight.Add(1) > t.maxHedges {
+		t.inFlight.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (t *HedgeTransport) record(ctx context.Context, req *http.Request, counter metric.Int64Counter) {
+	counter.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("http.method", req.Method),
+		attribute.String("net.peer.name", req.URL.Hostname()),
+	))
+}
+
+// cancelOnClose cancels the context of the attempt that won once its
+// response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// canHedge reports whether req can be in flight twice at once.
+func canHedge(req *http.Request) bool {
+	if !canRetry(req) {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
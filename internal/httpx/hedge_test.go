@@ -0,0 +1,220 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// fakeSupplier answers the nth attempt once told to through respond[n-1],
+// with the response or error sent, and reports attempts being canceled.
+type fakeSupplier struct {
+	started  chan int
+	respond  []chan error
+	canceled chan int
+	attempts atomic.Int32
+}
+
+func newFakeSupplier() *fakeSupplier {
+	return &fakeSupplier{
+		started:  make(chan int, 2),
+		respond:  []chan error{make(chan error, 1), make(chan error, 1)},
+		canceled: make(chan int, 2),
+	}
+}
+
+func (s *fakeSupplier) RoundTrip(req *http.Request) (*http.Response, error) {
+	n := int(s.attempts.Add(1))
+	if req.Body != nil {
+		if body, _ := io.ReadAll(req.Body); string(body) != "offer" {
+			return nil, errors.New("body lost")
+		}
+	}
+	s.started <- n
+	select {
+	case err := <-s.respond[n-1]:
+		if err != nil {
+			return nil, err
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(strings.Repeat("I", n))), Request: req}, nil
+	case <-req.Context().Done():
+		s.canceled <- n
+		return nil, req.Context().Err()
+	}
+}
+
+func TestHedgeTransport(t *testing.T) {
+	hedgeNow := make(chan time.Time)
+	tests := []struct {
+		name       string
+		method     string
+		body       bool
+		getBody    bool
+		hedge      bool
+		answer     []int // attempts to answer, in order
+		fail       int   // attempt answered with an error
+		wantBody   string
+		wantHedges int64
+		wantWins   int64
+		wantWinner int // the http.hedge.winner of hedged requests
+	}{
+		{name: "fast response", method: http.MethodGet, answer: []int{1}, wantBody: "I", wantWinner: 1},
+		{name: "hedge wins", method: http.MethodGet, hedge: true, answer: []int{2}, wantBody: "II", wantHedges: 1, wantWins: 1, wantWinner: 2},
+		{name: "original wins after hedging", method: http.MethodGet, hedge: true, answer: []int{1}, wantBody: "I", wantHedges: 1, wantWinner: 1},
+		{
+			name: "failed original waits for the hedge", method: http.MethodGet, hedge: true, fail: 1, answer: []int{1, 2},
+			wantBody: "II", wantHedges: 1, wantWins: 1, wantWinner: 2,
+		},
+		{
+			name: "body replayed", method: http.MethodPut, body: true, getBody: true, hedge: true, answer: []int{2},
+			wantBody: "II", wantHedges: 1, wantWins: 1, wantWinner: 2,
+		},
+		{name: "body without GetBody", method: http.MethodPut, body: true, answer: []int{1}, wantBody: "I"},
+		{name: "not idempotent", method: http.MethodPost, body: true, getBody: true, answer: []int{1}, wantBody: "I"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := otelt.InstallMetrics(t)
+			exp := otelt.InstallTracing(t)
+			supplier := newFakeSupplier()
+			rt := NewHedgeTransport(supplier)
+			rt.after = func(time.Duration) <-chan time.Time { return hedgeNow }
+
+			ctx, span := Tracer().Start(context.Background(), "search offers")
+			req, _ := http.NewRequestWithContext(ctx, tt.method, "http://supplier.test/offers", nil)
+			if tt.body {
+				req.Body = io.NopCloser(strings.NewReader("offer"))
+				if tt.getBody {
+					req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("offer")), nil }
+				}
+			}
+
+			type result struct {
+				resp *http.Response
+				err  error
+			}
+			done := make(chan result, 1)
+			go func() {
+				resp, err := rt.RoundTrip(req)
+				done <- result{resp, err}
+			}()
+			<-supplier.started
+			if tt.hedge {
+				hedgeNow <- time.Now()
+				<-supplier.started
+			}
+			for _, n := range tt.answer {
+				var err error
+				if n == tt.fail {
+					err = errors.New("connection reset")
+				}
+				supplier.respond[n-1] <- err
+			}
+
+			r := <-done
+			if r.err != nil {
+				t.Fatalf("RoundTrip: %v", r.err)
+			}
+			body, _ := io.ReadAll(r.resp.Body)
+			_ = r.resp.Body.Close()
+			if string(body) != tt.wantBody {
+				t.Errorf("response from attempt %q, want %q", body, tt.wantBody)
+			}
+			if tt.hedge && len(tt.answer) == 1 {
+				if loser := <-supplier.canceled; loser == tt.answer[0] {
+					t.Errorf("attempt %d canceled, want the loser", loser)
+				}
+			}
+			span.End()
+
+			for name, want := range map[string]int64{"http.client.hedges": tt.wantHedges, "http.client.hedge.wins": tt.wantWins} {
+				var got int64
+				for _, dp := range collectSum(t, reader, name) {
+					got += dp.Value
+				}
+				if got != want {
+					t.Errorf("%s = %d, want %d", name, got, want)
+				}
+			}
+			winner := attrValue(exp.GetSpans()[0].Attributes, "http.hedge.winner")
+			if tt.wantWinner == 0 && winner.Type() != attribute.INVALID || tt.wantWinner != 0 && winner != attribute.IntValue(tt.wantWinner) {
+				t.Errorf("http.hedge.winner = %v, want %d", winner.Emit(), tt.wantWinner)
+			}
+		})
+	}
+}
+
+func TestHedgeTransport_MaxHedges(t *testing.T) {
+	otelt.InstallMetrics(t)
+	supplier := newFakeSupplier()
+	rt := NewHedgeTransport(supplier, WithMaxHedges(1))
+	rt.after = func(time.Duration) <-chan time.Time {
+		c := make(chan time.Time, 1)
+		c <- time.Now()
+		return c
+	}
+	rt.inFlight.Add(1) // another request's hedge
+
+	supplier.respond[0] <- nil
+	req, _ := http.NewRequest(http.MethodGet, "http://supplier.test/offers", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	_ = resp.Body.Close()
+	if supplier.attempts.Load() != 1 {
+		t.Errorf("sent %d attempts, want no hedge beyond the cap", supplier.attempts.Load())
+	}
+}
+
+func TestHedgeTransport_HedgeLifetime(t *testing.T) {
+	otelt.InstallMetrics(t)
+	supplier := newFakeSupplier()
+	headers := make(chan http.Header, 2)
+	rt := NewHedgeTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		headers <- req.Header
+		return supplier.RoundTrip(req)
+	}))
+	hedgeNow := make(chan time.Time)
+	rt.after = func(time.Duration) <-chan time.Time { return hedgeNow }
+
+	req, _ := http.NewRequest(http.MethodGet, "http://supplier.test/offers", nil)
+	done := make(chan *http.Response, 1)
+	go func() {
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Errorf("RoundTrip: %v", err)
+		}
+		done <- resp
+	}()
+	<-supplier.started
+	hedgeNow <- time.Now()
+	<-supplier.started
+	supplier.respond[1] <- nil
+	resp := <-done
+
+	first, second := <-headers, <-headers
+	first.Set("X-Probe", "1")
+	if second.Get("X-Probe") != "" {
+		t.Error("the attempts share their header map")
+	}
+	if n := rt.inFlight.Load(); n != 1 {
+		t.Errorf("%d hedges in flight while the winning one is read, want 1", n)
+	}
+	if resp != nil {
+		_ = resp.Body.Close()
+		_ = resp.Body.Close()
+	}
+	if n := rt.inFlight.Load(); n != 0 {
+		t.Errorf("%d hedges in flight once done, want 0", n)
+	}
+}
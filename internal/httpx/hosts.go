@@ -0,0 +1,156 @@
+package httpx
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Errors answered by AllowedHostsMiddleware.
+var (
+	ErrHostInvalid    = &StatusError{Status: http.StatusBadRequest, Type: "invalid_host", Err: errors.New("missing or malformed Host header")}
+	ErrHostNotAllowed = &StatusError{Status: http.StatusMisdirectedRequest, Type: "misdirected_request", Err: errors.New("host not served here")}
+)
+
+// HostsOption configures AllowedHostsMiddleware.
+type HostsOption func(*hostsConfig)
+
+type hostsConfig struct {
+	patterns    []hostPattern
+	exemptPaths []string
+}
+
+// WithHostExemptPaths serves requests to the given exact paths whatever
+// their Host, in addition to /healthz and /readyz.
+func WithHostExemptPaths(paths ...string) HostsOption {
+	return func(c *hostsConfig) { c.exemptPaths = append(c.exemptPaths, paths...) }
+}
+
+// AllowedHostsMiddleware only serves requests for one of the allowed hosts,
+// so that a forged Host never ends up in a response, or in a cache key.
+// Patterns are exact names ("api.acai.travel"), wildcard subdomains
+// ("*.acai.travel") or IP literals ("[::1]"), matching any port unless they
+// have one ("localhost:8080"). The host of an absolute request target takes
+// the place of the Host header, as in net/http.
+//
+// Requests without a valid Host get a 400 and those for other hosts a 421.
+// Rejections are counted in http.server.host.rejected by reason and by
+// host.hash, the first two hex digits of the SHA-256 of the offending host.
+// That bounds the series to 256 per reason while still telling one host
+// hammered from many apart. Health checks, which often omit Host, are exempt.
+func AllowedHostsMiddleware(allowed []string, opts ...HostsOption) func(http.Handler) http.Handler {
+	cfg := hostsConfig{exemptPaths: []string{"/healthz", "/readyz"}}
+	for _, pattern := range allowed {
+		p, ok := parseHostPattern(pattern)
+		if !ok {
+			slog.Warn("Ignoring invalid allowed host", "pattern", pattern)
+			continue
+		}
+		cfg.patterns = append(cfg.patterns, p)
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(cfg.exemptPaths, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			name, port, ok := splitHost(r.Host)
+			switch {
+			case !ok:
+				rejectHost(w, r, "invalid", ErrHostInvalid)
+			case !cfg.allowed(name, port):
+				rejectHost(w, r, "not_allowed", ErrHostNotAllowed)
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func (c *hostsConfig) allowed(name, port string) bool {
+	for _, p := range c.patterns {
+		if p.matches(name, port) {
+			return true
+		}
+	}
+	return false
+}
+
+func rejectHost(w http.ResponseWriter, r *http.Request, reason string, err error) {
+	sum := sha256.Sum256([]byte(r.Host))
+	loadServerMetrics().hostRejected.Add(r.Context(), 1, metric.WithAttributes(
+		attribute.String("reason", reason),
+		attribute.String("host.hash", hex.EncodeToString(sum[:1])),
+	))
+	WriteError(w, r, err)
+}
+
+type hostPattern struct {
+	name     string
+	port     string // any if empty
+	wildcard bool   // name is a parent domain
+}
+
+func parseHostPattern(pattern string) (hostPattern, bool) {
+	var p hostPattern
+	if rest, ok := strings.CutPrefix(pattern, "*."); ok {
+		p.wildcard, pattern = true, rest
+	}
+	var ok bool
+	p.name, p.port, ok = splitHost(pattern)
+	return p, ok
+}
+
+func (p hostPattern) matches(name, port string) bool {
+	if p.port != "" && p.port != port {
+		return false
+	}
+	if p.wildcard {
+		return strings.HasSuffix(name, "."+p.name)
+	}
+	return name == p.name
+}
+
+// splitHost splits a Host header into its lowercased name, without the
+// brackets of IPv6 literals or a trailing dot, and its port. It fails on
+// anything that is not a host name or IP literal with an optional port.
+func splitHost(host string) (name, port string, ok bool) {
+	hasPort := false
+	if rest, bracketed := strings.CutPrefix(host, "["); bracketed {
+		var tail string
+		name, tail, ok = strings.Cut(rest, "]")
+		if addr, err := netip.ParseAddr(name); !ok || err != nil || !addr.Is6() {
+			return "", "", false
+		}
+		if tail != "" {
+			if port, hasPort = strings.CutPrefix(tail, ":"); !hasPort {
+				return "", "", false
+			}
+		}
+	} else {
+		name = host
+		if i := strings.LastIndexByte(host, ':'); i >= 0 {
+			name, port, hasPort = host[:i], host[i+1:], true
+		}
+		name = strings.TrimSuffix(name, ".")
+		if name == "" || strings.Trim(strings.ToLower(name), "abcdefghijklmnopqrstuvwxyz0123456789-._") != "" {
+			return "", "", false
+		}
+	}
+	if hasPort && !validPort(port) {
+		return "", "", false
+	}
+	return strings.ToLower(name), port, true
+}
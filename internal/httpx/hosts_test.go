@@ -0,0 +1,91 @@
+package httpx
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestAllowedHostsMiddleware(t *testing.T) {
+	otelt.InstallMetrics(t)
+	captureLogs(t)
+
+	handler := AllowedHostsMiddleware([]string{"api.acai.travel", "*.acai.travel", "localhost:8080", "[::1]", "bad host"})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name string
+		host string
+		path string
+		want int
+	}{
+		{name: "exact", host: "api.acai.travel", want: http.StatusOK},
+		{name: "case and trailing dot", host: "API.Acai.Travel.", want: http.StatusOK},
+		{name: "any port", host: "api.acai.travel:8443", want: http.StatusOK},
+		{name: "wildcard", host: "eu.api.acai.travel", want: http.StatusOK},
+		{name: "wildcard excludes apex", host: "acai.travel", want: http.StatusMisdirectedRequest},
+		{name: "lookalike suffix", host: "evilacai.travel", want: http.StatusMisdirectedRequest},
+		{name: "required port", host: "localhost:8080", want: http.StatusOK},
+		{name: "wrong port", host: "localhost:9090", want: http.StatusMisdirectedRequest},
+		{name: "missing required port", host: "localhost", want: http.StatusMisdirectedRequest},
+		{name: "IPv6", host: "[::1]", want: http.StatusOK},
+		{name: "IPv6 with port", host: "[::1]:8080", want: http.StatusOK},
+		{name: "other IPv6", host: "[2001:db8::1]", want: http.StatusMisdirectedRequest},
+		{name: "unbracketed IPv6", host: "::1", want: http.StatusBadRequest},
+		{name: "IPv4 in brackets", host: "[127.0.0.1]", want: http.StatusBadRequest},
+		{name: "other host", host: "evil.example", want: http.StatusMisdirectedRequest},
+		{name: "missing", host: "", want: http.StatusBadRequest},
+		{name: "malformed", host: "api.acai.travel@evil.example", want: http.StatusBadRequest},
+		{name: "bad port", host: "api.acai.travel:99999", want: http.StatusBadRequest},
+		{name: "health check exempt", host: "", path: "/healthz", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.path
+			if path == "" {
+				path = "/trips"
+			}
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Host = tt.host
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("Host %q got %d, want %d", tt.host, rec.Code, tt.want)
+			}
+		})
+	}
+
+	sum := sha256.Sum256([]byte("evil.example"))
+	otelt.RequireCounterValue(t, "http.server.host.rejected", []attribute.KeyValue{
+		attribute.String("reason", "not_allowed"),
+		attribute.String("host.hash", hex.EncodeToString(sum[:1])),
+	}, 1)
+}
+
+func TestAllowedHostsMiddleware_AbsoluteTarget(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	handler := AllowedHostsMiddleware([]string{"api.acai.travel"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for target, want := range map[string]int{
+		"http://evil.example/trips":    http.StatusMisdirectedRequest,
+		"http://api.acai.travel/trips": http.StatusOK,
+	} {
+		raw := "GET " + target + " HTTP/1.1\r\nHost: api.acai.travel\r\n\r\n"
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s got %d, want %d", target, rec.Code, want)
+		}
+	}
+}
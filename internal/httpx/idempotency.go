@@ -0,0 +1,342 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Headers of idempotent requests and of the responses replayed for them.
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// Errors answered by IdempotencyMiddleware.
+var (
+	ErrIdempotencyKeyMissing = &StatusError{Status: http.StatusBadRequest, Type: "idempotency_key_missing", Err: fmt.Errorf("%s header required", IdempotencyKeyHeader)}
+	ErrIdempotencyInProgress = &StatusError{Status: http.StatusConflict, Type: "idempotency_in_progress", Err: fmt.Errorf("a request with this %s is in progress", IdempotencyKeyHeader)}
+	ErrIdempotencyKeyReused  = &StatusError{Status: http.StatusUnprocessableEntity, Type: "idempotency_key_reused", Err: fmt.Errorf("%s already used with another request body", IdempotencyKeyHeader)}
+)
+
+// IdempotentResponse is a response stored for replay, along with the hash of
+// the request body that produced it.
+type IdempotentResponse struct {
+	BodyHash string
+	Status   int
+	Header   http.Header
+	Body     []byte
+}
+
+// IdempotencyStore keeps responses by idempotency key until their TTL runs
+// out. Implementations must be safe for concurrent use.
+type IdempotencyStore interface {
+	Get(ctx context.Context, key string) (IdempotentResponse, bool, error)
+	Set(ctx context.Context, key string, resp IdempotentResponse, ttl time.Duration) error
+}
+
+// IdempotencyOption configures IdempotencyMiddleware.
+type IdempotencyOption func(*idempotency)
+
+// WithIdempotencyTTL sets how long responses are replayed. Defaults to 24h.
+func WithIdempotencyTTL(d time.Duration) IdempotencyOption {
+	return func(c *idempotency) { c.ttl = d }
+}
+
+// WithIdempotencyMaxBody sets the largest response body stored. Larger
+// responses are not replayed: a retry runs the handler again. Defaults to
+// 64KiB.
+func WithIdempotencyMaxBody(n int) IdempotencyOption {
+	return func(c *idempotency) { c.maxBody = n }
+}
+
+// WithIdempotencyMaxRequestBody sets the largest request body read to hash
+// it, unless the RouteConfig of the route sets a MaxBody. Larger bodies get
+// a 413. Defaults to 1MiB, as for DecodeJSON.
+func WithIdempotencyMaxRequestBody(n int64) IdempotencyOption {
+	return func(c *idempotency) { c.maxRequest = n }
+}
+
+// WithIdempotencyWait makes duplicates of a request still in progress wait
+// for it and replay its response, instead of getting a 409.
+func WithIdempotencyWait(wait bool) IdempotencyOption {
+	return func(c *idempotency) { c.wait = wait }
+}
+
+// WithIdempotentRoutes limits the middleware to the routes registered on rt
+// with RouteIdempotent. Otherwise it covers every request it serves.
+func WithIdempotentRoutes(rt *Router) IdempotencyOption {
+	return func(c *idempotency) { c.routes = rt }
+}
+
+// IdempotencyMiddleware lets clients retry requests safely. Each request
+// must carry an Idempotency-Key header, and the first response for a key,
+// route and caller, the principal authenticated by AuthMiddleware or else
+// the client IP, is stored and replayed, flagged with Idempotent-Replayed,
+// to retries with the same body until the TTL runs out. Reusing a key with
+// another body gets a 422, and duplicates arriving while the first request
+// is in progress a 409. Server errors are not stored, so they can be retried.
+//
+// In-progress requests are only tracked within this process. Lookups are
+// counted in http.server.idempotency by result: hit, miss, conflict or
+// mismatch.
+func IdempotencyMiddleware(store IdempotencyStore, opts ...IdempotencyOption) func(http.Handler) http.Handler {
+	c := &idempotency{
+		store:      store,
+		ttl:        24 * time.Hour,
+		maxBody:    64 << 10,
+		maxRequest: defaultDecodeLimit,
+		inflight:   map[string]chan struct{}{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c.routes != nil && !routeConfig(r, c.routes).Idempotent {
+				next.ServeHTTP(w, r)
+				return
+			}
+			c.serveHTTP(next, w, r)
+		})
+	}
+}
+
+type idempotency struct {
+	store      IdempotencyStore
+	ttl        time.Duration
+	maxBody    int
+	maxRequest int64
+	wait       bool
+	routes     *Router
+
+	mu       sync.Mutex
+	inflight map[string]chan struct{} // closed when the request finishes
+}
+
+func (c *idempotency) serveHTTP(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		WriteError(w, r, ErrIdempotencyKeyMissing)
+		return
+	}
+
+	hash := sha256.New()
+	if r.Body != nil {
+		limit := c.maxRequest
+		if override := routeConfig(r, c.routes).MaxBody; override > 0 {
+			limit = override
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			WriteError(w, r, decodeError(http.StatusRequestEntityTooLarge, decodeTooLarge, fmt.Errorf("request body exceeds %d bytes", limit)))
+			return
+		}
+		if err != nil {
+			WriteError(w, r, fmt.Errorf("read request body: %w", err))
+			return
+		}
+		hash.Write(body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	bodyHash := hex.EncodeToString(hash.Sum(nil))
+	storeKey := c.route(r) + " " + idempotencyCaller(r) + " " + key
+
+	release, ok := c.acquire(ctx, storeKey)
+	if !ok {
+		c.count(ctx, "conflict")
+		WriteError(w, r, ErrIdempotencyInProgress)
+		return
+	}
+	defer release()
+
+	stored, found, err := c.store.Get(ctx, storeKey)
+	if err != nil {
+		WriteError(w, r, fmt.Errorf("look up idempotency key: %w", err))
+		return
+	}
+	if found {
+		if stored.BodyHash != bodyHash {
+			c.count(ctx, "mismatch")
+			WriteError(w, r, ErrIdempotencyKeyReused)
+			return
+		}
+		c.count(ctx, "hit")
+		replay(w, stored)
+		return
+	}
+	c.count(ctx, "miss")
+
+	rw := newRecordingWriter(w, c.maxBody)
+	next.ServeHTTP(rw, r)
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	if rw.status >= 500 || rw.overflow {
+		return
+	}
+	resp := IdempotentResponse{BodyHash: bodyHash, Status: rw.status, Header: rw.header, Body: rw.body.Bytes()}
+	if err := c.store.Set(ctx, storeKey, resp, c.ttl); err != nil {
+		slog.ErrorContext(ctx, "Failed to store idempotent response", "error", err, "request_id", RequestIDFromContext(ctx))
+	}
+}
+
+// route returns the route keys are scoped to.
+func (c *idempotency) route(r *http.Request) string {
+	if c.routes != nil {
+		if _, pattern := c.routes.mux.Handler(r); pattern != "" {
+			return pattern
+		}
+	}
+	if route := PatternRoute(r); route != UnmatchedRoute {
+		return r.Method + " " + route
+	}
+	return r.Method + " " + r.URL.Path
+}
+
+// idempotencyCaller returns who keys are scoped to, so that clients can't
+// replay each other's responses by guessing their keys.
+func idempotencyCaller(r *http.Request) string {
+	if p, ok := PrincipalFromContext(r.Context()); ok {
+		return p.Scheme + ":" + p.ID
+	}
+	return "ip:" + ClientIP(r)
+}
+
+// acquire marks key as in progress, waiting for a request already holding
+// it if configured to. It fails if it can't.
+func (c *idempotency) acquire(ctx context.Context, key string) (release func(), ok bool) {
+	for {
+		c.mu.Lock()
+		done, busy := c.inflight[key]
+		if !busy {
+			done = make(chan struct{})
+			c.inflight[key] = done
+			c.mu.Unlock()
+			return func() {
+				c.mu.Lock()
+				delete(c.inflight, key)
+				c.mu.Unlock()
+				close(done)
+			}, true
+		}
+		c.mu.Unlock()
+
+		if !c.wait {
+			return nil, false
+		}
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+func (c *idempotency) count(ctx context.Context, result string) {
+	loadServerMetrics().idempotency.Add(ctx, 1,
+		metric.WithAttributes(attribute.String("idempotency.result", result)))
+}
+
+// replay writes a stored response, keeping the headers already set for this
+// request.
+func replay(w http.ResponseWriter, resp IdempotentResponse) {
+	h := w.Header()
+	for k, vv := range resp.Header {
+		if _, ok := h[k]; !ok {
+			h[k] = vv
+		}
+	}
+	h.Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(resp.Status)
+	_, _ = w.Write(resp.Body)
+}
+
+// recordingWriter keeps a copy of the response for storage, up to max bytes
+// of body. Only the headers set by the handler are kept: those set before,
+// such as the request and trace IDs, belong to this request alone.
+type recordingWriter struct {
+	http.ResponseWriter
+	max      int
+	preset   http.Header
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func newRecordingWriter(w http.ResponseWriter, max int) *recordingWriter {
+	return &recordingWriter{ResponseWriter: w, max: max, preset: w.Header().Clone()}
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+		w.header = w.Header().Clone()
+		for k, vv := range w.preset {
+			if slices.Equal(w.header[k], vv) {
+				delete(w.header, k)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.overflow {
+		if w.body.Len()+len(b) > w.max {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// MemoryIdempotencyStore is an in-process IdempotencyStore forgetting the
+// least recently used responses beyond its capacity.
+type MemoryIdempotencyStore struct {
+	cache *ttlCache[IdempotentResponse]
+}
+
+// NewMemoryIdempotencyStore returns a store holding up to capacity
+// responses.
+func NewMemoryIdempotencyStore(capacity int) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{cache: newTTLCache[IdempotentResponse](capacity)}
+}
+
+// Get implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Get(_ context.Context, key string) (IdempotentResponse, bool, error) {
+	resp, ok := s.cache.get(key)
+	return resp, ok, nil
+}
+
+// Set implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Set(_ context.Context, key string, resp IdempotentResponse, ttl time.Duration) error {
+	s.cache.set(key, resp, ttl)
+	return nil
+}
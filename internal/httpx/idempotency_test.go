@@ -0,0 +1,237 @@
+package httpx
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	otelt.InstallMetrics(t)
+	captureLogs(t)
+
+	var bookings atomic.Int64
+	rt := NewRouter()
+	rt.HandleFunc("POST /bookings", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		n := bookings.Add(1)
+		w.Header().Set("Location", "/bookings/"+strconv.FormatInt(n, 10))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(body)
+	}, RouteIdempotent())
+	rt.HandleFunc("POST /searches", func(w http.ResponseWriter, r *http.Request) {})
+	handler := IdempotencyMiddleware(NewMemoryIdempotencyStore(10), WithIdempotentRoutes(rt))(rt)
+
+	post := func(path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := post("/bookings", "k1", `{"flight":"AC123"}`)
+	retry := post("/bookings", "k1", `{"flight":"AC123"}`)
+	if bookings.Load() != 1 {
+		t.Fatalf("handler ran %d times, want once", bookings.Load())
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() || retry.Header().Get("Location") != first.Header().Get("Location") {
+		t.Errorf("replay = %d %q %v, want the first response %d %q %v",
+			retry.Code, retry.Body.String(), retry.Header(), first.Code, first.Body.String(), first.Header())
+	}
+	if retry.Header().Get(IdempotentReplayedHeader) != "true" || first.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Errorf("only the replay should be flagged: first %v, retry %v", first.Header(), retry.Header())
+	}
+
+	if rec := post("/bookings", "k1", `{"flight":"ZZ999"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key with another body: got %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	if rec := post("/bookings", "", `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("missing key: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := post("/searches", "", `{}`); rec.Code != http.StatusOK {
+		t.Errorf("route not covered: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	post("/bookings", "k2", `{"flight":"AC123"}`)
+	if bookings.Load() != 2 {
+		t.Errorf("handler ran %d times for two keys, want twice", bookings.Load())
+	}
+
+	for result, want := range map[string]int64{"miss": 2, "hit": 1, "mismatch": 1} {
+		otelt.RequireCounterValue(t, "http.server.idempotency", []attribute.KeyValue{attribute.String("idempotency.result", result)}, want)
+	}
+}
+
+func TestIdempotencyMiddleware_ConcurrentDuplicates(t *testing.T) {
+	tests := []struct {
+		name  string
+		wait  bool
+		codes []int
+	}{
+		{name: "conflict", wait: false, codes: []int{http.StatusOK, http.StatusConflict}},
+		{name: "wait", wait: true, codes: []int{http.StatusOK, http.StatusOK}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			otelt.InstallMetrics(t)
+
+			var calls atomic.Int64
+			started, release := make(chan struct{}), make(chan struct{})
+			handler := IdempotencyMiddleware(NewMemoryIdempotencyStore(10), WithIdempotencyWait(tt.wait))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					close(started)
+					<-release
+				}
+				_, _ = io.WriteString(w, "booked")
+			}))
+			serve := func() *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/bookings", strings.NewReader("{}"))
+				req.Header.Set(IdempotencyKeyHeader, "k1")
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				return rec
+			}
+
+			first := make(chan *httptest.ResponseRecorder)
+			go func() { first <- serve() }()
+			<-started
+
+			dupDone := make(chan *httptest.ResponseRecorder)
+			go func() { dupDone <- serve() }()
+			var dup *httptest.ResponseRecorder
+			if tt.wait {
+				// Let the duplicate start waiting before the first finishes.
+				time.Sleep(10 * time.Millisecond)
+				close(release)
+				dup = <-dupDone
+			} else {
+				dup = <-dupDone
+				close(release)
+			}
+			firstRec := <-first
+
+			if firstRec.Code != tt.codes[0] || dup.Code != tt.codes[1] {
+				t.Errorf("got %d and %d, want %v", firstRec.Code, dup.Code, tt.codes)
+			}
+			if calls.Load() != 1 {
+				t.Errorf("handler ran %d times, want once", calls.Load())
+			}
+			if tt.wait && dup.Body.String() != "booked" {
+				t.Errorf("waiting duplicate got %q, want the replayed response", dup.Body.String())
+			}
+		})
+	}
+}
+
+func TestIdempotencyMiddleware_NotStored(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	var calls atomic.Int64
+	handler := IdempotencyMiddleware(NewMemoryIdempotencyStore(10), WithIdempotencyMaxBody(4))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = io.WriteString(w, "too big to store")
+	}))
+	for _, path := range []string{"/fail", "/fail", "/big", "/big"} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set(IdempotencyKeyHeader, "k1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if calls.Load() != 4 {
+		t.Errorf("handler ran %d times, want every retry to run it", calls.Load())
+	}
+}
+
+func TestIdempotencyMiddleware_ScopedToCaller(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	var calls atomic.Int64
+	handler := IdempotencyMiddleware(NewMemoryIdempotencyStore(10))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		p, _ := PrincipalFromContext(r.Context())
+		_, _ = io.WriteString(w, "booking of "+p.ID+" from "+r.RemoteAddr)
+	}))
+	post := func(principal, remoteAddr string) string {
+		req := httptest.NewRequest(http.MethodPost, "/bookings", strings.NewReader(`{"flight":"AC123"}`))
+		req.Header.Set(IdempotencyKeyHeader, "k1")
+		req.RemoteAddr = remoteAddr
+		if principal != "" {
+			req = req.WithContext(ContextWithPrincipal(req.Context(), Principal{ID: principal, Scheme: "bearer"}))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	alice := post("alice", "192.0.2.1:1234")
+	if got := post("alice", "192.0.2.9:1234"); got != alice {
+		t.Errorf("alice's retry from another address got %q, want her replay %q", got, alice)
+	}
+	if got := post("bob", "192.0.2.1:1234"); got == alice {
+		t.Errorf("bob got alice's response %q for the same key", got)
+	}
+	anonymous := post("", "192.0.2.1:1234")
+	if got := post("", "192.0.2.2:1234"); got == anonymous {
+		t.Errorf("another client IP got the response %q for the same key", got)
+	}
+	if calls.Load() != 4 {
+		t.Errorf("handler ran %d times, want once per caller", calls.Load())
+	}
+}
+
+func TestIdempotencyMiddleware_LargeRequestBody(t *testing.T) {
+	captureLogs(t)
+	otelt.InstallMetrics(t)
+
+	var calls atomic.Int64
+	handler := IdempotencyMiddleware(NewMemoryIdempotencyStore(10), WithIdempotencyMaxRequestBody(8))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/bookings", strings.NewReader(`{"flight":"AC123"}`))
+	req.Header.Set(IdempotencyKeyHeader, "k1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge || calls.Load() != 0 {
+		t.Errorf("got %d after %d handler runs, want a 413 without running it", rec.Code, calls.Load())
+	}
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	s := NewMemoryIdempotencyStore(2)
+	s.cache.now = func() time.Time { return now }
+
+	_ = s.Set(ctx, "a", IdempotentResponse{Status: 201}, time.Minute)
+	_ = s.Set(ctx, "b", IdempotentResponse{Status: 202}, time.Hour)
+	if _, ok, _ := s.Get(ctx, "a"); !ok {
+		t.Fatal("a missing")
+	}
+	_ = s.Set(ctx, "c", IdempotentResponse{Status: 203}, time.Hour)
+	if _, ok, _ := s.Get(ctx, "b"); ok {
+		t.Error("b kept over capacity although least recently used")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok, _ := s.Get(ctx, "a"); ok {
+		t.Error("a replayed after its TTL")
+	}
+	if resp, ok, _ := s.Get(ctx, "c"); !ok || resp.Status != 203 {
+		t.Errorf("c = %+v, %v, want 203", resp, ok)
+	}
+}
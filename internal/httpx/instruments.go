@@ -0,0 +1,126 @@
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// instrumentNameRE is the OpenTelemetry instrument name syntax.
+var instrumentNameRE = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_./-]{0,254}$`)
+
+var metricNamespace atomic.Pointer[string]
+
+// SetMetricNamespace prefixes the names of the instruments created afterwards
+// by Counter, Histogram and Gauge with ns and a dot, e.g. "bookings" turns
+// "created" into "bookings.created".
+func SetMetricNamespace(ns string) {
+	metricNamespace.Store(&ns)
+}
+
+// instrumentRegistry memoizes the instruments created by the helpers below,
+// per meter provider like serverMetrics.
+type instrumentRegistry struct {
+	mu       sync.Mutex
+	provider metric.MeterProvider
+	byName   map[string]any
+}
+
+var instruments instrumentRegistry
+
+// instrument returns the instrument registered as name, creating it with
+// create the first time. It fails on an invalid name or one registered
+// with another kind of instrument.
+func instrument[T any](name string, create func(m metric.Meter, name string) (T, error)) (T, error) {
+	var zero T
+	if ns := metricNamespace.Load(); ns != nil && *ns != "" {
+		name = *ns + "." + name
+	}
+	if !instrumentNameRE.MatchString(name) {
+		return zero, fmt.Errorf("invalid instrument name %q: it must start with a letter and have at most 255 letters, digits, '_', '.', '-' or '/'", name)
+	}
+
+	instruments.mu.Lock()
+	defer instruments.mu.Unlock()
+	if mp := otel.GetMeterProvider(); instruments.provider != mp {
+		instruments.provider = mp
+		instruments.byName = map[string]any{}
+	}
+	if existing, ok := instruments.byName[name]; ok {
+		inst, ok := existing.(T)
+		if !ok {
+			return zero, fmt.Errorf("instrument %q already exists as a %T", name, existing)
+		}
+		return inst, nil
+	}
+
+	inst, err := create(Meter(), name)
+	if err != nil {
+		return zero, fmt.Errorf("create instrument %q: %w", name, err)
+	}
+	instruments.byName[name] = inst
+	return inst, nil
+}
+
+// Counter returns the int64 counter with the given name, creating it on
+// first use. Calling it again with the same name returns the same counter.
+func Counter(name, desc string) (metric.Int64Counter, error) {
+	return instrument(name, func(m metric.Meter, name string) (metric.Int64Counter, error) {
+		return m.Int64Counter(name, metric.WithDescription(desc))
+	})
+}
+
+// Histogram returns the float64 histogram with the given name and unit
+// (e.g. "s" or "By"), creating it on first use.
+func Histogram(name, desc, unit string) (metric.Float64Histogram, error) {
+	return instrument(name, func(m metric.Meter, name string) (metric.Float64Histogram, error) {
+		return m.Float64Histogram(name, metric.WithDescription(desc), metric.WithUnit(unit))
+	})
+}
+
+// Gauge returns the gauge with the given name, observing callback at each
+// collection. Only the callback of the call creating the gauge is used.
+func Gauge(name, desc string, callback func(ctx context.Context) float64) (metric.Float64ObservableGauge, error) {
+	return instrument(name, func(m metric.Meter, name string) (metric.Float64ObservableGauge, error) {
+		return m.Float64ObservableGauge(name, metric.WithDescription(desc),
+			metric.WithFloat64Callback(func(ctx context.Context, o metric.Float64Observer) error {
+				o.Observe(callback(ctx))
+				return nil
+			}))
+	})
+}
+
+// RecordWithRoute adds value to a counter, or records it in a histogram,
+// with the route of the request being served by ctx as http.route. Handlers
+// only know their route when served by a Router; it is UnmatchedRoute
+// otherwise. The request ID is deliberately left out: every ID would be a
+// new series, and exemplars already link measurements to their trace.
+func RecordWithRoute(ctx context.Context, inst any, value float64) {
+	route := UnmatchedRoute
+	if st := stateFromContext(ctx); st != nil {
+		if r := st.matchedRoute(); r != "" {
+			route = r
+		}
+	}
+	attrs := metric.WithAttributes(attribute.String("http.route", route))
+
+	switch inst := inst.(type) {
+	case metric.Float64Histogram:
+		inst.Record(ctx, value, attrs)
+	case metric.Int64Histogram:
+		inst.Record(ctx, int64(value), attrs)
+	case metric.Int64Counter:
+		inst.Add(ctx, int64(value), attrs)
+	case metric.Float64Counter:
+		inst.Add(ctx, value, attrs)
+	default:
+		slog.ErrorContext(ctx, "RecordWithRoute given an unsupported instrument", "instrument", fmt.Sprintf("%T", inst))
+	}
+}
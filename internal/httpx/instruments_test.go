@@ -0,0 +1,105 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestCounter_Memoized(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	first, err := Counter("bookings.created", "Bookings created")
+	if err != nil {
+		t.Fatalf("Counter() unexpected error: %v", err)
+	}
+	second, err := Counter("bookings.created", "Bookings created")
+	if err != nil {
+		t.Fatalf("Counter() unexpected error: %v", err)
+	}
+	if first != second {
+		t.Error("Counter() created the same instrument twice")
+	}
+
+	if _, err := Histogram("bookings.created", "", "s"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Histogram() on a counter name: got %v, want an already exists error", err)
+	}
+}
+
+func TestCounter_InvalidName(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	for _, name := range []string{"", "1st", "bookings created", strings.Repeat("a", 256)} {
+		if _, err := Counter(name, ""); err == nil || !strings.Contains(err.Error(), "invalid instrument name") {
+			t.Errorf("Counter(%q): got %v, want an invalid name error", name, err)
+		}
+	}
+}
+
+func TestSetMetricNamespace(t *testing.T) {
+	otelt.InstallMetrics(t)
+	SetMetricNamespace("acai")
+	t.Cleanup(func() { SetMetricNamespace("") })
+
+	c, err := Counter("searches", "")
+	if err != nil {
+		t.Fatalf("Counter() unexpected error: %v", err)
+	}
+	c.Add(context.Background(), 1)
+
+	otelt.RequireCounterValue(t, "acai.searches", nil, 1)
+}
+
+func TestGauge(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	if _, err := Gauge("bookings.pending", "Pending bookings", func(context.Context) float64 { return 7 }); err != nil {
+		t.Fatalf("Gauge() unexpected error: %v", err)
+	}
+	m, ok := otelt.Metric(t, "bookings.pending")
+	if !ok {
+		t.Fatal("bookings.pending not collected")
+	}
+	if dps := m.Data.(metricdata.Gauge[float64]).DataPoints; len(dps) != 1 || dps[0].Value != 7 {
+		t.Errorf("bookings.pending = %v, want a single 7", dps)
+	}
+}
+
+func TestRecordWithRoute(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	searches, err := Counter("searches", "")
+	if err != nil {
+		t.Fatalf("Counter() unexpected error: %v", err)
+	}
+	latency, err := Histogram("search.latency", "", "s")
+	if err != nil {
+		t.Fatalf("Histogram() unexpected error: %v", err)
+	}
+
+	router := NewRouter()
+	router.HandleFunc("GET /flights/{destination}", func(w http.ResponseWriter, r *http.Request) {
+		RecordWithRoute(r.Context(), searches, 1)
+		RecordWithRoute(r.Context(), latency, 0.25)
+	})
+	MetricsMiddleware(router).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/flights/BCN", nil))
+	RecordWithRoute(context.Background(), searches, 1)
+
+	otelt.RequireCounterValue(t, "searches", []attribute.KeyValue{attribute.String("http.route", "/flights/{destination}")}, 1)
+	otelt.RequireCounterValue(t, "searches", []attribute.KeyValue{attribute.String("http.route", UnmatchedRoute)}, 1)
+
+	m, _ := otelt.Metric(t, "search.latency")
+	dps := m.Data.(metricdata.Histogram[float64]).DataPoints
+	if len(dps) != 1 || dps[0].Sum != 0.25 {
+		t.Fatalf("search.latency = %v, want a single 0.25", dps)
+	}
+	if route, _ := dps[0].Attributes.Value("http.route"); route.AsString() != "/flights/{destination}" {
+		t.Errorf("search.latency route = %q, want /flights/{destination}", route.AsString())
+	}
+}
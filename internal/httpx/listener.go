@@ -0,0 +1,67 @@
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// unixScheme prefixes the addresses of unix domain sockets, as in
+// unix:///var/run/acai.sock.
+const unixScheme = "unix://"
+
+// defaultSocketMode is the permissions of the unix sockets Run creates,
+// letting the owner's group connect.
+const defaultSocketMode os.FileMode = 0o660
+
+// listen binds addr: a unix domain socket for a unix:// address, created
+// with the given permissions, and a TCP address otherwise.
+func listen(addr string, socketMode os.FileMode) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixScheme)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("listen %s: no socket path", addr)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	// The socket file is removed when the listener is closed.
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		_ = ln.Close()
+		return nil, fmt.Errorf("listen %s: %w", addr, err)
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes the socket at path if nothing accepts
+// connections on it, as when left behind by a process that crashed. Files
+// that aren't sockets, and sockets still served, are left for Listen to
+// fail on.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return nil
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove stale socket: %w", err)
+	}
+	return nil
+}
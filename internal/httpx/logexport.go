@@ -0,0 +1,210 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutlog"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"google.golang.org/grpc"
+)
+
+// WithLogExport exports the records of the default logger as OTel logs,
+// next to traces and metrics: via OTLP when an endpoint is configured, to
+// stdout otherwise. The records still go to the handler of WithTraceLogging,
+// which is installed as if WithTraceLogging(nil) had been given unless it
+// was. Records logged with the context of a span carry its trace and span
+// IDs.
+//
+// Records are exported in batches from a bounded queue, so that a blocked
+// exporter drops logs rather than stalls the code logging them.
+func WithLogExport() TelemetryOption {
+	return func(c *telemetryConfig) { c.logExport = true }
+}
+
+// WithLogExporter exports logs through exp instead of OTLP or stdout, and
+// enables WithLogExport.
+func WithLogExporter(exp sdklog.Exporter) TelemetryOption {
+	return func(c *telemetryConfig) { c.logExport, c.logExporter = true, exp }
+}
+
+// newLogExporter returns the exporter given with WithLogExporter, or else
+// exports logs via OTLP gRPC when an endpoint is configured, and to stdout
+// otherwise.
+func newLogExporter(ctx context.Context, cfg telemetryConfig) (sdklog.Exporter, error) {
+	if cfg.logExporter != nil {
+		return cfg.logExporter, nil
+	}
+	if cfg.endpoint == "" {
+		if err := cfg.stdout.open(); err != nil {
+			return nil, err
+		}
+		opts := []stdoutlog.Option{stdoutlog.WithWriter(cfg.stdout)}
+		if cfg.stdout.prettyPrint() {
+			opts = append(opts, stdoutlog.WithPrettyPrint())
+		}
+		return stdoutlog.New(opts...)
+	}
+
+	initCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := []otlploggrpc.Option{otlploggrpc.WithDialOption(grpc.WithBlock())}
+	if strings.Contains(cfg.endpoint, "://") {
+		opts = append(opts, otlploggrpc.WithEndpointURL(cfg.endpoint))
+	} else {
+		opts = append(opts, otlploggrpc.WithEndpoint(cfg.endpoint))
+	}
+	if cfg.insecure {
+		opts = append(opts, otlploggrpc.WithInsecure())
+	}
+	if len(cfg.headers) > 0 {
+		opts = append(opts, otlploggrpc.WithHeaders(cfg.headers))
+	}
+	return otlploggrpc.New(initCtx, opts...)
+}
+
+// OTelLogHandler is a slog.Handler emitting records as OTel log records, at
+// the level set with SetLogLevel. The trace context comes from the context
+// records are logged with, and attributes in groups get dotted keys.
+type OTelLogHandler struct {
+	logger otellog.Logger
+	prefix string // of the keys, from the groups open
+	attrs  []otellog.KeyValue
+}
+
+// NewOTelLogHandler returns a handler emitting records through provider,
+// such as the global one set by InitTelemetry with WithLogExport.
+func NewOTelLogHandler(provider otellog.LoggerProvider) *OTelLogHandler {
+	return &OTelLogHandler{logger: provider.Logger(instrumentationName)}
+}
+
+func (h *OTelLogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= logLevel.Level() && h.logger.Enabled(ctx, otellog.EnabledParameters{Severity: logSeverity(level)})
+}
+
+func (h *OTelLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	var rec otellog.Record
+	rec.SetTimestamp(r.Time)
+	rec.SetSeverity(logSeverity(r.Level))
+	rec.SetSeverityText(r.Level.String())
+	rec.SetBody(otellog.StringValue(r.Message))
+	rec.AddAttributes(h.attrs...)
+	var attrs []otellog.KeyValue
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = appendLogAttr(attrs, h.prefix, a)
+		return true
+	})
+	rec.AddAttributes(attrs...)
+	h.logger.Emit(ctx, rec)
+	return nil
+}
+
+func (h *OTelLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	h2 := *h
+	h2.attrs = slices.Clip(h.attrs)
+	for _, a := range attrs {
+		h2.attrs = appendLogAttr(h2.attrs, h.prefix, a)
+	}
+	return &h2
+}
+
+func (h *OTelLogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
+
+// logSeverity maps slog levels to OTel severities, which line up 9 apart:
+// Debug is DEBUG, Info INFO, Warn WARN and Error ERROR, levels in between
+// being DEBUG2, INFO3 and so on.
+func logSeverity(level slog.Level) otellog.Severity {
+	return otellog.Severity(min(max(int(level)+9, int(otellog.SeverityTrace1)), int(otellog.SeverityFatal4)))
+}
+
+// appendLogAttr appends a converted to dst, dropping empty attributes and
+// flattening groups into dotted keys.
+func appendLogAttr(dst []otellog.KeyValue, prefix string, a slog.Attr) []otellog.KeyValue {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return dst
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			dst = appendLogAttr(dst, prefix, ga)
+		}
+		return dst
+	}
+	return append(dst, otellog.KeyValue{Key: prefix + a.Key, Value: logValue(a.Value)})
+}
+
+func logValue(v slog.Value) otellog.Value {
+	switch v.Kind() {
+	case slog.KindString:
+		return otellog.StringValue(v.String())
+	case slog.KindInt64:
+		return otellog.Int64Value(v.Int64())
+	case slog.KindUint64:
+		if u := v.Uint64(); u <= 1<<63-1 {
+			return otellog.Int64Value(int64(u))
+		}
+		return otellog.StringValue(v.String())
+	case slog.KindFloat64:
+		return otellog.Float64Value(v.Float64())
+	case slog.KindBool:
+		return otellog.BoolValue(v.Bool())
+	case slog.KindDuration:
+		return otellog.StringValue(v.Duration().String())
+	case slog.KindTime:
+		return otellog.StringValue(v.Time().Format(time.RFC3339Nano))
+	}
+	switch x := v.Any().(type) {
+	case error:
+		return otellog.StringValue(x.Error())
+	case []byte:
+		return otellog.BytesValue(x)
+	default:
+		return otellog.StringValue(fmt.Sprint(x))
+	}
+}
+
+// teeHandler hands records to both of its handlers.
+type teeHandler [2]slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return t[0].Enabled(ctx, level) || t[1].Enabled(ctx, level)
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return teeHandler{t[0].WithAttrs(attrs), t[1].WithAttrs(attrs)}
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	return teeHandler{t[0].WithGroup(name), t[1].WithGroup(name)}
+}
@@ -0,0 +1,150 @@
+package httpx
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// memoryLogExporter keeps the records exported, blocking while block is
+// open.
+type memoryLogExporter struct {
+	block chan struct{}
+
+	mu      sync.Mutex
+	records []sdklog.Record
+}
+
+func (e *memoryLogExporter) Export(ctx context.Context, records []sdklog.Record) error {
+	if e.block != nil {
+		select {
+		case <-e.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range records {
+		e.records = append(e.records, r.Clone())
+	}
+	return nil
+}
+
+func (e *memoryLogExporter) Shutdown(context.Context) error   { return nil }
+func (e *memoryLogExporter) ForceFlush(context.Context) error { return nil }
+
+func (e *memoryLogExporter) Records() []sdklog.Record {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]sdklog.Record(nil), e.records...)
+}
+
+func logAttrs(r sdklog.Record) map[string]string {
+	attrs := map[string]string{}
+	r.WalkAttributes(func(kv otellog.KeyValue) bool {
+		attrs[kv.Key] = kv.Value.String()
+		return true
+	})
+	return attrs
+}
+
+// installLogExport initializes telemetry exporting logs to exp.
+func installLogExport(t *testing.T, exp sdklog.Exporter) Shutdown {
+	t.Helper()
+	restoreGlobals(t)
+
+	shutdown, err := InitTelemetry(context.Background(), "test",
+		WithMetricReader(sdkmetric.NewManualReader()),
+		WithSpanExporter(tracetest.NewInMemoryExporter()),
+		WithTraceLogging(slog.NewTextHandler(io.Discard, nil)),
+		WithLogExporter(exp),
+	)
+	if err != nil {
+		t.Fatalf("InitTelemetry() unexpected error: %v", err)
+	}
+	return shutdown
+}
+
+func TestInitTelemetry_LogExport(t *testing.T) {
+	exp := &memoryLogExporter{}
+	shutdown := installLogExport(t, exp)
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "book")
+	slog.With("service", "booking").WithGroup("trip").WarnContext(ctx, "Fully booked", "id", "t1", slog.Group("seats", "left", 0))
+	slog.Debug("Below the level")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	var records []sdklog.Record
+	for _, r := range exp.Records() {
+		if r.Body().AsString() == "Fully booked" || r.Body().AsString() == "Below the level" {
+			records = append(records, r)
+		}
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want the Warn one only", len(records))
+	}
+	r := records[0]
+	if r.TraceID() != span.SpanContext().TraceID() || r.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("record trace %s span %s, want %s %s", r.TraceID(), r.SpanID(), span.SpanContext().TraceID(), span.SpanContext().SpanID())
+	}
+	if r.Severity() != otellog.SeverityWarn || r.SeverityText() != "WARN" {
+		t.Errorf("severity = %v %q, want WARN", r.Severity(), r.SeverityText())
+	}
+	want := map[string]string{"service": "booking", "trip.id": "t1", "trip.seats.left": "0"}
+	if got := logAttrs(r); !maps.Equal(got, want) {
+		t.Errorf("attributes = %v, want %v", got, want)
+	}
+}
+
+func TestInitTelemetry_LogExportBlocked(t *testing.T) {
+	exp := &memoryLogExporter{block: make(chan struct{})}
+	shutdown := installLogExport(t, exp)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 10_000 {
+			slog.Info("Busy")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("logging stalled on a blocked exporter")
+	}
+
+	close(exp.block)
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+}
+
+func TestLogSeverity(t *testing.T) {
+	for level, want := range map[slog.Level]otellog.Severity{
+		slog.LevelDebug:     otellog.SeverityDebug,
+		slog.LevelInfo:      otellog.SeverityInfo,
+		slog.LevelInfo + 2:  otellog.SeverityInfo3,
+		slog.LevelWarn:      otellog.SeverityWarn,
+		slog.LevelError:     otellog.SeverityError,
+		slog.LevelDebug - 8: otellog.SeverityTrace1,
+		slog.LevelError + 8: otellog.SeverityFatal4,
+	} {
+		if got := logSeverity(level); got != want {
+			t.Errorf("logSeverity(%v) = %v, want %v", level, got, want)
+		}
+	}
+}
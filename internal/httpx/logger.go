@@ -1,34 +1,187 @@
 package httpx
 
 import (
+	"encoding/binary"
 	"log/slog"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
-type statusAwareResponseWriter struct {
-	http.ResponseWriter
-	status int
+// Logger returns an access log middleware writing to the default logger.
+func Logger() func(handler http.Handler) http.Handler {
+	return AccessLogMiddleware(nil)
 }
 
-func (w *statusAwareResponseWriter) WriteHeader(status int) {
-	w.status = status
-	w.ResponseWriter.WriteHeader(status)
+// AccessLogOption configures AccessLogMiddleware.
+type AccessLogOption func(*accessLogConfig)
+
+type accessLogConfig struct {
+	ignoredPaths map[string]bool
+	sampling     bool
+	sampleRate   float64
+	slow         time.Duration
+	random       func() float64
 }
 
-func Logger() func(handler http.Handler) http.Handler {
-	return func(handler http.Handler) http.Handler {
+// WithAccessLogIgnoredPaths skips logging requests for the given exact paths,
+// such as health checks.
+func WithAccessLogIgnoredPaths(paths ...string) AccessLogOption {
+	return func(c *accessLogConfig) {
+		for _, p := range paths {
+			c.ignoredPaths[p] = true
+		}
+	}
+}
+
+// WithAccessLogSampling logs only the given fraction of successful requests
+// that are not slow; errors and slow requests are always logged. Sampled
+// lines get sampled=true and sample_rate, to scale counts back up. Requests
+// whose trace is sampled are kept or dropped according to their trace ID,
+// the way trace sampling is, so that logs and traces of the same requests
+// are kept.
+func WithAccessLogSampling(rate float64) AccessLogOption {
+	return func(c *accessLogConfig) { c.sampling, c.sampleRate = true, rate }
+}
+
+// WithAccessLogSlowThreshold sets how long a request must take to be always
+// logged when sampling. Defaults to one second.
+func WithAccessLogSlowThreshold(d time.Duration) AccessLogOption {
+	return func(c *accessLogConfig) { c.slow = d }
+}
+
+// AccessLogMiddleware logs one structured line per request at Info for
+// successful responses, Warn for 4xx and Error for 5xx. A nil logger means
+// slog.Default(). Trace and span IDs are included when a span is active.
+func AccessLogMiddleware(logger *slog.Logger, opts ...AccessLogOption) func(http.Handler) http.Handler {
+	cfg := accessLogConfig{ignoredPaths: map[string]bool{}, slow: time.Second, random: rand.Float64}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			saw := &statusAwareResponseWriter{ResponseWriter: w}
+			if cfg.ignoredPaths[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			sw, w := captureStatus(w)
+			r, st := withRequestState(r)
+
+			logRequest := func() {
+				st.notePattern(r)
+				status := sw.status
+				if _, _, panicked := st.panicInfo(); panicked && !sw.wroteHeader {
+					status = http.StatusInternalServerError
+				}
+				elapsed := time.Since(start)
+				sc := st.serverSpanContext(r.Context())
+				keep, sampled := cfg.sample(status, elapsed, sc)
+				if !keep {
+					return
+				}
 
+				origin := ExternalURL(r)
+				attrs := []slog.Attr{
+					slog.String("http_method", r.Method),
+					slog.String("http_scheme", origin.Scheme),
+					slog.String("http_host", origin.Host),
+					slog.String("http_route", PatternRoute(r)),
+					slog.String("http_path", r.URL.Path),
+					slog.Int("http_status", status),
+					slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+					slog.Int64("response_bytes", sw.written),
+					slog.String("client_ip", ClientIP(r)),
+					slog.String("user_agent", r.UserAgent()),
+				}
+				if ttfb, ok := sw.timeToFirstByte(start); ok {
+					attrs = append(attrs, slog.Float64("ttfb_ms", float64(ttfb.Microseconds())/1000))
+				}
+				if id := RequestIDFromContext(r.Context()); id != "" {
+					attrs = append(attrs, slog.String("request_id", id))
+				}
+				if sampled {
+					attrs = append(attrs, slog.Bool("sampled", true), slog.Float64("sample_rate", cfg.sampleRate))
+				}
+
+				l := logger
+				if l == nil {
+					l = slog.Default()
+				}
+				// A LogHandler adds the IDs itself when the span is in the context.
+				_, traced := l.Handler().(*LogHandler)
+				traced = traced && trace.SpanFromContext(r.Context()).IsRecording()
+				if sc.IsValid() && !traced {
+					attrs = append(attrs,
+						slog.String("trace_id", sc.TraceID().String()),
+						slog.String("span_id", sc.SpanID().String()),
+					)
+				}
+				l.LogAttrs(r.Context(), accessLogLevel(status), "HTTP request", attrs...)
+			}
+
+			// Note a panic before logging it, in case no middleware below did.
+			completed := false
 			defer func() {
-				if saw.status/100 == 5 {
-					slog.ErrorContext(r.Context(), "HTTP request failed", "http_method", r.Method, "http_path", r.URL.Path, "http_status", saw.status)
-				} else {
-					slog.InfoContext(r.Context(), "HTTP request complete", "http_method", r.Method, "http_path", r.URL.Path, "http_status", saw.status)
+				if completed {
+					return
+				}
+				v := recover()
+				if v != nil && v != http.ErrAbortHandler {
+					st.notePanic(v)
+				}
+				logRequest()
+				if v != nil {
+					panic(v)
 				}
 			}()
 
-			handler.ServeHTTP(saw, r)
+			next.ServeHTTP(w, r)
+			completed = true
+			logRequest()
 		})
 	}
 }
+
+// sample reports whether to log a request, and whether it was sampled to
+// be.
+func (c *accessLogConfig) sample(status int, elapsed time.Duration, sc trace.SpanContext) (keep, sampled bool) {
+	if !c.sampling || status >= 400 || elapsed >= c.slow {
+		return true, false
+	}
+	if sc.IsSampled() {
+		return traceIDFraction(sc.TraceID()) < c.sampleRate, true
+	}
+	return c.random() < c.sampleRate, true
+}
+
+// traceIDFraction maps a trace ID to [0, 1), from the same random bits as
+// the OpenTelemetry ratio sampler.
+func traceIDFraction(id trace.TraceID) float64 {
+	return float64(binary.BigEndian.Uint64(id[8:16])>>11) / (1 << 53)
+}
+
+func accessLogLevel(status int) slog.Level {
+	switch {
+	case status >= 500:
+		return slog.LevelError
+	case status >= 400:
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// remoteIP returns the IP of the direct peer.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,192 @@
+package httpx
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+)
+
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var lines []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var line map[string]any
+		if err := dec.Decode(&line); err != nil {
+			t.Fatalf("decode log line: %v", err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /trips/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("trip"))
+	})
+	mux.HandleFunc("GET /fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name      string
+		path      string
+		wantLevel string
+		wantRoute string
+		wantBytes float64
+	}{
+		{name: "success", path: "/trips/42", wantLevel: "INFO", wantRoute: "/trips/{id}", wantBytes: 4},
+		{name: "client error", path: "/nope", wantLevel: "WARN", wantRoute: UnmatchedRoute, wantBytes: 19},
+		{name: "server error", path: "/fail", wantLevel: "ERROR", wantRoute: "/fail"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, nil))
+			handler := AccessLogMiddleware(logger, WithAccessLogIgnoredPaths("/healthz"))(mux)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("User-Agent", "acai-test/1.0")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+			lines := decodeLogLines(t, &buf)
+			if len(lines) != 1 {
+				t.Fatalf("expected 1 log line, got %d: %v", len(lines), lines)
+			}
+			line := lines[0]
+			for key, want := range map[string]any{
+				"level":          tt.wantLevel,
+				"http_method":    http.MethodGet,
+				"http_route":     tt.wantRoute,
+				"http_path":      tt.path,
+				"response_bytes": tt.wantBytes,
+				"client_ip":      "192.0.2.1",
+				"user_agent":     "acai-test/1.0",
+			} {
+				if line[key] != want {
+					t.Errorf("%s mismatch: got %v, want %v", key, line[key], want)
+				}
+			}
+			if _, ok := line["duration_ms"].(float64); !ok {
+				t.Errorf("duration_ms missing or not a number: %v", line["duration_ms"])
+			}
+			if _, ok := line["trace_id"]; ok {
+				t.Error("trace_id should be absent without an active span")
+			}
+		})
+	}
+}
+
+func TestAccessLogMiddleware_TraceCorrelation(t *testing.T) {
+	exp := otelt.InstallTracing(t)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	handler := AccessLogMiddleware(logger)(TracingMiddleware(http.NotFoundHandler()))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	spans := exp.GetSpans()
+	lines := decodeLogLines(t, &buf)
+	if len(spans) != 1 || len(lines) != 1 {
+		t.Fatalf("expected 1 span and 1 log line, got %d and %d", len(spans), len(lines))
+	}
+	if got, want := lines[0]["trace_id"], spans[0].SpanContext.TraceID().String(); got != want {
+		t.Errorf("trace_id mismatch: got %v, want %v", got, want)
+	}
+	if got, want := lines[0]["span_id"], spans[0].SpanContext.SpanID().String(); got != want {
+		t.Errorf("span_id mismatch: got %v, want %v", got, want)
+	}
+}
+
+func TestAccessLogMiddleware_Sampling(t *testing.T) {
+	fixedRandom := func(v float64) AccessLogOption {
+		return func(c *accessLogConfig) { c.random = func() float64 { return v } }
+	}
+
+	tests := []struct {
+		name        string
+		status      int
+		delay       time.Duration
+		random      float64
+		wantLogged  bool
+		wantSampled bool
+	}{
+		{name: "dropped", status: http.StatusOK, random: 0.5},
+		{name: "sampled", status: http.StatusOK, random: 0.005, wantLogged: true, wantSampled: true},
+		{name: "client error", status: http.StatusNotFound, random: 0.5, wantLogged: true},
+		{name: "server error", status: http.StatusBadGateway, random: 0.5, wantLogged: true},
+		{name: "slow", status: http.StatusOK, delay: 20 * time.Millisecond, random: 0.5, wantLogged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, nil))
+			handler := AccessLogMiddleware(logger,
+				WithAccessLogSampling(0.01),
+				WithAccessLogSlowThreshold(10*time.Millisecond),
+				fixedRandom(tt.random),
+			)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.delay)
+				w.WriteHeader(tt.status)
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/trips", nil))
+
+			lines := decodeLogLines(t, &buf)
+			if got := len(lines) == 1; got != tt.wantLogged {
+				t.Fatalf("logged %d lines, want logged: %v", len(lines), tt.wantLogged)
+			}
+			if !tt.wantLogged {
+				return
+			}
+			if sampled, _ := lines[0]["sampled"].(bool); sampled != tt.wantSampled {
+				t.Errorf("sampled = %v, want %v", lines[0]["sampled"], tt.wantSampled)
+			}
+			if tt.wantSampled && lines[0]["sample_rate"] != 0.01 {
+				t.Errorf("sample_rate = %v, want 0.01", lines[0]["sample_rate"])
+			}
+		})
+	}
+}
+
+func TestAccessLogMiddleware_SamplingFollowsTrace(t *testing.T) {
+	otelt.InstallTracing(t)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	// The random source would keep every request: the trace ID decides.
+	keepAll := func(c *accessLogConfig) { c.random = func() float64 { return 0 } }
+	handler := Chain(TracingMiddleware, AccessLogMiddleware(logger, WithAccessLogSampling(0.5), keepAll))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, traceID := range []string{
+		"4bf92f3577b34da60000000000000001", // low random bits: kept
+		"4bf92f3577b34da6ffffffffffffffff", // high random bits: dropped
+	} {
+		for range 2 {
+			req := httptest.NewRequest(http.MethodGet, "/trips", nil)
+			req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	}
+
+	lines := decodeLogLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("logged %d lines, want both requests of the kept trace only", len(lines))
+	}
+	for _, line := range lines {
+		if line["trace_id"] != "4bf92f3577b34da60000000000000001" {
+			t.Errorf("logged trace %v", line["trace_id"])
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package httpx
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// LogHandler is a slog.Handler adding trace_id, span_id and sampled to
+// records logged with the context of a recording span.
+type LogHandler struct {
+	// root has no groups applied, so trace attributes can stay top level.
+	root  slog.Handler
+	ops   []logHandlerOp
+	inner slog.Handler
+}
+
+// logHandlerOp is a WithAttrs or WithGroup call, replayed on root when a
+// record needs trace attributes.
+type logHandlerOp struct {
+	group string
+	attrs []slog.Attr
+}
+
+// NewLogHandler wraps inner. Records without a recording span go straight to
+// inner.
+func NewLogHandler(inner slog.Handler) *LogHandler {
+	return &LogHandler{root: inner, inner: inner}
+}
+
+func (h *LogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *LogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx == nil {
+		return h.inner.Handle(ctx, r)
+	}
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return h.inner.Handle(ctx, r)
+	}
+
+	sc := span.SpanContext()
+	attrs := []slog.Attr{
+		slog.String("trace_id", sc.TraceID().String()),
+		slog.String("span_id", sc.SpanID().String()),
+		slog.Bool("sampled", sc.IsSampled()),
+	}
+	if len(h.ops) == 0 {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+		return h.inner.Handle(ctx, r)
+	}
+
+	// With groups open, attributes added to the record would land inside
+	// them; rebuild the handler with the trace attributes first instead.
+	inner := h.root.WithAttrs(attrs)
+	for _, op := range h.ops {
+		if op.group != "" {
+			inner = inner.WithGroup(op.group)
+		} else {
+			inner = inner.WithAttrs(op.attrs)
+		}
+	}
+	return inner.Handle(ctx, r)
+}
+
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return h.with(logHandlerOp{attrs: attrs}, h.inner.WithAttrs(attrs))
+}
+
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(logHandlerOp{group: name}, h.inner.WithGroup(name))
+}
+
+func (h *LogHandler) with(op logHandlerOp, inner slog.Handler) *LogHandler {
+	ops := make([]logHandlerOp, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &LogHandler{root: h.root, ops: append(ops, op), inner: inner}
+}
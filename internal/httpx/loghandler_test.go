@@ -0,0 +1,90 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestLogHandler(t *testing.T) {
+	otelt.InstallTracing(t)
+
+	tests := []struct {
+		name      string
+		withSpan  bool
+		setup     func(*slog.Logger) *slog.Logger
+		wantGroup string
+	}{
+		{name: "no span", setup: func(l *slog.Logger) *slog.Logger { return l }},
+		{name: "recording span", withSpan: true, setup: func(l *slog.Logger) *slog.Logger { return l }},
+		{
+			name:      "recording span with attrs and group",
+			withSpan:  true,
+			setup:     func(l *slog.Logger) *slog.Logger { return l.With("component", "chat").WithGroup("req") },
+			wantGroup: "req",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := tt.setup(slog.New(NewLogHandler(slog.NewJSONHandler(&buf, nil))))
+
+			ctx := context.Background()
+			var wantTrace, wantSpan string
+			if tt.withSpan {
+				var span trace.Span
+				ctx, span = Tracer().Start(ctx, "op")
+				defer span.End()
+				wantTrace, wantSpan = span.SpanContext().TraceID().String(), span.SpanContext().SpanID().String()
+			}
+			logger.InfoContext(ctx, "hello", "key", "value")
+
+			lines := decodeLogLines(t, &buf)
+			if len(lines) != 1 {
+				t.Fatalf("expected 1 log line, got %d", len(lines))
+			}
+			line := lines[0]
+
+			if !tt.withSpan {
+				for _, key := range []string{"trace_id", "span_id", "sampled"} {
+					if _, ok := line[key]; ok {
+						t.Errorf("unexpected %s without a span: %v", key, line)
+					}
+				}
+				return
+			}
+			if line["trace_id"] != wantTrace || line["span_id"] != wantSpan || line["sampled"] != true {
+				t.Errorf("trace attributes mismatch: got %v, want trace_id=%s span_id=%s sampled=true", line, wantTrace, wantSpan)
+			}
+			if tt.wantGroup != "" {
+				group, _ := line[tt.wantGroup].(map[string]any)
+				if group["key"] != "value" {
+					t.Errorf("record attribute not inside group %q: %v", tt.wantGroup, line)
+				}
+				if line["component"] != "chat" {
+					t.Errorf("handler attribute lost: %v", line)
+				}
+			}
+		})
+	}
+}
+
+func TestAccessLogMiddleware_LogHandlerDoesNotDuplicateIDs(t *testing.T) {
+	otelt.InstallTracing(t)
+
+	var buf bytes.Buffer
+	logger := slog.New(NewLogHandler(slog.NewJSONHandler(&buf, nil)))
+	handler := TracingMiddleware(AccessLogMiddleware(logger)(http.NotFoundHandler()))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if n := bytes.Count(buf.Bytes(), []byte(`"trace_id"`)); n != 1 {
+		t.Errorf("trace_id count mismatch: got %d, want 1 in %s", n, buf.String())
+	}
+}
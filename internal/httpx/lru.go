@@ -0,0 +1,70 @@
+package httpx
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// ttlCache is a least recently used cache whose entries expire, backing the
+// in-memory stores of this package.
+type ttlCache[V any] struct {
+	capacity int
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // most recently used first
+}
+
+type ttlEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+func newTTLCache[V any](capacity int) *ttlCache[V] {
+	return &ttlCache[V]{
+		capacity: max(capacity, 1),
+		now:      time.Now,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+func (c *ttlCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	el, ok := c.entries[key]
+	if !ok {
+		return zero, false
+	}
+	e := el.Value.(*ttlEntry[V])
+	if !c.now().Before(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return zero, false
+	}
+	c.lru.MoveToFront(el)
+	return e.value, true
+}
+
+func (c *ttlCache[V]) set(key string, v V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := &ttlEntry[V]{key: key, value: v, expires: c.now().Add(ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	if c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*ttlEntry[V]).key)
+	}
+}
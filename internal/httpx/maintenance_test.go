@@ -0,0 +1,149 @@
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestMaintenance(t *testing.T) {
+	captureLogs(t)
+	reader := otelt.InstallMetrics(t)
+
+	gauge := func() int64 {
+		t.Helper()
+		m, ok := collectMetric(t, reader, "http.server.maintenance")
+		if !ok {
+			t.Fatal("http.server.maintenance not recorded")
+		}
+		return m.Data.(metricdata.Gauge[int64]).DataPoints[0].Value
+	}
+
+	m := NewMaintenance(WithRetryAfter(30*time.Second), WithMaintenanceExemptPaths("/status"), WithMaintenanceExemptMethods(http.MethodGet))
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	if rec := serve(http.MethodPost, "/bookings"); rec.Code != http.StatusOK {
+		t.Errorf("disabled: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	if gauge() != 0 {
+		t.Error("gauge set before maintenance was enabled")
+	}
+
+	m.Enable()
+	rec := serve(http.MethodPost, "/bookings")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "30" {
+		t.Errorf("enabled: got %d with Retry-After %q, want 503 and 30", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	var body errorBody
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Code != "maintenance" {
+		t.Errorf("enabled: body %q (%v), want code maintenance", rec.Body.String(), err)
+	}
+	for _, exempt := range []struct{ method, path string }{
+		{http.MethodPost, "/readyz"},
+		{http.MethodPost, "/status"},
+		{http.MethodGet, "/bookings"},
+	} {
+		if rec := serve(exempt.method, exempt.path); rec.Code != http.StatusOK {
+			t.Errorf("enabled: %s %s got %d, want it exempt", exempt.method, exempt.path, rec.Code)
+		}
+	}
+	if gauge() != 1 {
+		t.Error("gauge not set while in maintenance")
+	}
+
+	m.Disable()
+	if rec := serve(http.MethodPost, "/bookings"); rec.Code != http.StatusOK {
+		t.Errorf("disabled again: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	if gauge() != 0 {
+		t.Error("gauge still set after maintenance")
+	}
+}
+
+func TestMaintenance_ConcurrentToggle(t *testing.T) {
+	captureLogs(t)
+	otelt.InstallMetrics(t)
+
+	m := NewMaintenance()
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+	}))
+
+	stop := make(chan struct{})
+	var toggler sync.WaitGroup
+	toggler.Add(1)
+	go func() {
+		defer toggler.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				m.Enable()
+				m.Disable()
+			}
+		}
+	}()
+
+	var requests sync.WaitGroup
+	for range 20 {
+		requests.Add(1)
+		go func() {
+			defer requests.Done()
+			for range 10 {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/bookings", nil))
+				if rec.Code != http.StatusOK && rec.Code != http.StatusServiceUnavailable {
+					t.Errorf("got %d, want 200 or 503", rec.Code)
+				}
+			}
+		}()
+	}
+	requests.Wait()
+	close(stop)
+	toggler.Wait()
+
+	if m.Enabled() {
+		t.Error("maintenance left enabled")
+	}
+}
+
+func TestMaintenance_Handler(t *testing.T) {
+	captureLogs(t)
+	m := NewMaintenance()
+	admin := AdminServer("", WithAdminMaintenance(m), WithPprof(false)).Handler()
+
+	for _, step := range []struct {
+		method string
+		want   bool
+	}{
+		{http.MethodGet, false},
+		{http.MethodPut, true},
+		{http.MethodGet, true},
+		{http.MethodDelete, false},
+	} {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(step.method, "/maintenance", nil))
+		var status maintenanceStatus
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil || status.Enabled != step.want {
+			t.Errorf("%s /maintenance: got %d %+v (%v), want enabled=%v", step.method, rec.Code, status, err, step.want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/maintenance", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /maintenance: got %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,138 @@
+package httpx
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// MaxBodyOption configures MaxBodyMiddleware.
+type MaxBodyOption func(*maxBodyConfig)
+
+type maxBodyConfig struct {
+	routes *Router
+}
+
+// WithRouteBodyLimits applies the limits registered on rt with RouteMaxBody
+// to the requests it routes, instead of the middleware default.
+func WithRouteBodyLimits(rt *Router) MaxBodyOption {
+	return func(c *maxBodyConfig) { c.routes = rt }
+}
+
+// MaxBodyMiddleware caps request bodies at limit bytes. Once a handler reads
+// past the limit it gets an *http.MaxBytesError, and whatever it answers is
+// replaced by a 413 with a JSON body, counted in
+// http.server.request.too_large. Handlers that don't read the body are not
+// affected.
+func MaxBodyMiddleware(limit int64, opts ...MaxBodyOption) func(http.Handler) http.Handler {
+	var cfg maxBodyConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := limit
+			if override := routeConfig(r, cfg.routes).MaxBody; override > 0 {
+				n = override
+			}
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			r, _ = withRequestState(r)
+			body := &maxBody{limit: n}
+			body.ReadCloser = http.MaxBytesReader(w, r.Body, n)
+			r.Body = body
+			mw := &maxBodyWriter{ResponseWriter: w, body: body}
+
+			next.ServeHTTP(mw, r)
+
+			if body.exceeded.Load() {
+				mw.reject()
+				loadServerMetrics().oversize.Add(r.Context(), 1, metric.WithAttributes(
+					attribute.String("http.method", metricMethod(r.Method)),
+					attribute.String("http.route", PatternRoute(r)),
+				))
+			}
+		})
+	}
+}
+
+// maxBody notes when a read went over the limit.
+type maxBody struct {
+	io.ReadCloser
+	limit    int64
+	exceeded atomic.Bool
+}
+
+func (b *maxBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		b.exceeded.Store(true)
+	}
+	return n, err
+}
+
+// maxBodyWriter holds back the handler's response once the body went over
+// the limit, so the 413 can take its place.
+type maxBodyWriter struct {
+	http.ResponseWriter
+	body        *maxBody
+	wroteHeader bool
+	rejected    bool
+}
+
+func (w *maxBodyWriter) WriteHeader(code int) {
+	if w.body.exceeded.Load() {
+		w.reject()
+		return
+	}
+	if code >= 200 {
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *maxBodyWriter) Write(b []byte) (int, error) {
+	if w.body.exceeded.Load() {
+		w.reject()
+		return len(b), nil
+	}
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming handlers working.
+func (w *maxBodyWriter) Flush() {
+	w.wroteHeader = true
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *maxBodyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// reject sends the 413 unless the handler's response already started.
+func (w *maxBodyWriter) reject() {
+	if w.rejected || w.wroteHeader {
+		return
+	}
+	w.rejected = true
+	h := w.Header()
+	for k := range h {
+		delete(h, k)
+	}
+	h.Set("Content-Type", "application/json")
+	h.Set("Connection", "close")
+	w.ResponseWriter.WriteHeader(http.StatusRequestEntityTooLarge)
+	_, _ = io.WriteString(w.ResponseWriter, `{"error":"request body exceeds `+strconv.FormatInt(w.body.limit, 10)+` bytes"}`+"\n")
+}
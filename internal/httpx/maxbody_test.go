@@ -0,0 +1,88 @@
+package httpx
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+)
+
+// chunked hides the length of r so the client streams it with chunked
+// transfer encoding.
+type chunked struct{ io.Reader }
+
+func TestMaxBodyMiddleware(t *testing.T) {
+	const limit = 1 << 10
+
+	reading := func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}
+	rt := NewRouter()
+	rt.HandleFunc("POST /bookings", reading)
+	rt.HandleFunc("POST /uploads", reading, RouteMaxBody(4*limit))
+	rt.HandleFunc("POST /ping", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name      string
+		path      string
+		size      int
+		want      int
+		wantCount int64
+	}{
+		{name: "at the limit", path: "/bookings", size: limit, want: http.StatusCreated},
+		{name: "just over the limit", path: "/bookings", size: limit + 1, want: http.StatusRequestEntityTooLarge, wantCount: 1},
+		{name: "route override", path: "/uploads", size: 2 * limit, want: http.StatusCreated},
+		{name: "handler ignoring the body", path: "/ping", size: 8 * limit, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := otelt.InstallMetrics(t)
+			var gotLength int64
+			handler := MetricsMiddleware(MaxBodyMiddleware(limit, WithRouteBodyLimits(rt))(rt))
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotLength = r.ContentLength
+				handler.ServeHTTP(w, r)
+			}))
+			defer srv.Close()
+
+			req, _ := http.NewRequest(http.MethodPost, srv.URL+tt.path, chunked{strings.NewReader(strings.Repeat("x", tt.size))})
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do() unexpected error: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+
+			if gotLength != -1 {
+				t.Fatalf("request was not chunked: Content-Length %d", gotLength)
+			}
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status mismatch: got %d, want %d (%s)", resp.StatusCode, tt.want, body)
+			}
+			if tt.want == http.StatusRequestEntityTooLarge {
+				if ct := resp.Header.Get("Content-Type"); ct != "application/json" || !strings.Contains(string(body), `"error"`) {
+					t.Errorf("expected a JSON error, got %q: %s", ct, body)
+				}
+			}
+
+			var count int64
+			for _, dp := range collectSum(t, reader, "http.server.request.too_large") {
+				count += dp.Value
+				if route, _ := dp.Attributes.Value("http.route"); route.AsString() != tt.path {
+					t.Errorf("http.route mismatch: got %q, want %q", route.AsString(), tt.path)
+				}
+			}
+			if count != tt.wantCount {
+				t.Errorf("too_large count mismatch: got %d, want %d", count, tt.wantCount)
+			}
+		})
+	}
+}
@@ -1,56 +1,735 @@
 package httpx
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var (
-	reqCounter       metric.Int64Counter
-	errCounter       metric.Int64Counter
-	latencyHistogram metric.Float64Histogram
-)
+// latencyBuckets covers typical HTTP latencies from 1ms up to 10s, in seconds.
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// latencyBucketsMs is latencyBuckets in milliseconds, for the deprecated
+// http.server.duration.ms histogram.
+var latencyBucketsMs = []float64{1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// middlewareBuckets covers the time of single middlewares, from 10µs up to
+// 1s, in seconds.
+var middlewareBuckets = []float64{0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// sizeBuckets covers request and response bodies from empty up to 16MiB.
+var sizeBuckets = []float64{0, 128, 512, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}
+
+// connRequestBuckets covers the requests served per connection, from one,
+// as when clients don't keep connections alive, up to thousands.
+var connRequestBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 500, 1000, 5000}
+
+// streamBuckets covers the seconds event streams stay open, from clients
+// leaving right away up to half a day.
+var streamBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 4 * 3600, 12 * 3600}
+
+// serverMetrics holds the instruments recorded by MetricsMiddleware, bound to
+// the meter provider they were created from.
+type serverMetrics struct {
+	provider metric.MeterProvider
+	requests metric.Int64Counter
+	errors   metric.Int64Counter
+	duration metric.Float64Histogram
+	// durationMs is the original millisecond histogram, kept until dashboards
+	// move to duration.
+	durationMs metric.Float64Histogram
+	ttfb       metric.Float64Histogram
+	queueTime  metric.Float64Histogram
+	active     metric.Int64UpDownCounter
+	reqSize    metric.Int64Histogram
+	respSize   metric.Int64Histogram
+
+	healthFailures metric.Int64Counter
+	throttled      metric.Int64Counter
+	queueDepth     metric.Int64UpDownCounter
+	queueWait      metric.Float64Histogram
+	shed           metric.Int64Counter
+	oversize       metric.Int64Counter
+	decodeErrors   metric.Int64Counter
+	attrOverflow   metric.Int64Counter
+	auditFailures  metric.Int64Counter
+	maintenance    metric.Int64Gauge
+	idempotency    metric.Int64Counter
+	cache          metric.Int64Counter
+	hostRejected   metric.Int64Counter
+	slow           metric.Int64Counter
+	logLevel       metric.Int64Gauge
+	sampleRatio    metric.Float64Gauge
+	middleware     metric.Float64Histogram
+	apdex          metric.Int64Counter
+	sliGood        metric.Int64Counter
+	sliTotal       metric.Int64Counter
+	mirror         metric.Int64Counter
+	tlsHandshake   metric.Int64Counter
+	conns          metric.Int64UpDownCounter
+	connsOpened    metric.Int64Counter
+	connsClosed    metric.Int64Counter
+	headerTimeouts metric.Int64Counter
+	connRequests   metric.Int64Histogram
+	shutdownPhase  metric.Int64Gauge
+	fingerprints   metric.Int64Counter
+	sseStreams     metric.Int64UpDownCounter
+	sseEvents      metric.Int64Counter
+	sseDuration    metric.Float64Histogram
+	opDuration     metric.Float64Histogram
+	opErrors       metric.Int64Counter
+}
+
+var currentServerMetrics atomic.Pointer[serverMetrics]
+
+func newServerMetrics(mp metric.MeterProvider) (*serverMetrics, error) {
+	m := mp.Meter(instrumentationName)
+	sm := &serverMetrics{provider: mp}
 
-func init() {
-	m := Meter()
-	reqCounter, _ = m.Int64Counter("http.server.requests",
+	var err, errs error
+	sm.requests, err = m.Int64Counter("http.server.requests",
 		metric.WithDescription("Total number of HTTP requests"))
-	errCounter, _ = m.Int64Counter("http.server.errors",
+	errs = errors.Join(errs, err)
+	sm.errors, err = m.Int64Counter("http.server.errors",
 		metric.WithDescription("Total number of HTTP error responses (status >= 400)"))
-	latencyHistogram, _ = m.Float64Histogram("http.server.duration.ms",
-		metric.WithDescription("Request duration in milliseconds"))
+	errs = errors.Join(errs, err)
+	sm.duration, err = m.Float64Histogram("http.server.request.duration",
+		metric.WithDescription("Duration of HTTP server requests"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(latencyBuckets...))
+	errs = errors.Join(errs, err)
+	sm.durationMs, err = m.Float64Histogram("http.server.duration.ms",
+		metric.WithDescription("Request duration in milliseconds. Deprecated: use http.server.request.duration"),
+		metric.WithExplicitBucketBoundaries(latencyBucketsMs...))
+	errs = errors.Join(errs, err)
+	sm.ttfb, err = m.Float64Histogram("http.server.time_to_first_byte",
+		metric.WithDescription("Time until HTTP server handlers start their response"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(latencyBuckets...))
+	errs = errors.Join(errs, err)
+	sm.queueTime, err = m.Float64Histogram("http.server.queue_time",
+		metric.WithDescription("Time requests spent queued in front of the server, from the load balancer's X-Request-Start"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(latencyBuckets...))
+	errs = errors.Join(errs, err)
+	sm.active, err = m.Int64UpDownCounter("http.server.active_requests",
+		metric.WithDescription("Number of HTTP requests currently being served"))
+	errs = errors.Join(errs, err)
+	sm.reqSize, err = m.Int64Histogram("http.server.request.size",
+		metric.WithDescription("Size of HTTP request bodies"),
+		metric.WithUnit("By"),
+		metric.WithExplicitBucketBoundaries(sizeBuckets...))
+	errs = errors.Join(errs, err)
+	sm.respSize, err = m.Int64Histogram("http.server.response.size",
+		metric.WithDescription("Size of HTTP response bodies"),
+		metric.WithUnit("By"),
+		metric.WithExplicitBucketBoundaries(sizeBuckets...))
+	errs = errors.Join(errs, err)
+	sm.healthFailures, err = m.Int64Counter("health.check.failures",
+		metric.WithDescription("Total number of failed health checks"))
+	errs = errors.Join(errs, err)
+	sm.throttled, err = m.Int64Counter("http.server.throttled",
+		metric.WithDescription("Total number of requests rejected by rate limiting"))
+	errs = errors.Join(errs, err)
+	sm.queueDepth, err = m.Int64UpDownCounter("http.server.queue.depth",
+		metric.WithDescription("Number of requests waiting for a concurrency slot"))
+	errs = errors.Join(errs, err)
+	sm.queueWait, err = m.Float64Histogram("http.server.queue.wait",
+		metric.WithDescription("Time requests spent waiting for a concurrency slot"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(latencyBuckets...))
+	errs = errors.Join(errs, err)
+	sm.shed, err = m.Int64Counter("http.server.shed",
+		metric.WithDescription("Total number of requests shed by the concurrency limit"))
+	errs = errors.Join(errs, err)
+	sm.oversize, err = m.Int64Counter("http.server.request.too_large",
+		metric.WithDescription("Total number of requests rejected for an oversized body"))
+	errs = errors.Join(errs, err)
+	sm.decodeErrors, err = m.Int64Counter("http.server.request.decode_errors",
+		metric.WithDescription("Total number of request bodies DecodeJSON failed to decode"))
+	errs = errors.Join(errs, err)
+	sm.attrOverflow, err = m.Int64Counter("http.server.attribute.overflow",
+		metric.WithDescription("Total number of distinct metric attribute values refused for exceeding the value limit"))
+	errs = errors.Join(errs, err)
+	sm.auditFailures, err = m.Int64Counter("http.server.audit.failures",
+		metric.WithDescription("Total number of audit records the audit sink failed to store"))
+	errs = errors.Join(errs, err)
+	sm.maintenance, err = m.Int64Gauge("http.server.maintenance",
+		metric.WithDescription("Whether the service is in maintenance mode (1) or not (0)"))
+	errs = errors.Join(errs, err)
+	sm.idempotency, err = m.Int64Counter("http.server.idempotency",
+		metric.WithDescription("Total number of idempotency key lookups, by result"))
+	errs = errors.Join(errs, err)
+	sm.cache, err = m.Int64Counter("http.server.cache",
+		metric.WithDescription("Total number of response cache lookups, by result"))
+	errs = errors.Join(errs, err)
+	sm.hostRejected, err = m.Int64Counter("http.server.host.rejected",
+		metric.WithDescription("Total number of requests rejected for their Host header"))
+	errs = errors.Join(errs, err)
+	sm.slow, err = m.Int64Counter("http.server.slow_requests",
+		metric.WithDescription("Total number of requests slower than their slow request threshold"))
+	errs = errors.Join(errs, err)
+	sm.logLevel, err = m.Int64Gauge("telemetry.log.level",
+		metric.WithDescription("Level of the default logger, as a slog.Level: -4 debug, 0 info, 4 warn, 8 error"))
+	errs = errors.Join(errs, err)
+	sm.sampleRatio, err = m.Float64Gauge("telemetry.trace.sample_ratio",
+		metric.WithDescription("Fraction of new traces sampled"))
+	errs = errors.Join(errs, err)
+	sm.middleware, err = m.Float64Histogram("http.server.middleware.duration",
+		metric.WithDescription("Time HTTP middlewares take without the handlers they call, by middleware"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(middlewareBuckets...))
+	errs = errors.Join(errs, err)
+	sm.apdex, err = m.Int64Counter("http.server.apdex",
+		metric.WithDescription("Total number of requests by route and Apdex level: satisfied, tolerating or frustrated"))
+	errs = errors.Join(errs, err)
+	sm.sliGood, err = m.Int64Counter("http.server.availability.good",
+		metric.WithDescription("Total number of requests served without a server error within their deadline, by route"))
+	errs = errors.Join(errs, err)
+	sm.sliTotal, err = m.Int64Counter("http.server.availability.total",
+		metric.WithDescription("Total number of requests counted for availability, by route"))
+	errs = errors.Join(errs, err)
+	sm.mirror, err = m.Int64Counter("http.server.mirror",
+		metric.WithDescription("Total number of requests mirrored, by result and status of the mirror"))
+	errs = errors.Join(errs, err)
+	sm.tlsHandshake, err = m.Int64Counter("http.server.tls.handshake.errors",
+		metric.WithDescription("Total number of failed TLS handshakes, by error.type"))
+	errs = errors.Join(errs, err)
+	sm.conns, err = m.Int64UpDownCounter("http.server.connections",
+		metric.WithDescription("Number of open connections serving a request or idle, by http.connection.state"))
+	errs = errors.Join(errs, err)
+	sm.connsOpened, err = m.Int64Counter("http.server.connections.opened",
+		metric.WithDescription("Total number of connections accepted"))
+	errs = errors.Join(errs, err)
+	sm.connsClosed, err = m.Int64Counter("http.server.connections.closed",
+		metric.WithDescription("Total number of connections closed or hijacked"))
+	errs = errors.Join(errs, err)
+	sm.headerTimeouts, err = m.Int64Counter("http.server.connections.header_timeouts",
+		metric.WithDescription("Total number of connections closed for not sending a request header in time"))
+	errs = errors.Join(errs, err)
+	sm.connRequests, err = m.Int64Histogram("http.server.connection.requests",
+		metric.WithDescription("Number of requests served per connection"),
+		metric.WithExplicitBucketBoundaries(connRequestBuckets...))
+	errs = errors.Join(errs, err)
+	sm.shutdownPhase, err = m.Int64Gauge("http.server.shutdown.phase",
+		metric.WithDescription("Phase of the server: 0 serving, 1 pre-drain, 2 draining, 3 flushing telemetry"))
+	errs = errors.Join(errs, err)
+	sm.fingerprints, err = m.Int64Counter("http.server.error_fingerprints",
+		metric.WithDescription("Total number of panics and server errors, by error.fingerprint and error.type"))
+	errs = errors.Join(errs, err)
+	sm.sseStreams, err = m.Int64UpDownCounter("http.server.sse.streams",
+		metric.WithDescription("Number of open server-sent event streams"))
+	errs = errors.Join(errs, err)
+	sm.sseEvents, err = m.Int64Counter("http.server.sse.events",
+		metric.WithDescription("Total number of server-sent events sent, by sse.event"))
+	errs = errors.Join(errs, err)
+	sm.sseDuration, err = m.Float64Histogram("http.server.sse.duration",
+		metric.WithDescription("Duration of server-sent event streams, by sse.close_reason"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(streamBuckets...))
+	errs = errors.Join(errs, err)
+	sm.opDuration, err = m.Float64Histogram("operation.duration",
+		metric.WithDescription("Duration of operations traced with StartSpan"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(latencyBuckets...))
+	errs = errors.Join(errs, err)
+	sm.opErrors, err = m.Int64Counter("operation.errors",
+		metric.WithDescription("Total number of errors recorded with RecordError"))
+	errs = errors.Join(errs, err)
+
+	if errs != nil {
+		return sm, fmt.Errorf("create HTTP server instruments: %w", errs)
+	}
+	return sm, nil
+}
+
+// loadServerMetrics returns the instruments for the current global meter
+// provider, creating them the first time the provider is seen. Creation
+// errors are logged; the SDK still hands back usable instruments.
+func loadServerMetrics() *serverMetrics {
+	mp := otel.GetMeterProvider()
+	if sm := currentServerMetrics.Load(); sm != nil && sm.provider == mp {
+		return sm
+	}
+
+	sm, err := newServerMetrics(mp)
+	if err != nil {
+		slog.Error("HTTP metrics may not be recorded", "error", err)
+	}
+	currentServerMetrics.Store(sm)
+	return sm
+}
+
+// UnmatchedRoute is the http.route value recorded for requests that did not
+// match any registered route.
+const UnmatchedRoute = "unmatched"
+
+// RouteResolver returns the route template (e.g. /bookings/{id}) that served
+// a request. It is called after the handler has run.
+type RouteResolver func(r *http.Request) string
+
+// PatternRoute resolves the route from the pattern set by http.ServeMux,
+// falling back to UnmatchedRoute.
+func PatternRoute(r *http.Request) string {
+	if r.Pattern != "" {
+		return routeFromPattern(r.Pattern)
+	}
+	if st := stateFromContext(r.Context()); st != nil {
+		if route := st.matchedRoute(); route != "" {
+			return route
+		}
+	}
+	return UnmatchedRoute
+}
+
+// OtherMethod is the http.method value recorded in metrics for requests
+// with a method net/http doesn't define, and the http.request.content_type
+// one for those of a media type it doesn't know.
+const OtherMethod = "_OTHER"
+
+// metricMethod returns method, or OtherMethod for the made-up ones scanners
+// send, each of which would be a new series.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return OtherMethod
+}
+
+// knownMediaTypes are the request media types recorded as such in
+// http.request.content_type.
+var knownMediaTypes = map[string]bool{
+	"application/json":                  true,
+	"application/problem+json":          true,
+	"application/x-ndjson":              true,
+	"application/msgpack":               true,
+	"application/xml":                   true,
+	"text/xml":                          true,
+	"application/x-www-form-urlencoded": true,
+	"multipart/form-data":               true,
+	"application/octet-stream":          true,
+	"text/plain":                        true,
+	"text/csv":                          true,
+}
+
+// metricContentType returns the media type of a Content-Type header, or
+// OtherMethod for those clients make up.
+func metricContentType(contentType string) string {
+	switch mt := mediaType(contentType); {
+	case mt == "" || mt == "invalid" || knownMediaTypes[mt]:
+		return mt
+	default:
+		return OtherMethod
+	}
+}
+
+// protocolVersion returns the network.protocol.version of r, such as 1.1
+// or 2.
+func protocolVersion(r *http.Request) string {
+	if r.ProtoMinor == 0 && r.ProtoMajor >= 2 {
+		return strconv.Itoa(r.ProtoMajor)
+	}
+	return strconv.Itoa(r.ProtoMajor) + "." + strconv.Itoa(r.ProtoMinor)
+}
+
+// route returns the http.route recorded in metrics: requests Router found
+// no route for are UnmatchedRoute, rather than NotFoundRoute as on their
+// span, and those it answered with a 405 are recorded under the route
+// their path has for other methods.
+func (cfg *metricsConfig) route(r *http.Request, st *requestState) string {
+	switch route := cfg.resolveRoute(r); route {
+	case NotFoundRoute:
+		return UnmatchedRoute
+	case MethodNotAllowedRoute:
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		if st.allowedRoute != "" {
+			return st.allowedRoute
+		}
+		return UnmatchedRoute
+	default:
+		return route
+	}
+}
+
+// MuxRoute resolves routes by asking mux which pattern matches the request.
+// Unlike PatternRoute it knows the route before the handler runs, which the
+// active requests gauge needs when the middleware wraps the mux.
+func MuxRoute(mux *http.ServeMux) RouteResolver {
+	return func(r *http.Request) string {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			return routeFromPattern(pattern)
+		}
+		return UnmatchedRoute
+	}
+}
+
+// StatusClientClosedRequest is the status recorded, after nginx's 499, for
+// requests whose client went away before the handler was done.
+const StatusClientClosedRequest = 499
+
+// clientCanceled reports whether r's client disconnected, canceling its
+// context, before the response was complete.
+func clientCanceled(r *http.Request) bool {
+	return errors.Is(r.Context().Err(), context.Canceled)
+}
+
+// responseCanceled is clientCanceled for requests whose response sw
+// captured, true only if the disconnect broke the response off: a response
+// written in full before the client went away keeps its status.
+func responseCanceled(r *http.Request, sw *statusCapturingWriter) bool {
+	return clientCanceled(r) && sw.brokenOff()
+}
+
+// queueTime returns how long before start the load balancer received the
+// request, according to the X-Request-Start or X-Queue-Start header. The
+// timestamp is in seconds, milliseconds or microseconds since the epoch,
+// optionally prefixed with "t=". Timestamps in the future, from clock skew,
+// count as no queueing; those older than limit are ignored.
+func queueTime(h http.Header, start time.Time, limit time.Duration) (time.Duration, bool) {
+	v := h.Get("X-Request-Start")
+	if v == "" {
+		v = h.Get("X-Queue-Start")
+	}
+	f, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(v), "t="), 64)
+	if err != nil || f <= 0 {
+		return 0, false
+	}
+	// The units are told apart by magnitude: 1e11 seconds is thousands of
+	// years away.
+	switch {
+	case f >= 1e14:
+		f /= 1e6
+	case f >= 1e11:
+		f /= 1e3
+	}
+	sec, frac := math.Modf(f)
+	queued := start.Sub(time.Unix(int64(sec), int64(frac*1e9)))
+	if queued > limit {
+		return 0, false
+	}
+	return max(queued, 0), true
+}
+
+// MetricsOption configures NewMetricsMiddleware.
+type MetricsOption func(*metricsConfig)
+
+type metricsConfig struct {
+	resolveRoute RouteResolver
+	ignore       []func(*http.Request) bool
+	extraAttrs   []func(*http.Request) []attribute.KeyValue
+	allowAttrs   []attribute.Key
+	valueLimit   int
+	guard        *attributeGuard
+	canceledErrs bool
+	maxQueueTime time.Duration
+	telemetry    *Telemetry
+	apdex        time.Duration
+	apdexRoutes  *Router
+	clientRules  []ClientRule
+	clients      *clientClassifier
+}
+
+// WithRouteResolver sets how the http.route attribute is derived. Defaults to
+// PatternRoute. Never return raw paths: every distinct value is a new series.
+func WithRouteResolver(resolve RouteResolver) MetricsOption {
+	return func(c *metricsConfig) { c.resolveRoute = resolve }
 }
 
-type statusCapturingWriter struct {
-	http.ResponseWriter
-	status int
+// WithIgnoredPaths records nothing for requests to the given exact paths,
+// such as load balancer health checks.
+func WithIgnoredPaths(paths ...string) MetricsOption {
+	ignored := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		ignored[p] = true
+	}
+	return WithRequestFilter(func(r *http.Request) bool { return ignored[r.URL.Path] })
 }
 
-func (w *statusCapturingWriter) WriteHeader(code int) {
-	w.status = code
-	w.ResponseWriter.WriteHeader(code)
+// WithRequestFilter records nothing for requests for which skip returns true.
+// It is called before the handler runs and may be given more than once.
+func WithRequestFilter(skip func(r *http.Request) bool) MetricsOption {
+	return func(c *metricsConfig) { c.ignore = append(c.ignore, skip) }
 }
 
+// WithMetricAttributes adds the attributes returned by attrs, called after
+// the handler, to the request metrics. It may be given more than once.
+func WithMetricAttributes(attrs func(r *http.Request) []attribute.KeyValue) MetricsOption {
+	return func(c *metricsConfig) { c.extraAttrs = append(c.extraAttrs, attrs) }
+}
+
+// WithAttributeAllowlist drops every metric attribute whose key is not
+// listed, including the default ones.
+func WithAttributeAllowlist(keys ...attribute.Key) MetricsOption {
+	return func(c *metricsConfig) { c.allowAttrs = append(c.allowAttrs, keys...) }
+}
+
+// WithAttributeValueLimit caps the distinct values recorded per attribute
+// key at n. Later values are recorded as OverflowValue, each counted once
+// in http.server.attribute.overflow.
+func WithAttributeValueLimit(n int) MetricsOption {
+	return func(c *metricsConfig) { c.valueLimit = n }
+}
+
+// WithCanceledAsErrors counts requests canceled by their client in
+// http.server.errors, which leaves them out by default.
+func WithCanceledAsErrors() MetricsOption {
+	return func(c *metricsConfig) { c.canceledErrs = true }
+}
+
+// WithMaxQueueTime ignores X-Request-Start timestamps more than d old, which
+// are bogus rather than queueing. Defaults to one minute.
+func WithMaxQueueTime(d time.Duration) MetricsOption {
+	return func(c *metricsConfig) { c.maxQueueTime = d }
+}
+
+// WithMetricsTelemetry records through the meter provider of t instead of
+// the global one.
+func WithMetricsTelemetry(t *Telemetry) MetricsOption {
+	return func(c *metricsConfig) { c.telemetry = t }
+}
+
+// MetricsMiddleware records request metrics using the default options.
 func MetricsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		sw := &statusCapturingWriter{ResponseWriter: w, status: http.StatusOK}
+	return NewMetricsMiddleware()(next)
+}
 
-		next.ServeHTTP(sw, r)
+// NewMetricsMiddleware returns a middleware recording request count, error
+// count, latency, time to first byte and request/response sizes per method,
+// route, status and network.protocol.version (1.1, 2). Time to first byte
+// is what matters for streaming endpoints, whose duration is that of the
+// connection. Request sizes are additionally broken down by content type.
+// Requests whose client disconnected are recorded with
+// StatusClientClosedRequest, whatever the handler answered the closed
+// connection. Event streams opened with SSE are the exception: they end that
+// way, and their latency is their time to first byte.
+//
+// Failed requests carry the error.type and error.class WriteError derived
+// from the handler's error, or those the handler set with SetErrorClass.
+//
+// The time requests spent queued before reaching the server, from the
+// X-Request-Start or X-Queue-Start header set by the load balancer, is
+// recorded in http.server.queue_time and on the span.
+func NewMetricsMiddleware(opts ...MetricsOption) func(http.Handler) http.Handler {
+	cfg := metricsConfig{resolveRoute: PatternRoute, maxQueueTime: time.Minute}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.allowAttrs != nil || cfg.valueLimit > 0 {
+		cfg.guard = newAttributeGuard(cfg.allowAttrs, cfg.valueLimit)
+		cfg.guard.telemetry = cfg.telemetry
+	}
+	if cfg.clientRules != nil {
+		clients, err := newClientClassifier(cfg.clientRules)
+		if err != nil {
+			panic(fmt.Sprintf("httpx: %v", err))
+		}
+		cfg.clients = clients
+	}
 
-		attrs := []attribute.KeyValue{
-			attribute.String("http.method", r.Method),
-			attribute.String("http.route", r.URL.Path),
-			attribute.Int("http.status_code", sw.status),
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg.serveHTTP(next, w, r)
+		})
+	}
+}
+
+func (cfg *metricsConfig) serveHTTP(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	for _, skip := range cfg.ignore {
+		if skip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	start := time.Now()
+	sw, w := captureStatus(w)
+	sw.watchContext(r.Context())
+	r, st := withRequestState(r)
+	sm := cfg.telemetry.serverMetrics()
+
+	// The route is resolved up front so the decrement matches the increment.
+	// With PatternRoute that only works when the middleware is registered on
+	// the mux; wrapping a whole mux needs MuxRoute.
+	activeAttrs := metric.WithAttributes(cfg.guard.filter(r.Context(), []attribute.KeyValue{
+		attribute.String("http.method", metricMethod(r.Method)),
+		attribute.String("http.route", cfg.route(r, st)),
+	})...)
+	sm.active.Add(r.Context(), 1, activeAttrs)
+	defer sm.active.Add(r.Context(), -1, activeAttrs)
+
+	if queued, ok := queueTime(r.Header, start, cfg.maxQueueTime); ok {
+		sm.queueTime.Record(r.Context(), queued.Seconds(), activeAttrs)
+		if span := trace.SpanFromContext(r.Context()); span.IsRecording() {
+			span.SetAttributes(attribute.Float64("http.server.queue_time", queued.Seconds()))
+		}
+	}
+
+	var body *countingBody
+	if r.Body != nil {
+		body = &countingBody{ReadCloser: r.Body}
+		r.Body = body
+	}
+
+	st.mu.Lock()
+	st.metricsDepth++
+	st.mu.Unlock()
+	defer func() {
+		st.mu.Lock()
+		st.metricsDepth--
+		st.mu.Unlock()
+	}()
+
+	// A panicking handler is still recorded, as a 500 unless a status was
+	// already sent, before the panic continues to the recovery middleware.
+	completed := false
+	defer func() {
+		if completed {
+			return
+		}
+		// A nil v is runtime.Goexit unwinding the handler, not a panic.
+		v := recover()
+		if v != nil && v != http.ErrAbortHandler {
+			st.notePanic(v)
+		}
+		cfg.record(r, st, sw, sm, start, body)
+		if v != nil {
+			panic(v)
+		}
+	}()
+
+	next.ServeHTTP(w, r)
+	completed = true
+	cfg.record(r, st, sw, sm, start, body)
+}
+
+func (cfg *metricsConfig) record(r *http.Request, st *requestState, sw *statusCapturingWriter, sm *serverMetrics, start time.Time, body *countingBody) {
+	st.notePattern(r)
+
+	_, _, panicked := st.panicInfo()
+	status := sw.status
+	if panicked && !sw.wroteHeader {
+		status = http.StatusInternalServerError
+	}
+	st.mu.Lock()
+	streaming := st.streaming
+	st.mu.Unlock()
+	// Event streams end when their client goes away; that is no cancellation.
+	canceled := !panicked && !sw.hijacked && !streaming && responseCanceled(r, sw)
+	if canceled {
+		status = StatusClientClosedRequest
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("http.method", metricMethod(r.Method)),
+		attribute.String("http.route", cfg.route(r, st)),
+		attribute.Int("http.status_code", status),
+		semconv.NetworkProtocolVersion(protocolVersion(r)),
+	}
+	if status == http.StatusMethodNotAllowed {
+		attrs = append(attrs, attribute.Bool("http.method_not_allowed", true))
+	}
+	if sw.hijacked {
+		attrs = append(attrs, attribute.Bool("http.hijacked", true))
+	}
+	st.mu.Lock()
+	timedOut, propagated, preflight, errorType := st.timedOut, st.deadlinePropagated, st.preflight, st.errorType
+	authResult := st.authResult
+	st.mu.Unlock()
+	if timedOut {
+		attrs = append(attrs, attribute.Bool("http.timeout", true))
+	}
+	if propagated {
+		attrs = append(attrs, attribute.Bool("http.deadline_propagated", true))
+	}
+	if authResult != "" {
+		attrs = append(attrs, attribute.String("auth.result", authResult))
+	}
+	if cfg.clients != nil {
+		kind := attribute.String("client.kind", cfg.clients.classify(r.UserAgent()))
+		attrs = append(attrs, kind)
+		if span := trace.SpanFromContext(r.Context()); span.IsRecording() {
+			span.SetAttributes(kind)
+		}
+	}
+	if panicked {
+		attrs = append(attrs, semconv.ErrorTypeKey.String("panic"))
+		st.mu.Lock()
+		st.panicCounted = true
+		st.mu.Unlock()
+	} else if errorType != "" {
+		attrs = append(attrs, semconv.ErrorTypeKey.String(errorType))
+	}
+	if class, ok := st.errorClassFor(panicked); ok {
+		attrs = append(attrs, attribute.String("error.class", string(class)))
+	}
+	for _, extra := range cfg.extraAttrs {
+		attrs = append(attrs, extra(r)...)
+	}
+	attrs = cfg.guard.filter(r.Context(), attrs)
+
+	sm.requests.Add(r.Context(), 1, metric.WithAttributes(attrs...))
+	elapsed := time.Since(start)
+	// An event stream lasts as long as its client listens, so its latency is
+	// how long it took to open.
+	latency := elapsed
+	if ttfb, ok := sw.timeToFirstByte(start); ok && streaming {
+		latency = ttfb
+	}
+	if !canceled {
+		cfg.recordSLI(r, st, sm, status, latency, timedOut, sw.hijacked)
+	}
+	// A hijacked connection lives as long as the protocol it was upgraded to,
+	// so its duration says nothing about request latency.
+	if !sw.hijacked {
+		sm.duration.Record(r.Context(), latency.Seconds(), metric.WithAttributes(attrs...))
+		sm.durationMs.Record(r.Context(), float64(latency)/float64(time.Millisecond), metric.WithAttributes(attrs...))
+		// Handlers that never wrote leave the response to net/http once they
+		// return, so their first byte goes out after the whole duration.
+		if ttfb, ok := sw.timeToFirstByte(start); ok {
+			sm.ttfb.Record(r.Context(), ttfb.Seconds(), metric.WithAttributes(attrs...))
+		} else {
+			emptyAttrs := append(slices.Clip(attrs), cfg.guard.filter(r.Context(), []attribute.KeyValue{
+				attribute.Bool("http.response.empty", true),
+			})...)
+			sm.ttfb.Record(r.Context(), elapsed.Seconds(), metric.WithAttributes(emptyAttrs...))
+		}
+		st.mu.Lock()
+		encoding := st.encoding
+		st.mu.Unlock()
+		encodingAttrs := []attribute.KeyValue{attribute.Bool("http.response.compressed", encoding != "")}
+		if encoding != "" {
+			encodingAttrs = append(encodingAttrs, attribute.String("http.response.content_encoding", encoding))
 		}
+		respAttrs := append(slices.Clip(attrs), cfg.guard.filter(r.Context(), encodingAttrs)...)
+		sm.respSize.Record(r.Context(), sw.written, metric.WithAttributes(respAttrs...))
 
-		reqCounter.Add(r.Context(), 1, metric.WithAttributes(attrs...))
-		latencyHistogram.Record(r.Context(), float64(time.Since(start).Milliseconds()), metric.WithAttributes(attrs...))
-		if sw.status >= 400 {
-			errCounter.Add(r.Context(), 1, metric.WithAttributes(attrs...))
+		var reqSize int64
+		if body != nil {
+			reqSize = body.size(r)
 		}
-	})
+		sizeAttrs := append(slices.Clip(attrs), cfg.guard.filter(r.Context(), []attribute.KeyValue{
+			attribute.String("http.request.content_type", metricContentType(r.Header.Get("Content-Type"))),
+		})...)
+		sm.reqSize.Record(r.Context(), reqSize, metric.WithAttributes(sizeAttrs...))
+	}
+	if (status >= 400 && !preflight && (!canceled || cfg.canceledErrs)) || panicked {
+		sm.errors.Add(r.Context(), 1, metric.WithAttributes(attrs...))
+	}
 }
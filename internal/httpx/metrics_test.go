@@ -0,0 +1,622 @@
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// collectMetric collects from reader and returns the named metric.
+func collectMetric(t *testing.T, reader sdkmetric.Reader, name string) (metricdata.Metrics, bool) {
+	t.Helper()
+	return otelt.MetricFrom(t, reader, name)
+}
+
+// collectSum returns the data points of the named int64 counter.
+func collectSum(t *testing.T, reader sdkmetric.Reader, name string) []metricdata.DataPoint[int64] {
+	t.Helper()
+
+	m, ok := collectMetric(t, reader, name)
+	if !ok {
+		return nil
+	}
+	return m.Data.(metricdata.Sum[int64]).DataPoints
+}
+
+// collectIntHistogram returns the data points of the named int64 histogram.
+func collectIntHistogram(t *testing.T, reader sdkmetric.Reader, name string) []metricdata.HistogramDataPoint[int64] {
+	t.Helper()
+
+	m, ok := collectMetric(t, reader, name)
+	if !ok {
+		return nil
+	}
+	return m.Data.(metricdata.Histogram[int64]).DataPoints
+}
+
+// collectHistogram returns the data points of the named float64 histogram.
+func collectHistogram(t *testing.T, reader sdkmetric.Reader, name string) []metricdata.HistogramDataPoint[float64] {
+	t.Helper()
+
+	m, ok := collectMetric(t, reader, name)
+	if !ok {
+		return nil
+	}
+	return m.Data.(metricdata.Histogram[float64]).DataPoints
+}
+
+func TestMetricsMiddleware_UsesRouteTemplate(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /bookings/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	handler := MetricsMiddleware(mux)
+
+	for _, path := range []string{"/bookings/8f3a", "/bookings/91bc", "/wp-admin.php", "/.env"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	routes := map[string]int64{}
+	for _, dp := range collectSum(t, reader, "http.server.requests") {
+		route, _ := dp.Attributes.Value(attribute.Key("http.route"))
+		routes[route.AsString()] += dp.Value
+	}
+
+	want := map[string]int64{"/bookings/{id}": 2, UnmatchedRoute: 2}
+	if len(routes) != len(want) {
+		t.Fatalf("route series mismatch: got %v, want %v", routes, want)
+	}
+	for route, n := range want {
+		if routes[route] != n {
+			t.Errorf("requests for %q: got %d, want %d", route, routes[route], n)
+		}
+	}
+}
+
+func TestMetricsMiddleware_RouteResolver(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	handler := NewMetricsMiddleware(WithRouteResolver(func(r *http.Request) string {
+		return "/custom"
+	}))(http.NotFoundHandler())
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/anything/123", nil))
+
+	dps := collectSum(t, reader, "http.server.requests")
+	if len(dps) != 1 {
+		t.Fatalf("expected 1 series, got %d", len(dps))
+	}
+	if route, _ := dps[0].Attributes.Value("http.route"); route.AsString() != "/custom" {
+		t.Errorf("http.route mismatch: got %q, want %q", route.AsString(), "/custom")
+	}
+}
+
+func TestMetricsMiddleware_IgnoredRequests(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	handler := NewMetricsMiddleware(
+		WithIgnoredPaths("/healthz", "/metrics"),
+		WithRequestFilter(func(r *http.Request) bool { return r.Method == http.MethodOptions }),
+	)(http.NotFoundHandler())
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/healthz", nil),
+		httptest.NewRequest(http.MethodGet, "/metrics", nil),
+		httptest.NewRequest(http.MethodOptions, "/trips", nil),
+		httptest.NewRequest(http.MethodGet, "/trips", nil),
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	dps := collectSum(t, reader, "http.server.requests")
+	if len(dps) != 1 || dps[0].Value != 1 {
+		t.Fatalf("data points mismatch: got %+v, want a single point with value 1", dps)
+	}
+	if method, _ := dps[0].Attributes.Value("http.method"); method.AsString() != http.MethodGet {
+		t.Errorf("http.method mismatch: got %q, want %q", method.AsString(), http.MethodGet)
+	}
+	if hs := collectHistogram(t, reader, "http.server.request.duration"); len(hs) != 1 || hs[0].Count != 1 {
+		t.Errorf("duration data points mismatch: got %+v, want a single recorded request", hs)
+	}
+}
+
+func TestInitTelemetry_MetricsMiddlewareRecords(t *testing.T) {
+	ctx := context.Background()
+	prevMP, prevTP := otel.GetMeterProvider(), otel.GetTracerProvider()
+	t.Cleanup(func() {
+		otel.SetMeterProvider(prevMP)
+		otel.SetTracerProvider(prevTP)
+	})
+
+	reader := sdkmetric.NewManualReader()
+	shutdown, err := InitTelemetry(ctx, "acai-test", WithMetricReader(reader))
+	if err != nil {
+		t.Fatalf("InitTelemetry() unexpected error: %v", err)
+	}
+	defer func() { _ = shutdown(ctx) }()
+
+	handler := MetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	for _, name := range []string{"http.server.requests", "http.server.errors"} {
+		dps := collectSum(t, reader, name)
+		if len(dps) != 1 || dps[0].Value != 1 {
+			t.Errorf("%s data points mismatch: got %+v, want a single point with value 1", name, dps)
+		}
+	}
+}
+
+func TestMetricsMiddleware_SubMillisecondLatency(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	handler := MetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(250 * time.Microsecond)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	tests := []struct {
+		name string
+		min  float64
+		max  float64
+	}{
+		{name: "http.server.request.duration", min: 0.00025, max: 1},
+		{name: "http.server.duration.ms", min: 0.25, max: 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dps := collectHistogram(t, reader, tt.name)
+			if len(dps) != 1 || dps[0].Count != 1 {
+				t.Fatalf("expected a single recorded data point, got %+v", dps)
+			}
+			if dps[0].Sum < tt.min || dps[0].Sum > tt.max {
+				t.Errorf("duration mismatch: got %v, want between %v and %v", dps[0].Sum, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestMetricsMiddleware_ResponseSize(t *testing.T) {
+	const partial = "partial"
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    int64
+	}{
+		{
+			name:    "no body",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) },
+			want:    0,
+		},
+		{
+			name: "multiple writes",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("hello "))
+				_, _ = w.Write([]byte("world"))
+			},
+			want: 11,
+		},
+		{
+			name: "panic after partial write",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(partial))
+				panic("boom")
+			},
+			want: int64(len(partial)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLogs(t)
+			reader := otelt.InstallMetrics(t)
+
+			handler := MetricsMiddleware(Recovery()(tt.handler))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			dps := collectIntHistogram(t, reader, "http.server.response.size")
+			if len(dps) != 1 {
+				t.Fatalf("expected 1 series, got %d", len(dps))
+			}
+			if dps[0].Count != 1 || dps[0].Sum != tt.want {
+				t.Errorf("response size mismatch: got count=%d sum=%d, want count=1 sum=%d", dps[0].Count, dps[0].Sum, tt.want)
+			}
+		})
+	}
+}
+
+// lyingBody reports fewer bytes than the request declares.
+type lyingBody struct{ io.Reader }
+
+func (lyingBody) Close() error { return nil }
+
+func TestMetricsMiddleware_RequestSize(t *testing.T) {
+	readAll := func(w http.ResponseWriter, r *http.Request) { _, _ = io.ReadAll(r.Body) }
+	ignore := func(w http.ResponseWriter, r *http.Request) {}
+
+	tests := []struct {
+		name            string
+		body            io.Reader
+		contentLength   int64
+		contentType     string
+		handler         http.HandlerFunc
+		wantSize        int64
+		wantContentType string
+	}{
+		{
+			name:     "empty body",
+			handler:  readAll,
+			wantSize: 0,
+		},
+		{
+			name:            "known length read by handler",
+			body:            strings.NewReader(`{"destination":"BCN"}`),
+			contentLength:   21,
+			contentType:     "application/json; charset=utf-8",
+			handler:         readAll,
+			wantSize:        21,
+			wantContentType: "application/json",
+		},
+		{
+			name:            "chunked body read by handler",
+			body:            strings.NewReader("0123456789"),
+			contentLength:   -1,
+			contentType:     "Multipart/Form-Data; boundary=x",
+			handler:         readAll,
+			wantSize:        10,
+			wantContentType: "multipart/form-data",
+		},
+		{
+			name:          "chunked body never read",
+			body:          strings.NewReader("0123456789"),
+			contentLength: -1,
+			handler:       ignore,
+			wantSize:      10,
+		},
+		{
+			name:            "content length larger than body",
+			body:            io.LimitReader(strings.NewReader("0123456789"), 4),
+			contentLength:   100,
+			contentType:     "not a media type;;",
+			handler:         readAll,
+			wantSize:        4,
+			wantContentType: "invalid",
+		},
+		{
+			name:            "made-up content type",
+			body:            strings.NewReader("0123456789"),
+			contentLength:   10,
+			contentType:     "application/x-scanner-42",
+			handler:         readAll,
+			wantSize:        10,
+			wantContentType: OtherMethod,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := otelt.InstallMetrics(t)
+
+			req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+			if tt.body != nil {
+				req.Body = lyingBody{tt.body}
+				req.ContentLength = tt.contentLength
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			MetricsMiddleware(tt.handler).ServeHTTP(httptest.NewRecorder(), req)
+
+			dps := collectIntHistogram(t, reader, "http.server.request.size")
+			if len(dps) != 1 {
+				t.Fatalf("expected 1 series, got %d", len(dps))
+			}
+			if dps[0].Sum != tt.wantSize {
+				t.Errorf("request size mismatch: got %d, want %d", dps[0].Sum, tt.wantSize)
+			}
+			if ct, _ := dps[0].Attributes.Value("http.request.content_type"); ct.AsString() != tt.wantContentType {
+				t.Errorf("content type mismatch: got %q, want %q", ct.AsString(), tt.wantContentType)
+			}
+		})
+	}
+}
+
+func TestMetricsMiddleware_ActiveRequests(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+	const n = 5
+
+	activeFor := func(route string) int64 {
+		var total int64
+		for _, dp := range collectSum(t, reader, "http.server.active_requests") {
+			if v, _ := dp.Attributes.Value("http.route"); v.AsString() == route {
+				total += dp.Value
+			}
+		}
+		return total
+	}
+
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(n)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /slow/{id}", func(w http.ResponseWriter, r *http.Request) {
+		started.Done()
+		<-release
+	})
+	mux.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	handler := NewMetricsMiddleware(WithRouteResolver(MuxRoute(mux)))(mux)
+
+	var done sync.WaitGroup
+	for i := range n {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, fmt.Sprintf("/slow/%d", i), nil))
+		}()
+	}
+	started.Wait()
+
+	if got := activeFor("/slow/{id}"); got != n {
+		t.Errorf("active requests while in flight: got %d, want %d", got, n)
+	}
+
+	close(release)
+	done.Wait()
+	if got := activeFor("/slow/{id}"); got != 0 {
+		t.Errorf("active requests after completion: got %d, want 0", got)
+	}
+
+	func() {
+		defer func() { _ = recover() }()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+	}()
+	if got := activeFor("/panic"); got != 0 {
+		t.Errorf("active requests after panic: got %d, want 0", got)
+	}
+}
+
+func TestMetricsMiddleware_ClientCanceled(t *testing.T) {
+	reader, exporter := otelt.Install(t)
+
+	started, finished := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /search", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		WriteError(w, r, fmt.Errorf("search flights: %w", r.Context().Err()))
+	})
+	handler := TracingMiddleware(MetricsMiddleware(mux))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(finished)
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/search", nil)
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, err := srv.Client().Do(req); err == nil {
+		t.Fatal("request succeeded, want it canceled")
+	}
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler still running after the client went away")
+	}
+
+	var requests []attribute.Set
+	for _, dp := range collectSum(t, reader, "http.server.requests") {
+		requests = append(requests, dp.Attributes)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("got %d request series, want 1", len(requests))
+	}
+	if status, _ := requests[0].Value("http.status_code"); status.AsInt64() != StatusClientClosedRequest {
+		t.Errorf("http.status_code = %d, want %d", status.AsInt64(), StatusClientClosedRequest)
+	}
+	if typ, _ := requests[0].Value("error.type"); typ.AsString() != "canceled" {
+		t.Errorf("error.type = %q, want canceled", typ.AsString())
+	}
+	if dps := collectSum(t, reader, "http.server.errors"); len(dps) != 0 {
+		t.Errorf("canceled request counted as an error: %v", dps)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Status.Code != codes.Unset {
+		t.Errorf("span status = %v, want unset", span.Status.Code)
+	}
+	if !slices.ContainsFunc(span.Events, func(e sdktrace.Event) bool { return e.Name == "http.request.canceled" }) {
+		t.Errorf("span events = %v, want http.request.canceled", span.Events)
+	}
+}
+
+func TestMetricsMiddleware_CanceledAsErrors(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	handler := NewMetricsMiddleware(WithCanceledAsErrors())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+	dps := collectSum(t, reader, "http.server.errors")
+	if len(dps) != 1 {
+		t.Fatalf("got %d error series, want 1", len(dps))
+	}
+	if status, _ := dps[0].Attributes.Value("http.status_code"); status.AsInt64() != StatusClientClosedRequest {
+		t.Errorf("http.status_code = %d, want %d", status.AsInt64(), StatusClientClosedRequest)
+	}
+}
+
+func TestMetricsMiddleware_CanceledAfterResponse(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := MetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"trips":[]}`)
+		// The client got its answer and hung up before the handler returned.
+		cancel()
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/trips", nil).WithContext(ctx))
+
+	otelt.RequireCounterValue(t, "http.server.requests", []attribute.KeyValue{
+		attribute.Int("http.status_code", http.StatusOK),
+	}, 1)
+	otelt.RequireCounterValue(t, "http.server.requests", []attribute.KeyValue{
+		attribute.Int("http.status_code", StatusClientClosedRequest),
+	}, 0)
+	if dps := collectSum(t, reader, "http.server.errors"); len(dps) != 0 {
+		t.Errorf("answered request counted as an error: %v", dps)
+	}
+}
+
+func TestMetricsMiddleware_TimeToFirstByte(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		_, _ = io.WriteString(w, "data: ping\n\n")
+	})
+	mux.HandleFunc("GET /silent", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	})
+	handler := MetricsMiddleware(mux)
+	for _, path := range []string{"/events", "/silent"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	ttfb := map[string]metricdata.HistogramDataPoint[float64]{}
+	for _, dp := range collectHistogram(t, reader, "http.server.time_to_first_byte") {
+		route, _ := dp.Attributes.Value("http.route")
+		ttfb[route.AsString()] = dp
+	}
+	duration := map[string]float64{}
+	for _, dp := range collectHistogram(t, reader, "http.server.request.duration") {
+		route, _ := dp.Attributes.Value("http.route")
+		duration[route.AsString()] = dp.Sum
+	}
+
+	events := ttfb["/events"]
+	if events.Count != 1 {
+		t.Fatalf("got %d first byte samples for /events, want 1", events.Count)
+	}
+	if events.Sum >= 0.05 || events.Sum >= duration["/events"] {
+		t.Errorf("/events first byte after %vs, want before the 50ms pause (duration %vs)", events.Sum, duration["/events"])
+	}
+	if _, ok := events.Attributes.Value("http.response.empty"); ok {
+		t.Error("/events marked as an empty response")
+	}
+
+	silent := ttfb["/silent"]
+	if silent.Count != 1 {
+		t.Fatalf("got %d first byte samples for /silent, want 1", silent.Count)
+	}
+	if silent.Sum != duration["/silent"] {
+		t.Errorf("/silent first byte after %vs, want the duration %vs", silent.Sum, duration["/silent"])
+	}
+	if empty, _ := silent.Attributes.Value("http.response.empty"); !empty.AsBool() {
+		t.Error("/silent not marked as an empty response")
+	}
+}
+
+func TestQueueTime(t *testing.T) {
+	start := time.Unix(1700000000, 500_000_000)
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "seconds", header: "X-Request-Start", value: "t=1700000000.250", want: 250 * time.Millisecond, wantOK: true},
+		{name: "seconds without prefix", header: "X-Request-Start", value: "1700000000", want: 500 * time.Millisecond, wantOK: true},
+		{name: "milliseconds", header: "X-Request-Start", value: "t=1700000000400", want: 100 * time.Millisecond, wantOK: true},
+		{name: "microseconds", header: "X-Request-Start", value: "1700000000499000", want: time.Millisecond, wantOK: true},
+		{name: "queue start", header: "X-Queue-Start", value: "t=1700000000450", want: 50 * time.Millisecond, wantOK: true},
+		{name: "clock skew", header: "X-Request-Start", value: "t=1700000001000", want: 0, wantOK: true},
+		{name: "too old", header: "X-Request-Start", value: "t=1699999000", wantOK: false},
+		{name: "garbage", header: "X-Request-Start", value: "t=soon", wantOK: false},
+		{name: "absent", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.header != "" {
+				h.Set(tt.header, tt.value)
+			}
+			got, ok := queueTime(h, start, time.Minute)
+			if ok != tt.wantOK || (ok && (got-tt.want).Abs() > time.Microsecond) {
+				t.Errorf("queueTime() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestMetricsMiddleware_QueueTime(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+	otelt.InstallTracing(t)
+
+	handler := Chain(TracingMiddleware, MetricsMiddleware)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, header := range []string{
+		fmt.Sprintf("t=%d", time.Now().Add(-200*time.Millisecond).UnixMilli()),
+		fmt.Sprintf("t=%d", time.Now().Add(time.Hour).UnixMilli()),
+		"",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set("X-Request-Start", header)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	dps := collectHistogram(t, reader, "http.server.queue_time")
+	if len(dps) != 1 || dps[0].Count != 2 {
+		t.Fatalf("got %+v, want two queue time samples", dps)
+	}
+	if dps[0].Sum < 0.2 || dps[0].Sum > 1 {
+		t.Errorf("queue time sum = %vs, want 0.2s and a clamped 0s", dps[0].Sum)
+	}
+
+	var queued []float64
+	for _, span := range otelt.Spans(t) {
+		for _, kv := range span.Attributes() {
+			if kv.Key == "http.server.queue_time" {
+				queued = append(queued, kv.Value.AsFloat64())
+			}
+		}
+	}
+	if len(queued) != 2 {
+		t.Errorf("queue time on %d spans, want 2", len(queued))
+	}
+}
@@ -0,0 +1,186 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// MetricsSnapshot collects the current metric values on demand, for
+// debugging where the metrics backend is out of reach. Give it to both
+// InitTelemetry, with WithMetricsSnapshot, and the admin server, with
+// WithAdminMetricsSnapshot.
+//
+// It reads through a reader of its own next to the exporting one: the
+// exporter still gets every measurement once, and collecting a snapshot
+// exports nothing.
+type MetricsSnapshot struct {
+	reader *sdkmetric.ManualReader
+}
+
+// NewMetricsSnapshot returns a MetricsSnapshot, to be registered with a
+// single meter provider.
+func NewMetricsSnapshot() *MetricsSnapshot {
+	return &MetricsSnapshot{reader: sdkmetric.NewManualReader()}
+}
+
+// WithMetricsSnapshot collects metrics for s in addition to exporting them.
+func WithMetricsSnapshot(s *MetricsSnapshot) TelemetryOption {
+	return func(c *telemetryConfig) { c.snapshot = s }
+}
+
+// snapshotMetric is a metric of the JSON snapshot. Values are cumulative
+// since the process started, whatever the temporality of the exporter.
+type snapshotMetric struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Unit        string          `json:"unit,omitempty"`
+	Scope       string          `json:"scope"`
+	Type        string          `json:"type"` // sum, gauge, histogram or exponential_histogram
+	Monotonic   bool            `json:"monotonic,omitempty"`
+	Points      []snapshotPoint `json:"points"`
+}
+
+type snapshotPoint struct {
+	Attributes map[string]any `json:"attributes"`
+	Value      any            `json:"value,omitempty"` // of sums and gauges
+
+	// Of histograms.
+	Count        uint64    `json:"count,omitempty"`
+	Sum          any       `json:"sum,omitempty"`
+	Min          any       `json:"min,omitempty"`
+	Max          any       `json:"max,omitempty"`
+	Bounds       []float64 `json:"bounds,omitempty"`
+	BucketCounts []uint64  `json:"bucket_counts,omitempty"`
+
+	// Of exponential histograms, whose buckets start at the offsets.
+	Scale          int32    `json:"scale,omitempty"`
+	ZeroCount      uint64   `json:"zero_count,omitempty"`
+	PositiveOffset int32    `json:"positive_offset,omitempty"`
+	PositiveCounts []uint64 `json:"positive_bucket_counts,omitempty"`
+	NegativeOffset int32    `json:"negative_offset,omitempty"`
+	NegativeCounts []uint64 `json:"negative_bucket_counts,omitempty"`
+}
+
+// Handler serves the current metrics as JSON, sorted by name. The name query
+// parameter keeps those whose name starts with it, as in
+// /debug/metrics?name=http.server.
+func (s *MetricsSnapshot) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics, err := s.collect(r.Context(), r.URL.Query().Get("name"))
+		if err != nil {
+			http.Error(w, "collect metrics: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		_ = WriteJSON(w, http.StatusOK, struct {
+			Metrics []snapshotMetric `json:"metrics"`
+		}{metrics})
+	})
+}
+
+func (s *MetricsSnapshot) collect(ctx context.Context, prefix string) ([]snapshotMetric, error) {
+	var rm metricdata.ResourceMetrics
+	if err := s.reader.Collect(ctx, &rm); err != nil {
+		return nil, err
+	}
+	metrics := []snapshotMetric{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if !strings.HasPrefix(m.Name, prefix) {
+				continue
+			}
+			out := snapshotMetric{Name: m.Name, Description: m.Description, Unit: m.Unit, Scope: sm.Scope.Name}
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				out.Type, out.Monotonic, out.Points = "sum", data.IsMonotonic, valuePoints(data.DataPoints)
+			case metricdata.Sum[float64]:
+				out.Type, out.Monotonic, out.Points = "sum", data.IsMonotonic, valuePoints(data.DataPoints)
+			case metricdata.Gauge[int64]:
+				out.Type, out.Points = "gauge", valuePoints(data.DataPoints)
+			case metricdata.Gauge[float64]:
+				out.Type, out.Points = "gauge", valuePoints(data.DataPoints)
+			case metricdata.Histogram[int64]:
+				out.Type, out.Points = "histogram", histogramPoints(data.DataPoints)
+			case metricdata.Histogram[float64]:
+				out.Type, out.Points = "histogram", histogramPoints(data.DataPoints)
+			case metricdata.ExponentialHistogram[int64]:
+				out.Type, out.Points = "exponential_histogram", exponentialPoints(data.DataPoints)
+			case metricdata.ExponentialHistogram[float64]:
+				out.Type, out.Points = "exponential_histogram", exponentialPoints(data.DataPoints)
+			default:
+				continue
+			}
+			metrics = append(metrics, out)
+		}
+	}
+	slices.SortStableFunc(metrics, func(a, b snapshotMetric) int { return strings.Compare(a.Name, b.Name) })
+	return metrics, nil
+}
+
+func valuePoints[N int64 | float64](dps []metricdata.DataPoint[N]) []snapshotPoint {
+	points := make([]snapshotPoint, 0, len(dps))
+	for _, dp := range dps {
+		points = append(points, snapshotPoint{Attributes: snapshotAttributes(dp.Attributes), Value: dp.Value})
+	}
+	return points
+}
+
+func histogramPoints[N int64 | float64](dps []metricdata.HistogramDataPoint[N]) []snapshotPoint {
+	points := make([]snapshotPoint, 0, len(dps))
+	for _, dp := range dps {
+		p := snapshotPoint{
+			Attributes:   snapshotAttributes(dp.Attributes),
+			Count:        dp.Count,
+			Sum:          dp.Sum,
+			Bounds:       dp.Bounds,
+			BucketCounts: dp.BucketCounts,
+		}
+		if v, ok := dp.Min.Value(); ok {
+			p.Min = v
+		}
+		if v, ok := dp.Max.Value(); ok {
+			p.Max = v
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+func exponentialPoints[N int64 | float64](dps []metricdata.ExponentialHistogramDataPoint[N]) []snapshotPoint {
+	points := make([]snapshotPoint, 0, len(dps))
+	for _, dp := range dps {
+		p := snapshotPoint{
+			Attributes:     snapshotAttributes(dp.Attributes),
+			Count:          dp.Count,
+			Sum:            dp.Sum,
+			Scale:          dp.Scale,
+			ZeroCount:      dp.ZeroCount,
+			PositiveOffset: dp.PositiveBucket.Offset,
+			PositiveCounts: dp.PositiveBucket.Counts,
+			NegativeOffset: dp.NegativeBucket.Offset,
+			NegativeCounts: dp.NegativeBucket.Counts,
+		}
+		if v, ok := dp.Min.Value(); ok {
+			p.Min = v
+		}
+		if v, ok := dp.Max.Value(); ok {
+			p.Max = v
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+func snapshotAttributes(set attribute.Set) map[string]any {
+	attrs := make(map[string]any, set.Len())
+	for _, kv := range set.ToSlice() {
+		attrs[string(kv.Key)] = kv.Value.AsInterface()
+	}
+	return attrs
+}
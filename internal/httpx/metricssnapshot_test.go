@@ -0,0 +1,113 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+func TestMetricsSnapshot(t *testing.T) {
+	ctx := context.Background()
+	prevMP, prevTP := otel.GetMeterProvider(), otel.GetTracerProvider()
+	t.Cleanup(func() {
+		otel.SetMeterProvider(prevMP)
+		otel.SetTracerProvider(prevTP)
+	})
+
+	reader := sdkmetric.NewManualReader()
+	snapshot := NewMetricsSnapshot()
+	shutdown, err := InitTelemetry(ctx, "acai-test", WithMetricReader(reader), WithMetricsSnapshot(snapshot))
+	if err != nil {
+		t.Fatalf("InitTelemetry() unexpected error: %v", err)
+	}
+	defer func() { _ = shutdown(ctx) }()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /trips/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") == "missing" {
+			WriteError(w, r, ErrNotFound)
+		}
+	})
+	handler := MetricsMiddleware(mux)
+	for _, path := range []string{"/trips/1", "/trips/2", "/trips/missing"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	admin := AdminServer("", WithAdminMetricsSnapshot(snapshot)).Handler()
+	get := func(target string) []snapshotMetric {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d, body %s", target, rec.Code, rec.Body)
+		}
+		var body struct {
+			Metrics []snapshotMetric `json:"metrics"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("GET %s: undecodable body %s: %v", target, rec.Body, err)
+		}
+		return body.Metrics
+	}
+
+	// Snapshots are cumulative: taking one doesn't reset the next.
+	get("/debug/metrics")
+	metrics := get("/debug/metrics?name=http.server.")
+	byName := map[string]snapshotMetric{}
+	for _, m := range metrics {
+		if !strings.HasPrefix(m.Name, "http.server.") {
+			t.Errorf("metric %s does not match the filter", m.Name)
+		}
+		byName[m.Name] = m
+	}
+
+	requests := byName["http.server.requests"]
+	if requests.Type != "sum" || !requests.Monotonic {
+		t.Errorf("http.server.requests is a %s, monotonic %v; want a monotonic sum", requests.Type, requests.Monotonic)
+	}
+	counts := map[float64]float64{}
+	for _, p := range requests.Points {
+		if p.Attributes["http.route"] != "/trips/{id}" {
+			t.Errorf("unexpected attributes %v", p.Attributes)
+		}
+		status, _ := p.Attributes["http.status_code"].(float64)
+		value, _ := p.Value.(float64)
+		counts[status] += value
+	}
+	if counts[200] != 2 || counts[404] != 1 {
+		t.Errorf("requests by status %v, want 2 with 200 and 1 with 404", counts)
+	}
+
+	duration := byName["http.server.request.duration"]
+	if duration.Type != "histogram" {
+		t.Fatalf("http.server.request.duration is a %q, want a histogram", duration.Type)
+	}
+	var count, inBuckets uint64
+	for _, p := range duration.Points {
+		count += p.Count
+		for _, n := range p.BucketCounts {
+			inBuckets += n
+		}
+		if len(p.BucketCounts) != len(p.Bounds)+1 || p.Sum == nil {
+			t.Errorf("histogram point without buckets or sum: %+v", p)
+		}
+	}
+	if count != 3 || inBuckets != 3 {
+		t.Errorf("request duration count %d, %d in buckets; want 3", count, inBuckets)
+	}
+
+	// The exporting reader sees every request once.
+	var exported int64
+	for _, dp := range collectSum(t, reader, "http.server.requests") {
+		exported += dp.Value
+	}
+	if exported != 3 {
+		t.Errorf("exported %d requests, want 3", exported)
+	}
+}
@@ -0,0 +1,282 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MirrorHeader marks the requests MirrorMiddleware sends to the mirror.
+const MirrorHeader = "X-Mirror"
+
+// MirrorOption configures MirrorMiddleware.
+type MirrorOption func(*mirror)
+
+// WithMirrorSampling mirrors the given fraction of the matching requests.
+// Defaults to 1, every one.
+func WithMirrorSampling(fraction float64) MirrorOption {
+	return func(m *mirror) { m.fraction = fraction }
+}
+
+// WithMirrorFilter mirrors the requests for which match returns true,
+// instead of the GET ones. It is called before the handler runs, so routes
+// are only known from MuxRoute.
+func WithMirrorFilter(match func(r *http.Request) bool) MirrorOption {
+	return func(m *mirror) { m.match = match }
+}
+
+// WithMirrorWorkers mirrors up to n requests at once, 4 by default, with up
+// to queue more waiting, 100 by default. Requests beyond that aren't
+// mirrored.
+func WithMirrorWorkers(n, queue int) MirrorOption {
+	return func(m *mirror) { m.workers, m.queueSize = max(n, 1), max(queue, 0) }
+}
+
+// WithMirrorMaxBody mirrors requests with bodies of up to n bytes, 64KiB by
+// default, which are buffered for the mirror while the handler reads them.
+func WithMirrorMaxBody(n int64) MirrorOption {
+	return func(m *mirror) { m.maxBody = n }
+}
+
+// WithMirrorTimeout gives up on a mirrored request after d, 5 seconds by
+// default.
+func WithMirrorTimeout(d time.Duration) MirrorOption {
+	return func(m *mirror) { m.timeout = d }
+}
+
+// WithMirrorCredentialHeaders names the headers, besides Authorization,
+// Cookie and X-API-Key, carrying credentials that aren't sent to the
+// mirror.
+func WithMirrorCredentialHeaders(names ...string) MirrorOption {
+	return func(m *mirror) { m.credentials = append(m.credentials, names...) }
+}
+
+// WithMirrorCredentials sends the credentials of the requests to the
+// mirror too, for a target trusted with them that authenticates requests.
+func WithMirrorCredentials() MirrorOption {
+	return func(m *mirror) { m.keepCredentials = true }
+}
+
+// WithMirrorContext stops mirroring once ctx is done, as when the server
+// shuts down: the workers stop, requests being mirrored are canceled and
+// later ones dropped.
+func WithMirrorContext(ctx context.Context) MirrorOption {
+	return func(m *mirror) { m.ctx = ctx }
+}
+
+// MirrorMiddleware sends a copy of requests to target once the handler is
+// done, to compare a new implementation against the one serving users. The
+// copies carry MirrorHeader, and the trace of the original request but not
+// its credentials; what target answers is discarded. They are sent by a few workers in the
+// background, so the handler is never held up: when they fall behind, the
+// requests are dropped. Requests are counted in http.server.mirror with
+// mirror.result: mirrored or error, along with the mirror's
+// http.status_code, and dropped when the queue is full or too_large when
+// the body exceeds the limit. See MirrorURL for a remote target.
+func MirrorMiddleware(target http.Handler, opts ...MirrorOption) func(http.Handler) http.Handler {
+	m := &mirror{
+		target:    target,
+		fraction:  1,
+		match:     func(r *http.Request) bool { return r.Method == http.MethodGet },
+		workers:   4,
+		queueSize: 100,
+		maxBody:   64 << 10,
+		timeout:   5 * time.Second,
+
+		credentials: []string{"Authorization", "Cookie", "X-API-Key"},
+		ctx:         context.Background(),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.queue = make(chan *http.Request, m.queueSize)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !m.match(r) || rand.Float64() >= m.fraction {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, ok := m.bufferBody(r)
+			next.ServeHTTP(w, r)
+			if !ok {
+				m.count(r.Context(), "too_large")
+				return
+			}
+			m.enqueue(r, body)
+		})
+	}
+}
+
+type mirror struct {
+	target    http.Handler
+	fraction  float64
+	match     func(r *http.Request) bool
+	workers   int
+	queueSize int
+	maxBody   int64
+	timeout   time.Duration
+
+	credentials     []string // headers not sent to the mirror
+	keepCredentials bool
+	ctx             context.Context // ends the mirroring
+
+	start sync.Once
+	queue chan *http.Request
+}
+
+// bufferBody reads up to maxBody bytes of the body for the mirror, putting
+// them back in front of the rest for the handler. It reports false when
+// the body is longer.
+func (m *mirror) bufferBody(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	if r.ContentLength > m.maxBody {
+		return nil, false
+	}
+	buf, err := io.ReadAll(io.LimitReader(r.Body, m.maxBody+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+	if err != nil || int64(len(buf)) > m.maxBody {
+		return nil, false
+	}
+	return buf, true
+}
+
+// enqueue queues a copy of r, with a context of its own so that the mirror
+// outlives the request.
+func (m *mirror) enqueue(r *http.Request, body []byte) {
+	if m.ctx.Err() != nil {
+		m.count(r.Context(), "dropped")
+		return
+	}
+	m.start.Do(func() {
+		for range m.workers {
+			go m.work()
+		}
+	})
+
+	ctx := trace.ContextWithSpanContext(m.ctx, trace.SpanContextFromContext(r.Context()))
+	req := r.Clone(ctx)
+	req.Header.Set(MirrorHeader, "1")
+	if !m.keepCredentials {
+		for _, k := range m.credentials {
+			req.Header.Del(k)
+		}
+	}
+	req.Body = http.NoBody
+	if body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	select {
+	case m.queue <- req:
+	default:
+		m.count(r.Context(), "dropped")
+	}
+}
+
+func (m *mirror) work() {
+	for {
+		select {
+		case req := <-m.queue:
+			m.send(req)
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *mirror) send(req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), m.timeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	status := http.StatusOK // if the target writes nothing, as net/http would
+	rec := &discardWriter{header: http.Header{}, status: &status}
+	func() {
+		defer func() {
+			if v := recover(); v != nil {
+				slog.WarnContext(ctx, "Mirror panicked", "panic", fmt.Sprint(v), "path", req.URL.Path)
+				status = http.StatusInternalServerError
+			}
+		}()
+		m.target.ServeHTTP(rec, req)
+	}()
+
+	result := "mirrored"
+	if status >= 500 {
+		result = "error"
+	}
+	m.count(ctx, result, attribute.Int("http.status_code", status))
+}
+
+func (m *mirror) count(ctx context.Context, result string, attrs ...attribute.KeyValue) {
+	loadServerMetrics().mirror.Add(ctx, 1, metric.WithAttributes(append(attrs, attribute.String("mirror.result", result))...))
+}
+
+// discardWriter is the ResponseWriter of mirrored requests, keeping only
+// the status.
+type discardWriter struct {
+	header      http.Header
+	status      *int
+	wroteHeader bool
+}
+
+func (w *discardWriter) Header() http.Header { return w.header }
+
+func (w *discardWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		*w.status, w.wroteHeader = status, true
+	}
+}
+
+func (w *discardWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return len(p), nil
+}
+
+// MirrorURL is a MirrorMiddleware target sending the requests to the same
+// path and query under base, through client, and answering with the status
+// it got: 502 when it got none. A nil client means one with NewTransport,
+// for the mirrored requests to be traced and measured as outbound ones.
+func MirrorURL(base string, client *http.Client) (http.Handler, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("mirror URL: %w", err)
+	}
+	if client == nil {
+		client = &http.Client{Transport: NewTransport(nil)}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := u.JoinPath(r.URL.Path)
+		target.RawQuery = r.URL.RawQuery
+		out, err := http.NewRequestWithContext(r.Context(), r.Method, target.String(), r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		out.Header = r.Header.Clone()
+		resp, err := client.Do(out)
+		if err != nil {
+			slog.DebugContext(r.Context(), "Mirror unreachable", "error", err)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		drainAndClose(resp.Body)
+		w.WriteHeader(resp.StatusCode)
+	}), nil
+}
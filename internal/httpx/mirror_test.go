@@ -0,0 +1,254 @@
+package httpx
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// mirrorCounts waits until n requests were counted in http.server.mirror,
+// then returns the counts by result and status.
+func mirrorCounts(t *testing.T, reader sdkmetric.Reader, n int64) map[string]int64 {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		counts := map[string]int64{}
+		var total int64
+		if _, ok := collectMetric(t, reader, "http.server.mirror"); ok {
+			for _, dp := range collectSum(t, reader, "http.server.mirror") {
+				result, _ := dp.Attributes.Value("mirror.result")
+				key := result.AsString()
+				if status, ok := dp.Attributes.Value("http.status_code"); ok {
+					key += " " + status.Emit()
+				}
+				counts[key] += dp.Value
+				total += dp.Value
+			}
+		}
+		if total >= n || time.Now().After(deadline) {
+			return counts
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMirrorMiddleware(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+	exp := otelt.InstallTracing(t)
+
+	mirrored := make(chan string, 10)
+	shadow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mirrored <- r.Method + " " + r.URL.RequestURI() + " " + string(body) + " " + r.Header.Get(MirrorHeader)
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	var served []string
+	primary := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		served = append(served, string(body))
+		_, _ = w.Write([]byte("primary"))
+	})
+	handler := TracingMiddleware(MirrorMiddleware(shadow,
+		WithMirrorFilter(func(r *http.Request) bool { return r.URL.Path != "/skip" }),
+		WithMirrorMaxBody(8),
+	)(primary))
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/quotes?from=BCN", nil),
+		httptest.NewRequest(http.MethodPost, "/quotes", strings.NewReader("to=LHR")),
+		httptest.NewRequest(http.MethodPost, "/quotes", strings.NewReader("a body over the limit")),
+		httptest.NewRequest(http.MethodGet, "/skip", nil),
+		httptest.NewRequest(http.MethodGet, "/broken", nil),
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Body.String() != "primary" {
+			t.Errorf("%s %s answered %q, want the primary's", req.Method, req.URL, rec.Body)
+		}
+	}
+
+	want := map[string]bool{"GET /quotes?from=BCN  1": true, "POST /quotes to=LHR 1": true, "GET /broken  1": true}
+	for range want {
+		select {
+		case got := <-mirrored:
+			if !want[got] {
+				t.Errorf("mirrored %q, want one of %v", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("requests not mirrored")
+		}
+	}
+	if got := strings.Join(served, "|"); got != "|to=LHR|a body over the limit||" {
+		t.Errorf("primary read bodies %q, want them whole", got)
+	}
+
+	counts := mirrorCounts(t, reader, 4)
+	wantCounts := map[string]int64{"mirrored 200": 2, "error 500": 1, "too_large": 1}
+	if len(counts) != len(wantCounts) {
+		t.Errorf("http.server.mirror = %v, want %v", counts, wantCounts)
+	}
+	for k, n := range wantCounts {
+		if counts[k] != n {
+			t.Errorf("http.server.mirror %s = %d, want %d", k, counts[k], n)
+		}
+	}
+	if spans := exp.GetSpans(); len(spans) != 5 {
+		t.Errorf("got %d spans, want the primary requests' only", len(spans))
+	}
+}
+
+func TestMirrorMiddleware_Dropped(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	release := make(chan struct{})
+	shadow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release })
+	handler := MirrorMiddleware(shadow, WithMirrorWorkers(1, 1))(http.NotFoundHandler())
+
+	start := time.Now()
+	for range 5 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/quotes", nil))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("primary requests took %v behind a stuck mirror", elapsed)
+	}
+	// At most one is being mirrored and another queued.
+	if dropped := mirrorCounts(t, reader, 3)["dropped"]; dropped < 3 {
+		t.Errorf("dropped %d requests, want 3 beyond the worker and queue", dropped)
+	}
+	close(release)
+	if counts := mirrorCounts(t, reader, 5); counts["mirrored 200"]+counts["dropped"] != 5 {
+		t.Errorf("http.server.mirror = %v, want the 5 requests mirrored or dropped", counts)
+	}
+}
+
+func TestMirrorMiddleware_Credentials(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	tests := []struct {
+		name string
+		opts []MirrorOption
+		want string
+	}{
+		{name: "stripped", opts: []MirrorOption{WithMirrorCredentialHeaders("X-Partner-Token")}, want: "||||en"},
+		{name: "kept", opts: []MirrorOption{WithMirrorCredentials()}, want: "Bearer t|s=1|k|p|en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mirrored := make(chan string, 1)
+			shadow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var got []string
+				for _, k := range []string{"Authorization", "Cookie", "X-API-Key", "X-Partner-Token", "Accept-Language"} {
+					got = append(got, r.Header.Get(k))
+				}
+				mirrored <- strings.Join(got, "|")
+			})
+			var primary string
+			handler := MirrorMiddleware(shadow, tt.opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				primary = r.Header.Get("Authorization")
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/quotes", nil)
+			req.Header.Set("Authorization", "Bearer t")
+			req.Header.Set("Cookie", "s=1")
+			req.Header.Set("X-API-Key", "k")
+			req.Header.Set("X-Partner-Token", "p")
+			req.Header.Set("Accept-Language", "en")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if primary != "Bearer t" {
+				t.Errorf("primary got Authorization %q, want it untouched", primary)
+			}
+			select {
+			case got := <-mirrored:
+				if got != tt.want {
+					t.Errorf("mirror got headers %q, want %q", got, tt.want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("request not mirrored")
+			}
+		})
+	}
+}
+
+func TestMirrorMiddleware_Context(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started, canceled := make(chan struct{}), make(chan struct{})
+	shadow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		close(canceled)
+	})
+	handler := MirrorMiddleware(shadow, WithMirrorContext(ctx), WithMirrorTimeout(time.Minute))(http.NotFoundHandler())
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/quotes", nil))
+	<-started
+	cancel()
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the mirrored request wasn't canceled with the context")
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/quotes", nil))
+	if counts := mirrorCounts(t, reader, 2); counts["dropped"] != 1 {
+		t.Errorf("http.server.mirror = %v, want the request after the context ended dropped", counts)
+	}
+}
+
+func TestMirrorMiddleware_Sampling(t *testing.T) {
+	otelt.InstallMetrics(t)
+	mirrored := make(chan struct{}, 10)
+	shadow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { mirrored <- struct{}{} })
+	handler := MirrorMiddleware(shadow, WithMirrorSampling(0))(http.NotFoundHandler())
+
+	for range 10 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/quotes", nil))
+	}
+	select {
+	case <-mirrored:
+		t.Error("request mirrored with sampling 0")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestMirrorURL(t *testing.T) {
+	otelt.InstallMetrics(t)
+	exp := otelt.InstallTracing(t)
+	got := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.URL.RequestURI() + " " + r.Header.Get(MirrorHeader)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer srv.Close()
+
+	target, err := MirrorURL(srv.URL+"/v2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/quotes?from=BCN", nil)
+	req.Header.Set(MirrorHeader, "1")
+	target.ServeHTTP(rec, req)
+
+	if uri := <-got; uri != "/v2/quotes?from=BCN 1" {
+		t.Errorf("remote got %q, want the path under the base", uri)
+	}
+	if rec.Code != http.StatusTeapot {
+		t.Errorf("status %d, want the remote's", rec.Code)
+	}
+	if spans := exp.GetSpans(); len(spans) != 1 || spans[0].Name != http.MethodGet {
+		t.Errorf("spans %v, want the outbound request's", spans)
+	}
+}
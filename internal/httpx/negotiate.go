@@ -0,0 +1,217 @@
+package httpx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encoder encodes the responses of Negotiate in one media type. Register
+// one with RegisterEncoder to offer it to the clients asking for it.
+type Encoder interface {
+	// ContentType is the media type encoded, as in "application/msgpack".
+	ContentType() string
+	Encode(v any) ([]byte, error)
+}
+
+// jsonEncoder is the encoder of the JSON media types, always offered.
+type jsonEncoder string
+
+func (e jsonEncoder) ContentType() string { return string(e) }
+
+func (e jsonEncoder) Encode(v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	return append(b, '\n'), err
+}
+
+const (
+	jsonResponses    jsonEncoder = "application/json"
+	problemResponses jsonEncoder = problemJSON
+)
+
+// MsgpackEncoder encodes responses as MessagePack, naming fields after
+// their json tags so that both encodings carry the same document:
+//
+//	httpx.RegisterEncoder(httpx.MsgpackEncoder{})
+type MsgpackEncoder struct{}
+
+func (MsgpackEncoder) ContentType() string { return "application/msgpack" }
+
+func (MsgpackEncoder) Encode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   []Encoder
+
+	strictNegotiation atomic.Bool
+)
+
+// RegisterEncoder offers enc to the clients of Negotiate, after JSON. Like
+// Router.Handle, it panics on an invalid media type or one offered already.
+func RegisterEncoder(enc Encoder) {
+	ct := enc.ContentType()
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil || strings.Contains(mt, "*") || !strings.Contains(mt, "/") {
+		panic(fmt.Sprintf("httpx: invalid encoder content type %q", ct))
+	}
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	for _, e := range append([]Encoder{jsonResponses, problemResponses}, encoders...) {
+		if mediaType(e.ContentType()) == mt {
+			panic(fmt.Sprintf("httpx: encoder for %s already registered", mt))
+		}
+	}
+	encoders = append(encoders, enc)
+}
+
+// SetStrictNegotiation makes Negotiate answer 406 Not Acceptable to the
+// clients accepting none of the encoders. Otherwise they get JSON.
+func SetStrictNegotiation(enabled bool) {
+	strictNegotiation.Store(enabled)
+}
+
+// Negotiate sends v with the given status in the media type r accepts best
+// among JSON and the registered encoders: the one of the highest q-value,
+// then the one the Accept header names most specifically, then JSON and the
+// encoders in the order registered. An error status is sent as
+// application/problem+json to clients naming it, v being the problem
+// document. Clients without an Accept header get JSON, as do those accepting
+// nothing offered unless SetStrictNegotiation(true) was called: then they get
+// a 406 and ErrNotAcceptable is returned. The response varies on Accept.
+//
+// Like WriteJSON, a v that can't be encoded is answered with a 500; failures
+// are logged and returned.
+func Negotiate(w http.ResponseWriter, r *http.Request, status int, v any) error {
+	ctx := r.Context()
+	w.Header().Add("Vary", "Accept")
+	enc, ok := negotiate(r.Header.Get("Accept"), status >= 400)
+	if !ok {
+		if strictNegotiation.Load() {
+			WriteError(w, r, ErrNotAcceptable)
+			return ErrNotAcceptable
+		}
+		enc = jsonResponses
+	}
+	if enc, ok := enc.(jsonEncoder); ok {
+		return writeEncoded(ctx, w, enc.ContentType(), status, v)
+	}
+
+	ct := enc.ContentType()
+	b, err := enc.Encode(v)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode response", "content_type", ct, "error", err)
+		_ = writeJSON(ctx, w, http.StatusInternalServerError, errorBody{Error: http.StatusText(http.StatusInternalServerError), Code: internalErrorType})
+		return err
+	}
+	w.Header().Set("Content-Type", ct)
+	w.WriteHeader(status)
+	if _, err := w.Write(b); err != nil {
+		slog.WarnContext(ctx, "Failed to write response", "content_type", ct, "error", err)
+		return err
+	}
+	return nil
+}
+
+// negotiate returns the encoder Negotiate answers an Accept header with,
+// offering problem+json for errors, or false if none is acceptable.
+func negotiate(header string, isError bool) (Encoder, bool) {
+	ranges := parseAccept(header)
+	if len(ranges) == 0 {
+		return jsonResponses, true
+	}
+	offers := []Encoder{jsonResponses}
+	if isError {
+		offers = []Encoder{problemResponses, jsonResponses}
+	}
+	encodersMu.RLock()
+	offers = append(offers, encoders...)
+	encodersMu.RUnlock()
+
+	var best Encoder
+	bestQ, bestSpecificity := 0.0, -1
+	for _, enc := range offers {
+		q, specificity := quality(ranges, mediaType(enc.ContentType()))
+		if enc == Encoder(problemResponses) && specificity < 2 {
+			// Clients must ask for problem details to get them.
+			continue
+		}
+		if q > bestQ || q == bestQ && q > 0 && specificity > bestSpecificity {
+			best, bestQ, bestSpecificity = enc, q, specificity
+		}
+	}
+	return best, best != nil
+}
+
+// acceptRange is a media range of an Accept header, such as text/* or
+// application/json, with its q-value.
+type acceptRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// parseAccept returns the media ranges of an Accept header, skipping those
+// it can't make sense of.
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		mt, params, _ := strings.Cut(part, ";")
+		typ, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(mt)), "/")
+		if !ok || typ == "" || subtype == "" || typ == "*" && subtype != "*" {
+			continue
+		}
+		ar := acceptRange{typ: typ, subtype: subtype, q: 1}
+		for _, param := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(param, "=")
+			if !strings.EqualFold(strings.TrimSpace(k), "q") {
+				continue
+			}
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && f >= 0 && f <= 1 {
+				ar.q = f
+			}
+			break
+		}
+		ranges = append(ranges, ar)
+	}
+	return ranges
+}
+
+// quality returns the q-value ranges give the media type mt, taken from the
+// most specific range covering it, and how specific that is: 2 for mt
+// itself, 1 for its type/*, 0 for */*, and -1 if no range covers it.
+func quality(ranges []acceptRange, mt string) (float64, int) {
+	typ, subtype, _ := strings.Cut(mt, "/")
+	q, specificity := 0.0, -1
+	for _, ar := range ranges {
+		s := -1
+		switch {
+		case ar.typ == typ && ar.subtype == subtype:
+			s = 2
+		case ar.typ == typ && ar.subtype == "*":
+			s = 1
+		case ar.typ == "*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = ar.q, s
+		}
+	}
+	return q, specificity
+}
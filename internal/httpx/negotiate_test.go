@@ -0,0 +1,196 @@
+package httpx
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// xmlEncoder shows an encoder added outside of the package.
+type xmlEncoder struct{}
+
+func (xmlEncoder) ContentType() string { return "application/xml" }
+
+func (xmlEncoder) Encode(v any) ([]byte, error) { return xml.Marshal(v) }
+
+// registerEncoders registers encs for the duration of the test.
+func registerEncoders(t *testing.T, encs ...Encoder) {
+	t.Helper()
+	encodersMu.Lock()
+	prev := encoders
+	encoders = nil
+	encodersMu.Unlock()
+	t.Cleanup(func() {
+		encodersMu.Lock()
+		encoders = prev
+		encodersMu.Unlock()
+	})
+	for _, enc := range encs {
+		RegisterEncoder(enc)
+	}
+}
+
+type negotiatedTrip struct {
+	XMLName xml.Name `json:"-" xml:"trip"`
+	TripID  string   `json:"trip_id" xml:"id"`
+	Nights  int      `json:"nights,omitempty" xml:"nights"`
+}
+
+func TestNegotiate(t *testing.T) {
+	registerEncoders(t, MsgpackEncoder{}, xmlEncoder{})
+
+	tests := []struct {
+		accept string
+		status int
+		want   string
+	}{
+		{"", http.StatusOK, "application/json"},
+		{"application/json", http.StatusOK, "application/json"},
+		{"application/msgpack", http.StatusOK, "application/msgpack"},
+		{"application/xml", http.StatusOK, "application/xml"},
+		{"application/json;q=0.5, application/msgpack", http.StatusOK, "application/msgpack"},
+		{"application/msgpack;q=0.2, application/json;q=0.8", http.StatusOK, "application/json"},
+		{"application/json; charset=utf-8; q=0.1, Application/MsgPack; q=0.2", http.StatusOK, "application/msgpack"},
+		{"application/xml, application/msgpack", http.StatusOK, "application/msgpack"},
+		{"*/*", http.StatusOK, "application/json"},
+		{"application/*", http.StatusOK, "application/json"},
+		{"application/msgpack, */*", http.StatusOK, "application/msgpack"},
+		{"application/msgpack;q=0.5, */*;q=0.9", http.StatusOK, "application/json"},
+		{"application/*;q=0.3, application/json;q=0", http.StatusOK, "application/msgpack"},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", http.StatusOK, "application/xml"},
+		{"text/html", http.StatusOK, "application/json"},
+		{"not a media type", http.StatusOK, "application/json"},
+		{"application/problem+json", http.StatusNotFound, problemJSON},
+		{"application/json, application/problem+json", http.StatusNotFound, problemJSON},
+		{"application/json, application/problem+json;q=0.5", http.StatusNotFound, "application/json"},
+		{"*/*", http.StatusNotFound, "application/json"},
+		{"application/*", http.StatusNotFound, "application/json"},
+		{"application/problem+json", http.StatusOK, "application/json"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/trips/42", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		rec := httptest.NewRecorder()
+		if err := Negotiate(rec, req, tt.status, negotiatedTrip{TripID: "42", Nights: 3}); err != nil {
+			t.Errorf("Accept %q: unexpected error: %v", tt.accept, err)
+			continue
+		}
+		if rec.Code != tt.status {
+			t.Errorf("Accept %q: status mismatch: got %d, want %d", tt.accept, rec.Code, tt.status)
+		}
+		if got := rec.Header().Get("Content-Type"); got != tt.want {
+			t.Errorf("Accept %q: Content-Type mismatch: got %q, want %q", tt.accept, got, tt.want)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept" {
+			t.Errorf("Accept %q: Vary mismatch: got %q, want Accept", tt.accept, got)
+		}
+	}
+}
+
+func TestNegotiate_Bodies(t *testing.T) {
+	registerEncoders(t, MsgpackEncoder{})
+
+	serve := func(accept string) []byte {
+		req := httptest.NewRequest(http.MethodGet, "/trips/42", nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		if err := Negotiate(rec, req, http.StatusOK, negotiatedTrip{TripID: "42"}); err != nil {
+			t.Fatalf("Accept %s: unexpected error: %v", accept, err)
+		}
+		return rec.Body.Bytes()
+	}
+
+	var fromJSON, fromMsgpack map[string]any
+	if err := json.Unmarshal(serve("application/json"), &fromJSON); err != nil {
+		t.Fatalf("decoding the JSON body: %v", err)
+	}
+	packed := serve("application/msgpack")
+	if err := msgpack.Unmarshal(packed, &fromMsgpack); err != nil {
+		t.Fatalf("decoding the msgpack body: %v", err)
+	}
+	if len(fromMsgpack) != 1 || fromMsgpack["trip_id"] != "42" || len(fromJSON) != 1 || fromJSON["trip_id"] != "42" {
+		t.Errorf("bodies differ: JSON %v, msgpack %v; want only trip_id 42 in both", fromJSON, fromMsgpack)
+	}
+}
+
+func TestNegotiate_NotAcceptable(t *testing.T) {
+	captureLogs(t)
+	registerEncoders(t, MsgpackEncoder{})
+	SetStrictNegotiation(true)
+	t.Cleanup(func() { SetStrictNegotiation(false) })
+
+	req := httptest.NewRequest(http.MethodGet, "/trips/42", nil)
+	req.Header.Set("Accept", "text/html, application/json;q=0")
+	rec := httptest.NewRecorder()
+	err := Negotiate(rec, req, http.StatusOK, negotiatedTrip{TripID: "42"})
+	if !errors.Is(err, ErrNotAcceptable) {
+		t.Errorf("error mismatch: got %v, want ErrNotAcceptable", err)
+	}
+	if rec.Code != http.StatusNotAcceptable {
+		t.Errorf("status mismatch: got %d, want %d", rec.Code, http.StatusNotAcceptable)
+	}
+	if !strings.Contains(rec.Body.String(), `"code":"not_acceptable"`) {
+		t.Errorf("body %q doesn't name the error", rec.Body.String())
+	}
+	if got := rec.Header().Get("Vary"); got != "Accept" {
+		t.Errorf("Vary mismatch: got %q, want Accept", got)
+	}
+
+	// Clients not saying what they accept still get JSON.
+	rec = httptest.NewRecorder()
+	if err := Negotiate(rec, httptest.NewRequest(http.MethodGet, "/trips/42", nil), http.StatusOK, negotiatedTrip{}); err != nil {
+		t.Fatalf("without Accept: unexpected error: %v", err)
+	}
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("without Accept: got %d %s, want 200 JSON", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestNegotiate_EncodeFailure(t *testing.T) {
+	logs := captureLogs(t)
+	registerEncoders(t, xmlEncoder{})
+
+	req := httptest.NewRequest(http.MethodGet, "/trips", nil)
+	req.Header.Set("Accept", "application/xml")
+	rec := httptest.NewRecorder()
+	// encoding/xml can't encode maps.
+	if err := Negotiate(rec, req, http.StatusOK, map[string]string{"trip_id": "42"}); err == nil {
+		t.Error("expected an error for a value the encoder can't encode")
+	}
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got %d %s, want 500 JSON", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(logs.String(), "Failed to encode response") {
+		t.Errorf("encoding failure not logged: %s", logs)
+	}
+}
+
+func TestRegisterEncoder_Invalid(t *testing.T) {
+	registerEncoders(t, MsgpackEncoder{})
+
+	tests := map[string]Encoder{
+		"json":       jsonEncoder("application/json; charset=utf-8"),
+		"problem":    jsonEncoder(problemJSON),
+		"duplicate":  MsgpackEncoder{},
+		"wildcard":   jsonEncoder("application/*"),
+		"no subtype": jsonEncoder("msgpack"),
+	}
+	for name, enc := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if msg, _ := recover().(string); !strings.HasPrefix(msg, "httpx: ") {
+					t.Errorf("RegisterEncoder(%q) didn't panic", enc.ContentType())
+				}
+			}()
+			RegisterEncoder(enc)
+		})
+	}
+}
@@ -2,90 +2,552 @@ package httpx
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"os"
+	"strings"
 	"time"
 
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/log/global"
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/exemplar"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
 	"google.golang.org/grpc"
 )
 
+// Shutdown flushes and releases what was started, giving up when ctx is
+// done.
 type Shutdown func(ctx context.Context) error
 
-func InitTelemetry(ctx context.Context, serviceName string) (Shutdown, error) {
-	res, err := resource.New(
-		ctx,
-		resource.WithSchemaURL(semconv.SchemaURL),
-		resource.WithAttributes(
-			semconv.ServiceNameKey.String(serviceName),
-		),
-	)
+// TelemetryOption configures InitTelemetry.
+type TelemetryOption func(*telemetryConfig)
+
+type telemetryConfig struct {
+	metricReader  sdkmetric.Reader
+	snapshot      *MetricsSnapshot
+	endpoint      string
+	insecure      bool
+	headers       map[string]string
+	resourceAttrs []attribute.KeyValue
+	detectors     []resource.Detector
+	detectTimeout time.Duration
+	sampler       sdktrace.Sampler
+	ratio         float64 // of new traces sampled by sampler
+	debugSampling bool
+	runtime       bool
+	logHandler    slog.Handler
+	views         []*viewSpec
+	spanExporter  sdktrace.SpanExporter
+	extraSpans    []sdktrace.SpanExporter
+	traceBuffer   *TraceBuffer
+	stdoutSpans   bool
+	stdout        *stdoutSink
+	logExport     bool
+	logExporter   sdklog.Exporter
+	export        exportTuning
+	failOpen      bool
+	failOpenRetry time.Duration // before the first retry
+	explicitHists bool          // exponential aggregations can't be exported
+}
+
+// WithOTLPEndpoint exports traces and metrics via OTLP gRPC to the given
+// collector endpoint, either host:port or a URL such as http://collector:4317.
+// Without it, telemetry is written to stdout, or as WithStdoutWriter and
+// WithStdoutFile say.
+func WithOTLPEndpoint(endpoint string) TelemetryOption {
+	return func(c *telemetryConfig) { c.endpoint = endpoint }
+}
+
+// WithOTLPInsecure disables TLS on the OTLP connection.
+func WithOTLPInsecure(insecure bool) TelemetryOption {
+	return func(c *telemetryConfig) { c.insecure = insecure }
+}
+
+// WithOTLPHeaders sends the given headers (e.g. auth tokens) with every export.
+func WithOTLPHeaders(headers map[string]string) TelemetryOption {
+	return func(c *telemetryConfig) { c.headers = headers }
+}
+
+// WithAdditionalSpanExporter exports spans through exp too, e.g. to a new
+// collector while migrating away from the old one. It may be given more than
+// once. Every exporter has its own batcher, so a slow backend doesn't hold up
+// the others, and Shutdown flushes them all.
+func WithAdditionalSpanExporter(exp sdktrace.SpanExporter) TelemetryOption {
+	return func(c *telemetryConfig) { c.extraSpans = append(c.extraSpans, exp) }
+}
+
+// WithStdoutSpans writes spans to stdout, or as WithStdoutWriter and
+// WithStdoutFile say, in addition to exporting them via OTLP or the exporter
+// of WithSpanExporter. Meant for staging.
+func WithStdoutSpans() TelemetryOption {
+	return func(c *telemetryConfig) { c.stdoutSpans = true }
+}
+
+// WithMetricReader collects metrics through the given reader instead of
+// exporting them periodically. Mostly useful in tests with a ManualReader.
+func WithMetricReader(reader sdkmetric.Reader) TelemetryOption {
+	return func(c *telemetryConfig) { c.metricReader = reader }
+}
+
+// WithSpanExporter exports spans through exp instead of OTLP or stdout.
+func WithSpanExporter(exp sdktrace.SpanExporter) TelemetryOption {
+	return func(c *telemetryConfig) { c.spanExporter = exp }
+}
+
+// WithServiceVersion sets the service.version resource attribute.
+func WithServiceVersion(version string) TelemetryOption {
+	return WithResourceAttributes(semconv.ServiceVersion(version))
+}
+
+// WithDeploymentEnvironment sets the deployment.environment resource
+// attribute, e.g. "staging" or "production".
+func WithDeploymentEnvironment(env string) TelemetryOption {
+	return WithResourceAttributes(semconv.DeploymentEnvironment(env))
+}
+
+// WithResourceAttributes adds extra attributes to the telemetry resource.
+// When the same key is set more than once, the last value wins.
+func WithResourceAttributes(attrs ...attribute.KeyValue) TelemetryOption {
+	return func(c *telemetryConfig) { c.resourceAttrs = append(c.resourceAttrs, attrs...) }
+}
+
+// WithSampleRatio samples the given fraction of new traces. Spans with a
+// parent follow their parent's decision, so traces are never cut in half.
+func WithSampleRatio(ratio float64) TelemetryOption {
+	return func(c *telemetryConfig) {
+		c.sampler, c.ratio = sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio)), ratio
+	}
+}
+
+// WithAlwaysSample records every span, regardless of the parent's decision.
+// Meant for development.
+func WithAlwaysSample() TelemetryOption {
+	return func(c *telemetryConfig) { c.sampler, c.ratio = sdktrace.AlwaysSample(), 1 }
+}
+
+// WithNeverSample records no spans. Meant for tests and CLIs.
+func WithNeverSample() TelemetryOption {
+	return func(c *telemetryConfig) { c.sampler, c.ratio = sdktrace.NeverSample(), 0 }
+}
+
+// WithDebugSampling lets requests approved by DebugTraceMiddleware be sampled
+// even when the configured sampler would drop them.
+func WithDebugSampling() TelemetryOption {
+	return func(c *telemetryConfig) { c.debugSampling = true }
+}
+
+// WithRuntimeMetrics reports goroutines, heap, GC, open file descriptors and
+// CPU time alongside the HTTP metrics.
+func WithRuntimeMetrics() TelemetryOption {
+	return func(c *telemetryConfig) { c.runtime = true }
+}
+
+// WithTraceLogging installs a default slog logger that writes to inner
+// through a LogHandler, so every log line made within a span carries its
+// trace and span IDs. A nil inner means text on stderr, at the level set
+// with SetLogLevel.
+func WithTraceLogging(inner slog.Handler) TelemetryOption {
+	return func(c *telemetryConfig) {
+		if inner == nil {
+			inner = defaultLogHandler()
+		}
+		c.logHandler = inner
+	}
+}
+
+func defaultLogHandler() slog.Handler {
+	// Wrapping slog.Default().Handler() would loop through the log package
+	// once installed as the default.
+	return slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: LogLevel()})
+}
+
+func InitTelemetry(ctx context.Context, serviceName string, opts ...TelemetryOption) (Shutdown, error) {
+	cfg := newTelemetryConfig(opts)
+	metricReader, err := newMetricReader(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
+	return initProviders(ctx, serviceName, cfg, metricReader)
+}
 
-	initCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
+// Telemetry is a set of providers that InitTelemetry would install as the
+// globals, for code that can't share them: tests running in parallel, or
+// several services embedded in one process. Pass it to the middlewares with
+// WithMetricsTelemetry and NewTracingMiddleware.
+type Telemetry struct {
+	MeterProvider  *sdkmetric.MeterProvider
+	TracerProvider *sdktrace.TracerProvider
+	LoggerProvider *sdklog.LoggerProvider // nil without WithLogExport
+	Propagator     propagation.TextMapPropagator
 
-	metricExp, err := otlpmetricgrpc.New(
-		initCtx,
-		otlpmetricgrpc.WithInsecure(),
-		otlpmetricgrpc.WithEndpoint("localhost:4317"),
-		otlpmetricgrpc.WithDialOption(grpc.WithBlock()),
-	)
+	// Shutdown flushes and releases the providers.
+	Shutdown Shutdown
+
+	server *serverMetrics
+	client *clientMetrics
+}
+
+// NewTelemetry returns the providers InitTelemetry would install, leaving
+// the globals alone. The runtime controls of SetSampleRatio don't apply to
+// them, and WithTraceLogging has no effect.
+func NewTelemetry(ctx context.Context, serviceName string, opts ...TelemetryOption) (*Telemetry, error) {
+	cfg := newTelemetryConfig(opts)
+	metricReader, err := newMetricReader(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newProviders(ctx, serviceName, cfg, metricReader, cfg.sampler)
 	if err != nil {
 		return nil, err
 	}
+	slog.Info("OpenTelemetry instance created", "sampler", cfg.sampler.Description(), "export", cfg.export)
+	return t, nil
+}
 
-	metricReader := sdkmetric.NewPeriodicReader(
-		metricExp,
-		sdkmetric.WithInterval(10*time.Second),
-	)
+// Meter returns the meter of this package's instrumentation scope.
+func (t *Telemetry) Meter() metric.Meter {
+	return t.MeterProvider.Meter(instrumentationName)
+}
+
+// Tracer returns the tracer of the same scope.
+func (t *Telemetry) Tracer() trace.Tracer {
+	return t.TracerProvider.Tracer(instrumentationName)
+}
+
+// serverMetrics returns the instruments of t, or those of the global meter
+// provider when t is nil.
+func (t *Telemetry) serverMetrics() *serverMetrics {
+	if t == nil {
+		return loadServerMetrics()
+	}
+	return t.server
+}
+
+func (t *Telemetry) tracer() trace.Tracer {
+	if t == nil {
+		return Tracer()
+	}
+	return t.Tracer()
+}
+
+func (t *Telemetry) propagator() propagation.TextMapPropagator {
+	if t == nil {
+		return otel.GetTextMapPropagator()
+	}
+	return t.Propagator
+}
+
+// newMetricReader returns the reader of WithMetricReader, or else one
+// exporting periodically.
+func newMetricReader(ctx context.Context, cfg telemetryConfig) (sdkmetric.Reader, error) {
+	if err := cfg.export.validate(); err != nil {
+		return nil, fmt.Errorf("invalid export settings: %w", err)
+	}
+	if cfg.metricReader != nil {
+		return cfg.metricReader, nil
+	}
+	metricExp, err := buildExporter(ctx, cfg, "metrics", newMetricExporter, newPendingMetricExporter)
+	if err != nil {
+		return nil, err
+	}
+	return sdkmetric.NewPeriodicReader(signalMetricExporter{metricExp}, cfg.export.readerOptions()...), nil
+}
+
+func newTelemetryConfig(opts []TelemetryOption) telemetryConfig {
+	// Same as the SDK default: sample new traces, follow the parent otherwise.
+	cfg := telemetryConfig{
+		sampler: sdktrace.ParentBased(sdktrace.AlwaysSample()),
+		ratio:   1,
+		stdout:  newStdoutSink(),
+		export:  defaultExportTuning(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.debugSampling {
+		cfg.sampler = debugSampler{base: cfg.sampler}
+	}
+	if cfg.logExport && cfg.logHandler == nil {
+		cfg.logHandler = defaultLogHandler()
+	}
+	return cfg
+}
+
+// initProviders installs the global meter and tracer providers, collecting
+// metrics through the given reader.
+func initProviders(ctx context.Context, serviceName string, cfg telemetryConfig, metricReader sdkmetric.Reader) (Shutdown, error) {
+	t, err := newProviders(ctx, serviceName, cfg, metricReader, &traceSampling)
+	if err != nil {
+		return nil, err
+	}
 
-	mp := sdkmetric.NewMeterProvider(
+	// Nothing is installed globally until all that can fail has succeeded, so
+	// a failure leaves the previous providers in place.
+	errHandler, err := newTelemetryErrorHandler(t.MeterProvider)
+	if err != nil {
+		_ = t.Shutdown(ctx)
+		return nil, err
+	}
+	otel.SetMeterProvider(t.MeterProvider)
+	currentServerMetrics.Store(t.server)
+	currentClientMetrics.Store(t.client)
+	otel.SetErrorHandler(errHandler)
+	if t.LoggerProvider != nil {
+		global.SetLoggerProvider(t.LoggerProvider)
+	}
+	traceSampling.install(cfg)
+	recordLogLevel(ctx)
+	otel.SetTracerProvider(t.TracerProvider)
+	otel.SetTextMapPropagator(t.Propagator)
+	if cfg.logHandler != nil {
+		var handler slog.Handler = NewLogHandler(cfg.logHandler)
+		if t.LoggerProvider != nil {
+			handler = teeHandler{handler, NewOTelLogHandler(t.LoggerProvider)}
+		}
+		slog.SetDefault(slog.New(handler))
+	}
+
+	spanExporters := len(cfg.extraSpans) + 1
+	if cfg.stdoutSpans && (cfg.endpoint != "" || cfg.spanExporter != nil) {
+		spanExporters++
+	}
+	if cfg.endpoint != "" {
+		slog.Info("OpenTelemetry initialized with OTLP exporters", "endpoint", cfg.endpoint, "insecure", cfg.insecure, "sampler", cfg.sampler.Description(), "span_exporters", spanExporters, "export", cfg.export)
+	} else {
+		slog.Info("OpenTelemetry initialized with stdout exporters", "sampler", cfg.sampler.Description(), "span_exporters", spanExporters, "export", cfg.export)
+	}
+	return t.Shutdown, nil
+}
+
+// newProviders builds the providers of cfg, sampling with sampler, without
+// installing them.
+func newProviders(ctx context.Context, serviceName string, cfg telemetryConfig, metricReader sdkmetric.Reader, sampler sdktrace.Sampler) (*Telemetry, error) {
+	res, err := newResource(ctx, serviceName, cfg)
+	if err != nil {
+		_ = metricReader.Shutdown(ctx)
+		return nil, err
+	}
+
+	traceExp, err := buildExporter(ctx, cfg, "traces", newTraceExporter, newPendingSpanExporter)
+	if err != nil {
+		_ = metricReader.Shutdown(ctx)
+		return nil, err
+	}
+	spanExps := append([]sdktrace.SpanExporter{traceExp}, cfg.extraSpans...)
+	if cfg.stdoutSpans && (cfg.endpoint != "" || cfg.spanExporter != nil) {
+		exp, err := newStdoutSpanExporter(cfg)
+		if err != nil {
+			_ = metricReader.Shutdown(ctx)
+			return nil, err
+		}
+		spanExps = append(spanExps, exp)
+	}
+
+	// Measurements made within a sampled span keep its trace and span IDs as
+	// an exemplar, one per histogram bucket, so a latency spike links to a
+	// trace that shows it. Unsampled measurements would link to nothing.
+	mpOpts := []sdkmetric.Option{
 		sdkmetric.WithReader(metricReader),
 		sdkmetric.WithResource(res),
-	)
-	otel.SetMeterProvider(mp)
+		sdkmetric.WithExemplarFilter(exemplar.TraceBasedFilter),
+		sdkmetric.WithView(cfg.metricViews()...),
+	}
+	if cfg.snapshot != nil {
+		mpOpts = append(mpOpts, sdkmetric.WithReader(cfg.snapshot.reader))
+	}
+	mp := sdkmetric.NewMeterProvider(mpOpts...)
 
-	traceExp, err := otlptracegrpc.New(
-		initCtx,
-		otlptracegrpc.WithInsecure(),
-		otlptracegrpc.WithEndpoint("localhost:4317"),
-		otlptracegrpc.WithDialOption(grpc.WithBlock()),
-	)
+	// Create the HTTP instruments now so misconfigurations surface at startup
+	// rather than silently recording into no-ops.
+	sm, err := newServerMetrics(mp)
+	if err != nil {
+		_ = mp.Shutdown(ctx)
+		return nil, err
+	}
+	cm, err := newClientMetrics(mp)
 	if err != nil {
+		_ = mp.Shutdown(ctx)
 		return nil, err
 	}
+	if err := registerBuildInfo(mp); err != nil {
+		_ = mp.Shutdown(ctx)
+		return nil, err
+	}
+	if cfg.runtime {
+		if err := registerRuntimeMetrics(mp); err != nil {
+			_ = mp.Shutdown(ctx)
+			return nil, err
+		}
+	}
+
+	// Records are queued for export in batches, so that logging never waits
+	// on the exporter.
+	var lp *sdklog.LoggerProvider
+	if cfg.logExport {
+		logExp, err := buildExporter(ctx, cfg, "logs", newLogExporter, newPendingLogExporter)
+		if err != nil {
+			_ = mp.Shutdown(ctx)
+			return nil, err
+		}
+		lp = sdklog.NewLoggerProvider(
+			sdklog.WithProcessor(sdklog.NewBatchProcessor(signalLogExporter{logExp})),
+			sdklog.WithResource(res),
+		)
+	}
 
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(traceExp),
+	tpOpts := []sdktrace.TracerProviderOption{
 		sdktrace.WithResource(res),
-	)
-	otel.SetTracerProvider(tp)
+		sdktrace.WithSampler(sampler),
+	}
+	var shutdownErrs spanShutdownErrors
+	for _, exp := range spanExps {
+		tpOpts = append(tpOpts, sdktrace.WithBatcher(shutdownErrs.wrap(signalSpanExporter{exp}), cfg.export.batcherOptions()...))
+	}
+	if cfg.traceBuffer != nil {
+		tpOpts = append(tpOpts, sdktrace.WithSpanProcessor(cfg.traceBuffer))
+	}
+	tp := sdktrace.NewTracerProvider(tpOpts...)
+
+	// Spans are flushed first: ending them may still log and record metrics.
+	// The stdout file, if any, is closed once all are done with it.
+	shutdowns := []Shutdown{shutdownErrs.shutdown(tp)}
+	if lp != nil {
+		shutdowns = append(shutdowns, lp.Shutdown)
+	}
+	shutdowns = append(shutdowns, mp.Shutdown, func(context.Context) error { return cfg.stdout.close() })
+	return &Telemetry{
+		MeterProvider:  mp,
+		TracerProvider: tp,
+		LoggerProvider: lp,
+		Propagator:     newPropagator(),
+		Shutdown:       CombineShutdowns(shutdowns...),
+		server:         sm,
+		client:         cm,
+	}, nil
+}
+
+func newStdoutSpanExporter(cfg telemetryConfig) (sdktrace.SpanExporter, error) {
+	if err := cfg.stdout.open(); err != nil {
+		return nil, err
+	}
+	opts := []stdouttrace.Option{stdouttrace.WithWriter(cfg.stdout)}
+	if cfg.stdout.prettyPrint() {
+		opts = append(opts, stdouttrace.WithPrettyPrint())
+	}
+	return stdouttrace.New(opts...)
+}
 
-	slog.Info("OpenTelemetry initialized with OTLP exporters")
+// newResource describes the service, with what the resource detectors
+// found and the version of the build. The service name argument always
+// takes precedence over a service.name passed via WithResourceAttributes or
+// detected, and WithServiceVersion over the build's.
+func newResource(ctx context.Context, serviceName string, cfg telemetryConfig) (*resource.Resource, error) {
+	attrs := detectResource(ctx, cfg)
+	if v := CurrentBuildInfo().Version; v != "" {
+		attrs = append(attrs, semconv.ServiceVersion(v))
+	}
+	attrs = append(attrs, cfg.resourceAttrs...)
+	attrs = append(attrs, semconv.ServiceName(serviceName))
+
+	return resource.New(
+		ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(attrs...),
+	)
+}
 
-	return func(ctx context.Context) error {
-		var firstErr error
+// newPropagator reads and writes W3C traceparent/tracestate and baggage
+// headers.
+func newPropagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
 
-		if err := tp.Shutdown(ctx); err != nil && firstErr == nil {
-			firstErr = err
+// newMetricExporter pushes metrics via OTLP gRPC when an endpoint is
+// configured, and to stdout otherwise.
+func newMetricExporter(ctx context.Context, cfg telemetryConfig) (sdkmetric.Exporter, error) {
+	if cfg.endpoint == "" {
+		if err := cfg.stdout.open(); err != nil {
+			return nil, err
 		}
-		if err := mp.Shutdown(ctx); err != nil && firstErr == nil {
-			firstErr = err
+		opts := []stdoutmetric.Option{stdoutmetric.WithWriter(cfg.stdout)}
+		if cfg.stdout.prettyPrint() {
+			opts = append(opts, stdoutmetric.WithPrettyPrint())
 		}
-		return firstErr
-	}, nil
+		return stdoutmetric.New(opts...)
+	}
+
+	initCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithDialOption(grpc.WithBlock())}
+	if strings.Contains(cfg.endpoint, "://") {
+		opts = append(opts, otlpmetricgrpc.WithEndpointURL(cfg.endpoint))
+	} else {
+		opts = append(opts, otlpmetricgrpc.WithEndpoint(cfg.endpoint))
+	}
+	if cfg.insecure {
+		opts = append(opts, otlpmetricgrpc.WithInsecure())
+	}
+	if len(cfg.headers) > 0 {
+		opts = append(opts, otlpmetricgrpc.WithHeaders(cfg.headers))
+	}
+	return otlpmetricgrpc.New(initCtx, opts...)
+}
+
+// newTraceExporter returns the exporter given with WithSpanExporter, or else
+// exports spans via OTLP gRPC when an endpoint is configured, and to stdout
+// otherwise.
+func newTraceExporter(ctx context.Context, cfg telemetryConfig) (sdktrace.SpanExporter, error) {
+	if cfg.spanExporter != nil {
+		return cfg.spanExporter, nil
+	}
+	if cfg.endpoint == "" {
+		return newStdoutSpanExporter(cfg)
+	}
+
+	initCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithDialOption(grpc.WithBlock())}
+	if strings.Contains(cfg.endpoint, "://") {
+		opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.endpoint))
+	} else {
+		opts = append(opts, otlptracegrpc.WithEndpoint(cfg.endpoint))
+	}
+	if cfg.insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	if len(cfg.headers) > 0 {
+		opts = append(opts, otlptracegrpc.WithHeaders(cfg.headers))
+	}
+	return otlptracegrpc.New(initCtx, opts...)
 }
 
+// instrumentationName is the scope of all instruments and spans created by
+// this package.
+const instrumentationName = "acai-server"
+
+// Meter returns the meter of this package's instrumentation scope, for
+// handlers recording their own metrics.
 func Meter() metric.Meter {
-	return otel.Meter("acai-server")
+	return otel.Meter(instrumentationName)
+}
+
+// Tracer returns the tracer of the same scope, for handlers starting their
+// own spans.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
 }
@@ -0,0 +1,407 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/log/global"
+	lognoop "go.opentelemetry.io/otel/log/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	collmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	colltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+)
+
+func TestNewResource_Options(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		opts []TelemetryOption
+		want map[attribute.Key]string
+	}{
+		{
+			name: "no options only sets service name",
+			want: map[attribute.Key]string{
+				semconv.ServiceNameKey: "acai-test",
+			},
+		},
+		{
+			name: "version, environment and extra attributes",
+			opts: []TelemetryOption{
+				WithServiceVersion("1.4.2"),
+				WithDeploymentEnvironment("staging"),
+				WithResourceAttributes(attribute.String("team", "travel")),
+			},
+			want: map[attribute.Key]string{
+				semconv.ServiceNameKey:           "acai-test",
+				semconv.ServiceVersionKey:        "1.4.2",
+				semconv.DeploymentEnvironmentKey: "staging",
+				"team":                           "travel",
+			},
+		},
+		{
+			name: "conflicting options use the last value",
+			opts: []TelemetryOption{
+				WithServiceVersion("1.0.0"),
+				WithDeploymentEnvironment("staging"),
+				WithResourceAttributes(semconv.ServiceVersion("1.1.0")),
+				WithServiceVersion("2.0.0"),
+				WithDeploymentEnvironment("production"),
+			},
+			want: map[attribute.Key]string{
+				semconv.ServiceNameKey:           "acai-test",
+				semconv.ServiceVersionKey:        "2.0.0",
+				semconv.DeploymentEnvironmentKey: "production",
+			},
+		},
+		{
+			name: "service name argument wins over resource attributes",
+			opts: []TelemetryOption{
+				WithResourceAttributes(semconv.ServiceName("other")),
+			},
+			want: map[attribute.Key]string{
+				semconv.ServiceNameKey: "acai-test",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := newResource(ctx, "acai-test", newTelemetryConfig(tt.opts))
+			if err != nil {
+				t.Fatalf("newResource() unexpected error: %v", err)
+			}
+
+			got := map[attribute.Key]string{}
+			for _, kv := range res.Attributes() {
+				got[kv.Key] = kv.Value.Emit()
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("attribute count mismatch: got %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("attribute %s mismatch: got %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestTelemetrySampler(t *testing.T) {
+	const spans = 2000
+
+	sampledParent := trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x01},
+		SpanID:     trace.SpanID{0x01},
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	}))
+	unsampledParent := trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x02},
+		SpanID:  trace.SpanID{0x02},
+		Remote:  true,
+	}))
+
+	tests := []struct {
+		name     string
+		opts     []TelemetryOption
+		parent   context.Context
+		min, max int
+	}{
+		{name: "default samples everything", parent: context.Background(), min: spans, max: spans},
+		{name: "ratio samples a fraction of new traces", opts: []TelemetryOption{WithSampleRatio(0.1)}, parent: context.Background(), min: 140, max: 260},
+		{name: "ratio follows a sampled parent", opts: []TelemetryOption{WithSampleRatio(0)}, parent: sampledParent, min: spans, max: spans},
+		{name: "ratio follows an unsampled parent", opts: []TelemetryOption{WithSampleRatio(1)}, parent: unsampledParent, min: 0, max: 0},
+		{name: "always ignores the parent", opts: []TelemetryOption{WithAlwaysSample()}, parent: unsampledParent, min: spans, max: spans},
+		{name: "never ignores the parent", opts: []TelemetryOption{WithNeverSample()}, parent: sampledParent, min: 0, max: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := tracetest.NewInMemoryExporter()
+			tp := sdktrace.NewTracerProvider(
+				sdktrace.WithSyncer(exp),
+				sdktrace.WithSampler(newTelemetryConfig(tt.opts).sampler),
+			)
+			t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+
+			for range spans {
+				_, span := tp.Tracer("test").Start(tt.parent, "op")
+				span.End()
+			}
+
+			if got := len(exp.GetSpans()); got < tt.min || got > tt.max {
+				t.Errorf("sampled span count mismatch: got %d, want between %d and %d", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+// fakeCollector is an OTLP gRPC endpoint keeping the metrics it receives.
+type fakeCollector struct {
+	collmetricpb.UnimplementedMetricsServiceServer
+
+	mu      sync.Mutex
+	metrics []*metricpb.ResourceMetrics
+}
+
+func (c *fakeCollector) Export(ctx context.Context, req *collmetricpb.ExportMetricsServiceRequest) (*collmetricpb.ExportMetricsServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metrics = append(c.metrics, req.GetResourceMetrics()...)
+	return &collmetricpb.ExportMetricsServiceResponse{}, nil
+}
+
+type fakeTraceService struct {
+	colltracepb.UnimplementedTraceServiceServer
+}
+
+func (fakeTraceService) Export(context.Context, *colltracepb.ExportTraceServiceRequest) (*colltracepb.ExportTraceServiceResponse, error) {
+	return &colltracepb.ExportTraceServiceResponse{}, nil
+}
+
+func startFakeCollector(t *testing.T) (*fakeCollector, string) {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	return serveFakeCollector(t, lis), lis.Addr().String()
+}
+
+func serveFakeCollector(t *testing.T, lis net.Listener) *fakeCollector {
+	t.Helper()
+
+	c := &fakeCollector{}
+	srv := grpc.NewServer()
+	collmetricpb.RegisterMetricsServiceServer(srv, c)
+	colltracepb.RegisterTraceServiceServer(srv, fakeTraceService{})
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return c
+}
+
+// restoreGlobals puts back the globals InitTelemetry replaces once t is
+// done.
+func restoreGlobals(t *testing.T) {
+	prevMP, prevTP, prevProp := otel.GetMeterProvider(), otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	prevLogger := slog.Default()
+	t.Cleanup(func() {
+		otel.SetMeterProvider(prevMP)
+		otel.SetTracerProvider(prevTP)
+		otel.SetTextMapPropagator(prevProp)
+		// The default global provider can't be set back: it'd delegate to itself.
+		global.SetLoggerProvider(lognoop.NewLoggerProvider())
+		slog.SetDefault(prevLogger)
+	})
+}
+
+func TestInitTelemetry_AdditionalSpanExporters(t *testing.T) {
+	restoreGlobals(t)
+	captureLogs(t)
+
+	var buf bytes.Buffer
+	primary, old, failing := keptSpans(), keptSpans(), keptSpans()
+	failing.err = errFailingShutdown
+	shutdown, err := InitTelemetry(context.Background(), "test",
+		WithMetricReader(sdkmetric.NewManualReader()),
+		WithSpanExporter(primary),
+		WithAdditionalSpanExporter(old),
+		WithAdditionalSpanExporter(failing),
+		WithStdoutSpans(),
+		WithStdoutWriter(&buf),
+	)
+	if err != nil {
+		t.Fatalf("InitTelemetry() unexpected error: %v", err)
+	}
+	for _, name := range []string{"search", "book"} {
+		_, span := otel.Tracer("test").Start(context.Background(), name)
+		span.End()
+	}
+
+	err = shutdown(context.Background())
+	if !errors.Is(err, errFailingShutdown) {
+		t.Errorf("shutdown error = %v, want the failing exporter's", err)
+	}
+
+	names := func(spans tracetest.SpanStubs) []string {
+		var names []string
+		for _, s := range spans {
+			names = append(names, s.Name+"/"+s.SpanContext.SpanID().String())
+		}
+		return names
+	}
+	want := names(primary.GetSpans())
+	if len(want) != 2 {
+		t.Fatalf("primary exporter got %v, want 2 spans", want)
+	}
+	for name, exp := range map[string]*keptSpansExporter{"additional": old, "failing": failing} {
+		if got := names(exp.GetSpans()); !slices.Equal(got, want) {
+			t.Errorf("%s exporter got %v, want %v", name, got, want)
+		}
+	}
+	if !strings.Contains(buf.String(), `"book"`) {
+		t.Errorf("stdout spans missing:\n%s", buf.String())
+	}
+}
+
+var errFailingShutdown = errors.New("backend gone")
+
+// keptSpansExporter keeps its spans on Shutdown, failing with err.
+type keptSpansExporter struct {
+	*tracetest.InMemoryExporter
+	err error
+}
+
+func keptSpans() *keptSpansExporter {
+	return &keptSpansExporter{InMemoryExporter: tracetest.NewInMemoryExporter()}
+}
+
+func (e *keptSpansExporter) Shutdown(context.Context) error { return e.err }
+
+func TestInitTelemetry_Exemplars(t *testing.T) {
+	collector, addr := startFakeCollector(t)
+
+	restoreGlobals(t)
+	shutdown, err := InitTelemetry(context.Background(), "test", WithOTLPEndpoint(addr), WithOTLPInsecure(true))
+	if err != nil {
+		t.Fatalf("InitTelemetry() unexpected error: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	var sampledTrace string
+	mux.HandleFunc("GET /sampled", func(w http.ResponseWriter, r *http.Request) {
+		sampledTrace = trace.SpanContextFromContext(r.Context()).TraceID().String()
+	})
+	mux.HandleFunc("GET /unsampled", func(w http.ResponseWriter, r *http.Request) {})
+	handler := TracingMiddleware(MetricsMiddleware(mux))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/sampled", nil))
+	req := httptest.NewRequest(http.MethodGet, "/unsampled", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	exemplars := map[string][]*metricpb.Exemplar{}
+	for _, rm := range collector.metrics {
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				if m.GetName() != "http.server.request.duration" {
+					continue
+				}
+				for _, dp := range m.GetHistogram().GetDataPoints() {
+					for _, kv := range dp.GetAttributes() {
+						if kv.GetKey() == "http.route" {
+							route := kv.GetValue().GetStringValue()
+							exemplars[route] = append(exemplars[route], dp.GetExemplars()...)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if len(exemplars["/sampled"]) != 1 {
+		t.Fatalf("expected one exemplar for the sampled request, got %d", len(exemplars["/sampled"]))
+	}
+	if got := hex.EncodeToString(exemplars["/sampled"][0].GetTraceId()); got != sampledTrace {
+		t.Errorf("exemplar trace ID mismatch: got %s, want %s", got, sampledTrace)
+	}
+	if n := len(exemplars["/unsampled"]); n != 0 {
+		t.Errorf("expected no exemplars for the unsampled request, got %d", n)
+	}
+}
+
+func TestNewTelemetry_Independent(t *testing.T) {
+	captureLogs(t)
+	prevMP, prevTP := otel.GetMeterProvider(), otel.GetTracerProvider()
+
+	type instance struct {
+		name    string
+		n       int // requests made
+		tel     *Telemetry
+		reader  *sdkmetric.ManualReader
+		spans   *tracetest.InMemoryExporter
+		handler http.Handler
+	}
+	newInstance := func(name string, n int) instance {
+		reader, spans := sdkmetric.NewManualReader(), tracetest.NewInMemoryExporter()
+		tel, err := NewTelemetry(context.Background(), name, WithMetricReader(reader), WithSpanExporter(spans))
+		if err != nil {
+			t.Fatalf("NewTelemetry() unexpected error: %v", err)
+		}
+		t.Cleanup(func() { _ = tel.Shutdown(context.Background()) })
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /"+name, func(w http.ResponseWriter, r *http.Request) {
+			_, span := tel.Tracer().Start(r.Context(), "query")
+			span.End()
+		})
+		return instance{name, n, tel, reader, spans, Chain(
+			NewTracingMiddleware(tel),
+			NewMetricsMiddleware(WithMetricsTelemetry(tel), WithRouteResolver(MuxRoute(mux))),
+		)(mux)}
+	}
+	instances := []instance{newInstance("search", 3), newInstance("booking", 5)}
+
+	var wg sync.WaitGroup
+	for _, inst := range instances {
+		for range inst.n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				inst.handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/"+inst.name, nil))
+			}()
+		}
+	}
+	wg.Wait()
+
+	for _, inst := range instances {
+		n := inst.n
+		points := collectSum(t, inst.reader, "http.server.requests")
+		if len(points) != 1 || points[0].Value != int64(n) {
+			t.Errorf("%s: requests = %+v, want %d", inst.name, points, n)
+		} else if route, _ := points[0].Attributes.Value("http.route"); route.AsString() != "/"+inst.name {
+			t.Errorf("%s: recorded route %s", inst.name, route.AsString())
+		}
+		if err := inst.tel.TracerProvider.ForceFlush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		spans := inst.spans.GetSpans()
+		if len(spans) != 2*n {
+			t.Errorf("%s: got %d spans, want %d", inst.name, len(spans), 2*n)
+		}
+		for _, s := range spans {
+			if name, _ := s.Resource.Set().Value(semconv.ServiceNameKey); name.AsString() != inst.name {
+				t.Errorf("%s: span %q from service %s", inst.name, s.Name, name.AsString())
+			}
+		}
+	}
+	if otel.GetMeterProvider() != prevMP || otel.GetTracerProvider() != prevTP {
+		t.Error("NewTelemetry replaced the global providers")
+	}
+}
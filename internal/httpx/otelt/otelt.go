@@ -0,0 +1,182 @@
+// Package otelt installs in-memory OpenTelemetry providers for tests and
+// offers helpers to assert on what was recorded.
+//
+// The providers are installed globally, as instrumented code reaches them
+// through the otel package, so tests using otelt cannot run in parallel:
+// calling t.Parallel after Install panics, like it does after t.Setenv.
+// Subtests may install their own providers; the previous ones come back when
+// they finish.
+package otelt
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// globalsEnv is set for the duration of an installation, only so that the
+// testing package rejects t.Parallel.
+const globalsEnv = "OTELT_GLOBAL_PROVIDERS"
+
+var (
+	mu       sync.Mutex
+	reader   *sdkmetric.ManualReader
+	exporter *tracetest.InMemoryExporter
+)
+
+// Install installs both a meter and a tracer provider for the duration of
+// the test. See InstallMetrics and InstallTracing.
+func Install(t testing.TB, opts ...sdktrace.TracerProviderOption) (*sdkmetric.ManualReader, *tracetest.InMemoryExporter) {
+	t.Helper()
+	return InstallMetrics(t), InstallTracing(t, opts...)
+}
+
+// InstallMetrics installs a global meter provider collecting through the
+// returned manual reader for the duration of the test.
+func InstallMetrics(t testing.TB) *sdkmetric.ManualReader {
+	t.Helper()
+	t.Setenv(globalsEnv, "1")
+
+	r := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(r))
+
+	mu.Lock()
+	prevReader, prevMP := reader, otel.GetMeterProvider()
+	reader = r
+	mu.Unlock()
+	otel.SetMeterProvider(mp)
+
+	t.Cleanup(func() {
+		otel.SetMeterProvider(prevMP)
+		mu.Lock()
+		reader = prevReader
+		mu.Unlock()
+		_ = mp.Shutdown(context.Background())
+	})
+	return r
+}
+
+// InstallTracing installs a global tracer provider exporting finished spans
+// synchronously to the returned exporter, along with the W3C trace context
+// and baggage propagator, for the duration of the test. opts configure the
+// provider, e.g. its sampler.
+func InstallTracing(t testing.TB, opts ...sdktrace.TracerProviderOption) *tracetest.InMemoryExporter {
+	t.Helper()
+	t.Setenv(globalsEnv, "1")
+
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(append([]sdktrace.TracerProviderOption{sdktrace.WithSyncer(exp)}, opts...)...)
+
+	mu.Lock()
+	prevExp, prevTP, prevProp := exporter, otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	exporter = exp
+	mu.Unlock()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevTP)
+		otel.SetTextMapPropagator(prevProp)
+		mu.Lock()
+		exporter = prevExp
+		mu.Unlock()
+		_ = tp.Shutdown(context.Background())
+	})
+	return exp
+}
+
+// Spans returns the spans finished so far under the installed tracer
+// provider.
+func Spans(t testing.TB) []sdktrace.ReadOnlySpan {
+	t.Helper()
+
+	mu.Lock()
+	exp := exporter
+	mu.Unlock()
+	if exp == nil {
+		t.Fatal("otelt: no tracer provider installed")
+	}
+	return exp.GetSpans().Snapshots()
+}
+
+// Metric collects from the installed meter provider and returns the named
+// metric.
+func Metric(t testing.TB, name string) (metricdata.Metrics, bool) {
+	t.Helper()
+
+	mu.Lock()
+	r := reader
+	mu.Unlock()
+	if r == nil {
+		t.Fatal("otelt: no meter provider installed")
+	}
+	return MetricFrom(t, r, name)
+}
+
+// MetricFrom collects from r, which need not come from InstallMetrics, and
+// returns the named metric.
+func MetricFrom(t testing.TB, r sdkmetric.Reader, name string) (metricdata.Metrics, bool) {
+	t.Helper()
+
+	var rm metricdata.ResourceMetrics
+	if err := r.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("otelt: collect metrics: %v", err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				return m, true
+			}
+		}
+	}
+	return metricdata.Metrics{}, false
+}
+
+// CounterValue returns the sum of the named int64 counter or up/down
+// counter over the data points carrying all of attrs, other attributes
+// being ignored. A metric that wasn't recorded counts as zero.
+func CounterValue(t testing.TB, name string, attrs []attribute.KeyValue) int64 {
+	t.Helper()
+
+	m, ok := Metric(t, name)
+	if !ok {
+		return 0
+	}
+	sum, ok := m.Data.(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("otelt: %s is a %T, not an int64 counter", name, m.Data)
+	}
+	var total int64
+	for _, dp := range sum.DataPoints {
+		if hasAll(dp.Attributes, attrs) {
+			total += dp.Value
+		}
+	}
+	return total
+}
+
+// RequireCounterValue fails the test unless CounterValue returns want.
+func RequireCounterValue(t testing.TB, name string, attrs []attribute.KeyValue, want int64) {
+	t.Helper()
+
+	if got := CounterValue(t, name, attrs); got != want {
+		t.Fatalf("%s%v = %d, want %d", name, attrs, got, want)
+	}
+}
+
+func hasAll(set attribute.Set, attrs []attribute.KeyValue) bool {
+	for _, kv := range attrs {
+		if v, ok := set.Value(kv.Key); !ok || v != kv.Value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package otelt_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+func TestInstall(t *testing.T) {
+	prevMP, prevTP := otel.GetMeterProvider(), otel.GetTracerProvider()
+
+	t.Run("records", func(t *testing.T) {
+		otelt.Install(t)
+
+		counter, err := otel.Meter("test").Int64Counter("requests")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		counter.Add(ctx, 2, metric.WithAttributes(attribute.String("route", "/a"), attribute.Int("status", 200)))
+		counter.Add(ctx, 3, metric.WithAttributes(attribute.String("route", "/a"), attribute.Int("status", 500)))
+		counter.Add(ctx, 7, metric.WithAttributes(attribute.String("route", "/b"), attribute.Int("status", 200)))
+
+		otelt.RequireCounterValue(t, "requests", nil, 12)
+		otelt.RequireCounterValue(t, "requests", []attribute.KeyValue{attribute.String("route", "/a")}, 5)
+		otelt.RequireCounterValue(t, "requests", []attribute.KeyValue{attribute.String("route", "/a"), attribute.Int("status", 500)}, 3)
+		otelt.RequireCounterValue(t, "missing", nil, 0)
+
+		_, span := otel.Tracer("test").Start(ctx, "op")
+		span.End()
+		if spans := otelt.Spans(t); len(spans) != 1 || spans[0].Name() != "op" {
+			t.Errorf("unexpected spans: %v", spans)
+		}
+
+		t.Run("nested install", func(t *testing.T) {
+			otelt.InstallMetrics(t)
+			otelt.RequireCounterValue(t, "requests", nil, 0)
+		})
+		otelt.RequireCounterValue(t, "requests", nil, 12)
+	})
+
+	if otel.GetMeterProvider() != prevMP || otel.GetTracerProvider() != prevTP {
+		t.Error("previous providers were not restored")
+	}
+}
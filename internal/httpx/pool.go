@@ -0,0 +1,124 @@
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// WithPoolMetrics records the connections the transport opens and closes,
+// and how long requests wait for one, by host: when a supplier slows down,
+// requests queue for the connections MaxConnsPerHost allows. The
+// connections are counted by dialing through a clone of the base
+// transport, which must be an *http.Transport; base itself is left alone,
+// and Transport.CloseIdleConnections closes the clone's idle connections.
+// Other transports get the wait metrics only.
+func WithPoolMetrics() TransportOption {
+	return func(t *Transport) { t.poolMetrics = true }
+}
+
+// countConnections returns a clone of base dialing counted connections.
+func countConnections(base http.RoundTripper) http.RoundTripper {
+	tr, ok := base.(*http.Transport)
+	if !ok {
+		slog.Warn("Outbound connections not counted: not an *http.Transport", "transport", fmt.Sprintf("%T", base))
+		return base
+	}
+	tr = tr.Clone()
+	dial := tr.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	tr.DialContext = countingDial(dial)
+	if tr.DialTLSContext != nil {
+		tr.DialTLSContext = countingDial(tr.DialTLSContext)
+	}
+	return tr
+}
+
+// WithForceHTTP2 speaks HTTP/2 to every upstream, over TLS and in cleartext
+// (h2c) alike, for internal services multiplexing requests on one
+// connection. Upstreams not speaking HTTP/2 fail. Like WithPoolMetrics, it
+// applies to a clone of an *http.Transport base only.
+func WithForceHTTP2() TransportOption {
+	return func(t *Transport) { t.http2 = true }
+}
+
+// forceHTTP2 returns a clone of base speaking HTTP/2 only.
+func forceHTTP2(base http.RoundTripper) http.RoundTripper {
+	tr, ok := base.(*http.Transport)
+	if !ok {
+		slog.Warn("HTTP/2 not forced: not an *http.Transport", "transport", fmt.Sprintf("%T", base))
+		return base
+	}
+	tr = tr.Clone()
+	tr.Protocols = new(http.Protocols)
+	tr.Protocols.SetHTTP2(true)
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	return tr
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func countingDial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		host, _, splitErr := net.SplitHostPort(addr)
+		if splitErr != nil {
+			host = addr
+		}
+		cm := loadClientMetrics()
+		attrs := metric.WithAttributeSet(attribute.NewSet(attribute.String("net.peer.name", host)))
+		cm.connsOpened.Add(ctx, 1, attrs)
+		return &countedConn{Conn: conn, closed: func() { cm.connsClosed.Add(context.Background(), 1, attrs) }}, nil
+	}
+}
+
+// countedConn counts its first Close.
+type countedConn struct {
+	net.Conn
+	once   sync.Once
+	closed func()
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(c.closed)
+	return c.Conn.Close()
+}
+
+// newPoolTrace records how long the request waits for a connection, from
+// asking the pool for one until it gets one freed by another request or
+// newly dialed. Idle connections, handed over at once, would only drown
+// the waits in zeros.
+func newPoolTrace(ctx context.Context, cm *clientMetrics, hostAttrs metric.MeasurementOption) *httptrace.ClientTrace {
+	var mu sync.Mutex
+	var asked time.Time
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			mu.Lock()
+			defer mu.Unlock()
+			asked = time.Now()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused && info.WasIdle {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !asked.IsZero() {
+				cm.connWait.Record(ctx, time.Since(asked).Seconds(), hostAttrs)
+			}
+		},
+	}
+}
@@ -0,0 +1,101 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestTransport_PoolMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer srv.Close()
+	reader := otelt.InstallMetrics(t)
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.MaxConnsPerHost = 1
+	transport := NewTransport(base, WithPoolMetrics())
+	client := &http.Client{Transport: transport}
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Errorf("Get: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	// Reusing the idle connection is no wait.
+	if resp, err := client.Get(srv.URL); err == nil {
+		resp.Body.Close()
+	}
+	client.CloseIdleConnections()
+
+	m, ok := collectMetric(t, reader, "http.client.connection.wait")
+	if !ok {
+		t.Fatal("http.client.connection.wait not recorded")
+	}
+	dps := m.Data.(metricdata.Histogram[float64]).DataPoints
+	if len(dps) != 1 || dps[0].Count != 2 {
+		t.Fatalf("connection waits %+v, want 2 points of one host", dps)
+	}
+	if longest, _ := dps[0].Max.Value(); longest < 0.04 {
+		t.Errorf("connection waits %+v, want 2 with one waiting for the other's 50ms request", dps)
+	}
+	if host, _ := dps[0].Attributes.Value("net.peer.name"); host.AsString() != "127.0.0.1" {
+		t.Errorf("net.peer.name = %q, want 127.0.0.1", host.AsString())
+	}
+
+	for name, want := range map[string]int64{"http.client.connections.opened": 1, "http.client.connections.closed": 1} {
+		if dps := collectSum(t, reader, name); len(dps) != 1 || dps[0].Value != want {
+			t.Errorf("%s = %+v, want %d for the single connection allowed", name, dps, want)
+		}
+	}
+	if dps := collectSum(t, reader, "http.client.active_requests"); len(dps) != 1 || dps[0].Value != 0 {
+		t.Errorf("http.client.active_requests = %+v, want back to 0", dps)
+	}
+	if base.DialContext == nil || transport.base == http.RoundTripper(base) {
+		t.Error("base transport modified rather than cloned")
+	}
+}
+
+func TestTransport_ActiveUntilBodyClosed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("offers"))
+	}))
+	defer srv.Close()
+	reader := otelt.InstallMetrics(t)
+	client := &http.Client{Transport: NewTransport(nil)}
+
+	active := func() int64 {
+		var n int64
+		for _, dp := range collectSum(t, reader, "http.client.active_requests") {
+			n += dp.Value
+		}
+		return n
+	}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got := active(); got != 1 {
+		t.Errorf("active requests while the body is unread: got %d, want 1", got)
+	}
+	resp.Body.Close()
+	resp.Body.Close()
+	if got := active(); got != 0 {
+		t.Errorf("active requests once the body is closed: got %d, want 0", got)
+	}
+}
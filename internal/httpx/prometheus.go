@@ -0,0 +1,76 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+)
+
+// InitPrometheus is like InitTelemetry, but instead of pushing metrics it
+// exposes them through the returned handler, meant to be mounted at /metrics.
+// Traces are still exported as configured by opts. Histograms keep explicit
+// buckets, so that text scrapes get them, even with WithExponentialLatency.
+func InitPrometheus(ctx context.Context, serviceName string, opts ...TelemetryOption) (http.Handler, Shutdown, error) {
+	cfg := newTelemetryConfig(opts)
+	cfg.explicitHists = true
+
+	reg := prometheus.NewRegistry()
+	tracked := &trackingRegisterer{Registerer: reg}
+
+	exp, err := otelprom.New(otelprom.WithRegisterer(tracked))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	shutdown, err := initProviders(ctx, serviceName, cfg, exp)
+	if err != nil {
+		tracked.unregisterAll()
+		return nil, nil, err
+	}
+
+	handler := promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+	return handler, func(ctx context.Context) error {
+		defer tracked.unregisterAll()
+		return shutdown(ctx)
+	}, nil
+}
+
+// trackingRegisterer remembers the collectors registered through it so they
+// can be unregistered on shutdown.
+type trackingRegisterer struct {
+	prometheus.Registerer
+
+	mu         sync.Mutex
+	collectors []prometheus.Collector
+}
+
+func (r *trackingRegisterer) Register(c prometheus.Collector) error {
+	if err := r.Registerer.Register(c); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *trackingRegisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (r *trackingRegisterer) unregisterAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.collectors {
+		r.Registerer.Unregister(c)
+	}
+	r.collectors = nil
+}
@@ -0,0 +1,128 @@
+package httpx_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestInitPrometheus_ExposesRequestMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	metrics, shutdown, err := httpx.InitPrometheus(ctx, "acai-test")
+	if err != nil {
+		t.Fatalf("InitPrometheus() unexpected error: %v", err)
+	}
+	defer func() {
+		if err := shutdown(ctx); err != nil {
+			t.Errorf("shutdown() unexpected error: %v", err)
+		}
+	}()
+
+	app := httpx.MetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/trips", nil))
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("scrape status mismatch: got %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`http_server_requests_total{`,
+		`http_method="GET"`,
+		`http_status_code="418"`,
+		`http_server_errors_total{`,
+		`http_server_request_duration_seconds_bucket{`,
+		`http_server_duration_ms_bucket{`,
+		`le="2.5"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("scrape output missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestInitPrometheus_AppliesViews(t *testing.T) {
+	ctx := context.Background()
+
+	metrics, shutdown, err := httpx.InitPrometheus(ctx, "acai-test",
+		httpx.WithRenamedInstrument("http.server.requests", "http.server.handled"),
+		httpx.WithHistogramBuckets("http.server.request.duration", 0.5),
+	)
+	if err != nil {
+		t.Fatalf("InitPrometheus() unexpected error: %v", err)
+	}
+	defer func() { _ = shutdown(ctx) }()
+
+	httpx.MetricsMiddleware(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, "http_server_handled_total{") || strings.Contains(body, "http_server_requests_total{") {
+		t.Errorf("rename not applied:\n%s", body)
+	}
+	var buckets []string
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "http_server_request_duration_seconds_bucket{") {
+			buckets = append(buckets, line)
+		}
+	}
+	duration := strings.Join(buckets, "\n")
+	if !strings.Contains(duration, `le="0.5"`) || strings.Contains(duration, `le="0.25"`) {
+		t.Errorf("buckets not applied:\n%s", duration)
+	}
+}
+
+func TestInitPrometheus_ExponentialLatency(t *testing.T) {
+	ctx := context.Background()
+
+	metrics, shutdown, err := httpx.InitPrometheus(ctx, "acai-test", httpx.WithExponentialLatency(0))
+	if err != nil {
+		t.Fatalf("InitPrometheus() unexpected error: %v", err)
+	}
+	defer func() { _ = shutdown(ctx) }()
+
+	httpx.MetricsMiddleware(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `http_server_request_duration_seconds_bucket{`) {
+		t.Errorf("text scrape has no duration buckets:\n%s", rec.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", string(expfmt.NewFormat(expfmt.TypeProtoDelim)))
+	rec = httptest.NewRecorder()
+	metrics.ServeHTTP(rec, req)
+
+	dec := expfmt.NewDecoder(rec.Body, expfmt.ResponseFormat(rec.Header()))
+	for {
+		var mf dto.MetricFamily
+		if err := dec.Decode(&mf); err != nil {
+			t.Fatalf("duration histogram not found: %v", err)
+		}
+		// Protobuf scrapes keep the dots of UTF-8 metric names.
+		if strings.ReplaceAll(mf.GetName(), ".", "_") != "http_server_request_duration_seconds" {
+			continue
+		}
+		h := mf.GetMetric()[0].GetHistogram()
+		if h.Schema != nil || len(h.GetBucket()) == 0 {
+			t.Errorf("expected explicit buckets, got schema %v and %d buckets", h.Schema, len(h.GetBucket()))
+		}
+		if h.GetSampleCount() != 1 {
+			t.Errorf("sample count mismatch: got %d", h.GetSampleCount())
+		}
+		return
+	}
+}
@@ -0,0 +1,167 @@
+package httpx
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// APIKeyHeader is the header API clients send their key in, as
+// APIKeyAuthenticator(APIKeyHeader, keys) expects it.
+const APIKeyHeader = "X-API-Key"
+
+// RateLimitKey returns the key a request is rate limited under and the kind
+// of key it is ("ip", "api_key", ...). Only the kind is recorded in metrics.
+type RateLimitKey func(r *http.Request) (key, kind string)
+
+// ClientIPKey limits by ClientIP, so by the real client when
+// ClientIPMiddleware runs first and by the direct peer otherwise.
+func ClientIPKey(r *http.Request) (string, string) {
+	return ClientIP(r), "ip"
+}
+
+// DefaultRateLimitKey limits by the principal AuthMiddleware authenticated,
+// of the kind of its scheme ("api_key", "bearer", ...), and by client IP
+// otherwise: before authentication, credentials are only claims anyone can
+// make up to get a bucket of their own. Principals are only known to a
+// RateLimitMiddleware that AuthMiddleware wraps.
+func DefaultRateLimitKey(r *http.Request) (string, string) {
+	if st := stateFromContext(r.Context()); st != nil {
+		st.mu.Lock()
+		p := st.principal
+		st.mu.Unlock()
+		if p.ID != "" {
+			return "principal:" + p.Scheme + ":" + p.ID, p.Scheme
+		}
+	}
+	return ClientIPKey(r)
+}
+
+// RateLimitOption configures RateLimitMiddleware.
+type RateLimitOption func(*rateLimiter)
+
+// WithRateLimitKey sets how requests are grouped. Defaults to
+// DefaultRateLimitKey.
+func WithRateLimitKey(key RateLimitKey) RateLimitOption {
+	return func(l *rateLimiter) { l.key = key }
+}
+
+// WithRateLimitClients bounds how many clients are tracked. The least
+// recently seen client is forgotten first, which hands it a full burst if it
+// comes back. Defaults to 10000.
+func WithRateLimitClients(n int) RateLimitOption {
+	return func(l *rateLimiter) { l.capacity = max(n, 1) }
+}
+
+// RateLimitMiddleware allows each client rate requests per second on
+// average, in bursts of up to burst requests. Routes whose RouteConfig sets
+// a RateLimit get theirs instead, counted apart from the other requests.
+// Requests over the limit get a 429 with Retry-After and are counted in
+// http.server.throttled.
+func RateLimitMiddleware(rate float64, burst int, opts ...RateLimitOption) func(http.Handler) http.Handler {
+	shared := newRateLimiter(rate, burst, opts)
+	var mu sync.Mutex
+	routes := map[string]*rateLimiter{} // bounded by the routes registered
+	limiter := func(r *http.Request) *rateLimiter {
+		cfg := routeConfig(r, nil)
+		if cfg.RateLimit == nil {
+			return shared
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		l, ok := routes[cfg.pattern]
+		if !ok {
+			l = newRateLimiter(cfg.RateLimit.Rate, cfg.RateLimit.Burst, opts)
+			routes[cfg.pattern] = l
+		}
+		return l
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			l := limiter(r)
+			key, kind := l.key(r)
+			if wait, ok := l.allow(key); !ok {
+				loadServerMetrics().throttled.Add(r.Context(), 1,
+					metric.WithAttributes(attribute.String("ratelimit.key_type", kind)))
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func newRateLimiter(rate float64, burst int, opts []RateLimitOption) *rateLimiter {
+	l := &rateLimiter{
+		rate:     rate,
+		burst:    float64(max(burst, 1)),
+		key:      DefaultRateLimitKey,
+		capacity: 10000,
+		now:      time.Now,
+		buckets:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+type rateLimiter struct {
+	rate     float64
+	burst    float64
+	key      RateLimitKey
+	capacity int
+	now      func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	lru     *list.List // most recently seen first
+}
+
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from key's bucket, or reports how long until one is
+// available.
+func (l *rateLimiter) allow(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var b *tokenBucket
+	if el, ok := l.buckets[key]; ok {
+		l.lru.MoveToFront(el)
+		b = el.Value.(*tokenBucket)
+		b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+	} else {
+		b = &tokenBucket{key: key, tokens: l.burst, last: now}
+		l.buckets[key] = l.lru.PushFront(b)
+		if l.lru.Len() > l.capacity {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*tokenBucket).key)
+		}
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	if l.rate <= 0 {
+		return time.Hour, false
+	}
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second)), false
+}
@@ -0,0 +1,141 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+)
+
+func newTestLimiter(t *testing.T, rate float64, burst int, opts ...RateLimitOption) (*rateLimiter, *time.Time) {
+	t.Helper()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var l *rateLimiter
+	opts = append(opts, func(rl *rateLimiter) {
+		rl.now = func() time.Time { return now }
+		l = rl
+	})
+	RateLimitMiddleware(rate, burst, opts...)
+	return l, &now
+}
+
+func TestRateLimiter_Burst(t *testing.T) {
+	l, now := newTestLimiter(t, 1, 3)
+
+	for i := range 3 {
+		if _, ok := l.allow("a"); !ok {
+			t.Fatalf("request %d within burst was limited", i)
+		}
+	}
+	wait, ok := l.allow("a")
+	if ok || wait != time.Second {
+		t.Errorf("over burst: got %v, %v, want limited for 1s", wait, ok)
+	}
+	if _, ok := l.allow("b"); !ok {
+		t.Error("other client was limited")
+	}
+
+	*now = now.Add(time.Second)
+	if _, ok := l.allow("a"); !ok {
+		t.Error("refilled token was not granted")
+	}
+	if _, ok := l.allow("a"); ok {
+		t.Error("only one token should have been refilled")
+	}
+}
+
+func TestRateLimiter_EvictsLeastRecentlySeen(t *testing.T) {
+	l, _ := newTestLimiter(t, 0.001, 1, WithRateLimitClients(2))
+
+	l.allow("a")
+	l.allow("b")
+	l.allow("a") // a is now the most recent; b the oldest
+	l.allow("c") // evicts b
+
+	if len(l.buckets) != 2 || l.lru.Len() != 2 {
+		t.Fatalf("tracked clients mismatch: got %d, want 2", len(l.buckets))
+	}
+	if _, ok := l.buckets["b"]; ok {
+		t.Error("least recently seen client was not evicted")
+	}
+	if _, ok := l.allow("b"); !ok {
+		t.Error("evicted client should start with a full burst")
+	}
+	if _, ok := l.allow("c"); ok {
+		t.Error("recently seen client lost its state")
+	}
+}
+
+func TestRateLimitMiddleware_ThrottledRequestsAreRecorded(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	auth := AuthMiddleware(APIKeyAuthenticator(APIKeyHeader, map[string]string{"secret-key": "partner-1"}))
+	handler := MetricsMiddleware(auth(RateLimitMiddleware(0.001, 1)(http.NotFoundHandler())))
+	var last *httptest.ResponseRecorder
+	for range 2 {
+		req := httptest.NewRequest(http.MethodGet, "/search", nil)
+		req.Header.Set(APIKeyHeader, "secret-key")
+		last = httptest.NewRecorder()
+		handler.ServeHTTP(last, req)
+	}
+
+	if last.Code != http.StatusTooManyRequests {
+		t.Errorf("status mismatch: got %d, want %d", last.Code, http.StatusTooManyRequests)
+	}
+	if last.Header().Get("Retry-After") == "" {
+		t.Error("missing Retry-After header")
+	}
+
+	statuses := map[int64]int64{}
+	for _, dp := range collectSum(t, reader, "http.server.requests") {
+		status, _ := dp.Attributes.Value("http.status_code")
+		statuses[status.AsInt64()] += dp.Value
+	}
+	if statuses[http.StatusTooManyRequests] != 1 {
+		t.Errorf("429 requests mismatch: got %v", statuses)
+	}
+
+	dps := collectSum(t, reader, "http.server.throttled")
+	if len(dps) != 1 || dps[0].Value != 1 {
+		t.Fatalf("throttled data points mismatch: got %+v", dps)
+	}
+	if kind, _ := dps[0].Attributes.Value("ratelimit.key_type"); kind.AsString() != "api_key" {
+		t.Errorf("ratelimit.key_type mismatch: got %q, want %q", kind.AsString(), "api_key")
+	}
+	if dps[0].Attributes.Len() != 1 {
+		t.Errorf("unexpected attributes, the raw key must not be recorded: %v", dps[0].Attributes)
+	}
+}
+
+func TestDefaultRateLimitKey(t *testing.T) {
+	auth := AuthMiddleware(APIKeyAuthenticator(APIKeyHeader, map[string]string{"secret-key": "partner-1"}))
+	var key, kind string
+	record := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { key, kind = DefaultRateLimitKey(r) })
+
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		apiKey   string
+		wantKey  string
+		wantKind string
+	}{
+		{name: "authenticated", handler: auth(record), apiKey: "secret-key", wantKey: "principal:api_key:partner-1", wantKind: "api_key"},
+		{name: "not authenticated yet", handler: record, apiKey: "secret-key", wantKey: "192.0.2.1", wantKind: "ip"},
+		{name: "made up key", handler: record, apiKey: "guess-1", wantKey: "192.0.2.1", wantKind: "ip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, kind = "", ""
+			req := httptest.NewRequest(http.MethodGet, "/search", nil)
+			req.Header.Set(APIKeyHeader, tt.apiKey)
+			r, _ := withRequestState(req)
+			tt.handler.ServeHTTP(httptest.NewRecorder(), r)
+			if key != tt.wantKey || kind != tt.wantKind {
+				t.Errorf("got key %q of kind %q, want %q of kind %q", key, kind, tt.wantKey, tt.wantKind)
+			}
+		})
+	}
+}
@@ -4,24 +4,80 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 )
 
+// Recovery returns RecoverMiddleware in the form expected by routers' Use.
 func Recovery() func(handler http.Handler) http.Handler {
-	return func(handler http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			defer func() {
-				if v := recover(); v != nil {
-					err, ok := v.(error)
-					if !ok {
-						err = fmt.Errorf("%v", v)
-					}
-
-					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-					slog.ErrorContext(r.Context(), "HTTP handler recovered from panic", "error", err)
+	return RecoverMiddleware
+}
+
+// RecoverMiddleware turns a panicking handler into a 500 response and logs the
+// panic with its stack. http.ErrAbortHandler is re-panicked so net/http can
+// abort the response as usual.
+//
+// The metrics and tracing middlewares record panics themselves, whichever
+// side of this middleware they sit on. When no metrics middleware is in the
+// chain the panic is still counted in http.server.errors. Panics are also
+// counted in http.server.error_fingerprints by a fingerprint of their type
+// and the code that raised them, the same for every panic of one bug, also
+// logged and set on the span as error.fingerprint.
+func RecoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw, w := captureStatus(w)
+		r, st := withRequestState(r)
+
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			st.notePanic(v)
+			_, stack, _ := st.panicInfo()
+			st.mu.Lock()
+			fp := st.fingerprint
+			st.mu.Unlock()
+			slog.ErrorContext(r.Context(), "HTTP handler recovered from panic",
+				"error", panicError(v), "error_fingerprint", fp, "request_id", RequestIDFromContext(r.Context()), "stack", string(stack))
+			recordErrorFingerprint(r.Context(), fp, "panic")
+
+			if !sw.wroteHeader {
+				msg := "Internal Server Error"
+				if id := RequestIDFromContext(r.Context()); id != "" {
+					msg += " (request ID " + id + ")"
 				}
-			}()
+				http.Error(w, msg, http.StatusInternalServerError)
+			}
+
+			st.mu.Lock()
+			counted := st.panicCounted || st.metricsDepth > 0
+			st.mu.Unlock()
+			if !counted {
+				st.notePattern(r)
+				loadServerMetrics().errors.Add(r.Context(), 1, metric.WithAttributes(
+					attribute.String("http.method", metricMethod(r.Method)),
+					attribute.String("http.route", PatternRoute(r)),
+					attribute.Int("http.status_code", sw.status),
+					semconv.ErrorTypeKey.String("panic"),
+				))
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
 
-			handler.ServeHTTP(w, r)
-		})
+// panicError converts a recovered value into an error.
+func panicError(v any) error {
+	if err, ok := v.(error); ok {
+		return err
 	}
+	return fmt.Errorf("%v", v)
 }
@@ -0,0 +1,155 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// captureLogs redirects the default slog logger to a buffer for the
+// duration of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+func panickingHandler(w http.ResponseWriter, r *http.Request) {
+	panic("boom")
+}
+
+func TestRecoverMiddleware_RecordsPanic(t *testing.T) {
+	tests := []struct {
+		name  string
+		chain func(http.Handler) http.Handler
+	}{
+		{
+			name: "recover outermost",
+			chain: func(h http.Handler) http.Handler {
+				return RecoverMiddleware(TracingMiddleware(MetricsMiddleware(h)))
+			},
+		},
+		{
+			name: "recover innermost",
+			chain: func(h http.Handler) http.Handler {
+				return TracingMiddleware(MetricsMiddleware(RecoverMiddleware(h)))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			reader := otelt.InstallMetrics(t)
+			exp := otelt.InstallTracing(t)
+
+			rec := httptest.NewRecorder()
+			tt.chain(http.HandlerFunc(panickingHandler)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != http.StatusInternalServerError {
+				t.Errorf("status mismatch: got %d, want %d", rec.Code, http.StatusInternalServerError)
+			}
+
+			errs := collectSum(t, reader, "http.server.errors")
+			if len(errs) != 1 || errs[0].Value != 1 {
+				t.Fatalf("errors mismatch: got %+v, want a single point with value 1", errs)
+			}
+			if v, _ := errs[0].Attributes.Value("error.type"); v.AsString() != "panic" {
+				t.Errorf("error.type mismatch: got %q, want %q", v.AsString(), "panic")
+			}
+			if v, _ := errs[0].Attributes.Value("http.status_code"); v.AsInt64() != http.StatusInternalServerError {
+				t.Errorf("http.status_code mismatch: got %d, want %d", v.AsInt64(), http.StatusInternalServerError)
+			}
+
+			spans := exp.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("expected 1 span, got %d", len(spans))
+			}
+			if spans[0].Status.Code != codes.Error {
+				t.Errorf("span status mismatch: got %v, want %v", spans[0].Status.Code, codes.Error)
+			}
+			if len(spans[0].Events) != 1 || spans[0].Events[0].Name != "exception" {
+				t.Errorf("span events mismatch: got %+v, want a single exception event", spans[0].Events)
+			}
+
+			// The logged stack must point at the handler, not at a middleware
+			// that re-panicked.
+			if !strings.Contains(logs.String(), "panickingHandler") {
+				t.Errorf("log does not contain the panic origin:\n%s", logs.String())
+			}
+		})
+	}
+}
+
+func TestRecoverMiddleware_WithoutMetricsMiddleware(t *testing.T) {
+	captureLogs(t)
+	reader := otelt.InstallMetrics(t)
+
+	RecoverMiddleware(http.HandlerFunc(panickingHandler)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("SCAN", "/", nil))
+
+	errs := collectSum(t, reader, "http.server.errors")
+	if len(errs) != 1 || errs[0].Value != 1 {
+		t.Fatalf("errors mismatch: got %+v, want a single point with value 1", errs)
+	}
+	if method, _ := errs[0].Attributes.Value("http.method"); method.AsString() != OtherMethod {
+		t.Errorf("http.method = %q, want %q for a made-up method", method.AsString(), OtherMethod)
+	}
+}
+
+func TestRecoverMiddleware_RepanicsOnAbortHandler(t *testing.T) {
+	captureLogs(t)
+	otelt.InstallMetrics(t)
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("recovered value mismatch: got %v, want http.ErrAbortHandler", v)
+		}
+	}()
+
+	RecoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestMiddlewares_Goexit(t *testing.T) {
+	logs := captureLogs(t)
+	otelt.InstallMetrics(t)
+
+	audited := make(chan AuditRecord, 1)
+	sink := AuditSinkFunc(func(_ context.Context, rec AuditRecord) error {
+		audited <- rec
+		return nil
+	})
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runtime.Goexit()
+	})
+	handler = TracingMiddleware(AccessLogMiddleware(slog.Default())(MetricsMiddleware(AuditMiddleware(sink)(handler))))
+
+	recovered := make(chan any, 1)
+	go func() {
+		defer func() { recovered <- recover() }()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/bookings", nil))
+	}()
+	if v := <-recovered; v != nil {
+		t.Fatalf("runtime.Goexit turned into a panic: %v", v)
+	}
+	otelt.RequireCounterValue(t, "http.server.requests", nil, 1)
+	if len(audited) != 1 || !strings.Contains(logs.String(), "/bookings") {
+		t.Errorf("request not audited or logged: %d records, logs %s", len(audited), logs)
+	}
+	if strings.Contains(logs.String(), "panic") {
+		t.Errorf("runtime.Goexit logged as a panic: %s", logs)
+	}
+}
@@ -0,0 +1,73 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader is read from incoming requests and echoed in responses.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds caller-supplied request IDs.
+const maxRequestIDLen = 128
+
+// requestIDKey is the span attribute carrying the request ID.
+const requestIDKey = attribute.Key("http.request.id")
+
+// RequestIDMiddleware makes sure every request has an ID: the caller's
+// X-Request-ID when it is sane, a new UUID otherwise. The ID is echoed in the
+// response header, attached to the server span, and available to handlers
+// through RequestIDFromContext.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+
+		r, st := withRequestState(r)
+		st.mu.Lock()
+		st.requestID = id
+		st.mu.Unlock()
+
+		w.Header().Set(RequestIDHeader, id)
+		if span := trace.SpanFromContext(r.Context()); span.IsRecording() {
+			span.SetAttributes(requestIDKey.String(id))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequestIDFromContext returns the ID assigned by RequestIDMiddleware, or ""
+// when there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	st := stateFromContext(ctx)
+	if st == nil {
+		return ""
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.requestID
+}
+
+// validRequestID accepts short IDs made of visible ASCII characters that are
+// safe to echo in headers and logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
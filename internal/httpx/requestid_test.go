@@ -0,0 +1,111 @@
+package httpx
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"github.com/google/uuid"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		wantSame bool
+	}{
+		{name: "passes through a valid ID", incoming: "req-8f3a_91bc.1", wantSame: true},
+		{name: "generates when absent"},
+		{name: "replaces an ID with unsafe characters", incoming: "abc\" onload=\"x"},
+		{name: "replaces an overly long ID", incoming: strings.Repeat("a", maxRequestIDLen+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = RequestIDFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get(RequestIDHeader); got != seen {
+				t.Errorf("response header mismatch: got %q, want %q", got, seen)
+			}
+			if tt.wantSame {
+				if seen != tt.incoming {
+					t.Errorf("request ID mismatch: got %q, want %q", seen, tt.incoming)
+				}
+				return
+			}
+			if _, err := uuid.Parse(seen); err != nil {
+				t.Errorf("expected a generated UUID, got %q", seen)
+			}
+		})
+	}
+}
+
+func TestRequestIDMiddleware_Propagation(t *testing.T) {
+	const id = "req-123"
+
+	t.Run("span attribute with tracing inside or outside", func(t *testing.T) {
+		for _, chain := range []func(http.Handler) http.Handler{
+			func(h http.Handler) http.Handler { return RequestIDMiddleware(TracingMiddleware(h)) },
+			func(h http.Handler) http.Handler { return TracingMiddleware(RequestIDMiddleware(h)) },
+		} {
+			exp := otelt.InstallTracing(t)
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(RequestIDHeader, id)
+			chain(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := exp.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("expected 1 span, got %d", len(spans))
+			}
+			found := false
+			for _, kv := range spans[0].Attributes {
+				if kv.Key == requestIDKey && kv.Value.AsString() == id {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("span attributes missing %s=%s: %v", requestIDKey, id, spans[0].Attributes)
+			}
+		}
+	})
+
+	t.Run("access log", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewJSONHandler(&buf, nil))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(RequestIDHeader, id)
+		AccessLogMiddleware(logger)(RequestIDMiddleware(http.NotFoundHandler())).ServeHTTP(httptest.NewRecorder(), req)
+
+		lines := decodeLogLines(t, &buf)
+		if len(lines) != 1 || lines[0]["request_id"] != id {
+			t.Errorf("log lines mismatch: got %v, want request_id=%s", lines, id)
+		}
+	})
+
+	t.Run("panic response", func(t *testing.T) {
+		captureLogs(t)
+		otelt.InstallMetrics(t)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(RequestIDHeader, id)
+		rec := httptest.NewRecorder()
+		RecoverMiddleware(RequestIDMiddleware(http.HandlerFunc(panickingHandler))).ServeHTTP(rec, req)
+
+		if !strings.Contains(rec.Body.String(), id) {
+			t.Errorf("error body does not include the request ID: %q", rec.Body.String())
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package httpx
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// defaultDetectTimeout bounds resource detection unless
+// WithResourceDetectionTimeout says otherwise.
+const defaultDetectTimeout = 2 * time.Second
+
+// WithHostDetection adds the host name and architecture and the OS type and
+// description to the telemetry resource.
+func WithHostDetection() TelemetryOption {
+	return WithResourceDetector(hostDetector{})
+}
+
+// WithContainerDetection adds the ID of the container the process runs in,
+// as found in its cgroups or mounts, to the telemetry resource. Outside a
+// container it adds nothing.
+func WithContainerDetection() TelemetryOption {
+	return WithResourceDetector(containerDetector{proc: os.DirFS("/proc/self")})
+}
+
+// WithEnvResourceAttributes adds the attributes of OTEL_RESOURCE_ATTRIBUTES
+// to the telemetry resource.
+func WithEnvResourceAttributes() TelemetryOption {
+	return WithResourceDetector(envDetector{})
+}
+
+// WithResourceDetector adds what d detects to the telemetry resource, e.g.
+// the cloud metadata of an AWS or GCP detector. Detectors run concurrently;
+// those given later win over earlier ones, and the attributes of
+// WithResourceAttributes win over them all.
+//
+// A detector failing or not done within the timeout of
+// WithResourceDetectionTimeout contributes whatever it did detect, with a
+// warning, rather than fail InitTelemetry.
+func WithResourceDetector(d resource.Detector) TelemetryOption {
+	return func(c *telemetryConfig) { c.detectors = append(c.detectors, d) }
+}
+
+// WithResourceDetectionTimeout bounds how long resource detectors may delay
+// InitTelemetry, 2 seconds by default.
+func WithResourceDetectionTimeout(d time.Duration) TelemetryOption {
+	return func(c *telemetryConfig) { c.detectTimeout = d }
+}
+
+// detectResource returns the attributes the detectors of cfg detect within
+// the timeout, those of later detectors last.
+func detectResource(ctx context.Context, cfg telemetryConfig) []attribute.KeyValue {
+	if len(cfg.detectors) == 0 {
+		return nil
+	}
+	timeout := cfg.detectTimeout
+	if timeout <= 0 {
+		timeout = defaultDetectTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		res *resource.Resource
+		err error
+	}
+	results := make([]chan result, len(cfg.detectors))
+	for i, d := range cfg.detectors {
+		results[i] = make(chan result, 1)
+		go func() {
+			res, err := d.Detect(ctx)
+			results[i] <- result{res, err}
+		}()
+	}
+
+	var attrs []attribute.KeyValue
+	for i, ch := range results {
+		var r result
+		select {
+		case r = <-ch:
+		case <-ctx.Done():
+			// A detector ignoring ctx mustn't hold up startup, nor cost the
+			// results of those done in time.
+			select {
+			case r = <-ch:
+			default:
+				r.err = fmt.Errorf("not done within %v", timeout)
+			}
+		}
+		if r.err != nil {
+			slog.Warn("Resource detection failed", "detector", fmt.Sprintf("%T", cfg.detectors[i]), "error", r.err)
+		}
+		if r.res != nil {
+			attrs = append(attrs, r.res.Attributes()...)
+		}
+	}
+	return attrs
+}
+
+type hostDetector struct{}
+
+func (hostDetector) Detect(ctx context.Context) (*resource.Resource, error) {
+	return resource.New(ctx,
+		resource.WithHost(),
+		resource.WithOS(),
+		resource.WithAttributes(semconv.HostArchKey.String(runtime.GOARCH)),
+	)
+}
+
+type envDetector struct{}
+
+func (envDetector) Detect(ctx context.Context) (*resource.Resource, error) {
+	return resource.New(ctx, resource.WithFromEnv())
+}
+
+// containerDetector reads the container ID from the cgroup paths of the
+// process, as with cgroup v1, or else from its mounts, as Docker and Podman
+// mount the container's hostname file from a directory named after it.
+type containerDetector struct {
+	proc fs.FS // /proc/self
+}
+
+var (
+	cgroupContainerID = regexp.MustCompile(`[-/]([0-9a-f]{64})(?:\.scope)?$`)
+	mountContainerID  = regexp.MustCompile(`containers/([0-9a-f]{64})/`)
+)
+
+func (d containerDetector) Detect(context.Context) (*resource.Resource, error) {
+	id, err := d.scan("cgroup", func(line string) string {
+		// hierarchy-ID:controllers:path
+		if _, path, ok := strings.Cut(line, ":"); ok {
+			if _, path, ok = strings.Cut(path, ":"); ok {
+				return submatch(cgroupContainerID, path)
+			}
+		}
+		return ""
+	})
+	if id == "" && err == nil {
+		id, err = d.scan("mountinfo", func(line string) string { return submatch(mountContainerID, line) })
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil // not on Linux
+	}
+	if id == "" {
+		return nil, err
+	}
+	return resource.NewSchemaless(semconv.ContainerID(id)), err
+}
+
+// scan returns the first ID found by match on the lines of the named file.
+func (d containerDetector) scan(name string, match func(line string) string) (string, error) {
+	f, err := d.proc.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if id := match(s.Text()); id != "" {
+			return id, nil
+		}
+	}
+	return "", s.Err()
+}
+
+func submatch(re *regexp.Regexp, s string) string {
+	if m := re.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+	return ""
+}
@@ -0,0 +1,163 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+type detectorFunc func(ctx context.Context) (*resource.Resource, error)
+
+func (f detectorFunc) Detect(ctx context.Context) (*resource.Resource, error) { return f(ctx) }
+
+func detected(attrs ...attribute.KeyValue) resource.Detector {
+	return detectorFunc(func(context.Context) (*resource.Resource, error) {
+		return resource.NewSchemaless(attrs...), nil
+	})
+}
+
+func resourceAttrs(res *resource.Resource) map[string]string {
+	got := map[string]string{}
+	for _, kv := range res.Attributes() {
+		got[string(kv.Key)] = kv.Value.Emit()
+	}
+	return got
+}
+
+func TestNewResource_Detectors(t *testing.T) {
+	logs := captureLogs(t)
+	hanging := make(chan struct{})
+	defer close(hanging)
+
+	start := time.Now()
+	res, err := newResource(context.Background(), "acai-test", newTelemetryConfig([]TelemetryOption{
+		WithResourceAttributes(attribute.String("team", "travel")),
+		WithResourceDetector(detected(semconv.CloudProviderGCP, semconv.CloudRegion("europe-west1"), attribute.String("team", "cloud"))),
+		WithResourceDetector(detectorFunc(func(context.Context) (*resource.Resource, error) {
+			return resource.NewSchemaless(semconv.CloudAvailabilityZone("europe-west1-b")), fmt.Errorf("%w: no instance ID", resource.ErrPartialResource)
+		})),
+		WithResourceDetector(detectorFunc(func(context.Context) (*resource.Resource, error) {
+			<-hanging // a metadata endpoint ignoring the context
+			return nil, nil
+		})),
+		WithResourceDetector(detected(semconv.CloudRegion("us-east1"), semconv.ServiceName("detected"))),
+		WithResourceDetectionTimeout(50 * time.Millisecond),
+	}))
+	if err != nil {
+		t.Fatalf("newResource() unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("detection took %v despite the timeout", elapsed)
+	}
+
+	want := map[string]string{
+		"service.name":            "acai-test",
+		"team":                    "travel",
+		"cloud.provider":          "gcp",
+		"cloud.region":            "us-east1",
+		"cloud.availability_zone": "europe-west1-b",
+	}
+	if got := resourceAttrs(res); !maps.Equal(got, want) {
+		t.Errorf("attributes = %v, want %v", got, want)
+	}
+
+	var warnings []string
+	for _, line := range decodeLogLines(t, logs) {
+		if line["msg"] == "Resource detection failed" {
+			warnings = append(warnings, line["error"].(string))
+		}
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "no instance ID") || !strings.Contains(warnings[1], "not done within 50ms") {
+		t.Errorf("warnings = %q, want the partial and the hanging detectors'", warnings)
+	}
+}
+
+func TestHostDetector(t *testing.T) {
+	res, err := hostDetector{}.Detect(context.Background())
+	if err != nil {
+		t.Fatalf("Detect() unexpected error: %v", err)
+	}
+	hostname, _ := os.Hostname()
+	got := resourceAttrs(res)
+	if got["host.name"] != hostname || got["host.arch"] != runtime.GOARCH || got["os.type"] != runtime.GOOS {
+		t.Errorf("attributes = %v, want host %s on %s/%s", got, hostname, runtime.GOOS, runtime.GOARCH)
+	}
+}
+
+func TestContainerDetector(t *testing.T) {
+	const id = "a3f7c2d1e4b5968776655443322110ffeeddccbbaa99887766554433221100ff"
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name:  "docker on cgroup v1",
+			files: map[string]string{"cgroup": "12:pids:/docker/" + id + "\n11:memory:/docker/" + id + "\n"},
+			want:  id,
+		},
+		{
+			name:  "kubernetes",
+			files: map[string]string{"cgroup": "1:name=systemd:/kubepods/burstable/pod5a1c/" + id + "\n"},
+			want:  id,
+		},
+		{
+			name:  "systemd scope",
+			files: map[string]string{"cgroup": "0::/system.slice/docker-" + id + ".scope\n"},
+			want:  id,
+		},
+		{
+			name: "docker on cgroup v2",
+			files: map[string]string{
+				"cgroup":    "0::/\n",
+				"mountinfo": "580 571 0:60 / / rw - overlay overlay rw,lowerdir=/var/lib/docker/overlay2/l/X\n605 580 254:1 /var/lib/docker/containers/" + id + "/hostname /etc/hostname rw - ext4 /dev/vda1 rw\n",
+			},
+			want: id,
+		},
+		{
+			name:  "no container",
+			files: map[string]string{"cgroup": "0::/user.slice/user-1000.slice/session-2.scope\n", "mountinfo": "22 1 8:1 / / rw - ext4 /dev/sda1 rw\n"},
+		},
+		{
+			name: "no proc",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proc := fstest.MapFS{}
+			for name, data := range tt.files {
+				proc[name] = &fstest.MapFile{Data: []byte(data)}
+			}
+			res, err := containerDetector{proc: proc}.Detect(context.Background())
+			if err != nil {
+				t.Fatalf("Detect() unexpected error: %v", err)
+			}
+			var got string
+			if res != nil {
+				got = resourceAttrs(res)["container.id"]
+			}
+			if got != tt.want {
+				t.Errorf("container.id = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainerDetector_ReadError(t *testing.T) {
+	proc := fstest.MapFS{"cgroup": &fstest.MapFile{Mode: os.ModeDir}}
+	if _, err := (containerDetector{proc: proc}).Detect(context.Background()); err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Detect() error = %v, want the read error", err)
+	}
+}
@@ -0,0 +1,276 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StatusError is an error answered with a specific HTTP status by
+// WriteError. Wrap it with %w to add context:
+//
+//	return fmt.Errorf("booking %s: %w", id, httpx.ErrNotFound)
+type StatusError struct {
+	Status int
+	// Type is sent as the error code and recorded as error.type, so it must
+	// come from a small fixed set.
+	Type string
+	Err  error
+}
+
+func (e *StatusError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return http.StatusText(e.Status)
+}
+
+func (e *StatusError) Unwrap() error { return e.Err }
+
+// Errors mapped to their usual status codes by WriteError.
+var (
+	ErrValidation      = &StatusError{Status: http.StatusBadRequest, Type: "validation", Err: errors.New("validation failed")}
+	ErrNotFound        = &StatusError{Status: http.StatusNotFound, Type: "not_found", Err: errors.New("not found")}
+	ErrConflict        = &StatusError{Status: http.StatusConflict, Type: "conflict", Err: errors.New("conflict")}
+	ErrNotAcceptable   = &StatusError{Status: http.StatusNotAcceptable, Type: "not_acceptable", Err: errors.New("not acceptable")}
+	ErrUpstreamTimeout = &StatusError{Status: http.StatusGatewayTimeout, Type: "upstream_timeout", Err: errors.New("upstream timeout")}
+)
+
+// Error types WriteError assigns without a StatusError: "internal" to the
+// errors it can't classify and "canceled" when the client went away.
+const (
+	internalErrorType = "internal"
+	canceledErrorType = "canceled"
+)
+
+// errorBody is the JSON envelope of error responses.
+type errorBody struct {
+	Error     string `json:"error"`
+	Code      string `json:"code"`
+	Field     string `json:"field,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// problemJSON is the media type of RFC 7807 problem details.
+const problemJSON = "application/problem+json"
+
+// problemBody is an RFC 7807 problem details document, extended with the
+// members of errorBody and the trace ID.
+type problemBody struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail"`
+	Instance  string `json:"instance"`
+	Code      string `json:"code"`
+	Field     string `json:"field,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+}
+
+var problemDetails atomic.Bool
+
+// SetProblemDetails makes WriteError always answer with problem+json.
+// Otherwise only clients asking for it in Accept get it, and others the
+// plain JSON envelope.
+func SetProblemDetails(enabled bool) {
+	problemDetails.Store(enabled)
+}
+
+// problemType returns the problem type URI for an error type. Errors that
+// couldn't be classified have no more semantics than their status.
+func problemType(typ string) string {
+	if typ == internalErrorType {
+		return "about:blank"
+	}
+	return "urn:acai:problem:" + typ
+}
+
+// WriteJSON sends v as a JSON response with the given status. If v can't be
+// encoded the client gets a 500 instead; failures are logged and returned.
+func WriteJSON(w http.ResponseWriter, status int, v any) error {
+	return writeJSON(context.Background(), w, status, v)
+}
+
+func writeJSON(ctx context.Context, w http.ResponseWriter, status int, v any) error {
+	return writeEncoded(ctx, w, "application/json", status, v)
+}
+
+func writeEncoded(ctx context.Context, w http.ResponseWriter, contentType string, status int, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode JSON response", "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"Internal Server Error","code":"internal"}` + "\n"))
+		return err
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		// The status is already out; all that is left is to tell someone.
+		slog.WarnContext(ctx, "Failed to write JSON response", "error", err)
+		return err
+	}
+	return nil
+}
+
+// WriteError answers r with the status err maps to: the one of a wrapped
+// StatusError, 504 for an expired context deadline, 500 otherwise. Client
+// errors are described with err's message; server errors only with their
+// status text, the details being logged instead. A context.Canceled error on
+// a request whose client disconnected is not an error of ours: nothing is
+// written or logged, and the type recorded is "canceled".
+//
+// A wrapped FieldError on a client error names its field in the body.
+//
+// Clients accepting application/problem+json, or all of them after
+// SetProblemDetails(true), get an RFC 7807 document instead of the envelope.
+//
+// The error is recorded on the active span and its type is added as
+// error.type to the request metrics, or counted in http.server.errors when
+// no metrics middleware serves the request.
+//
+// Server errors also get a fingerprint of their type and the types of the
+// errors they wrap, logged, set on the span as error.fingerprint and counted
+// in http.server.error_fingerprints, so one alert stands for one bug.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	ctx := r.Context()
+	status, typ := classifyError(err)
+	if errors.Is(err, context.Canceled) && clientCanceled(r) {
+		// Nobody is left to read the answer, and nothing failed on our side.
+		status, typ = StatusClientClosedRequest, canceledErrorType
+	}
+	if err == nil {
+		err = errors.New(http.StatusText(status))
+	}
+
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err, trace.WithAttributes(semconv.ErrorTypeKey.String(typ)))
+	span.SetAttributes(semconv.ErrorTypeKey.String(typ))
+
+	msg := err.Error()
+	var field string
+	if fe := (*FieldError)(nil); status < 500 && errors.As(err, &fe) {
+		field = fe.Field
+	}
+	if status >= 500 && typ != canceledErrorType {
+		fp := errorFingerprint(err, typ)
+		span.SetAttributes(attribute.String(errorFingerprintKey, fp))
+		if st := stateFromContext(ctx); st != nil {
+			st.mu.Lock()
+			if st.fingerprint == "" {
+				st.fingerprint = fp
+			}
+			st.mu.Unlock()
+		}
+		slog.ErrorContext(ctx, "HTTP handler failed",
+			"error", err, "error_type", typ, "error_fingerprint", fp, "request_id", RequestIDFromContext(ctx))
+		recordErrorFingerprint(ctx, fp, typ)
+		msg = http.StatusText(status)
+	}
+
+	noteErrorType(r, status, typ, classifyErrorClass(err, status, typ))
+	if typ == canceledErrorType {
+		return
+	}
+	if !problemDetails.Load() && !acceptsProblem(r.Header.Get("Accept")) {
+		_ = writeJSON(ctx, w, status, errorBody{Error: msg, Code: typ, Field: field, RequestID: RequestIDFromContext(ctx)})
+		return
+	}
+
+	problem := problemBody{
+		Type:      problemType(typ),
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    msg,
+		Instance:  r.URL.Path,
+		Code:      typ,
+		Field:     field,
+		RequestID: RequestIDFromContext(ctx),
+	}
+	sc := trace.SpanContextFromContext(ctx)
+	if st := stateFromContext(ctx); st != nil {
+		sc = st.serverSpanContext(ctx)
+	}
+	if sc.IsValid() {
+		problem.TraceID = sc.TraceID().String()
+	}
+	_ = writeEncoded(ctx, w, problemJSON, status, problem)
+}
+
+// acceptsProblem reports whether an Accept header asks for problem+json.
+func acceptsProblem(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		mt, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(mt), problemJSON) {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// classifyError returns the status and error.type err is answered with.
+func classifyError(err error) (int, string) {
+	var se *StatusError
+	switch {
+	case errors.As(err, &se) && se.Status >= 400:
+		typ := se.Type
+		if typ == "" {
+			typ = internalErrorType
+		}
+		return se.Status, typ
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrUpstreamTimeout.Status, ErrUpstreamTimeout.Type
+	}
+	return http.StatusInternalServerError, internalErrorType
+}
+
+// noteErrorType hands the error type and class to the metrics middleware
+// serving r, or counts the error itself when there is none. A class set
+// with SetErrorClass is kept. Canceled requests are not counted, as
+// MetricsMiddleware doesn't count them by default.
+func noteErrorType(r *http.Request, status int, typ string, class ErrorClass) {
+	if st := stateFromContext(r.Context()); st != nil {
+		st.mu.Lock()
+		st.errorType = typ
+		if st.errorClassSet {
+			class = st.errorClass
+		} else {
+			st.errorClass = class
+		}
+		recorded := st.metricsDepth > 0
+		st.mu.Unlock()
+		if recorded {
+			return
+		}
+	}
+	if typ == canceledErrorType {
+		return
+	}
+	loadServerMetrics().errors.Add(r.Context(), 1, metric.WithAttributes(
+		attribute.String("http.method", metricMethod(r.Method)),
+		attribute.String("http.route", PatternRoute(r)),
+		attribute.Int("http.status_code", status),
+		semconv.ErrorTypeKey.String(typ),
+		attribute.String("error.class", string(class)),
+	))
+}
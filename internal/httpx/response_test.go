@@ -0,0 +1,272 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantMsg    string
+	}{
+		{
+			name:       "validation",
+			err:        fmt.Errorf("start date %q: %w", "tomorrow", ErrValidation),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "validation",
+			wantMsg:    `start date "tomorrow": validation failed`,
+		},
+		{
+			name:       "not found",
+			err:        fmt.Errorf("booking 42: %w", ErrNotFound),
+			wantStatus: http.StatusNotFound,
+			wantCode:   "not_found",
+			wantMsg:    "booking 42: not found",
+		},
+		{
+			name:       "conflict",
+			err:        ErrConflict,
+			wantStatus: http.StatusConflict,
+			wantCode:   "conflict",
+			wantMsg:    "conflict",
+		},
+		{
+			name:       "custom status error",
+			err:        &StatusError{Status: http.StatusUnprocessableEntity, Type: "unprocessable", Err: errors.New("trip ends before it starts")},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantCode:   "unprocessable",
+			wantMsg:    "trip ends before it starts",
+		},
+		{
+			name:       "deadline exceeded is an upstream timeout",
+			err:        fmt.Errorf("call flights API: %w", context.DeadlineExceeded),
+			wantStatus: http.StatusGatewayTimeout,
+			wantCode:   "upstream_timeout",
+			wantMsg:    "Gateway Timeout",
+		},
+		{
+			name:       "unknown errors hide their details",
+			err:        errors.New("mongo: connection refused on 10.0.0.3"),
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   "internal",
+			wantMsg:    "Internal Server Error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLogs(t)
+			handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				WriteError(w, r, tt.err)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/bookings/42", nil)
+			req.Header.Set(RequestIDHeader, "req-1")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status mismatch: got %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type mismatch: got %q", got)
+			}
+			var body errorBody
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			want := errorBody{Error: tt.wantMsg, Code: tt.wantCode, RequestID: "req-1"}
+			if body != want {
+				t.Errorf("body mismatch: got %+v, want %+v", body, want)
+			}
+		})
+	}
+}
+
+func TestWriteError_Observability(t *testing.T) {
+	exp := otelt.InstallTracing(t)
+	captureLogs(t)
+
+	newHandler := func(metrics bool) http.Handler {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /bookings/{id}", func(w http.ResponseWriter, r *http.Request) {
+			WriteError(w, r, fmt.Errorf("booking %s: %w", r.PathValue("id"), ErrNotFound))
+		})
+		if metrics {
+			return TracingMiddleware(MetricsMiddleware(mux))
+		}
+		return TracingMiddleware(mux)
+	}
+
+	for _, metrics := range []bool{true, false} {
+		t.Run(fmt.Sprintf("metrics middleware %t", metrics), func(t *testing.T) {
+			otelt.InstallMetrics(t)
+			exp.Reset()
+			newHandler(metrics).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/bookings/42", nil))
+
+			otelt.RequireCounterValue(t, "http.server.errors", []attribute.KeyValue{
+				attribute.String("http.route", "/bookings/{id}"),
+				semconv.ErrorTypeKey.String("not_found"),
+			}, 1)
+
+			spans := otelt.Spans(t)
+			if len(spans) != 1 {
+				t.Fatalf("expected one span, got %d", len(spans))
+			}
+			events := spans[0].Events()
+			if len(events) != 1 || events[0].Name != "exception" {
+				t.Fatalf("expected the error to be recorded on the span, got %+v", events)
+			}
+		})
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	t.Run("encodes", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		if err := WriteJSON(rec, http.StatusCreated, map[string]string{"id": "42"}); err != nil {
+			t.Fatalf("WriteJSON() unexpected error: %v", err)
+		}
+		if rec.Code != http.StatusCreated || rec.Body.String() != `{"id":"42"}`+"\n" {
+			t.Errorf("unexpected response: %d %q", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("encoding failure becomes a 500", func(t *testing.T) {
+		logs := captureLogs(t)
+		rec := httptest.NewRecorder()
+		if err := WriteJSON(rec, http.StatusOK, math.Inf(1)); err == nil {
+			t.Fatal("WriteJSON() expected an error")
+		}
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status mismatch: got %d", rec.Code)
+		}
+		if !strings.Contains(logs.String(), "Failed to encode JSON response") {
+			t.Errorf("encoding failure not logged: %s", logs)
+		}
+	})
+
+	t.Run("write failure is logged", func(t *testing.T) {
+		logs := captureLogs(t)
+		if err := WriteJSON(failingWriter{httptest.NewRecorder()}, http.StatusOK, "ok"); err == nil {
+			t.Fatal("WriteJSON() expected an error")
+		}
+		if !strings.Contains(logs.String(), "Failed to write JSON response") {
+			t.Errorf("write failure not logged: %s", logs)
+		}
+	})
+}
+
+type failingWriter struct{ *httptest.ResponseRecorder }
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestWriteError_ProblemDetails(t *testing.T) {
+	tests := []struct {
+		name        string
+		accept      string
+		always      bool
+		err         error
+		wantProblem bool
+		wantStatus  int
+		wantType    string
+	}{
+		{
+			name:       "plain JSON by default",
+			err:        ErrNotFound,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:        "negotiated",
+			accept:      "application/problem+json, application/json;q=0.5",
+			err:         ErrNotFound,
+			wantProblem: true,
+			wantStatus:  http.StatusNotFound,
+			wantType:    "urn:acai:problem:not_found",
+		},
+		{
+			name:       "refused with q=0",
+			accept:     "application/problem+json;q=0, application/json",
+			err:        ErrConflict,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:        "enabled for everyone",
+			always:      true,
+			err:         ErrConflict,
+			wantProblem: true,
+			wantStatus:  http.StatusConflict,
+			wantType:    "urn:acai:problem:conflict",
+		},
+		{
+			name:        "unknown error falls back to 500",
+			accept:      "application/problem+json",
+			err:         errors.New("disk on fire"),
+			wantProblem: true,
+			wantStatus:  http.StatusInternalServerError,
+			wantType:    "about:blank",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := otelt.InstallTracing(t)
+			captureLogs(t)
+			SetProblemDetails(tt.always)
+			t.Cleanup(func() { SetProblemDetails(false) })
+
+			handler := TracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				WriteError(w, r, tt.err)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/bookings/42", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status mismatch: got %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !tt.wantProblem {
+				if got := rec.Header().Get("Content-Type"); got != "application/json" {
+					t.Errorf("Content-Type mismatch: got %q", got)
+				}
+				return
+			}
+
+			if got := rec.Header().Get("Content-Type"); got != "application/problem+json" {
+				t.Errorf("Content-Type mismatch: got %q", got)
+			}
+			var problem problemBody
+			if err := json.NewDecoder(rec.Body).Decode(&problem); err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if problem.Type != tt.wantType || problem.Status != tt.wantStatus ||
+				problem.Title != http.StatusText(tt.wantStatus) || problem.Instance != "/bookings/42" {
+				t.Errorf("unexpected problem: %+v", problem)
+			}
+			spans := exp.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("expected one span, got %d", len(spans))
+			}
+			if want := spans[0].SpanContext.TraceID().String(); problem.TraceID != want {
+				t.Errorf("trace_id mismatch: got %q, want %q", problem.TraceID, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RetryPolicy reports whether a round trip should be retried. Exactly one of
+// resp and err is non-nil.
+type RetryPolicy func(resp *http.Response, err error) bool
+
+// DefaultRetryPolicy retries connection errors and 429, 502, 503 and 504
+// responses. ErrCircuitOpen and ErrUpstreamRateLimited are not retried.
+func DefaultRetryPolicy(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, ErrCircuitOpen) && !errors.Is(err, ErrUpstreamRateLimited)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// RetryOption configures NewRetryTransport.
+type RetryOption func(*RetryTransport)
+
+// WithMaxAttempts sets how many times a request is sent in total, including
+// the first attempt. Defaults to 3.
+func WithMaxAttempts(n int) RetryOption {
+	return func(t *RetryTransport) { t.maxAttempts = max(n, 1) }
+}
+
+// WithBackoff sets the exponential backoff: the wait before retry n is
+// random between 0 and min(base*2^(n-1), maxDelay). maxDelay also caps
+// Retry-After; servers asking for longer are not retried. Defaults to 100ms
+// and 2s.
+func WithBackoff(base, maxDelay time.Duration) RetryOption {
+	return func(t *RetryTransport) { t.baseDelay, t.maxDelay = base, maxDelay }
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) RetryOption {
+	return func(t *RetryTransport) { t.retryable = policy }
+}
+
+// RetryTransport resends failed requests with exponential backoff and
+// jitter. Layer it on top of NewTransport so every attempt gets its own
+// client span and metrics:
+//
+//	NewRetryTransport(NewTransport(nil))
+//
+// Only requests that can be sent twice are retried: their body, if any,
+// must be replayable through GetBody, as it is for the requests of
+// http.NewRequest with a bytes or strings reader.
+type RetryTransport struct {
+	base        http.RoundTripper
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	retryable   RetryPolicy
+	// wait is replaced in tests to avoid sleeping.
+	wait func(ctx context.Context, d time.Duration) error
+}
+
+// NewRetryTransport returns a RetryTransport sending attempts through base. A
+// nil base means http.DefaultTransport.
+func NewRetryTransport(base http.RoundTripper, opts ...RetryOption) *RetryTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &RetryTransport{
+		base:        base,
+		maxAttempts: 3,
+		baseDelay:   100 * time.Millisecond,
+		maxDelay:    2 * time.Second,
+		retryable:   DefaultRetryPolicy,
+		wait:        sleepContext,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if !canRetry(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	attempt := 1
+	resp, err := t.base.RoundTrip(req)
+	outcome := "success"
+	for {
+		if ctx.Err() != nil {
+			outcome = "canceled"
+			break
+		}
+		if !t.retryable(resp, err) {
+			// The final attempt decides, whatever the earlier ones got.
+			if err != nil || resp.StatusCode >= 400 {
+				outcome = "failure"
+			}
+			break
+		}
+		if attempt == t.maxAttempts {
+			outcome = "exhausted"
+			break
+		}
+
+		delay, ok := t.delay(attempt, resp)
+		if !ok {
+			outcome = "exhausted"
+			break
+		}
+
+		reason := attribute.String("http.retry.reason", "error")
+		if err == nil {
+			reason = attribute.Int("http.retry.reason.status_code", resp.StatusCode)
+			drainAndClose(resp.Body)
+		}
+		attempt++
+		trace.SpanFromContext(ctx).AddEvent("http.retry", trace.WithAttributes(
+			attribute.Int("http.retry.attempt", attempt),
+			attribute.Int64("http.retry.delay_ms", delay.Milliseconds()),
+			reason,
+		))
+
+		if werr := t.wait(ctx, delay); werr != nil {
+			t.recordRetries(ctx, req, attempt, "canceled")
+			return nil, werr
+		}
+
+		retry, rerr := rewind(req)
+		if rerr != nil {
+			t.recordRetries(ctx, req, attempt, "failure")
+			return nil, rerr
+		}
+		resp, err = t.base.RoundTrip(retry)
+	}
+
+	t.recordRetries(ctx, req, attempt, outcome)
+	return resp, err
+}
+
+// recordRetries counts the retries made for one request, each labelled with
+// its attempt number and how the request finally ended.
+func (t *RetryTransport) recordRetries(ctx context.Context, req *http.Request, attempts int, outcome string) {
+	if attempts < 2 {
+		return
+	}
+	cm := loadClientMetrics()
+	for n := 2; n <= attempts; n++ {
+		cm.retries.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("net.peer.name", req.URL.Hostname()),
+			attribute.Int("http.retry.attempt", n),
+			attribute.String("http.retry.outcome", outcome),
+		))
+	}
+}
+
+// delay returns how long to wait before retrying after the given attempt,
+// honouring Retry-After. It reports false when the server asks for a longer
+// wait than maxDelay.
+func (t *RetryTransport) delay(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if d, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return d, d <= t.maxDelay
+		}
+	}
+
+	backoff := t.maxDelay
+	if shift := attempt - 1; shift < 32 {
+		backoff = min(t.baseDelay<<shift, t.maxDelay)
+	}
+	if backoff <= 0 {
+		return 0, true
+	}
+	return rand.N(backoff + 1), true
+}
+
+// retryAfter parses a Retry-After header given either in seconds or as an
+// HTTP date.
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// canRetry reports whether req can be sent more than once: it has no body,
+// or one GetBody replays.
+func canRetry(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// rewind returns a copy of req with a fresh body for another attempt.
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.GetBody == nil || req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	retry.Body = body
+	return retry, nil
+}
+
+// drainAndClose reads a bit of an abandoned response body so the connection
+// can be reused.
+func drainAndClose(body io.ReadCloser) {
+	_, _ = io.CopyN(io.Discard, body, 4<<10)
+	_ = body.Close()
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,179 @@
+package httpx
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+)
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		body         string
+		header       http.Header
+		noGetBody    bool
+		statuses     []int
+		retryAfter   string
+		wantAttempts int
+		wantStatus   int
+		wantOutcome  string
+	}{
+		{name: "retries until success", method: http.MethodGet, statuses: []int{503, 502, 200}, wantAttempts: 3, wantStatus: 200, wantOutcome: "success"},
+		{name: "gives up after max attempts", method: http.MethodGet, statuses: []int{503, 503, 503, 503}, wantAttempts: 3, wantStatus: 503, wantOutcome: "exhausted"},
+		{name: "does not retry client errors", method: http.MethodGet, statuses: []int{400, 200}, wantAttempts: 1, wantStatus: 400},
+		{name: "fails on the final status", method: http.MethodGet, statuses: []int{503, 404}, wantAttempts: 2, wantStatus: 404, wantOutcome: "failure"},
+		{name: "replays the body of requests", method: http.MethodPost, body: "booking", statuses: []int{503, 200}, wantAttempts: 2, wantStatus: 200, wantOutcome: "success"},
+		{name: "does not retry bodies it can't replay", method: http.MethodPost, body: "booking", noGetBody: true, statuses: []int{503, 200}, wantAttempts: 1, wantStatus: 503},
+		{
+			name: "replays the body of requests with an idempotency key", method: http.MethodPost, body: "booking",
+			header: http.Header{"Idempotency-Key": {"abc"}}, statuses: []int{503, 200}, wantAttempts: 2, wantStatus: 200, wantOutcome: "success",
+		},
+		{name: "retry after within the limit", method: http.MethodGet, statuses: []int{429, 200}, retryAfter: "1", wantAttempts: 2, wantStatus: 200, wantOutcome: "success"},
+		{name: "retry after beyond the limit", method: http.MethodGet, statuses: []int{429, 200}, retryAfter: "120", wantAttempts: 1, wantStatus: 429, wantOutcome: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := otelt.InstallMetrics(t)
+
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(calls.Add(1)) - 1
+				if body, _ := io.ReadAll(r.Body); string(body) != tt.body {
+					t.Errorf("attempt %d body mismatch: got %q, want %q", n+1, body, tt.body)
+				}
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.statuses[n])
+			}))
+			defer srv.Close()
+
+			var delays []time.Duration
+			rt := NewRetryTransport(nil, WithBackoff(10*time.Millisecond, 5*time.Second))
+			rt.wait = func(ctx context.Context, d time.Duration) error {
+				delays = append(delays, d)
+				return nil
+			}
+
+			req, _ := http.NewRequest(tt.method, srv.URL, strings.NewReader(tt.body))
+			if tt.noGetBody {
+				req.GetBody = nil
+			}
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			resp, err := rt.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("RoundTrip() unexpected error: %v", err)
+			}
+			_ = resp.Body.Close()
+
+			if got := int(calls.Load()); got != tt.wantAttempts {
+				t.Errorf("attempts mismatch: got %d, want %d", got, tt.wantAttempts)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status mismatch: got %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.retryAfter == "1" && (len(delays) != 1 || delays[0] != time.Second) {
+				t.Errorf("delays mismatch: got %v, want [1s]", delays)
+			}
+			for i, d := range delays {
+				if tt.retryAfter == "" && d > 10*time.Millisecond<<i {
+					t.Errorf("delay %d above backoff: got %v", i, d)
+				}
+			}
+
+			dps := collectSum(t, reader, "http.client.retries")
+			var retries int64
+			for _, dp := range dps {
+				retries += dp.Value
+				if outcome, _ := dp.Attributes.Value("http.retry.outcome"); outcome.AsString() != tt.wantOutcome {
+					t.Errorf("http.retry.outcome mismatch: got %q, want %q", outcome.AsString(), tt.wantOutcome)
+				}
+			}
+			if want := int64(tt.wantAttempts - 1); retries != want {
+				t.Errorf("retries mismatch: got %d, want %d", retries, want)
+			}
+		})
+	}
+}
+
+func TestRetryTransport_SpanEventsAndCancel(t *testing.T) {
+	otelt.InstallMetrics(t)
+	exp := otelt.InstallTracing(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx, span := Tracer().Start(ctx, "handler")
+
+	rt := NewRetryTransport(NewTransport(nil), WithMaxAttempts(5))
+	waits := 0
+	rt.wait = func(ctx context.Context, d time.Duration) error {
+		waits++
+		if waits == 2 {
+			cancel()
+			return ctx.Err()
+		}
+		return nil
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if _, err := rt.RoundTrip(req); err != context.Canceled {
+		t.Errorf("RoundTrip() error mismatch: got %v, want %v", err, context.Canceled)
+	}
+	span.End()
+
+	var parent, clients int
+	for _, s := range exp.GetSpans() {
+		if s.Name == "handler" {
+			parent++
+			if len(s.Events) != 2 {
+				t.Errorf("expected 2 retry events, got %d", len(s.Events))
+			}
+			continue
+		}
+		clients++
+	}
+	if parent != 1 || clients != 2 {
+		t.Errorf("span count mismatch: got %d parent and %d client spans, want 1 and 2", parent, clients)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: ""},
+		{value: "3", want: 3 * time.Second, wantOK: true},
+		{value: "-1"},
+		{value: "Wed, 01 May 2024 12:00:30 GMT", want: 30 * time.Second, wantOK: true},
+		{value: "Wed, 01 May 2024 11:00:00 GMT", want: 0, wantOK: true},
+		{value: "soon"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := retryAfter(tt.value, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("retryAfter(%q) mismatch: got %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RouteConfig bundles the settings of a route for the middlewares of this
+// package, registered with WithRouteConfig or the Route options. Zero
+// fields leave the middleware defaults.
+//
+// Middlewares find the RouteConfig in the request context: following
+// RouteConfigMiddleware, or within the route once the Router picked it, as
+// for CacheMiddleware. Those given the Router, e.g. with WithRouteTimeouts,
+// look the route up themselves otherwise.
+type RouteConfig struct {
+	Timeout       time.Duration   // TimeoutMiddleware deadline
+	MaxBody       int64           // MaxBodyMiddleware limit, in bytes
+	RateLimit     *RouteRateLimit // RateLimitMiddleware limit, instead of the shared one
+	SlowThreshold time.Duration   // SlowRequestDetector threshold
+	ApdexTarget   time.Duration   // WithApdex target latency
+	CacheTTL      time.Duration   // CacheMiddleware TTL, for GET routes
+	Cache         *CachePolicy    // CacheControlMiddleware policy, for GET routes
+	Public        bool            // served by AuthMiddleware without credentials
+	Idempotent    bool            // covered by IdempotencyMiddleware
+
+	pattern string // registered, with the router prefix
+}
+
+// RouteRateLimit is the rate limit of a route: each client is allowed Rate
+// requests per second on average, in bursts of up to Burst requests.
+type RouteRateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// WithRouteConfig applies cfg to the route, overriding the Route options
+// given before it.
+func WithRouteConfig(cfg RouteConfig) RouteOption {
+	return func(c *RouteConfig) { *c = cfg }
+}
+
+// validate reports the settings out of range or that make no sense for a
+// route registered with pattern.
+func (c RouteConfig) validate(pattern string) error {
+	var errs []error
+	fail := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+
+	for _, d := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"timeout", c.Timeout},
+		{"slow threshold", c.SlowThreshold},
+		{"Apdex target", c.ApdexTarget},
+		{"cache TTL", c.CacheTTL},
+	} {
+		if d.d < 0 {
+			fail("%s %v is negative", d.name, d.d)
+		}
+	}
+	if c.MaxBody < 0 {
+		fail("max body %d is negative", c.MaxBody)
+	}
+	if c.RateLimit != nil && (c.RateLimit.Rate < 0 || c.RateLimit.Burst < 1) {
+		fail("rate limit of %v per second in bursts of %d is not a limit", c.RateLimit.Rate, c.RateLimit.Burst)
+	}
+	if c.Timeout > 0 && c.SlowThreshold >= c.Timeout {
+		fail("slow threshold %v is not below the timeout %v", c.SlowThreshold, c.Timeout)
+	}
+	var method string // of "[METHOD ][HOST]/PATH", if any
+	if m, _, ok := strings.Cut(pattern, " "); ok && !strings.Contains(m, "/") {
+		method = m
+	}
+	if c.CacheTTL > 0 && method != "" && method != http.MethodGet {
+		fail("cache TTL on a %s route, while only GET responses are cached", method)
+	}
+	if c.Cache != nil {
+		if c.Cache.MaxAge < 0 || c.Cache.SMaxAge < 0 {
+			fail("cache policy max-age %v or s-maxage %v is negative", c.Cache.MaxAge, c.Cache.SMaxAge)
+		}
+		if method != "" && method != http.MethodGet && method != http.MethodHead {
+			fail("cache policy on a %s route, while only GET and HEAD responses get one", method)
+		}
+		if c.CacheTTL > 0 && (c.Cache.Private || c.Cache.NoStore) {
+			fail("cache TTL on a route whose responses are private or no-store")
+		}
+	}
+	if c.Idempotent && (method == http.MethodGet || method == http.MethodHead) {
+		fail("%s requests are idempotent without an Idempotency-Key", method)
+	}
+	return errors.Join(errs...)
+}
+
+// RouteConfigMiddleware looks up the RouteConfig of the route of rt serving
+// each request, for the middlewares it wraps. Register it outermost, around
+// the middlewares wrapping rt.
+func RouteConfigMiddleware(rt *Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, st := withRequestState(r)
+			st.noteRouteConfig(rt.configFor(r))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// routeConfig returns the RouteConfig of the request from its context, or
+// else from rt, which may be nil.
+func routeConfig(r *http.Request, rt *Router) RouteConfig {
+	if st := stateFromContext(r.Context()); st != nil {
+		st.mu.Lock()
+		cfg := st.routeConfig
+		st.mu.Unlock()
+		if cfg != nil {
+			return *cfg
+		}
+	}
+	if rt != nil {
+		return rt.configFor(r)
+	}
+	return RouteConfig{}
+}
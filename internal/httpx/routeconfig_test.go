@@ -0,0 +1,146 @@
+package httpx
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ttlRecorder is a ResponseCache recording the TTL responses are cached
+// for.
+type ttlRecorder struct {
+	mu   sync.Mutex
+	ttls []time.Duration
+}
+
+func (c *ttlRecorder) Get(context.Context, string) (CachedResponse, bool, error) {
+	return CachedResponse{}, false, nil
+}
+
+func (c *ttlRecorder) Set(_ context.Context, _ string, _ CachedResponse, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttls = append(c.ttls, ttl)
+	return nil
+}
+
+func TestRouteConfig(t *testing.T) {
+	otelt.InstallMetrics(t)
+	captureLogs(t)
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	cache := &ttlRecorder{}
+	rt := NewRouter()
+	rt.HandleFunc("GET /trips", ok)
+	rt.HandleFunc("GET /search", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}, WithRouteConfig(RouteConfig{Timeout: 5 * time.Millisecond}))
+	rt.HandleFunc("POST /uploads", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}, WithRouteConfig(RouteConfig{MaxBody: 4}))
+	rt.HandleFunc("GET /quotes", ok, WithRouteConfig(RouteConfig{RateLimit: &RouteRateLimit{Rate: 0, Burst: 1}}))
+	rt.HandleFunc("GET /reports", ok, WithRouteConfig(RouteConfig{SlowThreshold: time.Nanosecond}))
+	rt.HandleFunc("GET /status", ok, WithRouteConfig(RouteConfig{Public: true}))
+	rt.Handle("GET /offers", CacheMiddleware(cache)(http.HandlerFunc(ok)), WithRouteConfig(RouteConfig{CacheTTL: time.Minute, Public: true}))
+	// Responses to authenticated requests are never cached.
+	rt.Handle("GET /hotels", CacheMiddleware(cache)(http.HandlerFunc(ok)), WithRouteConfig(RouteConfig{Public: true}))
+
+	auth := AuthenticatorFunc(func(r *http.Request) (Principal, error) {
+		if r.Header.Get("X-Agent") == "" {
+			return Principal{}, ErrCredentialsMissing
+		}
+		return Principal{ID: r.Header.Get("X-Agent")}, nil
+	})
+	// None of the middlewares is given the router.
+	handler := Chain(
+		RouteConfigMiddleware(rt),
+		NewSlowRequestDetector(time.Hour).Middleware,
+		TimeoutMiddleware(time.Hour),
+		MaxBodyMiddleware(1<<20),
+		RateLimitMiddleware(1000, 1000),
+		AuthMiddleware(auth),
+	)(rt)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		agent  bool
+		want   int
+	}{
+		{name: "defaults", method: http.MethodGet, path: "/trips", agent: true, want: http.StatusOK},
+		{name: "credentials required by default", method: http.MethodGet, path: "/trips", want: http.StatusUnauthorized},
+		{name: "public", method: http.MethodGet, path: "/status", want: http.StatusOK},
+		{name: "timeout", method: http.MethodGet, path: "/search", agent: true, want: http.StatusGatewayTimeout},
+		{name: "within the body limit", method: http.MethodPost, path: "/uploads", body: "1234", agent: true, want: http.StatusOK},
+		{name: "over the body limit", method: http.MethodPost, path: "/uploads", body: "12345", agent: true, want: http.StatusRequestEntityTooLarge},
+		{name: "first of the burst", method: http.MethodGet, path: "/quotes", agent: true, want: http.StatusOK},
+		{name: "rate limited", method: http.MethodGet, path: "/quotes", agent: true, want: http.StatusTooManyRequests},
+		{name: "shared limit left alone", method: http.MethodGet, path: "/trips", agent: true, want: http.StatusOK},
+		{name: "slow threshold", method: http.MethodGet, path: "/reports", agent: true, want: http.StatusOK},
+		{name: "cache TTL", method: http.MethodGet, path: "/offers", want: http.StatusOK},
+		{name: "default cache TTL", method: http.MethodGet, path: "/hotels", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.agent {
+			req.Header.Set("X-Agent", "agent-7")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: %s %s = %d, want %d", tt.name, tt.method, tt.path, rec.Code, tt.want)
+		}
+	}
+
+	otelt.RequireCounterValue(t, "http.server.slow_requests", []attribute.KeyValue{attribute.String("http.route", "/reports")}, 1)
+	if got := cache.ttls; len(got) != 2 || got[0] != time.Minute || got[1] != 30*time.Second {
+		t.Errorf("cached for %v, want the route's 1m then the default 30s", got)
+	}
+}
+
+func TestRouteConfig_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		cfg     RouteConfig
+		want    string // in the panic, if any
+	}{
+		{name: "cache TTL on GET", pattern: "GET /offers", cfg: RouteConfig{CacheTTL: time.Minute}},
+		{name: "cache TTL on any method", pattern: "/offers", cfg: RouteConfig{CacheTTL: time.Minute}},
+		{name: "cache TTL on POST", pattern: "POST /bookings", cfg: RouteConfig{CacheTTL: time.Minute}, want: "cache TTL on a POST route"},
+		{name: "idempotent GET", pattern: "GET /trips", cfg: RouteConfig{Idempotent: true}, want: "GET requests are idempotent"},
+		{name: "negative timeout", pattern: "GET /trips", cfg: RouteConfig{Timeout: -time.Second}, want: "timeout -1s is negative"},
+		{name: "slow after the timeout", pattern: "GET /trips", cfg: RouteConfig{Timeout: time.Second, SlowThreshold: 2 * time.Second}, want: "slow threshold 2s is not below the timeout 1s"},
+		{name: "cache policy on POST", pattern: "POST /bookings", cfg: RouteConfig{Cache: &CachePolicy{MaxAge: time.Minute}}, want: "cache policy on a POST route"},
+		{name: "cached private responses", pattern: "GET /offers", cfg: RouteConfig{CacheTTL: time.Minute, Cache: &CachePolicy{Private: true}}, want: "private or no-store"},
+		{name: "empty burst", pattern: "GET /quotes", cfg: RouteConfig{RateLimit: &RouteRateLimit{Rate: 1}}, want: "is not a limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			func() {
+				defer func() {
+					if v := recover(); v != nil {
+						got = v.(string)
+					}
+				}()
+				NewRouter().HandleFunc(tt.pattern, func(http.ResponseWriter, *http.Request) {}, WithRouteConfig(tt.cfg))
+			}()
+			if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+				t.Errorf("registration panic %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route values recorded by Router for requests no registered pattern serves,
+// on their spans and logs. MetricsMiddleware records them as UnmatchedRoute,
+// or the route their path has for other methods.
+const (
+	NotFoundRoute         = "not_found"
+	MethodNotAllowedRoute = "method_not_allowed"
+)
+
+// Router is an http.ServeMux that shares the matched pattern with the
+// metrics, tracing and logging middlewares wrapping it, so they label
+// requests with the registered route template (e.g. /trips/{id}) without a
+// RouteResolver.
+type Router struct {
+	mux    *http.ServeMux
+	prefix string
+	routes *routeTable
+}
+
+// routeTable holds per-route settings shared by a router and its groups,
+// keyed by the full registered pattern.
+type routeTable struct {
+	mu      sync.RWMutex
+	options map[string]RouteConfig
+}
+
+// RouteOption configures a single route registered on a Router.
+type RouteOption func(*RouteConfig)
+
+// RouteTimeout overrides the TimeoutMiddleware deadline for the route.
+func RouteTimeout(d time.Duration) RouteOption {
+	return func(c *RouteConfig) { c.Timeout = d }
+}
+
+// RouteMaxBody overrides the MaxBodyMiddleware limit for the route.
+func RouteMaxBody(limit int64) RouteOption {
+	return func(c *RouteConfig) { c.MaxBody = limit }
+}
+
+// RouteIdempotent makes IdempotencyMiddleware cover the route.
+func RouteIdempotent() RouteOption {
+	return func(c *RouteConfig) { c.Idempotent = true }
+}
+
+// RoutePublic lets requests to the route through AuthMiddleware without
+// credentials.
+func RoutePublic() RouteOption {
+	return func(c *RouteConfig) { c.Public = true }
+}
+
+// RouteSlowThreshold overrides the SlowRequestDetector threshold for the
+// route.
+func RouteSlowThreshold(d time.Duration) RouteOption {
+	return func(c *RouteConfig) { c.SlowThreshold = d }
+}
+
+// RouteApdexTarget overrides the WithApdex target latency for the route.
+func RouteApdexTarget(d time.Duration) RouteOption {
+	return func(c *RouteConfig) { c.ApdexTarget = d }
+}
+
+// NewRouter returns an empty Router.
+func NewRouter() *Router {
+	return &Router{mux: http.NewServeMux(), routes: &routeTable{options: map[string]RouteConfig{}}}
+}
+
+// Mux returns the underlying ServeMux, shared by the router and its groups.
+func (rt *Router) Mux() *http.ServeMux { return rt.mux }
+
+// Group returns a router registering its patterns under prefix on the same
+// mux: Group("/api").Handle("GET /trips", h) serves GET /api/trips.
+func (rt *Router) Group(prefix string) *Router {
+	return &Router{mux: rt.mux, prefix: rt.prefix + strings.TrimSuffix(prefix, "/"), routes: rt.routes}
+}
+
+// Handle registers h for pattern, using ServeMux pattern syntax. Like
+// ServeMux.Handle, it panics on an invalid pattern, and also on a RouteConfig
+// that makes no sense for it.
+func (rt *Router) Handle(pattern string, h http.Handler, opts ...RouteOption) {
+	var cfg RouteConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	pattern = rt.withPrefix(pattern)
+	if err := cfg.validate(pattern); err != nil {
+		panic(fmt.Sprintf("httpx: route %q: %v", pattern, err))
+	}
+	cfg.pattern = pattern
+	rt.routes.mu.Lock()
+	rt.routes.options[pattern] = cfg
+	rt.routes.mu.Unlock()
+
+	rt.mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if st := stateFromContext(r.Context()); st != nil {
+			st.notePattern(r)
+			st.noteRouteConfig(cfg)
+		}
+		h.ServeHTTP(w, r)
+	}))
+}
+
+// HandleFunc registers f for pattern.
+func (rt *Router) HandleFunc(pattern string, f func(http.ResponseWriter, *http.Request), opts ...RouteOption) {
+	rt.Handle(pattern, http.HandlerFunc(f), opts...)
+}
+
+// ServeHTTP dispatches to the matching handler. Requests matching nothing
+// are labelled NotFoundRoute or MethodNotAllowedRoute; for the latter, the
+// route their path has for other methods is kept for the metrics.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sw, w := captureStatus(w)
+	r, st := withRequestState(r)
+
+	rt.mux.ServeHTTP(w, r)
+
+	if st.matchedRoute() == "" {
+		route := NotFoundRoute
+		if sw.status == http.StatusMethodNotAllowed {
+			route = MethodNotAllowedRoute
+			if allowed := rt.allowedRoute(r); allowed != "" {
+				st.mu.Lock()
+				st.allowedRoute = allowed
+				st.mu.Unlock()
+			}
+		}
+		st.noteRoute(route)
+	}
+}
+
+// allowedRoute returns the route r's path has for some other method.
+func (rt *Router) allowedRoute(r *http.Request) string {
+	probe := *r
+	for _, method := range []string{
+		http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions,
+	} {
+		probe.Method = method
+		if _, pattern := rt.mux.Handler(&probe); pattern != "" {
+			return routeFromPattern(pattern)
+		}
+	}
+	return ""
+}
+
+// configFor returns the RouteConfig of the route r would be served by.
+func (rt *Router) configFor(r *http.Request) RouteConfig {
+	_, pattern := rt.mux.Handler(r)
+	if pattern == "" {
+		return RouteConfig{}
+	}
+	rt.routes.mu.RLock()
+	defer rt.routes.mu.RUnlock()
+	return rt.routes.options[pattern]
+}
+
+// withPrefix inserts the router prefix in front of the path of pattern,
+// keeping any method and host.
+func (rt *Router) withPrefix(pattern string) string {
+	if rt.prefix == "" {
+		return pattern
+	}
+	i := strings.Index(pattern, "/")
+	if i < 0 {
+		return pattern
+	}
+	return pattern[:i] + rt.prefix + pattern[i:]
+}
@@ -0,0 +1,103 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+)
+
+func TestRouter(t *testing.T) {
+	rt := NewRouter()
+	rt.HandleFunc("GET /trips/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.PathValue("id")))
+	})
+	rt.Group("/api/").HandleFunc("POST /bookings", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantRoute  string
+		wantSpan   string
+	}{
+		{name: "matched pattern", method: http.MethodGet, path: "/trips/42", wantStatus: http.StatusOK, wantRoute: "/trips/{id}", wantSpan: "GET /trips/{id}"},
+		{name: "group prefix", method: http.MethodPost, path: "/api/bookings", wantStatus: http.StatusCreated, wantRoute: "/api/bookings", wantSpan: "POST /api/bookings"},
+		{name: "unknown path", method: http.MethodGet, path: "/wp-admin.php", wantStatus: http.StatusNotFound, wantRoute: UnmatchedRoute, wantSpan: "GET"},
+		{name: "wrong method", method: http.MethodDelete, path: "/trips/42", wantStatus: http.StatusMethodNotAllowed, wantRoute: "/trips/{id}", wantSpan: "DELETE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := otelt.InstallMetrics(t)
+			exp := otelt.InstallTracing(t)
+
+			rec := httptest.NewRecorder()
+			TracingMiddleware(MetricsMiddleware(rt)).ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status mismatch: got %d, want %d", rec.Code, tt.wantStatus)
+			}
+			dps := collectSum(t, reader, "http.server.requests")
+			if len(dps) != 1 {
+				t.Fatalf("expected 1 series, got %d", len(dps))
+			}
+			if route, _ := dps[0].Attributes.Value("http.route"); route.AsString() != tt.wantRoute {
+				t.Errorf("http.route mismatch: got %q, want %q", route.AsString(), tt.wantRoute)
+			}
+			_, notAllowed := dps[0].Attributes.Value("http.method_not_allowed")
+			if want := tt.wantStatus == http.StatusMethodNotAllowed; notAllowed != want {
+				t.Errorf("http.method_not_allowed set: %v, want %v", notAllowed, want)
+			}
+			spans := exp.GetSpans()
+			if len(spans) != 1 || spans[0].Name != tt.wantSpan {
+				t.Errorf("span name mismatch: got %v, want %q", spans, tt.wantSpan)
+			}
+		})
+	}
+}
+
+func TestRouter_ScannerTraffic(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+	exp := otelt.InstallTracing(t)
+
+	rt := NewRouter()
+	rt.HandleFunc("GET /trips/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	handler := TracingMiddleware(MetricsMiddleware(rt))
+
+	for i := range 50 {
+		for _, req := range []struct{ method, path string }{
+			{"FOO", "/"},
+			{"PROPFIND" + strconv.Itoa(i), "/trips/" + strconv.Itoa(i)},
+			{http.MethodGet, "/wp-admin.php?" + strconv.Itoa(i)},
+			{http.MethodGet, "/.env" + strconv.Itoa(i)},
+			{http.MethodPost, "/trips/" + strconv.Itoa(i)},
+		} {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+		}
+	}
+
+	series := map[string]bool{}
+	for _, dp := range collectSum(t, reader, "http.server.requests") {
+		method, _ := dp.Attributes.Value("http.method")
+		route, _ := dp.Attributes.Value("http.route")
+		series[method.AsString()+" "+route.AsString()] = true
+	}
+	want := map[string]bool{"_OTHER unmatched": true, "_OTHER /trips/{id}": true, "GET unmatched": true, "POST /trips/{id}": true}
+	if len(series) != len(want) {
+		t.Errorf("series %v, want %v", series, want)
+	}
+	for s := range want {
+		if !series[s] {
+			t.Errorf("series %q missing from %v", s, series)
+		}
+	}
+	if spans := exp.GetSpans(); len(spans) == 0 || spans[0].Name != "FOO" {
+		t.Errorf("first span %v, want it named after the raw method", spans[:min(len(spans), 1)])
+	}
+}
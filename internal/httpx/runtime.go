@@ -0,0 +1,72 @@
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+)
+
+// registerRuntimeMetrics reports Go runtime and process metrics through
+// observable instruments read on every collection.
+func registerRuntimeMetrics(mp metric.MeterProvider) error {
+	m := mp.Meter(instrumentationName)
+
+	goroutines, err := m.Int64ObservableGauge("process.runtime.go.goroutines",
+		metric.WithDescription("Number of live goroutines"))
+	if err != nil {
+		return fmt.Errorf("create runtime instruments: %w", err)
+	}
+	heapInUse, err := m.Int64ObservableGauge("process.runtime.go.mem.heap_inuse",
+		metric.WithDescription("Bytes in in-use heap spans"),
+		metric.WithUnit("By"))
+	if err != nil {
+		return fmt.Errorf("create runtime instruments: %w", err)
+	}
+	gcPause, err := m.Float64ObservableCounter("process.runtime.go.gc.pause_total",
+		metric.WithDescription("Cumulative time spent in GC stop-the-world pauses"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return fmt.Errorf("create runtime instruments: %w", err)
+	}
+	gcCount, err := m.Int64ObservableCounter("process.runtime.go.gc.count",
+		metric.WithDescription("Number of completed GC cycles"))
+	if err != nil {
+		return fmt.Errorf("create runtime instruments: %w", err)
+	}
+	openFDs, err := m.Int64ObservableGauge("process.open_file_descriptors",
+		metric.WithDescription("Number of file descriptors held by the process"))
+	if err != nil {
+		return fmt.Errorf("create runtime instruments: %w", err)
+	}
+	cpuTime, err := m.Float64ObservableCounter("process.cpu.time",
+		metric.WithDescription("CPU time consumed by the process, user plus system"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return fmt.Errorf("create runtime instruments: %w", err)
+	}
+
+	_, err = m.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+
+		o.ObserveInt64(goroutines, int64(runtime.NumGoroutine()))
+		o.ObserveInt64(heapInUse, int64(ms.HeapInuse))
+		o.ObserveFloat64(gcPause, time.Duration(ms.PauseTotalNs).Seconds())
+		o.ObserveInt64(gcCount, int64(ms.NumGC))
+		// Platforms without these numbers simply don't report them.
+		if n, ok := openFileDescriptors(); ok {
+			o.ObserveInt64(openFDs, n)
+		}
+		if d, ok := processCPUTime(); ok {
+			o.ObserveFloat64(cpuTime, d.Seconds())
+		}
+		return nil
+	}, goroutines, heapInUse, gcPause, gcCount, openFDs, cpuTime)
+	if err != nil {
+		return fmt.Errorf("register runtime metrics callback: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package httpx
+
+import "time"
+
+func openFileDescriptors() (int64, bool) { return 0, false }
+
+func processCPUTime() (time.Duration, bool) { return 0, false }
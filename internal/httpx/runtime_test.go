@@ -0,0 +1,48 @@
+package httpx
+
+import (
+	"context"
+	"testing"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestRegisterRuntimeMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	t.Cleanup(func() { _ = mp.Shutdown(context.Background()) })
+
+	if err := registerRuntimeMetrics(mp); err != nil {
+		t.Fatalf("registerRuntimeMetrics() unexpected error: %v", err)
+	}
+
+	for _, name := range []string{
+		"process.runtime.go.goroutines",
+		"process.runtime.go.mem.heap_inuse",
+		"process.open_file_descriptors",
+	} {
+		m, ok := collectMetric(t, reader, name)
+		if !ok {
+			t.Errorf("%s not collected", name)
+			continue
+		}
+		if dps := m.Data.(metricdata.Gauge[int64]).DataPoints; len(dps) != 1 || dps[0].Value <= 0 {
+			t.Errorf("%s data points mismatch: got %+v, want one positive value", name, dps)
+		}
+	}
+
+	for _, name := range []string{"process.runtime.go.gc.pause_total", "process.cpu.time"} {
+		m, ok := collectMetric(t, reader, name)
+		if !ok {
+			t.Errorf("%s not collected", name)
+			continue
+		}
+		if dps := m.Data.(metricdata.Sum[float64]).DataPoints; len(dps) != 1 {
+			t.Errorf("%s data points mismatch: got %+v, want one", name, dps)
+		}
+	}
+	if _, ok := collectMetric(t, reader, "process.runtime.go.gc.count"); !ok {
+		t.Error("process.runtime.go.gc.count not collected")
+	}
+}
@@ -0,0 +1,166 @@
+package httpx
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestSecureHeadersMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []SecureHeadersOption
+		handler http.HandlerFunc
+		header  string
+		want    string
+	}{
+		{name: "content type options", header: "X-Content-Type-Options", want: "nosniff"},
+		{name: "frame options", header: "X-Frame-Options", want: "DENY"},
+		{name: "referrer policy", header: "Referrer-Policy", want: "no-referrer"},
+		{name: "content security policy", header: "Content-Security-Policy", want: "default-src 'none'; frame-ancestors 'none'"},
+		{name: "permissions policy", header: "Permissions-Policy", want: "camera=(), geolocation=(), microphone=()"},
+		{name: "no HSTS over plain HTTP", header: "Strict-Transport-Security", want: ""},
+		{
+			name:   "configured content security policy",
+			opts:   []SecureHeadersOption{WithContentSecurityPolicy("default-src 'self'")},
+			header: "Content-Security-Policy",
+			want:   "default-src 'self'",
+		},
+		{
+			name:   "override",
+			opts:   []SecureHeadersOption{WithSecureHeader("referrer-policy", "same-origin")},
+			header: "Referrer-Policy",
+			want:   "same-origin",
+		},
+		{
+			name:   "removal",
+			opts:   []SecureHeadersOption{WithSecureHeader("X-Frame-Options", "")},
+			header: "X-Frame-Options",
+			want:   "",
+		},
+		{
+			name:   "extra header",
+			opts:   []SecureHeadersOption{WithSecureHeader("Cross-Origin-Opener-Policy", "same-origin")},
+			header: "Cross-Origin-Opener-Policy",
+			want:   "same-origin",
+		},
+		{
+			name:    "handler wins",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.Header().Set("X-Frame-Options", "SAMEORIGIN") },
+			header:  "X-Frame-Options",
+			want:    "SAMEORIGIN",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := tt.handler
+			if handler == nil {
+				handler = func(w http.ResponseWriter, r *http.Request) {}
+			}
+			rec := httptest.NewRecorder()
+			SecureHeadersMiddleware(tt.opts...)(handler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := rec.Header().Values(tt.header); len(got) > 1 || rec.Header().Get(tt.header) != tt.want {
+				t.Errorf("%s = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecureHeadersMiddleware_KeepsHeadersAlreadySet(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Security-Policy", "sandbox")
+	SecureHeadersMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := rec.Header().Get("Content-Security-Policy"); got != "sandbox" {
+		t.Errorf("Content-Security-Policy = %q, want the value set before", got)
+	}
+}
+
+func TestSecureHeadersMiddleware_HSTS(t *testing.T) {
+	proxies := WithHSTSBehindProxy(netip.MustParsePrefix("10.0.0.0/8"))
+
+	tests := []struct {
+		name    string
+		opts    []SecureHeadersOption
+		tls     bool
+		peer    string
+		headers map[string]string
+		want    bool
+	}{
+		{name: "TLS", tls: true, want: true},
+		{name: "plain HTTP", want: false},
+		{
+			name:    "forwarded proto without trusted proxies",
+			peer:    "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-Proto": "https"},
+			want:    false,
+		},
+		{
+			name:    "trusted proxy over HTTPS",
+			opts:    []SecureHeadersOption{proxies},
+			peer:    "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-Proto": "https"},
+			want:    true,
+		},
+		{
+			name:    "trusted proxy over HTTP",
+			opts:    []SecureHeadersOption{proxies},
+			peer:    "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-Proto": "http"},
+			want:    false,
+		},
+		{
+			name:    "trusted proxy reports the nearest hop",
+			opts:    []SecureHeadersOption{proxies},
+			peer:    "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-Proto": "https, http"},
+			want:    false,
+		},
+		{
+			name:    "trusted proxy using Forwarded",
+			opts:    []SecureHeadersOption{proxies},
+			peer:    "10.0.0.2:5000",
+			headers: map[string]string{"Forwarded": `for=198.51.100.9;proto=https`, "X-Forwarded-Proto": "http"},
+			want:    true,
+		},
+		{
+			name:    "spoofed header from an untrusted peer",
+			opts:    []SecureHeadersOption{proxies},
+			peer:    "203.0.113.7:5000",
+			headers: map[string]string{"X-Forwarded-Proto": "https"},
+			want:    false,
+		},
+		{
+			name: "removed",
+			opts: []SecureHeadersOption{WithSecureHeader("Strict-Transport-Security", "")},
+			tls:  true,
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if tt.peer != "" {
+				req.RemoteAddr = tt.peer
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			SecureHeadersMiddleware(tt.opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Strict-Transport-Security"); (got != "") != tt.want {
+				t.Errorf("Strict-Transport-Security = %q, want present: %v", got, tt.want)
+			}
+		})
+	}
+}
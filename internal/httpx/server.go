@@ -0,0 +1,381 @@
+package httpx
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// RunOption configures Run.
+type RunOption func(*runConfig)
+
+type runConfig struct {
+	grace      time.Duration
+	preDrain   time.Duration
+	telemetry  Shutdown
+	onListen   func(net.Addr)
+	health     *Health
+	admin      *Admin
+	addrs      []string
+	listeners  []net.Listener
+	socketMode os.FileMode
+	tls        *tlsSettings
+	h2c        bool
+	limits     serverLimits
+}
+
+// serverLimits are the http.Server timeouts and limits of the main server.
+type serverLimits struct {
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxHeaderBytes    int
+}
+
+// handshakeTimeout bounds TLS handshakes as net/http would: by the
+// shortest of the timeouts set.
+func (l serverLimits) handshakeTimeout() time.Duration {
+	var d time.Duration
+	for _, t := range []time.Duration{l.readHeaderTimeout, l.readTimeout, l.writeTimeout} {
+		if t > 0 && (d == 0 || t < d) {
+			d = t
+		}
+	}
+	return d
+}
+
+// defaultLimits are those of the admin server, and of the main one unless
+// changed.
+var defaultLimits = serverLimits{readHeaderTimeout: 10 * time.Second}
+
+// WithGracePeriod bounds how long in-flight requests may take to finish once
+// shutdown starts. Defaults to 10s.
+func WithGracePeriod(d time.Duration) RunOption {
+	return func(c *runConfig) { c.grace = d }
+}
+
+// WithPreDrainDelay keeps serving for d once shutdown starts, with the
+// health not ready, before draining: load balancers take a few seconds to
+// notice a failing readiness check and stop sending new connections, which
+// would otherwise be refused. Defaults to none.
+func WithPreDrainDelay(d time.Duration) RunOption {
+	return func(c *runConfig) { c.preDrain = d }
+}
+
+// WithTelemetryShutdown flushes telemetry with shutdown after the server has
+// drained, so spans and metrics of the last requests are exported.
+func WithTelemetryShutdown(shutdown Shutdown) RunOption {
+	return func(c *runConfig) { c.telemetry = shutdown }
+}
+
+// WithOnListen calls fn with each bound address once the server listens,
+// which is how callers learn the port chosen for ":0".
+func WithOnListen(fn func(net.Addr)) RunOption {
+	return func(c *runConfig) { c.onListen = fn }
+}
+
+// WithListenAddrs serves the handler on addrs too, in the same form as the
+// address given to Run.
+func WithListenAddrs(addrs ...string) RunOption {
+	return func(c *runConfig) { c.addrs = append(c.addrs, addrs...) }
+}
+
+// WithListeners serves the handler on lns too, such as ones inherited from
+// systemd. Run closes them on shutdown. With listeners, the address given to
+// Run may be empty.
+func WithListeners(lns ...net.Listener) RunOption {
+	return func(c *runConfig) { c.listeners = append(c.listeners, lns...) }
+}
+
+// WithSocketMode sets the permissions of the unix domain sockets Run
+// creates. Defaults to 0660.
+func WithSocketMode(mode os.FileMode) RunOption {
+	return func(c *runConfig) { c.socketMode = mode }
+}
+
+// WithH2C also serves HTTP/2 without TLS to clients starting with it, such
+// as the service mesh, which otherwise fall back to HTTP/1.1 and lose
+// multiplexing. Clients asking to upgrade an HTTP/1.1 connection to h2c stay
+// on HTTP/1.1.
+func WithH2C() RunOption {
+	return func(c *runConfig) { c.h2c = true }
+}
+
+// WithReadHeaderTimeout closes connections not sending a request header
+// within d. Defaults to 10s.
+func WithReadHeaderTimeout(d time.Duration) RunOption {
+	return func(c *runConfig) { c.limits.readHeaderTimeout = d }
+}
+
+// WithReadTimeout bounds the time to read a request, body included. There
+// is no limit by default.
+func WithReadTimeout(d time.Duration) RunOption {
+	return func(c *runConfig) { c.limits.readTimeout = d }
+}
+
+// WithWriteTimeout bounds the time from the end of the request header to
+// the end of the response, which streaming responses must fit in too. There
+// is no limit by default.
+func WithWriteTimeout(d time.Duration) RunOption {
+	return func(c *runConfig) { c.limits.writeTimeout = d }
+}
+
+// WithIdleTimeout closes keep-alive connections idle for d. Defaults to the
+// read timeout, and no limit without one.
+func WithIdleTimeout(d time.Duration) RunOption {
+	return func(c *runConfig) { c.limits.idleTimeout = d }
+}
+
+// WithMaxHeaderBytes bounds the size of request headers. Defaults to
+// http.DefaultMaxHeaderBytes, 1MB.
+func WithMaxHeaderBytes(n int) RunOption {
+	return func(c *runConfig) { c.limits.maxHeaderBytes = n }
+}
+
+// WithHealth marks h ready once the server listens and not ready as soon as
+// shutdown starts, so load balancers stop sending traffic while draining.
+func WithHealth(h *Health) RunOption {
+	return func(c *runConfig) { c.health = h }
+}
+
+// WithAdmin runs the admin server alongside the main one. Both start
+// before the health is marked ready and drain together on shutdown.
+func WithAdmin(a *Admin) RunOption {
+	return func(c *runConfig) { c.admin = a }
+}
+
+// Run serves handler on addr until ctx is done or the process gets SIGINT or
+// SIGTERM. It then marks the health not ready, keeps serving for the
+// pre-drain delay, stops accepting connections, waits up to the grace
+// period for in-flight requests, and flushes telemetry. Each phase is logged
+// and reported by the http.server.shutdown.phase gauge: 0 serving, 1
+// pre-drain, 2 draining, 3 flushing telemetry. It returns the first error
+// from listening, serving or shutting down.
+//
+// The address is a TCP one such as ":8080", or a unix domain socket such as
+// unix:///var/run/acai.sock. A socket file left behind by a process that
+// died is replaced, and the socket is removed on shutdown.
+//
+// The connections of the main server are recorded in the
+// http.server.connections metrics: those open by http.connection.state,
+// active or idle, those opened and closed, those closed for not sending a
+// request header in time, and the requests each served.
+func Run(ctx context.Context, addr string, handler http.Handler, opts ...RunOption) error {
+	cfg := runConfig{grace: 10 * time.Second, socketMode: defaultSocketMode, limits: defaultLimits}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	main := &server{name: "HTTP", addrs: cfg.addrs, lns: cfg.listeners, handler: handler, onListen: cfg.onListen, h2c: cfg.h2c,
+		limits: cfg.limits, conns: newConnTracker(cfg.limits.headerTimeout())}
+	if addr != "" || len(cfg.listeners) == 0 {
+		main.addrs = append([]string{addr}, main.addrs...)
+	}
+	servers := []*server{main}
+	if a := cfg.admin; a != nil {
+		admin := &server{name: "Admin", lns: a.listeners, handler: a.Handler(), onListen: a.onListen, limits: defaultLimits}
+		if a.addr != "" || len(a.listeners) == 0 {
+			admin.addrs = []string{a.addr}
+		}
+		servers = append(servers, admin)
+	}
+	fail := func(err error) error {
+		for _, s := range servers {
+			s.close()
+		}
+		_ = cfg.shutdownTelemetry()
+		return err
+	}
+	if cfg.tls != nil {
+		tlsConfig, certs, err := cfg.tls.config()
+		if err != nil {
+			return fail(err)
+		}
+		main.tls = tlsConfig
+		go certs.watch(ctx, cfg.tls.reloadInterval)
+	}
+	for _, s := range servers {
+		if err := s.listen(cfg.socketMode); err != nil {
+			return fail(err)
+		}
+	}
+
+	var listeners int
+	for _, s := range servers {
+		listeners += len(s.lns)
+	}
+	serveErr := make(chan error, listeners)
+	for _, s := range servers {
+		for _, ln := range s.lns {
+			go func() { serveErr <- s.srv.Serve(ln) }()
+			slog.Info(s.name+" server listening", "addr", ln.Addr().String())
+		}
+	}
+	if cfg.health != nil {
+		cfg.health.SetReady()
+	}
+	recordShutdownPhase(phaseServing)
+
+	var firstErr error
+	pending := listeners
+	select {
+	case err := <-serveErr:
+		// One server failing takes the others down with it.
+		firstErr = err
+		pending--
+	case <-ctx.Done():
+	}
+
+	if cfg.health != nil {
+		cfg.health.SetNotReady()
+	}
+	if cfg.preDrain > 0 && firstErr == nil {
+		recordShutdownPhase(phasePreDrain)
+		slog.Info("Shutdown started, serving until load balancers stop sending traffic", "pre_drain_delay", cfg.preDrain)
+		timer := time.NewTimer(cfg.preDrain)
+		select {
+		case err := <-serveErr:
+			firstErr = err
+			pending--
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+
+	recordShutdownPhase(phaseDraining)
+	slog.Info("Shutting down HTTP server", "grace_period", cfg.grace)
+	drainStart := time.Now()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.grace)
+	defer cancel()
+	shutdownErr := make(chan error, len(servers))
+	for _, s := range servers {
+		go func() {
+			err := s.srv.Shutdown(shutdownCtx)
+			if err != nil {
+				_ = s.srv.Close()
+			}
+			shutdownErr <- err
+		}()
+	}
+	for range servers {
+		if err := <-shutdownErr; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for range pending {
+		if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	slog.Info("HTTP server drained", "duration", time.Since(drainStart))
+
+	recordShutdownPhase(phaseFlushing)
+	slog.Info("Flushing telemetry")
+	if err := cfg.shutdownTelemetry(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// Values of the http.server.shutdown.phase gauge.
+const (
+	phaseServing int64 = iota
+	phasePreDrain
+	phaseDraining
+	phaseFlushing
+)
+
+func recordShutdownPhase(phase int64) {
+	loadServerMetrics().shutdownPhase.Record(context.Background(), phase)
+}
+
+// server is one of the servers run by Run, on one or more listeners.
+type server struct {
+	name     string
+	addrs    []string
+	handler  http.Handler
+	onListen func(net.Addr)
+	tls      *tls.Config // to serve over TLS, when set
+	h2c      bool
+	limits   serverLimits
+	conns    *connTracker // recording the connections in metrics, when set
+
+	lns []net.Listener // given, then bound from addrs
+	srv *http.Server
+}
+
+func (s *server) listen(socketMode os.FileMode) error {
+	for _, addr := range s.addrs {
+		ln, err := listen(addr, socketMode)
+		if err != nil {
+			return err
+		}
+		s.lns = append(s.lns, ln)
+	}
+	s.srv = &http.Server{
+		Handler:           s.handler,
+		ReadHeaderTimeout: s.limits.readHeaderTimeout,
+		ReadTimeout:       s.limits.readTimeout,
+		WriteTimeout:      s.limits.writeTimeout,
+		IdleTimeout:       s.limits.idleTimeout,
+		MaxHeaderBytes:    s.limits.maxHeaderBytes,
+	}
+	if s.conns != nil {
+		s.srv.ConnState = s.conns.connState
+	}
+	if s.h2c {
+		s.srv.Protocols = new(http.Protocols)
+		s.srv.Protocols.SetHTTP1(true)
+		s.srv.Protocols.SetHTTP2(true)
+		s.srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	if s.tls != nil {
+		for i, ln := range s.lns {
+			s.lns[i] = newHandshakeListener(ln, s.tls, s.limits.handshakeTimeout())
+		}
+		s.srv.Handler = clientCertMiddleware(s.handler)
+		s.srv.ErrorLog = tlsErrorLog(s.name)
+	}
+	if s.onListen != nil {
+		for _, ln := range s.lns {
+			s.onListen(ln.Addr())
+		}
+	}
+	return nil
+}
+
+// close closes the listeners of a server that never served.
+func (s *server) close() {
+	for _, ln := range s.lns {
+		_ = ln.Close()
+	}
+}
+
+// headerTimeout returns the time the server gives clients to send a
+// request header, which is the read timeout unless set apart.
+func (l serverLimits) headerTimeout() time.Duration {
+	if l.readHeaderTimeout > 0 {
+		return l.readHeaderTimeout
+	}
+	return l.readTimeout
+}
+
+func (c *runConfig) shutdownTelemetry() error {
+	if c.telemetry == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return c.telemetry(ctx)
+}
@@ -0,0 +1,352 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestRun_DrainsInFlightRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	health := NewHealth()
+	var flushed, drained atomic.Bool
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("done"))
+		drained.Store(true)
+	})
+	addrCh := make(chan string, 1)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- Run(ctx, "127.0.0.1:0", handler,
+			WithGracePeriod(5*time.Second),
+			WithHealth(health),
+			WithOnListen(func(a net.Addr) { addrCh <- a.String() }),
+			WithTelemetryShutdown(func(context.Context) error {
+				flushed.Store(drained.Load())
+				return nil
+			}),
+		)
+	}()
+	addr := <-addrCh
+
+	respErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err == nil {
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("status mismatch: got %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+			_ = resp.Body.Close()
+		}
+		respErr <- err
+	}()
+
+	<-started
+	if !health.Ready() {
+		t.Error("health not ready while serving")
+	}
+	cancel()
+
+	if err := <-respErr; err != nil {
+		t.Fatalf("in-flight request failed during shutdown: %v", err)
+	}
+	if err := <-runErr; err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+	if health.Ready() {
+		t.Error("health still ready after shutdown")
+	}
+	if !flushed.Load() {
+		t.Error("telemetry was not flushed after draining")
+	}
+	if _, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		t.Error("server still accepting connections after Run returned")
+	}
+}
+
+func TestRun_PreDrainDelay(t *testing.T) {
+	logs := captureLogs(t)
+	reader := otelt.InstallMetrics(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	phase := func() int64 {
+		t.Helper()
+		m, ok := collectMetric(t, reader, "http.server.shutdown.phase")
+		if !ok {
+			t.Fatal("http.server.shutdown.phase not recorded")
+		}
+		return m.Data.(metricdata.Gauge[int64]).DataPoints[0].Value
+	}
+
+	health := NewHealth()
+	mux := http.NewServeMux()
+	mux.Handle("GET /readyz", health.ReadinessHandler())
+	mux.HandleFunc("GET /trips", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("trips"))
+	})
+	var flushPhase atomic.Int64
+	addrCh := make(chan string, 1)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- Run(ctx, "127.0.0.1:0", mux,
+			WithPreDrainDelay(500*time.Millisecond),
+			WithHealth(health),
+			WithOnListen(func(a net.Addr) { addrCh <- a.String() }),
+			WithTelemetryShutdown(func(context.Context) error {
+				flushPhase.Store(phase())
+				return nil
+			}),
+		)
+	}()
+	addr := <-addrCh
+	get := func(path string) (int, error) {
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			return 0, err
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	if status, err := get("/readyz"); err != nil || status != http.StatusOK {
+		t.Fatalf("/readyz while serving: %d, %v", status, err)
+	}
+	if got := phase(); got != phaseServing {
+		t.Errorf("phase while serving: got %d, want %d", got, phaseServing)
+	}
+	cancel()
+
+	// Load balancers see the instance fail its readiness check, while the
+	// requests they still send are served.
+	eventually(t, "readiness turns off", func() bool {
+		status, err := get("/readyz")
+		return err == nil && status == http.StatusServiceUnavailable
+	})
+	if status, err := get("/trips"); err != nil || status != http.StatusOK {
+		t.Errorf("request during the pre-drain delay: %d, %v", status, err)
+	}
+	if got := phase(); got != phasePreDrain {
+		t.Errorf("phase during the pre-drain delay: got %d, want %d", got, phasePreDrain)
+	}
+
+	if err := <-runErr; err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+	if got := flushPhase.Load(); got != phaseFlushing {
+		t.Errorf("phase while flushing telemetry: got %d, want %d", got, phaseFlushing)
+	}
+	var msgs []any
+	for _, line := range decodeLogLines(t, logs) {
+		msgs = append(msgs, line["msg"])
+	}
+	want := []any{
+		"Shutdown started, serving until load balancers stop sending traffic",
+		"Shutting down HTTP server",
+		"HTTP server drained",
+		"Flushing telemetry",
+	}
+	if len(msgs) < len(want) || !slices.Equal(msgs[len(msgs)-len(want):], want) {
+		t.Errorf("shutdown logs: got %v, want them to end with %v", msgs, want)
+	}
+}
+
+func TestRun_BindFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() unexpected error: %v", err)
+	}
+	defer ln.Close()
+
+	flushed := false
+	err = Run(context.Background(), ln.Addr().String(), http.NotFoundHandler(),
+		WithTelemetryShutdown(func(context.Context) error {
+			flushed = true
+			return nil
+		}),
+	)
+	if err == nil {
+		t.Fatal("Run() expected an error for an address in use")
+	}
+	if !flushed {
+		t.Error("telemetry was not flushed after a bind failure")
+	}
+}
+
+func TestRun_Listeners(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	socket := filepath.Join(t.TempDir(), "acai.sock")
+	prebuilt, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, "ok") })
+	addrCh := make(chan net.Addr, 3)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- Run(ctx, "unix://"+socket, handler,
+			WithListenAddrs("127.0.0.1:0"),
+			WithListeners(prebuilt),
+			WithSocketMode(0o600),
+			WithOnListen(func(a net.Addr) { addrCh <- a }),
+		)
+	}()
+	addrs := []net.Addr{<-addrCh, <-addrCh, <-addrCh}
+
+	info, err := os.Lstat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode %v, want a socket with permissions 0600", info.Mode())
+	}
+
+	for _, addr := range addrs {
+		transport := &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, addr.Network(), addr.String())
+		}}
+		resp, err := (&http.Client{Transport: transport}).Get("http://acai/")
+		if err != nil {
+			t.Errorf("GET over %s %s: %v", addr.Network(), addr, err)
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		transport.CloseIdleConnections()
+		if string(body) != "ok" {
+			t.Errorf("GET over %s %s: got %q, want ok", addr.Network(), addr, body)
+		}
+	}
+
+	cancel()
+	if err := <-runErr; err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+	if _, err := os.Lstat(socket); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket file left after shutdown: %v", err)
+	}
+	for _, addr := range addrs {
+		if conn, err := net.DialTimeout(addr.Network(), addr.String(), time.Second); err == nil {
+			conn.Close()
+			t.Errorf("%s still accepting connections after Run returned", addr)
+		}
+	}
+}
+
+func TestRun_StaleUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	run := func(path string) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		return Run(ctx, "unix://"+path, http.NotFoundHandler(), WithOnListen(func(net.Addr) { cancel() }))
+	}
+
+	// A socket left behind by a process that died is replaced.
+	stale := filepath.Join(dir, "stale.sock")
+	ln, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	if err := run(stale); err != nil {
+		t.Errorf("stale socket: Run() unexpected error: %v", err)
+	}
+
+	// One still served, or a file that isn't a socket, is not.
+	live := filepath.Join(dir, "live.sock")
+	ln, err = net.Listen("unix", live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if err := run(live); err == nil {
+		t.Error("live socket: Run() expected an error for a socket in use")
+	}
+	if conn, err := net.Dial("unix", live); err != nil {
+		t.Errorf("live socket no longer served: %v", err)
+	} else {
+		conn.Close()
+	}
+
+	file := filepath.Join(dir, "file.sock")
+	if err := os.WriteFile(file, []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(file); err == nil {
+		t.Error("regular file: Run() expected an error")
+	}
+	if data, err := os.ReadFile(file); err != nil || string(data) != "data" {
+		t.Errorf("regular file changed: %q, %v", data, err)
+	}
+}
+
+func TestRun_H2C(t *testing.T) {
+	otelt.InstallMetrics(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := MetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// HTTP/2 streams can be flushed but not hijacked.
+		_, flushes := w.(http.Flusher)
+		_, hijacks := w.(http.Hijacker)
+		if !flushes || hijacks != (r.ProtoMajor == 1) {
+			t.Errorf("%s: writer is a Flusher %v and a Hijacker %v", r.Proto, flushes, hijacks)
+		}
+		_, _ = io.WriteString(w, r.Proto)
+		w.(http.Flusher).Flush()
+	}))
+	addrCh := make(chan string, 1)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- Run(ctx, "127.0.0.1:0", handler, WithH2C(), WithOnListen(func(a net.Addr) { addrCh <- a.String() }))
+	}()
+	addr := <-addrCh
+
+	for _, tt := range []struct {
+		transport   *Transport
+		wantProto   string
+		wantVersion string
+	}{
+		{transport: NewTransport(&http.Transport{}, WithForceHTTP2()), wantProto: "HTTP/2.0", wantVersion: "2"},
+		{transport: NewTransport(&http.Transport{}), wantProto: "HTTP/1.1", wantVersion: "1.1"},
+	} {
+		resp, err := (&http.Client{Transport: tt.transport}).Get("http://" + addr + "/trips")
+		if err != nil {
+			t.Fatalf("GET over %s: %v", tt.wantProto, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		tt.transport.CloseIdleConnections()
+		if resp.Proto != tt.wantProto || string(body) != tt.wantProto {
+			t.Errorf("got a %s response to a %s request, want %s", resp.Proto, body, tt.wantProto)
+		}
+		otelt.RequireCounterValue(t, "http.server.requests", []attribute.KeyValue{
+			attribute.String("network.protocol.version", tt.wantVersion),
+		}, 1)
+	}
+
+	cancel()
+	if err := <-runErr; err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+}
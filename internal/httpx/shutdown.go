@@ -0,0 +1,64 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// shutdownStepGrace bounds the steps of CombineShutdowns starting once the
+// caller's context is done, or running without a deadline.
+var shutdownStepGrace = 5 * time.Second
+
+// CombineShutdowns returns a Shutdown running each of fns in order, even
+// when an earlier one fails or ctx is done, and joining their errors. Each
+// step gets the values of ctx but not its cancellation: it has until the
+// deadline of ctx, or 5s once that passed or without one, before it is
+// abandoned and the next one runs. Calls after the first do nothing.
+func CombineShutdowns(fns ...Shutdown) Shutdown {
+	fns = slices.Clone(fns)
+	var once sync.Once
+	return func(ctx context.Context) error {
+		var errs error
+		once.Do(func() {
+			for i, fn := range fns {
+				if fn == nil {
+					continue
+				}
+				if err := runShutdown(ctx, fn); err != nil {
+					errs = errors.Join(errs, fmt.Errorf("shutdown step %d: %w", i+1, err))
+				}
+			}
+		})
+		return errs
+	}
+}
+
+// CombineShutdownsReverse is CombineShutdowns running fns last to first, so
+// they can be listed in the order things were started.
+func CombineShutdownsReverse(fns ...Shutdown) Shutdown {
+	fns = slices.Clone(fns)
+	slices.Reverse(fns)
+	return CombineShutdowns(fns...)
+}
+
+func runShutdown(ctx context.Context, fn Shutdown) error {
+	deadline, ok := ctx.Deadline()
+	if !ok || ctx.Err() != nil || !time.Now().Before(deadline) {
+		deadline = time.Now().Add(shutdownStepGrace)
+	}
+	ctx, cancel := context.WithDeadline(context.WithoutCancel(ctx), deadline)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- fn(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
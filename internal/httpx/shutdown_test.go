@@ -0,0 +1,113 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCombineShutdowns(t *testing.T) {
+	errTracer, errMeter := errors.New("tracer"), errors.New("meter")
+
+	var calls []string
+	step := func(name string, err error) Shutdown {
+		return func(ctx context.Context) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+
+	t.Run("runs every step and joins errors", func(t *testing.T) {
+		calls = nil
+		shutdown := CombineShutdowns(step("tracer", errTracer), nil, step("meter", errMeter), step("db", nil))
+
+		err := shutdown(context.Background())
+		if !errors.Is(err, errTracer) || !errors.Is(err, errMeter) {
+			t.Errorf("expected both errors, got %v", err)
+		}
+		if want := []string{"tracer", "meter", "db"}; !slices.Equal(calls, want) {
+			t.Errorf("calls mismatch: got %v, want %v", calls, want)
+		}
+
+		if err := shutdown(context.Background()); err != nil {
+			t.Errorf("second call returned %v", err)
+		}
+		if len(calls) != 3 {
+			t.Errorf("second call ran steps again: %v", calls)
+		}
+	})
+
+	t.Run("reverse", func(t *testing.T) {
+		calls = nil
+		if err := CombineShutdownsReverse(step("server", nil), step("db", nil))(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := []string{"db", "server"}; !slices.Equal(calls, want) {
+			t.Errorf("calls mismatch: got %v, want %v", calls, want)
+		}
+	})
+
+	t.Run("abandons a step past the deadline and runs the rest", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		stuck := func(ctx context.Context) error {
+			<-release
+			return nil
+		}
+		ran := make(chan error, 1)
+		next := func(ctx context.Context) error {
+			ran <- ctx.Err()
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		err := CombineShutdowns(stuck, next)(ctx)
+
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("shutdown waited %v for a stuck step", elapsed)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the deadline error, got %v", err)
+		}
+		select {
+		case err := <-ran:
+			if err != nil {
+				t.Errorf("step after the deadline ran with ctx error %v, want a live ctx", err)
+			}
+		default:
+			t.Error("step after the deadline wasn't run")
+		}
+	})
+
+	t.Run("bounds steps after the deadline", func(t *testing.T) {
+		defer func(grace time.Duration) { shutdownStepGrace = grace }(shutdownStepGrace)
+		shutdownStepGrace = 20 * time.Millisecond
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		deadlines := make(chan time.Duration, 2)
+		step := func(ctx context.Context) error {
+			deadline, _ := ctx.Deadline()
+			deadlines <- time.Until(deadline)
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		err := CombineShutdowns(step, step)(ctx)
+
+		if len(deadlines) != 2 {
+			t.Fatalf("%d steps ran after cancellation, want both", len(deadlines))
+		}
+		for range 2 {
+			if d := <-deadlines; d <= 0 {
+				t.Errorf("step after cancellation got a deadline in %v, want a grace period", d)
+			}
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the deadline error, got %v", err)
+		}
+	})
+}
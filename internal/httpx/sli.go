@@ -0,0 +1,78 @@
+package httpx
+
+import (
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Apdex levels, the apdex.level attribute of http.server.apdex.
+const (
+	ApdexSatisfied  = "satisfied"
+	ApdexTolerating = "tolerating"
+	ApdexFrustrated = "frustrated"
+)
+
+// WithApdex counts every request in http.server.apdex by route, as
+// satisfied when served within target, tolerating within four times target,
+// and frustrated otherwise or on a server error. The route's Apdex score is
+// (satisfied + tolerating/2) / all. Requests are also counted in
+// http.server.availability.total and, when served with a status below 500
+// within their TimeoutMiddleware deadline, http.server.availability.good,
+// whose ratio is the availability SLO burn-rate alerts watch. Requests
+// canceled by their client are left out of both.
+func WithApdex(target time.Duration) MetricsOption {
+	return func(c *metricsConfig) { c.apdex = target }
+}
+
+// WithApdexRoutes applies the targets of the routes registered on rt with
+// RouteApdexTarget instead of the WithApdex default.
+func WithApdexRoutes(rt *Router) MetricsOption {
+	return func(c *metricsConfig) { c.apdexRoutes = rt }
+}
+
+// apdexLevel returns the Apdex level of a request served in elapsed with
+// status.
+func apdexLevel(elapsed, target time.Duration, status int) string {
+	switch {
+	case status >= 500:
+		return ApdexFrustrated
+	case elapsed <= target:
+		return ApdexSatisfied
+	case elapsed <= 4*target:
+		return ApdexTolerating
+	default:
+		return ApdexFrustrated
+	}
+}
+
+// available reports whether a request counts as good for availability.
+func available(status int, timedOut bool) bool {
+	return status < 500 && !timedOut
+}
+
+func (cfg *metricsConfig) recordSLI(r *http.Request, st *requestState, sm *serverMetrics, status int, elapsed time.Duration, timedOut, hijacked bool) {
+	target := cfg.apdex
+	if override := routeConfig(r, cfg.apdexRoutes).ApdexTarget; override > 0 {
+		target = override
+	}
+	if target <= 0 {
+		return
+	}
+
+	route := attribute.String("http.route", cfg.route(r, st))
+	routeAttrs := metric.WithAttributes(cfg.guard.filter(r.Context(), []attribute.KeyValue{route})...)
+	sm.sliTotal.Add(r.Context(), 1, routeAttrs)
+	if available(status, timedOut) {
+		sm.sliGood.Add(r.Context(), 1, routeAttrs)
+	}
+	// As for the duration, that of a hijacked connection says nothing.
+	if !hijacked {
+		level := apdexLevel(elapsed, target, status)
+		sm.apdex.Add(r.Context(), 1, metric.WithAttributes(cfg.guard.filter(r.Context(), []attribute.KeyValue{
+			route, attribute.String("apdex.level", level),
+		})...))
+	}
+}
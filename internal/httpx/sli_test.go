@@ -0,0 +1,107 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+)
+
+func TestApdexLevel(t *testing.T) {
+	const target = 100 * time.Millisecond
+	tests := []struct {
+		elapsed time.Duration
+		status  int
+		want    string
+	}{
+		{elapsed: 10 * time.Millisecond, status: 200, want: ApdexSatisfied},
+		{elapsed: target, status: 200, want: ApdexSatisfied},
+		{elapsed: target + time.Millisecond, status: 200, want: ApdexTolerating},
+		{elapsed: 4 * target, status: 404, want: ApdexTolerating},
+		{elapsed: 4*target + time.Millisecond, status: 200, want: ApdexFrustrated},
+		{elapsed: time.Millisecond, status: 503, want: ApdexFrustrated},
+	}
+	for _, tt := range tests {
+		if got := apdexLevel(tt.elapsed, target, tt.status); got != tt.want {
+			t.Errorf("apdexLevel(%v, %v, %d) = %q, want %q", tt.elapsed, target, tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	tests := []struct {
+		status   int
+		timedOut bool
+		want     bool
+	}{
+		{status: 200, want: true},
+		{status: 429, want: true},
+		{status: 500},
+		{status: 503},
+		{status: 200, timedOut: true},
+	}
+	for _, tt := range tests {
+		if got := available(tt.status, tt.timedOut); got != tt.want {
+			t.Errorf("available(%d, %v) = %v, want %v", tt.status, tt.timedOut, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsMiddleware_Apdex(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	rt := NewRouter()
+	rt.HandleFunc("/trips", func(w http.ResponseWriter, r *http.Request) {})
+	// No request is served within a nanosecond, nor four.
+	rt.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {}, RouteApdexTarget(time.Nanosecond))
+	rt.HandleFunc("/book", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusBadGateway) })
+	handler := NewMetricsMiddleware(WithApdex(time.Hour), WithApdexRoutes(rt))(rt)
+	for _, path := range []string{"/trips", "/trips", "/search", "/book"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	levels := map[string]int64{}
+	for _, dp := range collectSum(t, reader, "http.server.apdex") {
+		route, _ := dp.Attributes.Value("http.route")
+		level, _ := dp.Attributes.Value("apdex.level")
+		levels[route.AsString()+" "+level.AsString()] += dp.Value
+	}
+	want := map[string]int64{"/trips satisfied": 2, "/search frustrated": 1, "/book frustrated": 1}
+	if len(levels) != len(want) {
+		t.Errorf("apdex counts %v, want %v", levels, want)
+	}
+	for k, n := range want {
+		if levels[k] != n {
+			t.Errorf("apdex %s = %d, want %d", k, levels[k], n)
+		}
+	}
+
+	for name, want := range map[string]map[string]int64{
+		"http.server.availability.total": {"/trips": 2, "/search": 1, "/book": 1},
+		"http.server.availability.good":  {"/trips": 2, "/search": 1},
+	} {
+		got := map[string]int64{}
+		for _, dp := range collectSum(t, reader, name) {
+			route, _ := dp.Attributes.Value("http.route")
+			got[route.AsString()] += dp.Value
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+		for route, n := range want {
+			if got[route] != n {
+				t.Errorf("%s for %s = %d, want %d", name, route, got[route], n)
+			}
+		}
+	}
+}
+
+func TestMetricsMiddleware_NoApdex(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+	MetricsMiddleware(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if _, ok := collectMetric(t, reader, "http.server.apdex"); ok {
+		t.Error("http.server.apdex recorded without WithApdex")
+	}
+}
@@ -0,0 +1,139 @@
+package httpx
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SlowRequestOption configures NewSlowRequestDetector.
+type SlowRequestOption func(*SlowRequestDetector)
+
+// WithSlowRoutes applies the thresholds of the routes registered on rt with
+// RouteSlowThreshold.
+func WithSlowRoutes(rt *Router) SlowRequestOption {
+	return func(d *SlowRequestDetector) { d.routes = rt }
+}
+
+// SlowRequestDetector flags requests taking longer than a threshold, which
+// can be changed at runtime. Slow requests are logged at Warn, counted in
+// http.server.slow_requests by method and route, and get a "slow" event on
+// their span.
+type SlowRequestDetector struct {
+	threshold atomic.Int64 // time.Duration; 0 disables the detector
+	routes    *Router
+	now       func() time.Time
+}
+
+// NewSlowRequestDetector returns a detector flagging requests slower than
+// threshold.
+func NewSlowRequestDetector(threshold time.Duration, opts ...SlowRequestOption) *SlowRequestDetector {
+	d := &SlowRequestDetector{now: time.Now}
+	d.threshold.Store(int64(threshold))
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// SetThreshold changes the threshold of routes without their own. Zero
+// disables detection for them.
+func (d *SlowRequestDetector) SetThreshold(threshold time.Duration) {
+	d.threshold.Store(int64(threshold))
+	slog.Info("Slow request threshold changed", "threshold", threshold)
+}
+
+// Threshold returns the threshold of routes without their own.
+func (d *SlowRequestDetector) Threshold() time.Duration {
+	return time.Duration(d.threshold.Load())
+}
+
+// Middleware flags requests slower than their threshold once the handler
+// returns.
+func (d *SlowRequestDetector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := d.now()
+		r, st := withRequestState(r)
+		next.ServeHTTP(w, r)
+
+		elapsed := d.now().Sub(start)
+		threshold := d.thresholdFor(r)
+		if threshold <= 0 || elapsed < threshold {
+			return
+		}
+
+		st.notePattern(r)
+		ctx := r.Context()
+		route := PatternRoute(r)
+		loadServerMetrics().slow.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("http.method", metricMethod(r.Method)),
+			attribute.String("http.route", route),
+		))
+		trace.SpanFromContext(ctx).AddEvent("slow", trace.WithAttributes(
+			attribute.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+			attribute.Float64("threshold_ms", float64(threshold.Microseconds())/1000),
+		))
+
+		attrs := []slog.Attr{
+			slog.String("http_method", r.Method),
+			slog.String("http_route", route),
+			slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+			slog.Float64("threshold_ms", float64(threshold.Microseconds())/1000),
+		}
+		if sc := st.serverSpanContext(ctx); sc.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+		}
+		if id := RequestIDFromContext(ctx); id != "" {
+			attrs = append(attrs, slog.String("request_id", id))
+		}
+		slog.LogAttrs(ctx, slog.LevelWarn, "Slow HTTP request", attrs...)
+	})
+}
+
+func (d *SlowRequestDetector) thresholdFor(r *http.Request) time.Duration {
+	if threshold := routeConfig(r, d.routes).SlowThreshold; threshold > 0 {
+		return threshold
+	}
+	return d.Threshold()
+}
+
+// slowThresholdBody is the body served and accepted by the slow request
+// admin endpoint.
+type slowThresholdBody struct {
+	Threshold string `json:"threshold"`
+}
+
+// Handler serves the threshold: GET reports it and PUT changes it to the
+// duration in a {"threshold": "500ms"} body.
+func (d *SlowRequestDetector) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut:
+			var body slowThresholdBody
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&body); err != nil {
+				http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+				return
+			}
+			threshold, err := time.ParseDuration(body.Threshold)
+			if err != nil || threshold < 0 {
+				http.Error(w, fmt.Sprintf("invalid threshold %q", body.Threshold), http.StatusBadRequest)
+				return
+			}
+			d.SetThreshold(threshold)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		_ = WriteJSON(w, http.StatusOK, slowThresholdBody{Threshold: d.Threshold().String()})
+	})
+}
@@ -0,0 +1,140 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestSlowRequestDetector(t *testing.T) {
+	otelt.InstallMetrics(t)
+	otelt.InstallTracing(t)
+	logs := captureLogs(t)
+
+	now := time.Now()
+	rt := NewRouter()
+	took := func(w http.ResponseWriter, r *http.Request) {
+		d, _ := time.ParseDuration(r.URL.Query().Get("took"))
+		now = now.Add(d)
+	}
+	rt.HandleFunc("GET /trips", took)
+	rt.HandleFunc("GET /reports", took, RouteSlowThreshold(5*time.Second))
+	detector := NewSlowRequestDetector(time.Second, WithSlowRoutes(rt))
+	detector.now = func() time.Time { return now }
+	handler := Chain(TracingMiddleware, detector.Middleware)(rt)
+	admin := AdminServer("", WithAdminSlowRequests(detector), WithPprof(false)).Handler()
+
+	serve := func(target string) {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	serve("/trips?took=500ms")
+	serve("/trips?took=2s")
+	serve("/reports?took=2s")
+	serve("/reports?took=6s")
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/slow", strings.NewReader(`{"threshold":"3s"}`)))
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"threshold":"3s"}` {
+		t.Fatalf("PUT /slow = %d %s", rec.Code, rec.Body.String())
+	}
+	serve("/trips?took=2s")
+	serve("/trips?took=4s")
+
+	for route, want := range map[string]int64{"/trips": 2, "/reports": 1} {
+		otelt.RequireCounterValue(t, "http.server.slow_requests", []attribute.KeyValue{attribute.String("http.route", route)}, want)
+	}
+
+	var slow []map[string]any
+	for _, line := range decodeLogLines(t, logs) {
+		if line["msg"] == "Slow HTTP request" {
+			slow = append(slow, line)
+		}
+	}
+	if len(slow) != 3 {
+		t.Fatalf("got %d slow request logs, want 3", len(slow))
+	}
+	if line := slow[0]; line["level"] != "WARN" || line["http_route"] != "/trips" || line["duration_ms"] != 2000.0 || line["trace_id"] == nil {
+		t.Errorf("slow request log = %v", line)
+	}
+
+	var events int
+	for _, span := range otelt.Spans(t) {
+		for _, event := range span.Events() {
+			if event.Name == "slow" {
+				events++
+			}
+		}
+	}
+	if events != 3 {
+		t.Errorf("got %d slow span events, want 3", events)
+	}
+}
+
+func TestSlowRequestDetector_InsideOtelhttp(t *testing.T) {
+	otelt.InstallMetrics(t)
+	otelt.InstallTracing(t)
+	logs := captureLogs(t)
+
+	// As in cmd/server: the detector is chained inside the otelhttp span.
+	detector := NewSlowRequestDetector(10 * time.Millisecond)
+	handler := otelhttp.NewHandler(ChainNamed(
+		Named("trace_header", TraceHeaderMiddleware),
+		Named("slow", detector.Middleware),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	})), "twirp.chatservice")
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/twirp/acai.chat.ChatService/StartConversation", nil))
+
+	spans := otelt.Spans(t)
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want the otelhttp one", len(spans))
+	}
+	if !slices.ContainsFunc(spans[0].Events(), func(e sdktrace.Event) bool { return e.Name == "slow" }) {
+		t.Errorf("span events = %v, want slow", spans[0].Events())
+	}
+	var logged bool
+	for _, line := range decodeLogLines(t, logs) {
+		if line["msg"] == "Slow HTTP request" {
+			logged = true
+			if line["trace_id"] != spans[0].SpanContext().TraceID().String() {
+				t.Errorf("slow request logged with trace_id %v, want the span's %s", line["trace_id"], spans[0].SpanContext().TraceID())
+			}
+		}
+	}
+	if !logged {
+		t.Error("slow request not logged")
+	}
+}
+
+func TestSlowRequestDetector_Handler(t *testing.T) {
+	captureLogs(t)
+	detector := NewSlowRequestDetector(time.Second)
+
+	for _, tt := range []struct {
+		method string
+		body   string
+		want   int
+	}{
+		{method: http.MethodGet, want: http.StatusOK},
+		{method: http.MethodPut, body: `{"threshold":"soon"}`, want: http.StatusBadRequest},
+		{method: http.MethodPut, body: `{"threshold":"-1s"}`, want: http.StatusBadRequest},
+		{method: http.MethodDelete, want: http.StatusMethodNotAllowed},
+	} {
+		rec := httptest.NewRecorder()
+		detector.Handler().ServeHTTP(rec, httptest.NewRequest(tt.method, "/slow", strings.NewReader(tt.body)))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s got %d, want %d", tt.method, tt.body, rec.Code, tt.want)
+		}
+	}
+	if detector.Threshold() != time.Second {
+		t.Errorf("threshold = %v, want unchanged 1s", detector.Threshold())
+	}
+}
@@ -0,0 +1,51 @@
+package httpx
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StartSpan starts a span named name, a fixed operation name such as
+// "search flights", with the given attributes. The returned end function
+// ends it, recording its duration in operation.duration and err, when not
+// nil, with RecordError:
+//
+//	ctx, span, end := httpx.StartSpan(ctx, "search flights")
+//	defer func() { end(err) }()
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span, func(err error)) {
+	start := time.Now()
+	ctx, span := Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+
+	end := func(err error) {
+		opAttrs := []attribute.KeyValue{attribute.String("operation.name", name)}
+		if err != nil {
+			RecordError(ctx, err)
+			_, typ := classifyError(err)
+			opAttrs = append(opAttrs, semconv.ErrorTypeKey.String(typ))
+		}
+		loadServerMetrics().opDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(opAttrs...))
+		span.End()
+	}
+	return ctx, span, end
+}
+
+// RecordError marks the span in ctx as failed with err, adding an exception
+// event, and counts err in operation.errors by its error.type, the one
+// WriteError would answer it with. A nil err is ignored.
+func RecordError(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	_, typ := classifyError(err)
+
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err, trace.WithAttributes(semconv.ErrorTypeKey.String(typ)))
+	span.SetStatus(codes.Error, err.Error())
+	loadServerMetrics().opErrors.Add(ctx, 1, metric.WithAttributes(semconv.ErrorTypeKey.String(typ)))
+}
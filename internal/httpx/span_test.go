@@ -0,0 +1,79 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+func TestStartSpan(t *testing.T) {
+	reader, _ := otelt.Install(t)
+
+	_, _, end := StartSpan(context.Background(), "search flights", attribute.String("destination", "BCN"))
+	end(nil)
+	_, _, end = StartSpan(context.Background(), "book flight")
+	end(fmt.Errorf("book: %w", ErrConflict))
+
+	spans := otelt.Spans(t)
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	search, failed := spans[0], spans[1]
+	if got := search.InstrumentationScope().Name; got != instrumentationName {
+		t.Errorf("scope = %q, want %q", got, instrumentationName)
+	}
+	if search.Name() != "search flights" || !slices.Contains(search.Attributes(), attribute.String("destination", "BCN")) {
+		t.Errorf("span %q with attributes %v, want search flights with destination=BCN", search.Name(), search.Attributes())
+	}
+	if search.Status().Code != codes.Unset {
+		t.Errorf("successful span status = %v, want unset", search.Status().Code)
+	}
+	if failed.Status().Code != codes.Error || len(failed.Events()) != 1 || failed.Events()[0].Name != "exception" {
+		t.Errorf("failed span status %v with events %v, want error with an exception event", failed.Status().Code, failed.Events())
+	}
+
+	m, ok := collectMetric(t, reader, "operation.duration")
+	if !ok {
+		t.Fatal("operation.duration not recorded")
+	}
+	durations := map[string]metricdata.HistogramDataPoint[float64]{}
+	for _, dp := range m.Data.(metricdata.Histogram[float64]).DataPoints {
+		name, _ := dp.Attributes.Value("operation.name")
+		durations[name.AsString()] = dp
+	}
+	if durations["search flights"].Count != 1 || durations["book flight"].Count != 1 {
+		t.Errorf("operation.duration = %v, want one sample per operation", durations)
+	}
+	book := durations["book flight"]
+	if typ, _ := book.Attributes.Value(semconv.ErrorTypeKey); typ.AsString() != "conflict" {
+		t.Errorf("book flight error.type = %q, want conflict", typ.AsString())
+	}
+
+	otelt.RequireCounterValue(t, "operation.errors", []attribute.KeyValue{semconv.ErrorTypeKey.String("conflict")}, 1)
+}
+
+func TestRecordError(t *testing.T) {
+	otelt.Install(t)
+
+	ctx, span := Tracer().Start(context.Background(), "op")
+	RecordError(ctx, nil)
+	RecordError(ctx, errors.New("supplier unavailable"))
+	span.End()
+
+	spans := otelt.Spans(t)
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if st := spans[0].Status(); st.Code != codes.Error || st.Description != "supplier unavailable" {
+		t.Errorf("span status = %v, want error supplier unavailable", st)
+	}
+	otelt.RequireCounterValue(t, "operation.errors", []attribute.KeyValue{semconv.ErrorTypeKey.String(internalErrorType)}, 1)
+}
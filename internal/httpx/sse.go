@@ -0,0 +1,310 @@
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// DefaultHeartbeat is how long an event stream may stay idle before SSE
+// sends a comment, well under the minute after which proxies usually drop
+// idle connections.
+const DefaultHeartbeat = 15 * time.Second
+
+// maxEventNames caps the distinct sse.event values of
+// http.server.sse.events; later ones are recorded as OverflowValue.
+const maxEventNames = 50
+
+var eventNames = newAttributeGuard(nil, maxEventNames)
+
+// Why event streams end, recorded as sse.close_reason.
+const (
+	streamClientGone  = "client"   // the client disconnected
+	streamClosed      = "server"   // the handler closed the stream
+	streamShutdown    = "shutdown" // the server shut down
+	streamWriteFailed = "error"    // writing to the client failed
+)
+
+// ErrStreamClosed is returned by Send once the event stream ended.
+var ErrStreamClosed = errors.New("event stream closed")
+
+// SSEOption configures an event stream opened by SSE.
+type SSEOption func(*EventStream)
+
+// WithHeartbeat sets how long the stream may stay idle before a comment is
+// sent to keep it open, instead of DefaultHeartbeat. Zero disables them.
+func WithHeartbeat(d time.Duration) SSEOption {
+	return func(s *EventStream) { s.heartbeat = d }
+}
+
+// EventStream sends server-sent events to the client of a request. Send
+// may be called from several goroutines.
+type EventStream struct {
+	r         *http.Request
+	w         http.ResponseWriter
+	rc        *http.ResponseController
+	heartbeat time.Duration
+	start     time.Time
+	route     attribute.KeyValue
+	sm        *serverMetrics
+	streams   *streamSet
+
+	mu        sync.Mutex
+	lastWrite time.Time
+	reason    string // why the stream ended, once it did
+
+	done    chan struct{} // closed when the stream ends
+	stopped chan struct{} // closed when the heartbeats stop
+}
+
+// SSE answers r with a text/event-stream response and returns the stream
+// to send its events with. The stream ends when the client disconnects, a
+// write fails, the http.Server serving r shuts down or the handler calls
+// Close, which it must do before returning:
+//
+//	stream, err := httpx.SSE(w, r)
+//	if err != nil {
+//		httpx.WriteError(w, r, err)
+//		return
+//	}
+//	defer stream.Close()
+//	for {
+//		select {
+//		case price := <-prices:
+//			_ = stream.Send("price", price)
+//		case <-stream.Done():
+//			return
+//		}
+//	}
+//
+// The open streams are counted in http.server.sse.streams, their events in
+// http.server.sse.events and their durations in http.server.sse.duration.
+// SSE fails if w can't be flushed, as behind TimeoutMiddleware.
+func SSE(w http.ResponseWriter, r *http.Request, opts ...SSEOption) (*EventStream, error) {
+	s := &EventStream{
+		r:         r,
+		w:         w,
+		rc:        http.NewResponseController(w),
+		heartbeat: DefaultHeartbeat,
+		route:     attribute.String("http.route", PatternRoute(r)),
+		sm:        loadServerMetrics(),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Keeps nginx from buffering the events.
+	h.Set("X-Accel-Buffering", "no")
+	h.Del("Content-Length")
+	if err := s.rc.Flush(); err != nil {
+		for _, k := range []string{"Content-Type", "Cache-Control", "X-Accel-Buffering"} {
+			h.Del(k)
+		}
+		return nil, fmt.Errorf("start event stream: %w", err)
+	}
+	s.start = time.Now()
+	s.lastWrite = s.start
+	if st := stateFromContext(r.Context()); st != nil {
+		st.mu.Lock()
+		st.streaming = true
+		st.mu.Unlock()
+	}
+	s.sm.sseStreams.Add(r.Context(), 1, metric.WithAttributes(s.route))
+
+	s.streams = streamsOf(r)
+	if s.streams != nil && !s.streams.add(s) {
+		s.end(streamShutdown)
+	}
+	go s.run()
+	return s, nil
+}
+
+// Send sends an event of the given type with data, one data line per line
+// of it. An empty event is of the default type, message. A failed write
+// ends the stream, and later calls return ErrStreamClosed.
+func (s *EventStream) Send(event, data string) error {
+	if strings.ContainsAny(event, "\r\n") {
+		return fmt.Errorf("event type %q spans lines", event)
+	}
+	var b strings.Builder
+	if event != "" {
+		b.WriteString("event: " + event + "\n")
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	if err := s.write(b.String()); err != nil {
+		return err
+	}
+
+	if event == "" {
+		event = "message"
+	}
+	ctx := s.r.Context()
+	attrs := eventNames.filter(ctx, []attribute.KeyValue{attribute.String("sse.event", event)})
+	s.sm.sseEvents.Add(ctx, 1, metric.WithAttributes(append(attrs, s.route)...))
+	return nil
+}
+
+// Done is closed when the stream ends, for whatever reason.
+func (s *EventStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close ends the stream, if it didn't already end, and stops its
+// heartbeats.
+func (s *EventStream) Close() {
+	s.end(streamClosed)
+	<-s.stopped
+	if s.streams != nil {
+		s.streams.remove(s)
+	}
+}
+
+func (s *EventStream) write(msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reason != "" {
+		return ErrStreamClosed
+	}
+	_, err := s.w.Write([]byte(msg))
+	if err == nil {
+		err = s.rc.Flush()
+	}
+	if err != nil {
+		s.endLocked(streamWriteFailed)
+		return err
+	}
+	s.lastWrite = time.Now()
+	return nil
+}
+
+// run sends the heartbeats until the stream ends, and ends it when the
+// client disconnects.
+func (s *EventStream) run() {
+	defer close(s.stopped)
+	var timer *time.Timer
+	var beat <-chan time.Time
+	if s.heartbeat > 0 {
+		timer = time.NewTimer(s.heartbeat)
+		defer timer.Stop()
+		beat = timer.C
+	}
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.r.Context().Done():
+			s.end(streamClientGone)
+			return
+		case <-beat:
+			timer.Reset(s.beat())
+		}
+	}
+}
+
+// beat sends a heartbeat if nothing was sent for a heartbeat period, and
+// returns how long until the next one is due.
+func (s *EventStream) beat() time.Duration {
+	s.mu.Lock()
+	idle := time.Since(s.lastWrite)
+	s.mu.Unlock()
+	if wait := s.heartbeat - idle; wait > 0 {
+		return wait
+	}
+	_ = s.write(": heartbeat\n\n")
+	return s.heartbeat
+}
+
+func (s *EventStream) end(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.endLocked(reason)
+}
+
+func (s *EventStream) endLocked(reason string) {
+	if s.reason != "" {
+		return
+	}
+	s.reason = reason
+	close(s.done)
+	ctx := s.r.Context()
+	s.sm.sseStreams.Add(ctx, -1, metric.WithAttributes(s.route))
+	s.sm.sseDuration.Record(ctx, time.Since(s.start).Seconds(), metric.WithAttributes(
+		s.route, attribute.String("sse.close_reason", reason)))
+}
+
+// serverStreams holds the streamSet of each http.Server serving streams,
+// until it shuts down. Shutdown waits for handlers to return, which
+// streaming ones only do once their stream ends.
+var serverStreams sync.Map // *http.Server → *streamSet
+
+// streamSet holds the open streams of a server, ending them when it shuts
+// down.
+type streamSet struct {
+	mu       sync.Mutex
+	streams  map[*EventStream]struct{}
+	shutdown bool
+}
+
+// streamsOf returns the streamSet of the server serving r, or nil when r
+// didn't come from an http.Server.
+func streamsOf(r *http.Request) *streamSet {
+	srv, _ := r.Context().Value(http.ServerContextKey).(*http.Server)
+	if srv == nil {
+		return nil
+	}
+	if set, ok := serverStreams.Load(srv); ok {
+		return set.(*streamSet)
+	}
+	set, loaded := serverStreams.LoadOrStore(srv, &streamSet{streams: map[*EventStream]struct{}{}})
+	if !loaded {
+		srv.RegisterOnShutdown(func() {
+			set.(*streamSet).endAll()
+			serverStreams.Delete(srv)
+		})
+	}
+	return set.(*streamSet)
+}
+
+// add adds s to the set, unless the server is shutting down.
+func (set *streamSet) add(s *EventStream) bool {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if set.shutdown {
+		return false
+	}
+	set.streams[s] = struct{}{}
+	return true
+}
+
+func (set *streamSet) remove(s *EventStream) {
+	set.mu.Lock()
+	delete(set.streams, s)
+	set.mu.Unlock()
+}
+
+func (set *streamSet) endAll() {
+	set.mu.Lock()
+	set.shutdown = true
+	streams := make([]*EventStream, 0, len(set.streams))
+	for s := range set.streams {
+		streams = append(streams, s)
+	}
+	set.mu.Unlock()
+	for _, s := range streams {
+		s.end(streamShutdown)
+	}
+}
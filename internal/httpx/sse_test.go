@@ -0,0 +1,192 @@
+package httpx
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// readFrame reads the lines of the next event stream frame, up to the blank
+// line ending it.
+func readFrame(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the stream after %q: %v", lines, err)
+		}
+		if line == "\n" {
+			return lines
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+}
+
+func TestSSE_ClientDisconnect(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	returned := make(chan error, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /prices", func(w http.ResponseWriter, r *http.Request) {
+		stream, err := SSE(w, r, WithHeartbeat(20*time.Millisecond))
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		defer stream.Close()
+		_ = stream.Send("price", "EUR 120\nUSD 131")
+		_ = stream.Send("", "hello")
+		<-stream.Done()
+		returned <- stream.Send("price", "EUR 121")
+	})
+	srv := httptest.NewServer(NewMetricsMiddleware()(mux))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/prices", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /prices: %v", err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type mismatch: got %q, want text/event-stream", got)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Cache-Control mismatch: got %q, want no-cache", got)
+	}
+
+	body := bufio.NewReader(resp.Body)
+	if got, want := strings.Join(readFrame(t, body), "|"), "event: price|data: EUR 120|data: USD 131"; got != want {
+		t.Errorf("first frame mismatch: got %q, want %q", got, want)
+	}
+	if got, want := strings.Join(readFrame(t, body), "|"), "data: hello"; got != want {
+		t.Errorf("second frame mismatch: got %q, want %q", got, want)
+	}
+	for i := 0; i < 2; i++ {
+		if got := readFrame(t, body); len(got) != 1 || got[0] != ": heartbeat" {
+			t.Errorf("idle stream sent %q, want a heartbeat", got)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-returned:
+		if !errors.Is(err, ErrStreamClosed) {
+			t.Errorf("Send after the disconnect: got %v, want ErrStreamClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the handler didn't see the client disconnect")
+	}
+
+	eventually(t, "the request to be recorded", func() bool {
+		return len(collectSum(t, reader, "http.server.requests")) > 0
+	})
+	otelt.RequireCounterValue(t, "http.server.requests", []attribute.KeyValue{
+		attribute.String("http.route", "/prices"),
+		attribute.Int("http.status_code", http.StatusOK),
+	}, 1)
+	otelt.RequireCounterValue(t, "http.server.sse.events", []attribute.KeyValue{
+		attribute.String("sse.event", "price"),
+	}, 1)
+	otelt.RequireCounterValue(t, "http.server.sse.events", []attribute.KeyValue{
+		attribute.String("sse.event", "message"),
+	}, 1)
+	otelt.RequireCounterValue(t, "http.server.sse.streams", []attribute.KeyValue{
+		attribute.String("http.route", "/prices"),
+	}, 0)
+
+	streams := collectHistogram(t, reader, "http.server.sse.duration")
+	if len(streams) != 1 {
+		t.Fatalf("got %d stream duration series, want 1", len(streams))
+	}
+	if reason, _ := streams[0].Attributes.Value("sse.close_reason"); reason.AsString() != streamClientGone {
+		t.Errorf("stream closed for %q, want %q", reason.AsString(), streamClientGone)
+	}
+	latency := collectHistogram(t, reader, "http.server.request.duration")
+	if len(latency) != 1 || latency[0].Sum >= 0.04 || latency[0].Sum >= streams[0].Sum {
+		t.Errorf("request duration %v, want the time to open the stream, not its %vs", latency, streams[0].Sum)
+	}
+}
+
+func TestSSE_Shutdown(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	opened := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream, err := SSE(w, r, WithHeartbeat(0))
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		defer stream.Close()
+		close(opened)
+		<-stream.Done()
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+	<-opened
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() waited on the stream: %v", err)
+	}
+	eventually(t, "the stream to be recorded", func() bool {
+		return len(collectHistogram(t, reader, "http.server.sse.duration")) > 0
+	})
+	streams := collectHistogram(t, reader, "http.server.sse.duration")
+	if reason, _ := streams[0].Attributes.Value("sse.close_reason"); reason.AsString() != streamShutdown {
+		t.Errorf("stream closed for %q, want %q", reason.AsString(), streamShutdown)
+	}
+	eventually(t, "the server's streams to be forgotten", func() bool {
+		_, ok := serverStreams.Load(srv.Config)
+		return !ok
+	})
+}
+
+func TestSSE_Misuse(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	// A writer hiding Flush, as TimeoutMiddleware's does.
+	rec := httptest.NewRecorder()
+	_, err := SSE(struct{ http.ResponseWriter }{rec}, httptest.NewRequest(http.MethodGet, "/prices", nil))
+	if !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("SSE() on a writer that can't flush: got %v, want http.ErrNotSupported", err)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "" || rec.Body.Len() > 0 {
+		t.Errorf("failed SSE() left Content-Type %q and body %q", got, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	stream, err := SSE(rec, httptest.NewRequest(http.MethodGet, "/prices", nil))
+	if err != nil {
+		t.Fatalf("SSE() unexpected error: %v", err)
+	}
+	if err := stream.Send("price\nevent: hijacked", "EUR 120"); err == nil {
+		t.Error("expected an error for an event type spanning lines")
+	}
+	stream.Close()
+	stream.Close()
+	if err := stream.Send("price", "EUR 120"); !errors.Is(err, ErrStreamClosed) {
+		t.Errorf("Send after Close: got %v, want ErrStreamClosed", err)
+	}
+	if rec.Body.Len() > 0 {
+		t.Errorf("nothing sent, yet the body is %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,143 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestState is shared through the request context by the middlewares of
+// this package, so outer layers can see what inner layers learned (such as
+// the matched route) even when the request was copied in between.
+type requestState struct {
+	mu           sync.Mutex
+	route        string
+	allowedRoute string // of the other methods, when Router answered 405
+	routeConfig  *RouteConfig
+	spanContext  trace.SpanContext
+	requestID    string
+	timedOut     bool   // TimeoutMiddleware answered with a 504
+	encoding     string // CompressMiddleware compressed the response with it
+	preflight    bool   // a CORS preflight, never an error
+	streaming    bool   // an event stream opened with SSE
+	errorType    string // set by WriteError
+	fingerprint  string // of the panic, or of the error WriteError answered with a 5xx
+	errorClass   ErrorClass
+	// errorClassSet tells a class set by SetErrorClass, which WriteError
+	// keeps, from one of WriteError.
+	errorClassSet bool
+	clientIP      string // resolved by ClientIPMiddleware
+	scheme        string // forwarded by a trusted proxy
+	host          string // forwarded by a trusted proxy
+	authResult    string // set by AuthMiddleware
+	tenant        string // of the principal AuthMiddleware authenticated
+
+	// principal is the one AuthMiddleware authenticated, for the
+	// middlewares it wraps.
+	principal Principal
+
+	// deadlinePropagated is set along with timedOut when the deadline was
+	// the caller's.
+	deadlinePropagated bool
+
+	panicked     bool
+	panicValue   any
+	panicStack   []byte
+	panicCounted bool // a metrics middleware recorded the panicking request
+	metricsDepth int  // metrics middlewares currently serving the request
+}
+
+type requestStateKey struct{}
+
+// withRequestState returns r carrying a requestState, reusing the one
+// installed by an outer middleware.
+func withRequestState(r *http.Request) (*http.Request, *requestState) {
+	if st, ok := r.Context().Value(requestStateKey{}).(*requestState); ok {
+		return r, st
+	}
+	st := &requestState{}
+	return r.WithContext(context.WithValue(r.Context(), requestStateKey{}, st)), st
+}
+
+func stateFromContext(ctx context.Context) *requestState {
+	st, _ := ctx.Value(requestStateKey{}).(*requestState)
+	return st
+}
+
+// notePattern remembers the pattern http.ServeMux set on r, if any.
+func (st *requestState) notePattern(r *http.Request) {
+	if r.Pattern == "" {
+		return
+	}
+	st.noteRoute(routeFromPattern(r.Pattern))
+}
+
+// noteRoute sets the route directly, for routes that aren't mux patterns.
+func (st *requestState) noteRoute(route string) {
+	st.mu.Lock()
+	st.route = route
+	st.mu.Unlock()
+}
+
+// noteRouteConfig remembers the RouteConfig of the route serving the
+// request, for the middlewares consulting it.
+func (st *requestState) noteRouteConfig(cfg RouteConfig) {
+	st.mu.Lock()
+	st.routeConfig = &cfg
+	st.mu.Unlock()
+}
+
+func (st *requestState) matchedRoute() string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.route
+}
+
+// notePanic remembers the first panic recovered for the request, along with
+// the stack at that point so that middlewares recovering and re-panicking
+// further out can still report where it came from.
+func (st *requestState) notePanic(v any) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.panicked {
+		return
+	}
+	st.panicked = true
+	st.panicValue = v
+	st.panicStack = debug.Stack()
+	pcs := make([]uintptr, 64)
+	st.fingerprint = panicFingerprint(v, pcs[:runtime.Callers(1, pcs)])
+}
+
+func (st *requestState) panicInfo() (v any, stack []byte, ok bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.panicValue, st.panicStack, st.panicked
+}
+
+// serverSpanContext returns the span started by the tracing middleware, which
+// middlewares outside it cannot find in their own context, or else the span
+// in ctx.
+func (st *requestState) serverSpanContext(ctx context.Context) trace.SpanContext {
+	st.mu.Lock()
+	sc := st.spanContext
+	st.mu.Unlock()
+	if sc.IsValid() {
+		return sc
+	}
+	return trace.SpanContextFromContext(ctx)
+}
+
+// routeFromPattern strips the method and host from a ServeMux pattern of the
+// form "[METHOD ][HOST]/PATH".
+func routeFromPattern(pattern string) string {
+	if i := strings.Index(pattern, "/"); i >= 0 {
+		return pattern[i:]
+	}
+	return pattern
+}
@@ -0,0 +1,143 @@
+package httpx
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// WithStdoutWriter writes the telemetry exported without an OTLP endpoint to
+// w instead of stdout, e.g. a buffer in tests. Writes are serialized, so
+// every span batch and metric collection comes out whole.
+func WithStdoutWriter(w io.Writer) TelemetryOption {
+	return func(c *telemetryConfig) { c.stdout.w, c.stdout.path = w, "" }
+}
+
+// WithStdoutFile appends the telemetry exported without an OTLP endpoint to
+// the file at path, created if need be, instead of stdout. The file is
+// reopened on SIGHUP, so that logrotate can move it away and signal us.
+func WithStdoutFile(path string) TelemetryOption {
+	return func(c *telemetryConfig) { c.stdout.w, c.stdout.path = nil, path }
+}
+
+// WithStdoutPrettyPrint forces the telemetry exported without an OTLP
+// endpoint to be indented or not. By default it is indented when written to
+// a terminal only, one JSON document per line otherwise.
+func WithStdoutPrettyPrint(pretty bool) TelemetryOption {
+	return func(c *telemetryConfig) { c.stdout.pretty = &pretty }
+}
+
+// stdoutSink is where the stdout exporters write, shared by both so that
+// their output doesn't interleave.
+type stdoutSink struct {
+	w      io.Writer
+	path   string
+	pretty *bool
+
+	openMu sync.Mutex
+	opened bool
+	stop   func()
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newStdoutSink() *stdoutSink {
+	return &stdoutSink{w: os.Stdout}
+}
+
+// open opens the file, if any, the first time an exporter needs the sink,
+// and again on the next call if that failed.
+func (s *stdoutSink) open() error {
+	s.openMu.Lock()
+	defer s.openMu.Unlock()
+	if s.opened || s.path == "" {
+		return nil
+	}
+	f, err := openAppend(s.path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.file, s.w = f, f
+	s.mu.Unlock()
+	s.opened = true
+	s.stop = s.reopenOnSignal()
+	return nil
+}
+
+func (s *stdoutSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// prettyPrint reports whether to indent the output.
+func (s *stdoutSink) prettyPrint() bool {
+	if s.pretty != nil {
+		return *s.pretty
+	}
+	f, ok := s.w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// reopen switches to a new file at the path, keeping the current one if
+// that fails.
+func (s *stdoutSink) reopen() {
+	f, err := openAppend(s.path)
+	if err != nil {
+		slog.Error("Failed to reopen the telemetry file", "path", s.path, "error", err)
+		return
+	}
+	s.mu.Lock()
+	old := s.file
+	s.file, s.w = f, f
+	s.mu.Unlock()
+	_ = old.Close()
+}
+
+func (s *stdoutSink) reopenOnSignal() func() {
+	sig := make(chan os.Signal, 1)
+	notifyReopen(sig)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sig:
+				s.reopen()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sig)
+		close(done)
+	}
+}
+
+// close stops reopening and closes the file, once the exporters are done.
+func (s *stdoutSink) close() error {
+	if s.stop != nil {
+		s.stop()
+		s.stop = nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func openAppend(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
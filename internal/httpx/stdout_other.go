@@ -0,0 +1,8 @@
+//go:build !unix
+
+package httpx
+
+import "os"
+
+// There is no SIGHUP to reopen the file on.
+func notifyReopen(sig chan<- os.Signal) {}
@@ -0,0 +1,96 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestInitTelemetry_StdoutWriter(t *testing.T) {
+	restoreGlobals(t)
+
+	tests := []struct {
+		name       string
+		opts       []TelemetryOption
+		wantIndent bool
+	}{
+		{name: "compact off a terminal"},
+		{name: "forced pretty", opts: []TelemetryOption{WithStdoutPrettyPrint(true)}, wantIndent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			shutdown, err := InitTelemetry(context.Background(), "test", append([]TelemetryOption{WithStdoutWriter(&buf)}, tt.opts...)...)
+			if err != nil {
+				t.Fatalf("InitTelemetry() unexpected error: %v", err)
+			}
+			_, span := otel.Tracer("test").Start(context.Background(), "plan-trip")
+			span.End()
+			if err := shutdown(context.Background()); err != nil {
+				t.Fatalf("shutdown: %v", err)
+			}
+
+			out := buf.String()
+			if !strings.Contains(out, `"plan-trip"`) {
+				t.Errorf("span missing from the output:\n%s", out)
+			}
+			if !strings.Contains(out, `"ScopeMetrics"`) {
+				t.Errorf("metrics missing from the output:\n%s", out)
+			}
+			if got := strings.Contains(out, "\n\t"); got != tt.wantIndent {
+				t.Errorf("indented = %v, want %v:\n%s", got, tt.wantIndent, out)
+			}
+		})
+	}
+}
+
+func TestStdoutSink_Reopen(t *testing.T) {
+	captureLogs(t)
+	path := filepath.Join(t.TempDir(), "telemetry.json")
+	cfg := newTelemetryConfig([]TelemetryOption{WithStdoutFile(path)})
+	sink := cfg.stdout
+	if err := sink.open(); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := sink.Write([]byte("first\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// As logrotate does: move the file away, then signal.
+	rotated := path + ".1"
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	sink.reopen()
+	if _, err := sink.Write([]byte("second\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := sink.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	for file, want := range map[string]string{rotated: "first\n", path: "second\n"} {
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), got, want)
+		}
+	}
+	if sink.prettyPrint() {
+		t.Error("a file is pretty-printed by default")
+	}
+}
+
+func TestInitTelemetry_StdoutFileError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "telemetry.json")
+	if _, err := InitTelemetry(context.Background(), "test", WithStdoutFile(path)); err == nil {
+		t.Error("InitTelemetry() succeeded with a file in a missing directory")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build unix
+
+package httpx
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func notifyReopen(sig chan<- os.Signal) { signal.Notify(sig, syscall.SIGHUP) }
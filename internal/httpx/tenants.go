@@ -0,0 +1,111 @@
+package httpx
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// OtherTenant is the tenant.id recorded in metrics for tenants not tracked.
+const OtherTenant = "other"
+
+// maxTrackedTenants bounds the tenant.id values the admin endpoint lets
+// through to metrics.
+const maxTrackedTenants = 100
+
+// TrackedTenants is the set of tenants recorded by name in request metrics,
+// which can be changed at runtime.
+type TrackedTenants struct {
+	set atomic.Pointer[map[string]bool]
+}
+
+// NewTrackedTenants returns a set tracking the given tenants.
+func NewTrackedTenants(ids ...string) *TrackedTenants {
+	t := &TrackedTenants{}
+	t.Set(ids...)
+	return t
+}
+
+// Set replaces the tracked tenants.
+func (t *TrackedTenants) Set(ids ...string) {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	t.set.Store(&set)
+}
+
+// Tracked reports whether the tenant is tracked.
+func (t *TrackedTenants) Tracked(id string) bool { return (*t.set.Load())[id] }
+
+// List returns the tracked tenants, sorted.
+func (t *TrackedTenants) List() []string {
+	ids := make([]string, 0, len(*t.set.Load()))
+	for id := range *t.set.Load() {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// trackedTenantsBody is the body served and accepted by the tracked tenants
+// admin endpoint.
+type trackedTenantsBody struct {
+	Tenants []string `json:"tenants"`
+}
+
+// Handler serves the tracked tenants: GET reports them and PUT replaces
+// them with those in a {"tenants": [...]} body.
+func (t *TrackedTenants) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut:
+			var body trackedTenantsBody
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&body); err != nil {
+				http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+				return
+			}
+			if len(body.Tenants) > maxTrackedTenants {
+				http.Error(w, fmt.Sprintf("at most %d tenants can be tracked", maxTrackedTenants), http.StatusBadRequest)
+				return
+			}
+			t.Set(body.Tenants...)
+			slog.Info("Tracked tenants updated", "tenants", body.Tenants)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		_ = WriteJSON(w, http.StatusOK, trackedTenantsBody{Tenants: t.List()})
+	})
+}
+
+// WithTenantAttribute records the tenant of the principal authenticated by
+// AuthMiddleware as tenant.id, for tracked tenants, and as OtherTenant for
+// the rest, keeping the number of series bounded. Requests without a tenant
+// get no tenant.id. Spans always get the tenant itself.
+func WithTenantAttribute(tracked *TrackedTenants) MetricsOption {
+	return WithMetricAttributes(func(r *http.Request) []attribute.KeyValue {
+		st := stateFromContext(r.Context())
+		if st == nil {
+			return nil
+		}
+		st.mu.Lock()
+		tenant := st.tenant
+		st.mu.Unlock()
+		if tenant == "" {
+			return nil
+		}
+		if !tracked.Tracked(tenant) {
+			tenant = OtherTenant
+		}
+		return []attribute.KeyValue{attribute.String("tenant.id", tenant)}
+	})
+}
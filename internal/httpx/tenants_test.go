@@ -0,0 +1,104 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestWithTenantAttribute(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+	otelt.InstallTracing(t)
+	captureLogs(t)
+
+	tracked := NewTrackedTenants("acme")
+	verify := func(ctx context.Context, token string) (Principal, error) {
+		return Principal{ID: "agent-" + token, Tenant: token}, nil
+	}
+	handler := Chain(
+		TracingMiddleware,
+		NewMetricsMiddleware(WithTenantAttribute(tracked)),
+		AuthMiddleware(BearerAuthenticator(verify)),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	admin := AdminServer("", WithAdminTenants(tracked), WithPprof(false)).Handler()
+
+	serve := func(tenant string) {
+		req := httptest.NewRequest(http.MethodGet, "/trips", nil)
+		req.Header.Set("Authorization", "Bearer "+tenant)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	count := func(tenant string) int64 {
+		var n int64
+		for _, dp := range collectSum(t, reader, "http.server.requests") {
+			if v, ok := dp.Attributes.Value("tenant.id"); ok && v.AsString() == tenant {
+				n += dp.Value
+			}
+		}
+		return n
+	}
+
+	serve("acme")
+	serve("globex")
+	serve("initech")
+	serve("")
+	if count("acme") != 1 || count(OtherTenant) != 2 || count("globex") != 0 {
+		t.Errorf("got acme=%d other=%d globex=%d, want 1, 2 and 0", count("acme"), count(OtherTenant), count("globex"))
+	}
+	otelt.RequireCounterValue(t, "http.server.requests", []attribute.KeyValue{attribute.String("auth.result", "missing")}, 1)
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/tenants", strings.NewReader(`{"tenants":["globex","acme"]}`)))
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"tenants":["acme","globex"]}` {
+		t.Fatalf("PUT /tenants = %d %s", rec.Code, rec.Body.String())
+	}
+	serve("globex")
+	if count("globex") != 1 || count(OtherTenant) != 2 {
+		t.Errorf("after the update got globex=%d other=%d, want 1 and 2", count("globex"), count(OtherTenant))
+	}
+
+	tenants := map[string]bool{}
+	for _, span := range otelt.Spans(t) {
+		for _, kv := range span.Attributes() {
+			if kv.Key == "tenant.id" {
+				tenants[kv.Value.AsString()] = true
+			}
+		}
+	}
+	for _, want := range []string{"acme", "globex", "initech"} {
+		if !tenants[want] {
+			t.Errorf("no span with tenant.id %s, got %v", want, tenants)
+		}
+	}
+}
+
+func TestTrackedTenants_Handler(t *testing.T) {
+	tracked := NewTrackedTenants("acme")
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+	}{
+		{name: "get", method: http.MethodGet, wantStatus: http.StatusOK},
+		{name: "invalid body", method: http.MethodPut, body: `["acme"]`, wantStatus: http.StatusBadRequest},
+		{name: "too many", method: http.MethodPut, body: `{"tenants":[` + strings.Repeat(`"t",`, maxTrackedTenants) + `"t"]}`, wantStatus: http.StatusBadRequest},
+		{name: "other method", method: http.MethodDelete, wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tracked.Handler().ServeHTTP(rec, httptest.NewRequest(tt.method, "/tenants", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+	if got := tracked.List(); len(got) != 1 || got[0] != "acme" {
+		t.Errorf("tracked = %v, want unchanged [acme]", got)
+	}
+}
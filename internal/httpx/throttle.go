@@ -0,0 +1,180 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// ErrUpstreamRateLimited is returned by ThrottleTransport without sending
+// the request when the host's rate limit leaves no token for it before the
+// request's deadline. WriteError answers it with a 503.
+var ErrUpstreamRateLimited = &StatusError{Status: http.StatusServiceUnavailable, Type: "upstream_rate_limited", Err: errors.New("upstream rate limit reached")}
+
+// ThrottleOption configures NewThrottleTransport.
+type ThrottleOption func(*ThrottleTransport)
+
+// WithHostRate limits the requests to host, a host name or host:port, to
+// rate per second on average, in bursts of up to burst requests.
+func WithHostRate(host string, rate float64, burst int) ThrottleOption {
+	return func(t *ThrottleTransport) { t.limits[host] = hostRate{rate: rate, burst: float64(max(burst, 1))} }
+}
+
+// WithDefaultRate limits the requests to each host not given its own rate
+// with WithHostRate. Without it, those hosts are not limited.
+func WithDefaultRate(rate float64, burst int) ThrottleOption {
+	return func(t *ThrottleTransport) { t.fallback = &hostRate{rate: rate, burst: float64(max(burst, 1))} }
+}
+
+// ThrottleTransport paces the requests to each upstream host so they stay
+// within its rate limit, such as the one of a supplier contract. Requests
+// over the limit wait for a token, up to their context deadline: those that
+// would wait past it fail at once with ErrUpstreamRateLimited.
+//
+// Layer it under RetryTransport so retries take tokens too:
+//
+//	NewRetryTransport(NewThrottleTransport(NewTransport(nil), WithHostRate("api.supplier.test", 10, 5)))
+//
+// The wait of requests let through is recorded in
+// http.client.throttle.wait and the requests failed in
+// http.client.throttle.rejected, by net.peer.name.
+type ThrottleTransport struct {
+	base     http.RoundTripper
+	limits   map[string]hostRate
+	fallback *hostRate
+	// now and wait are replaced in tests with a fake clock.
+	now  func() time.Time
+	wait func(ctx context.Context, d time.Duration) error
+
+	mu      sync.Mutex
+	buckets map[string]*hostBucket
+}
+
+type hostRate struct {
+	rate  float64
+	burst float64
+}
+
+// hostBucket is the token bucket of a host. Its tokens go negative with the
+// requests waiting for one.
+type hostBucket struct {
+	hostRate
+	tokens float64
+	last   time.Time
+}
+
+// NewThrottleTransport returns a ThrottleTransport sending requests through
+// base. A nil base means http.DefaultTransport.
+func NewThrottleTransport(base http.RoundTripper, opts ...ThrottleOption) *ThrottleTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &ThrottleTransport{
+		base:    base,
+		limits:  map[string]hostRate{},
+		now:     time.Now,
+		wait:    sleepContext,
+		buckets: map[string]*hostBucket{},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *ThrottleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	key, limit, ok := t.limitFor(req)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	hostAttrs := metric.WithAttributes(attribute.String("net.peer.name", req.URL.Hostname()))
+	cm := loadClientMetrics()
+
+	delay := t.reserve(key, limit)
+	if delay > 0 {
+		deadline, hasDeadline := ctx.Deadline()
+		var err error = ErrUpstreamRateLimited
+		if delay < math.MaxInt64 && (!hasDeadline || deadline.Sub(t.now()) >= delay) {
+			err = t.wait(ctx, delay)
+		}
+		if err != nil {
+			t.unreserve(key)
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = ErrUpstreamRateLimited
+			}
+			if errors.Is(err, ErrUpstreamRateLimited) {
+				cm.throttleRejected.Add(ctx, 1, hostAttrs)
+				return nil, fmt.Errorf("%s: %w", req.URL.Host, err)
+			}
+			return nil, err
+		}
+	}
+	cm.throttleWait.Record(ctx, delay.Seconds(), hostAttrs)
+	return t.base.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of the underlying
+// transport, for http.Client.CloseIdleConnections.
+func (t *ThrottleTransport) CloseIdleConnections() {
+	if c, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// limitFor returns the bucket key and rate of the host of req, looked up by
+// host:port then host name, and false if it is not limited.
+func (t *ThrottleTransport) limitFor(req *http.Request) (string, hostRate, bool) {
+	for _, host := range []string{req.URL.Host, req.URL.Hostname()} {
+		if limit, ok := t.limits[host]; ok {
+			return host, limit, true
+		}
+	}
+	if t.fallback != nil {
+		return req.URL.Host, *t.fallback, true
+	}
+	return "", hostRate{}, false
+}
+
+// reserve takes a token from the bucket of key, returning how long until it
+// is there.
+func (t *ThrottleTransport) reserve(key string, limit hostRate) time.Duration {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b, ok := t.buckets[key]
+	if !ok {
+		b = &hostBucket{hostRate: limit, tokens: limit.burst, last: now}
+		t.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	if b.rate <= 0 {
+		return math.MaxInt64
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// unreserve gives back the token of a request that won't be sent.
+func (t *ThrottleTransport) unreserve(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if b, ok := t.buckets[key]; ok {
+		b.tokens = min(b.burst, b.tokens+1)
+	}
+}
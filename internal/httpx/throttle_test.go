@@ -0,0 +1,158 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// fakeThrottleClock stands in for the clock of a ThrottleTransport,
+// moving forward by what the transport waits.
+type fakeThrottleClock struct {
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeThrottleClock) install(t *ThrottleTransport) {
+	t.now = func() time.Time { return c.now }
+	t.wait = func(ctx context.Context, d time.Duration) error {
+		c.waits = append(c.waits, d)
+		c.now = c.now.Add(d)
+		return nil
+	}
+}
+
+func TestThrottleTransport(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+	clock := &fakeThrottleClock{now: time.Now()}
+	start := clock.now
+	sent := map[string][]time.Duration{}
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		sent[r.URL.Host] = append(sent[r.URL.Host], clock.now.Sub(start))
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+	})
+	th := NewThrottleTransport(base, WithHostRate("supplier.test", 2, 2), WithDefaultRate(1, 1))
+	clock.install(th)
+
+	do := func(ctx context.Context, host string) error {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/offers", nil)
+		_, err := th.RoundTrip(req)
+		return err
+	}
+	for i := range 5 {
+		if err := do(context.Background(), "supplier.test"); err != nil {
+			t.Fatalf("request %d: RoundTrip() unexpected error: %v", i, err)
+		}
+	}
+	want := []time.Duration{0, 0, 500 * time.Millisecond, time.Second, 1500 * time.Millisecond}
+	if got := sent["supplier.test"]; len(got) != len(want) {
+		t.Fatalf("sent at %v, want %v", got, want)
+	} else {
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("request %d sent at %v, want %v", i, got[i], want[i])
+			}
+		}
+	}
+
+	// A request whose deadline comes before its token fails at once, and
+	// hands the token back to the next one.
+	ctx, cancel := context.WithDeadline(context.Background(), clock.now.Add(200*time.Millisecond))
+	defer cancel()
+	waits := len(clock.waits)
+	if err := do(ctx, "supplier.test"); !errors.Is(err, ErrUpstreamRateLimited) {
+		t.Fatalf("request past its deadline: got %v, want %v", err, ErrUpstreamRateLimited)
+	}
+	if len(clock.waits) != waits || len(sent["supplier.test"]) != 5 {
+		t.Error("rate limited request waited or was sent")
+	}
+	if err := do(context.Background(), "supplier.test"); err != nil {
+		t.Fatal(err)
+	}
+	if got := clock.waits[len(clock.waits)-1]; got != 500*time.Millisecond {
+		t.Errorf("request after a rejected one waited %v, want 500ms", got)
+	}
+
+	// Other hosts have the default rate, each its own bucket.
+	for _, host := range []string{"hotels.test", "hotels.test", "cars.test:8443"} {
+		if err := do(context.Background(), host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := sent["hotels.test"]; len(got) != 2 || got[1]-got[0] != time.Second {
+		t.Errorf("hotels.test sent at %v, want one second apart", got)
+	}
+
+	otelt.RequireCounterValue(t, "http.client.throttle.rejected", []attribute.KeyValue{
+		attribute.String("net.peer.name", "supplier.test"),
+	}, 1)
+	var recorded uint64
+	for _, dp := range collectHistogram(t, reader, "http.client.throttle.wait") {
+		if host, _ := dp.Attributes.Value("net.peer.name"); host.AsString() == "supplier.test" {
+			recorded = dp.Count
+		}
+	}
+	if recorded != 6 {
+		t.Errorf("recorded %d waits for supplier.test, want 6", recorded)
+	}
+}
+
+func TestThrottleTransport_Unlimited(t *testing.T) {
+	otelt.InstallMetrics(t)
+	sent := 0
+	th := NewThrottleTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+	}), WithHostRate("supplier.test", 1, 1))
+	clock := &fakeThrottleClock{now: time.Now()}
+	clock.install(th)
+
+	for range 10 {
+		req, _ := http.NewRequest(http.MethodGet, "http://maps.test/", nil)
+		if _, err := th.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sent != 10 || len(clock.waits) != 0 {
+		t.Errorf("sent %d requests, waited %v; want 10 without waiting", sent, clock.waits)
+	}
+}
+
+func TestThrottleTransport_UnderRetries(t *testing.T) {
+	otelt.InstallMetrics(t)
+	clock := &fakeThrottleClock{now: time.Now()}
+	attempts := 0
+	throttle := NewThrottleTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		attempts++
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: http.NoBody, Request: r}, nil
+	}), WithHostRate("supplier.test", 1, 1))
+	clock.install(throttle)
+	rt := NewRetryTransport(throttle, WithMaxAttempts(3), WithBackoff(0, 0))
+	rt.wait = func(context.Context, time.Duration) error { return nil }
+
+	req, _ := http.NewRequest(http.MethodGet, "http://supplier.test/offers", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if attempts != 3 || len(clock.waits) != 2 || clock.waits[0] != time.Second || clock.waits[1] != time.Second {
+		t.Errorf("%d attempts after waiting %v, want 3 a second apart", attempts, clock.waits)
+	}
+
+	// Running out of time for a token is final.
+	ctx, cancel := context.WithDeadline(context.Background(), clock.now.Add(100*time.Millisecond))
+	defer cancel()
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, "http://supplier.test/offers", nil)
+	if _, err := rt.RoundTrip(req); !errors.Is(err, ErrUpstreamRateLimited) {
+		t.Errorf("got %v, want %v", err, ErrUpstreamRateLimited)
+	}
+	if attempts != 3 {
+		t.Errorf("%d attempts, want none more", attempts)
+	}
+}
@@ -0,0 +1,213 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RequestTimeoutHeader carries the time a caller is willing to wait, as a
+// duration such as "800ms". Transport sets it from the context deadline.
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// timeoutBody is the response sent when a handler overruns its deadline.
+const timeoutBody = `{"error":"request timed out"}` + "\n"
+
+// TimeoutOption configures TimeoutMiddleware.
+type TimeoutOption func(*timeoutConfig)
+
+type timeoutConfig struct {
+	routes        *Router
+	maxPropagated time.Duration
+}
+
+// WithRouteTimeouts applies the timeouts registered on rt with RouteTimeout
+// to the requests it routes, instead of the middleware default.
+func WithRouteTimeouts(rt *Router) TimeoutOption {
+	return func(c *timeoutConfig) { c.routes = rt }
+}
+
+// WithPropagatedTimeouts lets callers shorten the deadline of their request
+// with an X-Request-Timeout header, or a gRPC-style grpc-timeout one, below
+// the default or route timeout; longer values don't extend it. Values over
+// max are clamped to max, and malformed or non-positive ones ignored.
+// Requests running out of such a deadline are also recorded with
+// http.deadline_propagated=true.
+func WithPropagatedTimeouts(max time.Duration) TimeoutOption {
+	return func(c *timeoutConfig) { c.maxPropagated = max }
+}
+
+// TimeoutMiddleware gives each request a deadline of d. A handler that hasn't
+// finished by then gets its context canceled and the client gets a 504 with
+// a JSON body, recorded with http.timeout=true by outer metrics.
+//
+// Like http.TimeoutHandler, the response is buffered until the handler
+// returns, so handlers below it cannot flush or hijack, and writes after the
+// deadline fail with http.ErrHandlerTimeout instead of racing the 504.
+func TimeoutMiddleware(d time.Duration, opts ...TimeoutOption) func(http.Handler) http.Handler {
+	var cfg timeoutConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := d
+			if override := routeConfig(r, cfg.routes).Timeout; override > 0 {
+				timeout = override
+			}
+			propagated := false
+			if cfg.maxPropagated > 0 {
+				if budget, ok := requestTimeout(r.Header); ok && budget > 0 {
+					if budget = min(budget, cfg.maxPropagated); budget < timeout {
+						timeout, propagated = budget, true
+					}
+				}
+			}
+			serveWithTimeout(next, w, r, timeout, propagated)
+		})
+	}
+}
+
+// requestTimeout parses the timeout a caller sent in X-Request-Timeout or
+// grpc-timeout.
+func requestTimeout(h http.Header) (time.Duration, bool) {
+	if v := h.Get(RequestTimeoutHeader); v != "" {
+		d, err := time.ParseDuration(v)
+		return d, err == nil && d > 0
+	}
+	if v := h.Get("Grpc-Timeout"); v != "" {
+		return grpcTimeout(v)
+	}
+	return 0, false
+}
+
+// grpcTimeout parses a grpc-timeout value: at most 8 digits and a unit.
+// Values too large for a time.Duration come out as the largest one, for the
+// caller to clamp.
+func grpcTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+	if err != nil || n == 0 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond,
+	}
+	unit, ok := units[v[len(v)-1]]
+	if !ok {
+		return 0, false
+	}
+	if n > uint64(math.MaxInt64/int64(unit)) {
+		return math.MaxInt64, true
+	}
+	return time.Duration(n) * unit, true
+}
+
+func serveWithTimeout(next http.Handler, w http.ResponseWriter, r *http.Request, d time.Duration, propagated bool) {
+	r, st := withRequestState(r)
+	ctx, cancel := context.WithTimeout(r.Context(), d)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	tw := &timeoutWriter{w: w, h: make(http.Header)}
+	// done gets the handler's panic, or nil once it returned or called
+	// runtime.Goexit.
+	done := make(chan any, 1)
+	go func() {
+		defer func() {
+			v := recover()
+			if v != nil && v != http.ErrAbortHandler {
+				st.notePanic(v)
+			}
+			done <- v
+		}()
+		next.ServeHTTP(tw, r)
+	}()
+
+	select {
+	case v := <-done:
+		if v != nil {
+			panic(v)
+		}
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		dst := w.Header()
+		for k, vv := range tw.h {
+			dst[k] = vv
+		}
+		if !tw.wroteHeader {
+			tw.code = http.StatusOK
+		}
+		w.WriteHeader(tw.code)
+		_, _ = w.Write(tw.wbuf.Bytes())
+	case <-ctx.Done():
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		if ctx.Err() != context.DeadlineExceeded {
+			// The client went away; nobody is listening for a 504.
+			tw.err = ctx.Err()
+			return
+		}
+		st.mu.Lock()
+		st.timedOut = true
+		st.deadlinePropagated = propagated
+		st.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusGatewayTimeout)
+		_, _ = w.Write([]byte(timeoutBody))
+		tw.err = http.ErrHandlerTimeout
+	}
+}
+
+// timeoutWriter buffers the handler's response until it returns, and rejects
+// writes once the 504 has gone out.
+type timeoutWriter struct {
+	w    http.ResponseWriter
+	h    http.Header
+	wbuf bytes.Buffer
+
+	mu          sync.Mutex
+	err         error
+	wroteHeader bool
+	code        int
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.h }
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.err != nil {
+		return 0, tw.err
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	return tw.wbuf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.err != nil || tw.wroteHeader {
+		return
+	}
+	tw.writeHeaderLocked(code)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	// Informational responses can't be buffered meaningfully; drop them.
+	if code < 200 {
+		return
+	}
+	tw.wroteHeader = true
+	tw.code = code
+}
@@ -0,0 +1,240 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	t.Run("fast handler passes through", func(t *testing.T) {
+		handler := TimeoutMiddleware(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Trip", "42")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte("created"))
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+
+		if rec.Code != http.StatusCreated || rec.Body.String() != "created" || rec.Header().Get("X-Trip") != "42" {
+			t.Errorf("response mismatch: got %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+		}
+	})
+
+	t.Run("slow handler gets a 504", func(t *testing.T) {
+		reader := otelt.InstallMetrics(t)
+
+		lateWrite := make(chan error, 1)
+		handler := MetricsMiddleware(TimeoutMiddleware(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			time.Sleep(10 * time.Millisecond)
+			_, err := w.Write([]byte("too late"))
+			lateWrite <- err
+		})))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Errorf("status mismatch: got %d, want %d", rec.Code, http.StatusGatewayTimeout)
+		}
+		if rec.Body.String() != timeoutBody || rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("body mismatch: got %q", rec.Body.String())
+		}
+		if err := <-lateWrite; err != http.ErrHandlerTimeout {
+			t.Errorf("late write error mismatch: got %v, want %v", err, http.ErrHandlerTimeout)
+		}
+
+		dps := collectSum(t, reader, "http.server.requests")
+		if len(dps) != 1 {
+			t.Fatalf("expected 1 series, got %d", len(dps))
+		}
+		if timeout, _ := dps[0].Attributes.Value("http.timeout"); !timeout.AsBool() {
+			t.Errorf("expected http.timeout attribute, got %v", dps[0].Attributes)
+		}
+	})
+
+	t.Run("panic reaches the recovery middleware", func(t *testing.T) {
+		captureLogs(t)
+		handler := RecoverMiddleware(TimeoutMiddleware(time.Second)(http.HandlerFunc(panickingHandler)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status mismatch: got %d, want %d", rec.Code, http.StatusInternalServerError)
+		}
+	})
+}
+
+func TestTimeoutMiddleware_RouteOverrides(t *testing.T) {
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(30 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}
+
+	rt := NewRouter()
+	rt.HandleFunc("GET /search", slow, RouteTimeout(time.Second))
+	rt.Group("/api").HandleFunc("GET /quotes", slow)
+	handler := TimeoutMiddleware(5*time.Millisecond, WithRouteTimeouts(rt))(rt)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/search", want: http.StatusOK},
+		{path: "/api/quotes", want: http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("status mismatch: got %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeoutMiddleware_PropagatedTimeouts(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	var forwarded string
+	supplier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(RequestTimeoutHeader)
+	}))
+	defer supplier.Close()
+	supplierURL, _ := url.Parse(supplier.URL)
+	internal := &http.Client{Transport: NewTransport(nil, WithTimeoutPropagation(supplierURL.Hostname()))}
+	external := &http.Client{Transport: NewTransport(nil)}
+
+	var budget time.Duration
+	handler := MetricsMiddleware(TimeoutMiddleware(time.Second, WithPropagatedTimeouts(2*time.Second))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ := r.Context().Deadline()
+		budget = time.Until(deadline)
+		if client := r.URL.Query().Get("call"); client != "" {
+			req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, supplier.URL, nil)
+			c := internal
+			if client == "external" {
+				c = external
+			}
+			if resp, err := c.Do(req); err == nil {
+				_ = resp.Body.Close()
+			}
+		}
+		if r.URL.Query().Has("slow") {
+			<-r.Context().Done()
+		}
+	})))
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   time.Duration
+	}{
+		{name: "no header", want: time.Second},
+		{name: "shorter", header: map[string]string{RequestTimeoutHeader: "800ms"}, want: 800 * time.Millisecond},
+		{name: "longer", header: map[string]string{RequestTimeoutHeader: "1500ms"}, want: time.Second},
+		{name: "clamped", header: map[string]string{RequestTimeoutHeader: "1h"}, want: time.Second},
+		{name: "malformed", header: map[string]string{RequestTimeoutHeader: "soon"}, want: time.Second},
+		{name: "negative", header: map[string]string{RequestTimeoutHeader: "-5s"}, want: time.Second},
+		{name: "grpc", header: map[string]string{"Grpc-Timeout": "300m"}, want: 300 * time.Millisecond},
+		{name: "grpc huge hours", header: map[string]string{"Grpc-Timeout": "3000000H"}, want: time.Second},
+		{name: "grpc max hours", header: map[string]string{"Grpc-Timeout": "99999999H"}, want: time.Second},
+		{name: "grpc max minutes", header: map[string]string{"Grpc-Timeout": "99999999M"}, want: time.Second},
+		{name: "grpc max seconds", header: map[string]string{"Grpc-Timeout": "99999999S"}, want: time.Second},
+		{name: "grpc too many digits", header: map[string]string{"Grpc-Timeout": "123456789m"}, want: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if budget > tt.want || budget < tt.want-100*time.Millisecond {
+				t.Errorf("deadline in %v, want %v", budget, tt.want)
+			}
+		})
+	}
+
+	t.Run("forwarded downstream", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/?call=internal", nil)
+		req.Header.Set(RequestTimeoutHeader, "800ms")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		d, err := time.ParseDuration(forwarded)
+		if err != nil || d > 800*time.Millisecond || d < 700*time.Millisecond {
+			t.Errorf("supplier got %s %q, want a little under 800ms", RequestTimeoutHeader, forwarded)
+		}
+	})
+
+	t.Run("not forwarded to other hosts", func(t *testing.T) {
+		forwarded = "unset"
+		req := httptest.NewRequest(http.MethodGet, "/?call=external", nil)
+		req.Header.Set(RequestTimeoutHeader, "800ms")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if forwarded != "" {
+			t.Errorf("supplier got %s %q, want none", RequestTimeoutHeader, forwarded)
+		}
+	})
+
+	t.Run("expired budget", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/?slow", nil)
+		req.Header.Set(RequestTimeoutHeader, "10ms")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Errorf("status mismatch: got %d, want %d", rec.Code, http.StatusGatewayTimeout)
+		}
+		otelt.RequireCounterValue(t, "http.server.requests", []attribute.KeyValue{
+			attribute.Bool("http.timeout", true),
+			attribute.Bool("http.deadline_propagated", true),
+		}, 1)
+	})
+}
+
+func TestTimeoutMiddleware_LongerBudget(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	handler := MetricsMiddleware(TimeoutMiddleware(20*time.Millisecond, WithPropagatedTimeouts(time.Second))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestTimeoutHeader, "500ms")
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusGatewayTimeout || time.Since(start) >= 500*time.Millisecond {
+		t.Errorf("got %d after %v, want a 504 at the 20ms default", rec.Code, time.Since(start))
+	}
+	for _, dp := range collectSum(t, reader, "http.server.requests") {
+		if _, ok := dp.Attributes.Value("http.deadline_propagated"); ok {
+			t.Errorf("request recorded with %v, want no http.deadline_propagated: the caller's budget wasn't the deadline", dp.Attributes.ToSlice())
+		}
+	}
+}
+
+func TestTimeoutMiddleware_Goexit(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	handler := TimeoutMiddleware(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runtime.Goexit()
+	}))
+	start := time.Now()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("returned after %v, waiting out the deadline of a handler gone with runtime.Goexit", elapsed)
+	}
+}
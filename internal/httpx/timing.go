@@ -0,0 +1,185 @@
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MiddlewareTiming is what the middlewares of ChainNamed record of the time
+// they take.
+type MiddlewareTiming uint32
+
+const (
+	// MiddlewareTimingOff records nothing, at the cost of an atomic load per
+	// middleware and request.
+	MiddlewareTimingOff MiddlewareTiming = iota
+	// MiddlewareTimingSpans sets the time of each middleware as a
+	// http.middleware.<name>.duration attribute of the request span. Requests
+	// traced with DebugTraceMiddleware get a child span per middleware
+	// instead.
+	MiddlewareTimingSpans
+	// MiddlewareTimingMetrics does the same and records the times in the
+	// http.server.middleware.duration histogram, by http.middleware name.
+	MiddlewareTimingMetrics
+)
+
+var middlewareTiming atomic.Uint32
+
+// SetMiddlewareTiming sets what the middlewares of ChainNamed record, off by
+// default. The time of a middleware is its own, without that of the
+// middlewares and handler it calls. Middlewares outside TracingMiddleware
+// are done after the request span ends, so only their metrics are recorded.
+func SetMiddlewareTiming(t MiddlewareTiming) {
+	middlewareTiming.Store(uint32(t))
+}
+
+func (t MiddlewareTiming) String() string {
+	switch t {
+	case MiddlewareTimingOff:
+		return "off"
+	case MiddlewareTimingSpans:
+		return "spans"
+	case MiddlewareTimingMetrics:
+		return "metrics"
+	}
+	return fmt.Sprintf("MiddlewareTiming(%d)", uint32(t))
+}
+
+// UnmarshalText parses off, spans or metrics.
+func (t *MiddlewareTiming) UnmarshalText(text []byte) error {
+	for _, v := range []MiddlewareTiming{MiddlewareTimingOff, MiddlewareTimingSpans, MiddlewareTimingMetrics} {
+		if string(text) == v.String() {
+			*t = v
+			return nil
+		}
+	}
+	return fmt.Errorf("want off, spans or metrics")
+}
+
+// NamedMiddleware is a middleware ChainNamed times under Name, such as
+// "auth" or "rate_limit".
+type NamedMiddleware struct {
+	Name       string
+	Middleware func(http.Handler) http.Handler
+}
+
+// Named names middleware for ChainNamed.
+func Named(name string, middleware func(http.Handler) http.Handler) NamedMiddleware {
+	return NamedMiddleware{Name: name, Middleware: middleware}
+}
+
+// ChainNamed is Chain for named middlewares, which record the time they take
+// as SetMiddlewareTiming says.
+func ChainNamed(middlewares ...NamedMiddleware) func(http.Handler) http.Handler {
+	return chainTimed(func() MiddlewareTiming { return MiddlewareTiming(middlewareTiming.Load()) }, middlewares)
+}
+
+// ChainNamedTiming is ChainNamed recording the time of its middlewares as
+// timing says, whatever SetMiddlewareTiming set for the others.
+func ChainNamedTiming(timing MiddlewareTiming, middlewares ...NamedMiddleware) func(http.Handler) http.Handler {
+	return chainTimed(func() MiddlewareTiming { return timing }, middlewares)
+}
+
+func chainTimed(timing func() MiddlewareTiming, middlewares []NamedMiddleware) func(http.Handler) http.Handler {
+	timed := make([]func(http.Handler) http.Handler, len(middlewares))
+	for i, m := range middlewares {
+		timed[i] = func(next http.Handler) http.Handler { return m.timed(timing, next) }
+	}
+	return Chain(timed...)
+}
+
+// timed wraps next in m, with a handler on either side accounting for the
+// time spent in m and in next.
+func (m NamedMiddleware) timed(load func() MiddlewareTiming, next http.Handler) http.Handler {
+	key := &phaseKey{}
+	attrKey := attribute.Key("http.middleware." + m.Name + ".duration")
+	metricAttrs := metric.WithAttributeSet(attribute.NewSet(attribute.String("http.middleware", m.Name)))
+
+	inner := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := r.Context().Value(key).(*phase)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p.enter()
+		defer p.leave()
+		next.ServeHTTP(w, r)
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timing := load()
+		if timing == MiddlewareTimingOff {
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		var span trace.Span
+		if forced, _ := ctx.Value(forceSampleKey{}).(bool); forced {
+			ctx, span = Tracer().Start(ctx, "middleware "+m.Name)
+		}
+		p := &phase{start: time.Now()}
+		inner.ServeHTTP(w, r.WithContext(context.WithValue(ctx, key, p)))
+		own := p.own(time.Now())
+
+		if span != nil {
+			span.SetAttributes(attribute.Float64("http.middleware.self_duration", own.Seconds()))
+			span.End()
+		} else {
+			trace.SpanFromContext(ctx).SetAttributes(attrKey.Float64(own.Seconds()))
+		}
+		if timing == MiddlewareTimingMetrics {
+			loadServerMetrics().middleware.Record(ctx, own.Seconds(), metricAttrs)
+		}
+	})
+}
+
+type phaseKey struct{}
+
+// phase accounts for the time a middleware spends in the handler it wraps,
+// which it may call more than once or concurrently.
+type phase struct {
+	start time.Time
+
+	mu      sync.Mutex
+	calls   int // of the handler in progress
+	entered time.Time
+	inner   time.Duration
+}
+
+func (p *phase) enter() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.calls == 0 {
+		p.entered = time.Now()
+	}
+	p.calls++
+}
+
+func (p *phase) leave() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls--
+	if p.calls == 0 {
+		p.inner += time.Since(p.entered)
+	}
+}
+
+// own returns the time until end not spent in the handler, such as one
+// still running after a timeout.
+func (p *phase) own(end time.Time) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	inner := p.inner
+	if p.calls > 0 {
+		inner += end.Sub(p.entered)
+	}
+	return max(end.Sub(p.start)-inner, 0)
+}
@@ -0,0 +1,162 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// setMiddlewareTiming sets the timing for the test only.
+func setMiddlewareTiming(t *testing.T, timing MiddlewareTiming) {
+	t.Helper()
+	SetMiddlewareTiming(timing)
+	t.Cleanup(func() { SetMiddlewareTiming(MiddlewareTimingOff) })
+}
+
+// sleeping sleeps for d before calling the next handler.
+func sleeping(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(d)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestChainNamed_Timing(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+	exp := otelt.InstallTracing(t)
+	setMiddlewareTiming(t, MiddlewareTimingMetrics)
+
+	handler := ChainNamed(
+		Named("tracing", TracingMiddleware),
+		Named("auth", sleeping(20*time.Millisecond)),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	spans := exp.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want the request's", len(spans))
+	}
+	var auth float64
+	for _, a := range spans[0].Attributes {
+		if a.Key == "http.middleware.auth.duration" {
+			auth = a.Value.AsFloat64()
+		}
+	}
+	if auth < 0.02 || auth >= 0.05 {
+		t.Errorf("http.middleware.auth.duration = %v, want its 20ms sleep without the handler's 50ms", auth)
+	}
+
+	m, ok := collectMetric(t, reader, "http.server.middleware.duration")
+	if !ok {
+		t.Fatal("http.server.middleware.duration not recorded")
+	}
+	got := map[string]float64{}
+	for _, dp := range m.Data.(metricdata.Histogram[float64]).DataPoints {
+		name, _ := dp.Attributes.Value("http.middleware")
+		got[name.AsString()] = dp.Sum
+	}
+	if len(got) != 2 || got["auth"] != auth || got["tracing"] >= 0.02 {
+		t.Errorf("middleware durations = %v, want auth's %v and a short tracing", got, auth)
+	}
+}
+
+func TestChainNamed_DebugTraceChildSpans(t *testing.T) {
+	exp := otelt.InstallTracing(t)
+	setMiddlewareTiming(t, MiddlewareTimingSpans)
+
+	ChainNamed(
+		Named("debug_trace", DebugTraceMiddleware(DebugTraceSecret("s3cret"))),
+		Named("tracing", TracingMiddleware),
+		Named("auth", sleeping(time.Millisecond)),
+	)(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), debugRequest("s3cret"))
+
+	names := map[string]bool{}
+	for _, s := range exp.GetSpans() {
+		names[s.Name] = true
+		if s.Name == "middleware auth" && !hasAttribute(s.Attributes, "http.middleware.self_duration") {
+			t.Errorf("middleware span attributes %v, want its self duration", s.Attributes)
+		}
+	}
+	if !names["middleware auth"] || !names["middleware tracing"] {
+		t.Errorf("spans %v, want one per middleware within the debug trace", names)
+	}
+}
+
+func TestChainNamed_Off(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+	exp := otelt.InstallTracing(t)
+
+	var called bool
+	ChainNamed(
+		Named("tracing", TracingMiddleware),
+		Named("auth", sleeping(0)),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if !called {
+		t.Fatal("handler not called")
+	}
+	if spans := exp.GetSpans(); len(spans) != 1 || hasAttribute(spans[0].Attributes, "http.middleware.auth.duration") {
+		t.Errorf("spans %v, want the request's without timing", spans)
+	}
+	if _, ok := collectMetric(t, reader, "http.server.middleware.duration"); ok {
+		t.Error("http.server.middleware.duration recorded while timing is off")
+	}
+}
+
+func TestChainNamedTiming(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+	otelt.InstallTracing(t)
+
+	serve := func(chain func(http.Handler) http.Handler) {
+		chain(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	serve(ChainNamedTiming(MiddlewareTimingMetrics, Named("stack", sleeping(0))))
+	// The chain's timing stays its own.
+	serve(ChainNamed(Named("other", sleeping(0))))
+
+	m, ok := collectMetric(t, reader, "http.server.middleware.duration")
+	if !ok {
+		t.Fatal("http.server.middleware.duration not recorded")
+	}
+	dps := m.Data.(metricdata.Histogram[float64]).DataPoints
+	if name, _ := dps[0].Attributes.Value("http.middleware"); len(dps) != 1 || name.AsString() != "stack" {
+		t.Errorf("got %d series, want the stack's only", len(dps))
+	}
+}
+
+func TestPhase_ConcurrentCalls(t *testing.T) {
+	start := time.Now()
+	p := &phase{start: start}
+	p.enter()
+	p.enter()
+	p.leave()
+	// The handler still running, e.g. after a timeout, counts until the end.
+	if own := p.own(start.Add(time.Hour)); own > time.Second {
+		t.Errorf("own time %v, want the time outside the handler only", own)
+	}
+}
+
+func debugRequest(secret string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(DebugTraceHeader, secret)
+	return r
+}
+
+func hasAttribute(attrs []attribute.KeyValue, key attribute.Key) bool {
+	for _, a := range attrs {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
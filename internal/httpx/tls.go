@@ -0,0 +1,351 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// TLSOption configures WithTLS.
+type TLSOption func(*tlsSettings)
+
+type tlsSettings struct {
+	certFile       string
+	keyFile        string
+	clientCAFile   string
+	minVersion     uint16
+	reloadInterval time.Duration
+}
+
+// WithClientCAs requires clients to present a certificate signed by one of
+// the CAs in the PEM file, for mutual TLS.
+func WithClientCAs(file string) TLSOption {
+	return func(s *tlsSettings) { s.clientCAFile = file }
+}
+
+// WithMinTLSVersion refuses clients speaking a version older than v, such
+// as tls.VersionTLS13. Defaults to TLS 1.2.
+func WithMinTLSVersion(v uint16) TLSOption {
+	return func(s *tlsSettings) { s.minVersion = v }
+}
+
+// WithCertReloadInterval sets how often the certificate and key files are
+// checked for changes, 10 seconds by default.
+func WithCertReloadInterval(d time.Duration) TLSOption {
+	return func(s *tlsSettings) { s.reloadInterval = d }
+}
+
+// WithTLS serves the main server over TLS with the PEM certificate and key
+// in the given files, which are reloaded when they change so they can be
+// rotated without a restart. The admin server stays in plain HTTP. Failed
+// handshakes are counted in http.server.tls.handshake.errors by error.type.
+func WithTLS(certFile, keyFile string, opts ...TLSOption) RunOption {
+	s := &tlsSettings{certFile: certFile, keyFile: keyFile, minVersion: tls.VersionTLS12, reloadInterval: 10 * time.Second}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return func(c *runConfig) { c.tls = s }
+}
+
+// config loads the certificate and client CAs, returning the server's
+// configuration and the reloader to watch the certificate files with.
+func (s *tlsSettings) config() (*tls.Config, *certReloader, error) {
+	certs := &certReloader{certFile: s.certFile, keyFile: s.keyFile}
+	if err := certs.load(); err != nil {
+		return nil, nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:     s.minVersion,
+		GetCertificate: certs.getCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if s.clientCAFile != "" {
+		pem, err := os.ReadFile(s.clientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read client CAs: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("read client CAs: no certificate in %s", s.clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, certs, nil
+}
+
+// certReloader serves the certificate in its files, reloading them when
+// they change, as when cert-manager renews it.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+	stamp    string // of the files loaded
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+func (c *certReloader) load() error {
+	stamp, err := c.filesStamp()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	c.cert.Store(&cert)
+	c.stamp = stamp
+	return nil
+}
+
+// filesStamp identifies the version of the files, following symlinks such
+// as those of Kubernetes secret volumes.
+func (c *certReloader) filesStamp() (string, error) {
+	var b strings.Builder
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return "", fmt.Errorf("load TLS certificate: %w", err)
+		}
+		fmt.Fprintf(&b, "%d/%d;", info.ModTime().UnixNano(), info.Size())
+	}
+	return b.String(), nil
+}
+
+// watch reloads the files when they change until ctx is done. A certificate
+// that fails to load is logged and the previous one kept, since the files
+// may be caught in the middle of being replaced.
+func (c *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if stamp, err := c.filesStamp(); err == nil && stamp == c.stamp {
+			continue
+		}
+		if err := c.load(); err != nil {
+			slog.Error("Failed to reload the TLS certificate", "error", err)
+			continue
+		}
+		leaf := c.cert.Load().Leaf
+		slog.Info("TLS certificate reloaded", "subject", leaf.Subject.String(), "not_after", leaf.NotAfter)
+	}
+}
+
+// tlsErrorLog is the ErrorLog of servers over TLS, sending their errors to
+// slog as warnings.
+func tlsErrorLog(name string) *log.Logger {
+	return log.New(serverErrorWriter{name: name}, "", 0)
+}
+
+type serverErrorWriter struct{ name string }
+
+func (w serverErrorWriter) Write(p []byte) (int, error) {
+	slog.Warn(w.name+" server error", "error", string(bytes.TrimSpace(p)))
+	return len(p), nil
+}
+
+// handshakeListener is a TLS listener handing out connections once their
+// handshake succeeded, rather than leaving it to net/http, so that failed
+// handshakes are counted, by error.type, and logged at Debug, as they are
+// mostly scanners and clients giving up.
+type handshakeListener struct {
+	net.Listener // of the raw connections
+	config       *tls.Config
+	timeout      time.Duration // of a handshake, when not zero
+
+	ctx    context.Context // done once closed
+	cancel context.CancelFunc
+	conns  chan net.Conn
+	errs   chan error
+}
+
+// newHandshakeListener handshakes the connections of ln with config,
+// giving up on those taking longer than timeout, if not zero.
+func newHandshakeListener(ln net.Listener, config *tls.Config, timeout time.Duration) *handshakeListener {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &handshakeListener{
+		Listener: ln,
+		config:   config,
+		timeout:  timeout,
+		ctx:      ctx,
+		cancel:   cancel,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+	}
+	go l.accept()
+	return l
+}
+
+func (l *handshakeListener) accept() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			// Accept gets the error, then net/http backs off before
+			// accepting again.
+			select {
+			case l.errs <- err:
+			case <-l.ctx.Done():
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go l.handshake(conn)
+	}
+}
+
+func (l *handshakeListener) handshake(conn net.Conn) {
+	ctx := l.ctx
+	if l.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.timeout)
+		defer cancel()
+	}
+	tc := tls.Server(conn, l.config)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		_ = tc.Close()
+		if l.ctx.Err() == nil {
+			loadServerMetrics().tlsHandshake.Add(context.Background(), 1,
+				metric.WithAttributes(attribute.String("error.type", handshakeErrorType(err))))
+			slog.Debug("TLS handshake failed", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		}
+		return
+	}
+	select {
+	case l.conns <- tc:
+	case <-l.ctx.Done():
+		_ = tc.Close()
+	}
+}
+
+func (l *handshakeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections and abandons the pending handshakes.
+func (l *handshakeListener) Close() error {
+	l.cancel()
+	return l.Listener.Close()
+}
+
+// handshakeErrorType sorts handshake errors into a few error.type values.
+// Those of the TLS stack that carry no type, such as a client offering no
+// version supported, are told apart by their message.
+func handshakeErrorType(err error) string {
+	var verifyErr *tls.CertificateVerificationError
+	var alert tls.AlertError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &verifyErr):
+		return "certificate"
+	case errors.As(err, &alert):
+		// The client's alert, as named in RFC 8446, section 6.
+		switch alert {
+		case 42, 43, 44, 45, 46, 48, 116: // bad_certificate to unknown_ca, certificate_required
+			return "certificate"
+		case 70: // protocol_version
+			return "protocol_version"
+		}
+	case errors.Is(err, io.EOF), errors.Is(err, syscall.ECONNRESET):
+		return "connection_closed"
+	case errors.As(err, &netErr) && netErr.Timeout(), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+	switch msg := err.Error(); {
+	case strings.Contains(msg, "certificate"):
+		return "certificate"
+	case strings.Contains(msg, "version"):
+		return "protocol_version"
+	}
+	return "other"
+}
+
+// ClientCert identifies the client of a request by the certificate it
+// presented over mutual TLS.
+type ClientCert struct {
+	CommonName string
+	DNSNames   []string
+	URIs       []string // such as SPIFFE IDs
+}
+
+type clientCertKey struct{}
+
+// ClientCertFromContext returns the verified client certificate of the
+// request, set by Run with WithClientCAs, and false without one.
+func ClientCertFromContext(ctx context.Context) (ClientCert, bool) {
+	c, ok := ctx.Value(clientCertKey{}).(ClientCert)
+	return c, ok
+}
+
+// clientCertMiddleware hands the verified client certificate to the
+// handler through the context.
+func clientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		leaf := r.TLS.VerifiedChains[0][0]
+		c := ClientCert{CommonName: leaf.Subject.CommonName, DNSNames: leaf.DNSNames}
+		for _, u := range leaf.URIs {
+			c.URIs = append(c.URIs, u.String())
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientCertKey{}, c)))
+	})
+}
+
+// ClientCertAuthenticator authenticates requests by their verified client
+// certificate, the principal being its first URI, else its common name,
+// else its first DNS name. Its scheme is "client_cert".
+func ClientCertAuthenticator() Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (Principal, error) {
+		c, ok := ClientCertFromContext(r.Context())
+		if !ok {
+			return Principal{}, ErrCredentialsMissing
+		}
+		var id string
+		switch {
+		case len(c.URIs) > 0:
+			id = c.URIs[0]
+		case c.CommonName != "":
+			id = c.CommonName
+		case len(c.DNSNames) > 0:
+			id = c.DNSNames[0]
+		default:
+			return Principal{}, errors.New("client certificate without a name")
+		}
+		return Principal{ID: id, Scheme: "client_cert"}, nil
+	})
+}
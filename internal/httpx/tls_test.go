@@ -0,0 +1,310 @@
+package httpx
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// testCA issues certificates for the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "acai test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM certificate and key of a leaf with the given serial
+// and names, for servers on 127.0.0.1 and clients alike.
+func (ca *testCA) issue(t *testing.T, serial int64, cn string, uris ...string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	for _, u := range uris {
+		parsed, _ := url.Parse(u)
+		tmpl.URIs = append(tmpl.URIs, parsed)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runTLS runs handler over TLS with the given certificate files until the
+// test ends, returning its address.
+func runTLS(t *testing.T, handler http.Handler, certFile, keyFile string, opts ...TLSOption) string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	addrCh := make(chan string, 1)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- Run(ctx, "127.0.0.1:0", handler,
+			WithTLS(certFile, keyFile, opts...),
+			WithOnListen(func(a net.Addr) { addrCh <- a.String() }),
+		)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-runErr; err != nil {
+			t.Errorf("Run() unexpected error: %v", err)
+		}
+	})
+	select {
+	case addr := <-addrCh:
+		return addr
+	case err := <-runErr:
+		t.Fatalf("Run() failed to start: %v", err)
+		return ""
+	}
+}
+
+func TestRun_MutualTLS(t *testing.T) {
+	captureLogs(t)
+	otelt.InstallMetrics(t)
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certPEM, keyPEM := ca.issue(t, 2, "acai-server")
+	writeTestFile(t, filepath.Join(dir, "tls.crt"), certPEM)
+	writeTestFile(t, filepath.Join(dir, "tls.key"), keyPEM)
+	writeTestFile(t, filepath.Join(dir, "ca.crt"), ca.pem)
+	clientPEM, clientKeyPEM := ca.issue(t, 3, "booking-service", "spiffe://acai/booking")
+	clientCert, err := tls.X509KeyPair(clientPEM, clientKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := AuthMiddleware(ClientCertAuthenticator())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := PrincipalFromContext(r.Context())
+		c, _ := ClientCertFromContext(r.Context())
+		_, _ = io.WriteString(w, p.ID+" "+c.CommonName)
+	}))
+	addr := runTLS(t, handler, filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"),
+		WithClientCAs(filepath.Join(dir, "ca.crt")), WithMinTLSVersion(tls.VersionTLS13))
+
+	get := func(cfg *tls.Config) (string, error) {
+		cfg.RootCAs = ca.pool
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		defer client.CloseIdleConnections()
+		resp, err := client.Get("https://" + addr + "/")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), nil
+	}
+
+	if body, err := get(&tls.Config{Certificates: []tls.Certificate{clientCert}}); err != nil || body != "spiffe://acai/booking booking-service" {
+		t.Errorf("with a client certificate: got %q, %v; want the principal from its URI", body, err)
+	}
+	if _, err := get(&tls.Config{}); err == nil {
+		t.Error("without a client certificate: request succeeded")
+	}
+	if _, err := get(&tls.Config{Certificates: []tls.Certificate{clientCert}, MaxVersion: tls.VersionTLS12}); err == nil {
+		t.Error("over TLS 1.2: request succeeded")
+	}
+
+	// The server counts the failures once the handshakes are over on its side.
+	deadline := time.Now().Add(time.Second)
+	for {
+		got := otelt.CounterValue(t, "http.server.tls.handshake.errors", []attribute.KeyValue{attribute.String("error.type", "certificate")}) +
+			otelt.CounterValue(t, "http.server.tls.handshake.errors", []attribute.KeyValue{attribute.String("error.type", "protocol_version")})
+		if got == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("counted %d certificate and version handshake errors, want 2", got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHandshakeErrorType(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}, "certificate"},
+		{fmt.Errorf("read: %w", tls.AlertError(48)), "certificate"},
+		{tls.AlertError(70), "protocol_version"},
+		{errors.New("tls: client offered only unsupported versions: [303]"), "protocol_version"},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), "connection_closed"},
+		{io.EOF, "connection_closed"},
+		{context.DeadlineExceeded, "timeout"},
+		{errors.New("tls: first record does not look like a TLS handshake"), "other"},
+	}
+	for _, tt := range tests {
+		if got := handshakeErrorType(tt.err); got != tt.want {
+			t.Errorf("handshakeErrorType(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRun_TLSCertReload(t *testing.T) {
+	captureLogs(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	ca := newTestCA(t)
+	certPEM, keyPEM := ca.issue(t, 10, "acai-server")
+	writeTestFile(t, certFile, certPEM)
+	writeTestFile(t, keyFile, keyPEM)
+
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		_, _ = io.WriteString(w, "ok")
+	})
+	addr := runTLS(t, handler, certFile, keyFile, WithCertReloadInterval(10*time.Millisecond))
+
+	serial := func() (int64, error) {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: ca.pool})
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+	}
+	if got, err := serial(); err != nil || got != 10 {
+		t.Fatalf("initial certificate serial %d, %v; want 10", got, err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.pool}}}
+	defer client.CloseIdleConnections()
+	inFlight := make(chan error, 1)
+	go func() {
+		resp, err := client.Get("https://" + addr + "/slow")
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		inFlight <- err
+	}()
+
+	// A half-written pair is kept out until both files are right.
+	writeTestFile(t, certFile, []byte("not a certificate"))
+	time.Sleep(50 * time.Millisecond)
+	if got, err := serial(); err != nil || got != 10 {
+		t.Errorf("serial %d, %v with a broken file; want the previous certificate, 10", got, err)
+	}
+
+	certPEM, keyPEM = ca.issue(t, 11, "acai-server")
+	writeTestFile(t, keyFile, keyPEM)
+	writeTestFile(t, certFile, certPEM)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got, err := serial()
+		if err == nil && got == 11 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("serial %d, %v after the rotation; want 11", got, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(release)
+	if err := <-inFlight; err != nil {
+		t.Errorf("request in flight during the rotation failed: %v", err)
+	}
+}
+
+func TestClientCertAuthenticator(t *testing.T) {
+	tests := []struct {
+		name   string
+		cert   *ClientCert
+		wantID string
+		wantOK bool
+	}{
+		{name: "URI first", cert: &ClientCert{CommonName: "booking", URIs: []string{"spiffe://acai/booking"}}, wantID: "spiffe://acai/booking", wantOK: true},
+		{name: "common name", cert: &ClientCert{CommonName: "booking", DNSNames: []string{"booking.svc"}}, wantID: "booking", wantOK: true},
+		{name: "DNS name", cert: &ClientCert{DNSNames: []string{"booking.svc"}}, wantID: "booking.svc", wantOK: true},
+		{name: "no name", cert: &ClientCert{}},
+		{name: "no certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			if tt.cert != nil {
+				r = r.WithContext(context.WithValue(r.Context(), clientCertKey{}, *tt.cert))
+			}
+			p, err := ClientCertAuthenticator().Authenticate(r)
+			if (err == nil) != tt.wantOK || p.ID != tt.wantID {
+				t.Errorf("got %+v, %v; want ID %q", p, err, tt.wantID)
+			}
+			if tt.wantOK && p.Scheme != "client_cert" {
+				t.Errorf("scheme %q, want client_cert", p.Scheme)
+			}
+		})
+	}
+}
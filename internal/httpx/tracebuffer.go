@@ -0,0 +1,344 @@
+package httpx
+
+import (
+	"cmp"
+	"context"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultTraceBufferSize is how many finished spans a TraceBuffer keeps when
+// NewTraceBuffer is not given a size.
+const defaultTraceBufferSize = 1000
+
+// TraceBuffer keeps the spans of recent traces in memory, for looking at
+// them without a collector. Give it to both InitTelemetry, with
+// WithTraceBuffer, and the admin server, with WithAdminTraces.
+//
+// It holds the last size finished spans, the oldest making room for new
+// ones, and up to size spans still running.
+type TraceBuffer struct {
+	size int
+
+	mu       sync.Mutex
+	finished []sdktrace.ReadOnlySpan // a ring, next is the oldest once full
+	next     int
+	active   map[trace.SpanID]sdktrace.ReadWriteSpan
+}
+
+// NewTraceBuffer returns a TraceBuffer keeping size spans, or 1000 if size
+// is not positive.
+func NewTraceBuffer(size int) *TraceBuffer {
+	if size <= 0 {
+		size = defaultTraceBufferSize
+	}
+	return &TraceBuffer{
+		size:     size,
+		finished: make([]sdktrace.ReadOnlySpan, 0, size),
+		active:   map[trace.SpanID]sdktrace.ReadWriteSpan{},
+	}
+}
+
+// WithTraceBuffer keeps the recorded spans in b in addition to exporting
+// them. Meant for development: every span goes through b under its lock.
+func WithTraceBuffer(b *TraceBuffer) TelemetryOption {
+	return func(c *telemetryConfig) { c.traceBuffer = b }
+}
+
+// OnStart implements sdktrace.SpanProcessor.
+func (b *TraceBuffer) OnStart(_ context.Context, s sdktrace.ReadWriteSpan) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.active) < b.size {
+		b.active[s.SpanContext().SpanID()] = s
+	}
+}
+
+// OnEnd implements sdktrace.SpanProcessor.
+func (b *TraceBuffer) OnEnd(s sdktrace.ReadOnlySpan) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.active, s.SpanContext().SpanID())
+	if len(b.finished) < b.size {
+		b.finished = append(b.finished, s)
+		return
+	}
+	b.finished[b.next] = s
+	b.next = (b.next + 1) % b.size
+}
+
+// Shutdown implements sdktrace.SpanProcessor. The spans stay viewable.
+func (b *TraceBuffer) Shutdown(context.Context) error { return nil }
+
+// ForceFlush implements sdktrace.SpanProcessor.
+func (b *TraceBuffer) ForceFlush(context.Context) error { return nil }
+
+// bufferedSpan is a span of the buffer, finished or not.
+type bufferedSpan struct {
+	sdktrace.ReadOnlySpan
+	active bool
+}
+
+// spans returns the spans of the buffer, those of traceID only unless it is
+// invalid.
+func (b *TraceBuffer) spans(traceID trace.TraceID) []bufferedSpan {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	spans := make([]bufferedSpan, 0, len(b.finished)+len(b.active))
+	for _, s := range b.finished {
+		if !traceID.IsValid() || s.SpanContext().TraceID() == traceID {
+			spans = append(spans, bufferedSpan{ReadOnlySpan: s})
+		}
+	}
+	for _, s := range b.active {
+		if !traceID.IsValid() || s.SpanContext().TraceID() == traceID {
+			spans = append(spans, bufferedSpan{ReadOnlySpan: s, active: true})
+		}
+	}
+	return spans
+}
+
+// traceSummary is a trace of the /debug/traces list, described by its root
+// span: the earliest one whose parent is not in the buffer.
+type traceSummary struct {
+	TraceID    string        `json:"trace_id"`
+	Name       string        `json:"name"`
+	Start      time.Time     `json:"start"`
+	Duration   time.Duration `json:"duration"`
+	Status     string        `json:"status"` // Unset, Error or Ok
+	HTTPStatus int64         `json:"http_status,omitempty"`
+	Spans      int           `json:"spans"`
+	Errors     int           `json:"errors,omitempty"` // spans with an Error status
+	Active     bool          `json:"active,omitempty"` // the root is still running
+}
+
+type routeTraces struct {
+	Route  string         `json:"route"`
+	Traces []traceSummary `json:"traces"`
+}
+
+// traceSpan is a span of the /debug/traces/{id} tree.
+type traceSpan struct {
+	SpanID      string         `json:"span_id"`
+	Name        string         `json:"name"`
+	Kind        string         `json:"kind"`
+	Start       time.Time      `json:"start"`
+	Duration    time.Duration  `json:"duration"`
+	Status      string         `json:"status"`
+	Description string         `json:"status_description,omitempty"`
+	Active      bool           `json:"active,omitempty"`
+	Attributes  map[string]any `json:"attributes,omitempty"`
+	Events      []traceEvent   `json:"events,omitempty"`
+	Children    []*traceSpan   `json:"children,omitempty"`
+}
+
+type traceEvent struct {
+	Name       string         `json:"name"`
+	Time       time.Time      `json:"time"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// Handler serves the buffered traces: at /debug/traces the recent ones
+// grouped by route, newest first, and at /debug/traces/{id} the span tree
+// of one. Both are JSON, or HTML for browsers and with ?format=html.
+func (b *TraceBuffer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/traces", func(w http.ResponseWriter, r *http.Request) {
+		routes := summarizeTraces(b.spans(trace.TraceID{}), time.Now())
+		w.Header().Set("Cache-Control", "no-store")
+		if wantsHTML(r) {
+			renderTraceHTML(w, "list", routes)
+			return
+		}
+		_ = WriteJSON(w, http.StatusOK, struct {
+			Routes []routeTraces `json:"routes"`
+		}{routes})
+	})
+	mux.HandleFunc("GET /debug/traces/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := trace.TraceIDFromHex(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid trace ID", http.StatusBadRequest)
+			return
+		}
+		spans := b.spans(id)
+		if len(spans) == 0 {
+			http.Error(w, "trace not found", http.StatusNotFound)
+			return
+		}
+		body := struct {
+			TraceID string       `json:"trace_id"`
+			Spans   []*traceSpan `json:"spans"`
+		}{id.String(), spanTree(spans, time.Now())}
+		w.Header().Set("Cache-Control", "no-store")
+		if wantsHTML(r) {
+			renderTraceHTML(w, "trace", body)
+			return
+		}
+		_ = WriteJSON(w, http.StatusOK, body)
+	})
+	return mux
+}
+
+// summarizeTraces groups the traces of spans by the name of their root
+// span, which TracingMiddleware names after the route.
+func summarizeTraces(spans []bufferedSpan, now time.Time) []routeTraces {
+	byTrace := map[trace.TraceID][]bufferedSpan{}
+	for _, s := range spans {
+		id := s.SpanContext().TraceID()
+		byTrace[id] = append(byTrace[id], s)
+	}
+	byRoute := map[string][]traceSummary{}
+	for id, spans := range byTrace {
+		roots := rootSpans(spans)
+		root := roots[0]
+		sum := traceSummary{
+			TraceID:  id.String(),
+			Name:     root.Name(),
+			Start:    root.StartTime(),
+			Duration: spanDuration(root, now),
+			Status:   root.Status().Code.String(),
+			Spans:    len(spans),
+			Active:   root.active,
+		}
+		for _, kv := range root.Attributes() {
+			if kv.Key == "http.response.status_code" {
+				sum.HTTPStatus = kv.Value.AsInt64()
+			}
+		}
+		for _, s := range spans {
+			if s.Status().Code == codes.Error {
+				sum.Errors++
+			}
+		}
+		byRoute[root.Name()] = append(byRoute[root.Name()], sum)
+	}
+
+	routes := make([]routeTraces, 0, len(byRoute))
+	for route, traces := range byRoute {
+		slices.SortFunc(traces, func(a, b traceSummary) int { return b.Start.Compare(a.Start) })
+		routes = append(routes, routeTraces{Route: route, Traces: traces})
+	}
+	slices.SortFunc(routes, func(a, b routeTraces) int { return strings.Compare(a.Route, b.Route) })
+	return routes
+}
+
+// rootSpans returns the spans whose parent is not among spans, earliest
+// first. There is at least one, as spans can't all be each other's parents.
+func rootSpans(spans []bufferedSpan) []bufferedSpan {
+	ids := make(map[trace.SpanID]bool, len(spans))
+	for _, s := range spans {
+		ids[s.SpanContext().SpanID()] = true
+	}
+	var roots []bufferedSpan
+	for _, s := range spans {
+		if !ids[s.Parent().SpanID()] {
+			roots = append(roots, s)
+		}
+	}
+	slices.SortFunc(roots, func(a, b bufferedSpan) int { return a.StartTime().Compare(b.StartTime()) })
+	return roots
+}
+
+// spanTree returns the spans of a trace as trees under their roots, the
+// children of each span in the order they started.
+func spanTree(spans []bufferedSpan, now time.Time) []*traceSpan {
+	nodes := make(map[trace.SpanID]*traceSpan, len(spans))
+	for _, s := range spans {
+		node := &traceSpan{
+			SpanID:      s.SpanContext().SpanID().String(),
+			Name:        s.Name(),
+			Kind:        s.SpanKind().String(),
+			Start:       s.StartTime(),
+			Duration:    spanDuration(s, now),
+			Status:      s.Status().Code.String(),
+			Description: s.Status().Description,
+			Active:      s.active,
+			Attributes:  spanAttributes(s.Attributes()),
+		}
+		for _, e := range s.Events() {
+			node.Events = append(node.Events, traceEvent{Name: e.Name, Time: e.Time, Attributes: spanAttributes(e.Attributes)})
+		}
+		nodes[s.SpanContext().SpanID()] = node
+	}
+	var roots []*traceSpan
+	for _, s := range spans {
+		node := nodes[s.SpanContext().SpanID()]
+		if parent, ok := nodes[s.Parent().SpanID()]; ok {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	byStart := func(a, b *traceSpan) int {
+		return cmp.Or(a.Start.Compare(b.Start), strings.Compare(a.SpanID, b.SpanID))
+	}
+	for _, node := range nodes {
+		slices.SortFunc(node.Children, byStart)
+	}
+	slices.SortFunc(roots, byStart)
+	return roots
+}
+
+// spanDuration returns how long s took, or has been running for.
+func spanDuration(s bufferedSpan, now time.Time) time.Duration {
+	if s.active {
+		return now.Sub(s.StartTime())
+	}
+	return s.EndTime().Sub(s.StartTime())
+}
+
+func spanAttributes(kvs []attribute.KeyValue) map[string]any {
+	if len(kvs) == 0 {
+		return nil
+	}
+	attrs := make(map[string]any, len(kvs))
+	for _, kv := range kvs {
+		attrs[string(kv.Key)] = kv.Value.AsInterface()
+	}
+	return attrs
+}
+
+// wantsHTML reports whether r asks for HTML, with ?format=html or, like
+// browsers do, in its Accept header.
+func wantsHTML(r *http.Request) bool {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f == "html"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func renderTraceHTML(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = traceTemplates.ExecuteTemplate(w, name, data)
+}
+
+var traceTemplates = template.Must(template.New("").Parse(`
+{{define "list"}}<!DOCTYPE html>
+<title>Recent traces</title>
+{{range .}}<h2>{{.Route}}</h2>
+<table>
+<tr><th>Trace</th><th>Start</th><th>Duration</th><th>Status</th><th>Spans</th></tr>
+{{range .Traces}}<tr><td><a href="/debug/traces/{{.TraceID}}?format=html">{{.TraceID}}</a></td><td>{{.Start.Format "15:04:05.000"}}</td><td>{{.Duration}}{{if .Active}} (running){{end}}</td><td>{{.Status}}{{if .HTTPStatus}} {{.HTTPStatus}}{{end}}</td><td>{{.Spans}}{{if .Errors}}, {{.Errors}} failed{{end}}</td></tr>
+{{end}}</table>
+{{else}}<p>No traces recorded yet.</p>
+{{end}}{{end}}
+{{define "trace"}}<!DOCTYPE html>
+<title>Trace {{.TraceID}}</title>
+<h2>Trace {{.TraceID}}</h2>
+<ul>{{range .Spans}}{{template "span" .}}{{end}}</ul>
+<p><a href="/debug/traces?format=html">All traces</a></p>
+{{end}}
+{{define "span"}}<li><b>{{.Name}}</b> {{.Kind}} {{.Duration}}{{if .Active}} (running){{end}} {{.Status}}{{with .Description}}: {{.}}{{end}}
+{{if .Attributes}}<ul>{{range $k, $v := .Attributes}}<li>{{$k}} = {{$v}}</li>{{end}}</ul>{{end}}
+{{if .Events}}<ul>{{range .Events}}<li>event {{.Name}} at {{.Time.Format "15:04:05.000"}}</li>{{end}}</ul>{{end}}
+{{if .Children}}<ul>{{range .Children}}{{template "span" .}}{{end}}</ul>{{end}}</li>
+{{end}}`))
@@ -0,0 +1,166 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceBuffer(t *testing.T) {
+	ctx := context.Background()
+	buf := NewTraceBuffer(100)
+	tel, err := NewTelemetry(ctx, "acai-test",
+		WithMetricReader(sdkmetric.NewManualReader()),
+		WithSpanExporter(tracetest.NewInMemoryExporter()),
+		WithTraceBuffer(buf),
+	)
+	if err != nil {
+		t.Fatalf("NewTelemetry() unexpected error: %v", err)
+	}
+	defer func() { _ = tel.Shutdown(ctx) }()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /trips/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := tel.Tracer().Start(r.Context(), "load trip")
+		span.End()
+	})
+	mux.HandleFunc("POST /trips", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	app := NewTracingMiddleware(tel)(mux)
+	serve := func(method, path string) string {
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Header().Get(TraceIDHeader)
+	}
+	traceID := serve(http.MethodGet, "/trips/1")
+	serve(http.MethodGet, "/trips/2")
+	failedID := serve(http.MethodPost, "/trips")
+	_, running := tel.Tracer().Start(ctx, "GET /trips/{id}")
+	defer running.End()
+
+	admin := AdminServer("", WithAdminTraces(buf)).Handler()
+	get := func(target string, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/debug/traces/"+traceID, "application/json")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET trace: status %d, body %s", rec.Code, rec.Body)
+	}
+	var detail struct {
+		TraceID string       `json:"trace_id"`
+		Spans   []*traceSpan `json:"spans"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil {
+		t.Fatal(err)
+	}
+	if detail.TraceID != traceID || len(detail.Spans) != 1 {
+		t.Fatalf("trace %s with roots %+v, want %s with one root", detail.TraceID, detail.Spans, traceID)
+	}
+	root := detail.Spans[0]
+	if root.Name != "GET /trips/{id}" || root.Kind != "server" || root.Attributes["http.route"] != "/trips/{id}" {
+		t.Errorf("root span %+v, want the server span of GET /trips/{id}", root)
+	}
+	if len(root.Children) != 1 || root.Children[0].Name != "load trip" {
+		t.Errorf("root span children %+v, want load trip", root.Children)
+	}
+
+	rec = get("/debug/traces", "")
+	var list struct {
+		Routes []routeTraces `json:"routes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Routes) != 2 {
+		t.Fatalf("routes %+v, want GET /trips/{id} and POST /trips", list.Routes)
+	}
+	trips, failed := list.Routes[0], list.Routes[1]
+	if trips.Route != "GET /trips/{id}" || len(trips.Traces) != 3 || !trips.Traces[0].Active {
+		t.Errorf("GET /trips/{id} traces %+v, want 3, the running one first", trips.Traces)
+	}
+	for _, tr := range trips.Traces[1:] {
+		if tr.Spans != 2 || tr.HTTPStatus != http.StatusOK || tr.Active {
+			t.Errorf("finished trace %+v, want 2 spans and status 200", tr)
+		}
+	}
+	if failed.Route != "POST /trips" || len(failed.Traces) != 1 {
+		t.Fatalf("POST /trips traces %+v, want one", failed.Traces)
+	}
+	if tr := failed.Traces[0]; tr.TraceID != failedID || tr.Status != "Error" || tr.HTTPStatus != 500 || tr.Errors != 1 {
+		t.Errorf("failed trace %+v, want %s with an Error status", tr, failedID)
+	}
+
+	// Browsers get HTML.
+	rec = get("/debug/traces", "text/html,application/xhtml+xml")
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") || !strings.Contains(rec.Body.String(), failedID) {
+		t.Errorf("HTML list: %s %s", ct, rec.Body)
+	}
+	rec = get("/debug/traces/"+traceID+"?format=html", "")
+	if !strings.Contains(rec.Body.String(), "load trip") {
+		t.Errorf("HTML trace without its spans: %s", rec.Body)
+	}
+
+	if rec := get("/debug/traces/"+trace.TraceID{1}.String(), ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown trace: status %d, want 404", rec.Code)
+	}
+	if rec := get("/debug/traces/nope", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid trace ID: status %d, want 400", rec.Code)
+	}
+}
+
+func TestTraceBuffer_Bounded(t *testing.T) {
+	buf := NewTraceBuffer(5)
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(buf))
+	tracer := tp.Tracer("test")
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, span := tracer.Start(context.Background(), fmt.Sprintf("span %d", i))
+			span.End()
+		}()
+	}
+	wg.Wait()
+	_, last := tracer.Start(context.Background(), "last")
+	last.End()
+	for range 10 {
+		tracer.Start(context.Background(), "running")
+	}
+
+	spans := buf.spans(trace.TraceID{})
+	var finished, active int
+	sawLast := false
+	for _, s := range spans {
+		if s.active {
+			active++
+			continue
+		}
+		finished++
+		sawLast = sawLast || s.Name() == "last"
+	}
+	if finished != 5 || active != 5 {
+		t.Errorf("kept %d finished and %d running spans, want 5 of each", finished, active)
+	}
+	if !sawLast {
+		t.Error("the latest span was not kept")
+	}
+}
@@ -0,0 +1,157 @@
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Response headers carrying the trace ID of sampled requests.
+const (
+	TraceIDHeader       = "X-Trace-Id"
+	TraceResponseHeader = "traceresponse"
+)
+
+// TracingMiddleware starts a server span per request, named "METHOD route"
+// once the route is known. The span continues the trace of the caller when
+// the request carries a valid traceparent header; otherwise it starts a new
+// trace. Responses with a 5xx status mark the span as failed, unless the
+// client disconnected first: the span gets a cancellation event instead.
+// Failed requests get the same error.class attribute as in the metrics.
+// Sampled requests get their trace ID back in the X-Trace-Id and
+// traceresponse headers.
+func TracingMiddleware(next http.Handler) http.Handler {
+	return NewTracingMiddleware(nil)(next)
+}
+
+// NewTracingMiddleware returns TracingMiddleware tracing through the
+// providers of t, or the global ones when t is nil.
+func NewTracingMiddleware(t *Telemetry) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return tracingHandler(t, next)
+	}
+}
+
+func tracingHandler(t *Telemetry, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := t.propagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := t.tracer().Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.UserAgentOriginal(r.UserAgent()),
+				semconv.ClientAddress(ClientIP(r)),
+			),
+			trace.WithAttributes(originAttributes(ExternalURL(r))...),
+		)
+
+		setTraceHeaders(w.Header(), span.SpanContext())
+		sw, w := captureStatus(w)
+		sw.watchContext(ctx)
+		r, st := withRequestState(r.WithContext(ctx))
+		st.mu.Lock()
+		st.spanContext = span.SpanContext()
+		requestID := st.requestID
+		st.mu.Unlock()
+		if requestID != "" {
+			span.SetAttributes(requestIDKey.String(requestID))
+		}
+
+		// Record a panic on the span and end it, then let the panic continue to
+		// the recovery middleware.
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			v := recover()
+			if v != nil && v != http.ErrAbortHandler {
+				st.notePanic(v)
+			}
+			finishServerSpan(span, r, st, sw)
+			if v != nil {
+				panic(v)
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+		completed = true
+		finishServerSpan(span, r, st, sw)
+	})
+}
+
+// TraceHeaderMiddleware sets the X-Trace-Id and traceresponse headers for
+// spans started by other instrumentation, such as otelhttp. It must run
+// inside the handler starting the span.
+func TraceHeaderMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setTraceHeaders(w.Header(), trace.SpanContextFromContext(r.Context()))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setTraceHeaders exposes the trace of sc when it is sampled; the ID of an
+// unsampled trace leads nowhere.
+func setTraceHeaders(h http.Header, sc trace.SpanContext) {
+	if !sc.IsSampled() {
+		return
+	}
+	h.Set(TraceIDHeader, sc.TraceID().String())
+	h.Set(TraceResponseHeader, "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-"+sc.TraceFlags().String())
+}
+
+// isTemplateRoute reports whether route names an actual route template
+// rather than one of the fixed values for unrouted requests.
+func isTemplateRoute(route string) bool {
+	switch route {
+	case UnmatchedRoute, NotFoundRoute, MethodNotAllowedRoute:
+		return false
+	}
+	return true
+}
+
+// finishServerSpan sets the attributes and status known once the handler is
+// done and ends the span.
+func finishServerSpan(span trace.Span, r *http.Request, st *requestState, sw *statusCapturingWriter) {
+	st.notePattern(r)
+
+	status := sw.status
+	v, _, panicked := st.panicInfo()
+	if panicked {
+		if !sw.wroteHeader {
+			status = http.StatusInternalServerError
+		}
+		span.RecordError(panicError(v))
+		span.SetStatus(codes.Error, "panic: "+fmt.Sprint(v))
+		st.mu.Lock()
+		span.SetAttributes(attribute.String(errorFingerprintKey, st.fingerprint))
+		st.mu.Unlock()
+	}
+
+	if route := PatternRoute(r); isTemplateRoute(route) {
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(semconv.HTTPRoute(route))
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if class, ok := st.errorClassFor(panicked); ok {
+		span.SetAttributes(attribute.String("error.class", string(class)))
+	}
+	st.mu.Lock()
+	streaming := st.streaming
+	st.mu.Unlock()
+	switch {
+	case panicked:
+	case !sw.hijacked && !streaming && responseCanceled(r, sw):
+		span.AddEvent("http.request.canceled", trace.WithAttributes(
+			semconv.ErrorTypeKey.String(canceledErrorType)))
+	case status >= 500:
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
@@ -0,0 +1,220 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /trips/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") == "broken" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		wantName string
+		wantCode codes.Code
+	}{
+		{name: "successful request", path: "/trips/42", wantName: "GET /trips/{id}", wantCode: codes.Unset},
+		{name: "server error", path: "/trips/broken", wantName: "GET /trips/{id}", wantCode: codes.Error},
+		{name: "unmatched route", path: "/nope", wantName: "GET", wantCode: codes.Unset},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := otelt.InstallTracing(t)
+
+			TracingMiddleware(mux).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			spans := exp.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("expected 1 span, got %d", len(spans))
+			}
+			span := spans[0]
+			if span.Name != tt.wantName {
+				t.Errorf("span name mismatch: got %q, want %q", span.Name, tt.wantName)
+			}
+			if span.SpanKind != trace.SpanKindServer {
+				t.Errorf("span kind mismatch: got %v, want %v", span.SpanKind, trace.SpanKindServer)
+			}
+			if span.Status.Code != tt.wantCode {
+				t.Errorf("span status mismatch: got %v, want %v", span.Status.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestTracingMiddleware_RouteVisibleToOuterMetrics(t *testing.T) {
+	exp := otelt.InstallTracing(t)
+	reader := otelt.InstallMetrics(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /trips/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	MetricsMiddleware(TracingMiddleware(mux)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/trips/42", nil))
+
+	if spans := exp.GetSpans(); len(spans) != 1 || spans[0].Name != "GET /trips/{id}" {
+		t.Errorf("span mismatch: got %v, want a single span named %q", spans, "GET /trips/{id}")
+	}
+	dps := collectSum(t, reader, "http.server.requests")
+	if len(dps) != 1 {
+		t.Fatalf("expected 1 series, got %d", len(dps))
+	}
+	if route, _ := dps[0].Attributes.Value("http.route"); route.AsString() != "/trips/{id}" {
+		t.Errorf("http.route mismatch: got %q, want %q", route.AsString(), "/trips/{id}")
+	}
+}
+
+func TestTracingMiddleware_SharesWriterWithMetrics(t *testing.T) {
+	otelt.InstallTracing(t)
+	reader := otelt.InstallMetrics(t)
+
+	var got http.ResponseWriter
+	handler := TracingMiddleware(MetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = w
+		w.WriteHeader(http.StatusCreated)
+	})))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+
+	cw, ok := got.(capturingWriter)
+	if !ok {
+		t.Fatalf("handler writer mismatch: got %T, want a capturing writer", got)
+	}
+	if _, nested := cw.capturing().ResponseWriter.(capturingWriter); nested {
+		t.Error("response writer was wrapped twice")
+	}
+
+	dps := collectSum(t, reader, "http.server.requests")
+	if len(dps) != 1 {
+		t.Fatalf("expected 1 series, got %d", len(dps))
+	}
+	if status, _ := dps[0].Attributes.Value("http.status_code"); status.AsInt64() != http.StatusCreated {
+		t.Errorf("http.status_code mismatch: got %d, want %d", status.AsInt64(), http.StatusCreated)
+	}
+}
+
+func TestTracingMiddleware_ContinuesIncomingTrace(t *testing.T) {
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+
+	tests := []struct {
+		name        string
+		traceparent string
+		wantParent  bool
+	}{
+		{name: "valid traceparent", traceparent: "00-" + traceID + "-" + parentID + "-01", wantParent: true},
+		{name: "malformed traceparent", traceparent: "00-not-a-trace-01"},
+		{name: "no traceparent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := otelt.InstallTracing(t)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			TracingMiddleware(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := exp.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("expected 1 span, got %d", len(spans))
+			}
+			span := spans[0]
+			if !span.SpanContext.IsValid() {
+				t.Fatal("span context is not valid")
+			}
+
+			if !tt.wantParent {
+				if span.Parent.IsValid() {
+					t.Errorf("expected a root span, got parent %v", span.Parent)
+				}
+				return
+			}
+			if got := span.SpanContext.TraceID().String(); got != traceID {
+				t.Errorf("trace ID mismatch: got %s, want %s", got, traceID)
+			}
+			if got := span.Parent.SpanID().String(); got != parentID {
+				t.Errorf("parent span ID mismatch: got %s, want %s", got, parentID)
+			}
+			if !span.Parent.IsRemote() {
+				t.Error("expected parent to be remote")
+			}
+		})
+	}
+}
+
+func TestTracingMiddleware_TraceHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		sampler sdktrace.Sampler
+		want    bool
+	}{
+		{name: "sampled", sampler: sdktrace.AlwaysSample(), want: true},
+		{name: "unsampled", sampler: sdktrace.NeverSample()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := otelt.InstallTracing(t, sdktrace.WithSampler(tt.sampler))
+
+			var seenByHandler string
+			handler := TracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seenByHandler = w.Header().Get(TraceIDHeader)
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if !tt.want {
+				if got := rec.Header().Get(TraceIDHeader); got != "" {
+					t.Errorf("unexpected %s %q", TraceIDHeader, got)
+				}
+				if got := rec.Header().Get(TraceResponseHeader); got != "" {
+					t.Errorf("unexpected %s %q", TraceResponseHeader, got)
+				}
+				return
+			}
+
+			spans := exp.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("expected 1 span, got %d", len(spans))
+			}
+			sc := spans[0].SpanContext
+			if got := rec.Header().Get(TraceIDHeader); got != sc.TraceID().String() {
+				t.Errorf("%s mismatch: got %q, want %q", TraceIDHeader, got, sc.TraceID())
+			}
+			want := "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-01"
+			if got := rec.Header().Get(TraceResponseHeader); got != want {
+				t.Errorf("%s mismatch: got %q, want %q", TraceResponseHeader, got, want)
+			}
+			if seenByHandler == "" {
+				t.Error("header was not set before the handler ran")
+			}
+		})
+	}
+}
+
+func TestTraceHeaderMiddleware(t *testing.T) {
+	otelt.InstallTracing(t)
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "otelhttp")
+	defer span.End()
+	rec := httptest.NewRecorder()
+	TraceHeaderMiddleware(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+	if got := rec.Header().Get(TraceIDHeader); got != span.SpanContext().TraceID().String() {
+		t.Errorf("%s mismatch: got %q", TraceIDHeader, got)
+	}
+}
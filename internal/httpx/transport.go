@@ -0,0 +1,272 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// clientMetrics holds the instruments recorded by Transport, bound to the
+// meter provider they were created from.
+type clientMetrics struct {
+	provider  metric.MeterProvider
+	requests  metric.Int64Counter
+	errors    metric.Int64Counter
+	duration  metric.Float64Histogram
+	retries   metric.Int64Counter
+	hedges    metric.Int64Counter
+	hedgeWins metric.Int64Counter
+	active    metric.Int64UpDownCounter
+
+	connsOpened metric.Int64Counter
+	connsClosed metric.Int64Counter
+	connWait    metric.Float64Histogram
+
+	breakerTransitions metric.Int64Counter
+	breakerState       metric.Int64Gauge
+
+	throttleWait     metric.Float64Histogram
+	throttleRejected metric.Int64Counter
+}
+
+var currentClientMetrics atomic.Pointer[clientMetrics]
+
+func newClientMetrics(mp metric.MeterProvider) (*clientMetrics, error) {
+	m := mp.Meter(instrumentationName)
+	cm := &clientMetrics{provider: mp}
+
+	var err, errs error
+	cm.requests, err = m.Int64Counter("http.client.requests",
+		metric.WithDescription("Total number of outbound HTTP requests"))
+	errs = errors.Join(errs, err)
+	cm.errors, err = m.Int64Counter("http.client.errors",
+		metric.WithDescription("Total number of outbound HTTP requests that failed without a response"))
+	errs = errors.Join(errs, err)
+	cm.duration, err = m.Float64Histogram("http.client.duration",
+		metric.WithDescription("Time until the response headers of outbound HTTP requests were received"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(latencyBuckets...))
+	errs = errors.Join(errs, err)
+	cm.retries, err = m.Int64Counter("http.client.retries",
+		metric.WithDescription("Total number of outbound HTTP request retries"))
+	errs = errors.Join(errs, err)
+	cm.hedges, err = m.Int64Counter("http.client.hedges",
+		metric.WithDescription("Total number of hedged outbound HTTP requests sent"))
+	errs = errors.Join(errs, err)
+	cm.hedgeWins, err = m.Int64Counter("http.client.hedge.wins",
+		metric.WithDescription("Total number of hedged outbound HTTP requests answered before the original"))
+	errs = errors.Join(errs, err)
+	cm.active, err = m.Int64UpDownCounter("http.client.active_requests",
+		metric.WithDescription("Number of outbound HTTP requests in flight, by host"))
+	errs = errors.Join(errs, err)
+	cm.connsOpened, err = m.Int64Counter("http.client.connections.opened",
+		metric.WithDescription("Total number of outbound connections opened, by host"))
+	errs = errors.Join(errs, err)
+	cm.connsClosed, err = m.Int64Counter("http.client.connections.closed",
+		metric.WithDescription("Total number of outbound connections closed, by host"))
+	errs = errors.Join(errs, err)
+	cm.connWait, err = m.Float64Histogram("http.client.connection.wait",
+		metric.WithDescription("Time outbound HTTP requests waited for a pooled or new connection, by host"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(latencyBuckets...))
+	errs = errors.Join(errs, err)
+	cm.breakerTransitions, err = m.Int64Counter("http.client.breaker.transitions",
+		metric.WithDescription("Total number of circuit breaker state changes"))
+	errs = errors.Join(errs, err)
+	cm.breakerState, err = m.Int64Gauge("http.client.breaker.state",
+		metric.WithDescription("Circuit breaker state per host: 0 closed, 1 open, 2 half open"))
+	errs = errors.Join(errs, err)
+	cm.throttleWait, err = m.Float64Histogram("http.client.throttle.wait",
+		metric.WithDescription("Time outbound HTTP requests waited for the rate limit of their host"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(latencyBuckets...))
+	errs = errors.Join(errs, err)
+	cm.throttleRejected, err = m.Int64Counter("http.client.throttle.rejected",
+		metric.WithDescription("Total number of outbound HTTP requests failed for the rate limit of their host"))
+	errs = errors.Join(errs, err)
+
+	if errs != nil {
+		return cm, fmt.Errorf("create HTTP client instruments: %w", errs)
+	}
+	return cm, nil
+}
+
+// loadClientMetrics is loadServerMetrics for the client instruments.
+func loadClientMetrics() *clientMetrics {
+	mp := otel.GetMeterProvider()
+	if cm := currentClientMetrics.Load(); cm != nil && cm.provider == mp {
+		return cm
+	}
+
+	cm, err := newClientMetrics(mp)
+	if err != nil {
+		slog.Error("HTTP client metrics may not be recorded", "error", err)
+	}
+	currentClientMetrics.Store(cm)
+	return cm
+}
+
+// Transport is an http.RoundTripper recording client metrics and spans for
+// every outbound request, and propagating the trace context downstream. The
+// time left before the context deadline is sent, in X-Request-Timeout, to
+// the hosts named with WithTimeoutPropagation only.
+type Transport struct {
+	base        http.RoundTripper
+	connTrace   bool
+	poolMetrics bool
+	http2       bool
+	budgetHosts map[string]bool
+}
+
+// TransportOption configures NewTransport.
+type TransportOption func(*Transport)
+
+// WithTimeoutPropagation sends the time left before the context deadline,
+// in X-Request-Timeout, to the given hosts, matched against the request URL
+// without its port. Meant for internal services running TimeoutMiddleware
+// with WithPropagatedTimeouts; third parties would only learn how long we
+// wait for them.
+func WithTimeoutPropagation(hosts ...string) TransportOption {
+	return func(t *Transport) {
+		if t.budgetHosts == nil {
+			t.budgetHosts = make(map[string]bool, len(hosts))
+		}
+		for _, host := range hosts {
+			t.budgetHosts[host] = true
+		}
+	}
+}
+
+// NewTransport instruments base. A nil base means http.DefaultTransport.
+func NewTransport(base http.RoundTripper, opts ...TransportOption) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &Transport{base: base}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.http2 {
+		t.base = forceHTTP2(t.base)
+	}
+	if t.poolMetrics {
+		t.base = countConnections(t.base)
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper. Responses of any status are
+// successful round trips; only requests that got no response count as
+// errors.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	cm := loadClientMetrics()
+	// Hosts are bounded by construction: only a handful of APIs are called.
+	hostAttrs := metric.WithAttributeSet(attribute.NewSet(attribute.String("net.peer.name", req.URL.Hostname())))
+	// A request is in flight until its response body is closed.
+	cm.active.Add(req.Context(), 1, hostAttrs)
+	done := sync.OnceFunc(func() { cm.active.Add(context.WithoutCancel(req.Context()), -1, hostAttrs) })
+
+	ctx, span := Tracer().Start(req.Context(), req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLFull(redactedURL(req)),
+			semconv.ServerAddress(req.URL.Hostname()),
+		),
+	)
+	defer span.End()
+	if t.connTrace {
+		ctx = httptrace.WithClientTrace(ctx, newConnTrace(ctx, span))
+	}
+	if t.poolMetrics {
+		ctx = httptrace.WithClientTrace(ctx, newPoolTrace(ctx, cm, hostAttrs))
+	}
+
+	// A RoundTripper must not modify the caller's request.
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if deadline, ok := ctx.Deadline(); ok && t.budgetHosts[req.URL.Hostname()] && req.Header.Get(RequestTimeoutHeader) == "" {
+		// Tell the server when we'll stop waiting, so it can stop too.
+		if remaining := time.Until(deadline).Round(time.Millisecond); remaining > 0 {
+			req.Header.Set(RequestTimeoutHeader, remaining.String())
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+
+	attrs := []attribute.KeyValue{
+		attribute.String("http.method", req.Method),
+		attribute.String("net.peer.name", req.URL.Hostname()),
+	}
+	if err != nil {
+		attrs = append(attrs, semconv.ErrorTypeKey.String(clientErrorType(ctx, err)))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		cm.errors.Add(ctx, 1, metric.WithAttributes(attrs...))
+	} else {
+		attrs = append(attrs, attribute.Int("http.status_code", resp.StatusCode))
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+		if resp.StatusCode >= 400 {
+			span.SetStatus(codes.Error, "")
+		}
+	}
+
+	cm.requests.Add(ctx, 1, metric.WithAttributes(attrs...))
+	cm.duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attrs...))
+	if err != nil {
+		done()
+	} else if _, upgraded := resp.Body.(io.Writer); upgraded {
+		// The connection of a 101 is the caller's to keep.
+		done()
+	} else {
+		resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: done}
+	}
+	return resp, err
+}
+
+// CloseIdleConnections closes the idle connections of the underlying
+// transport, for http.Client.CloseIdleConnections.
+func (t *Transport) CloseIdleConnections() {
+	if c, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// clientErrorType classifies a failed round trip for the error.type
+// attribute.
+func clientErrorType(ctx context.Context, err error) string {
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "transport"
+	}
+}
+
+// redactedURL returns the request URL without credentials or query string,
+// which often carry API keys for supplier APIs.
+func redactedURL(req *http.Request) string {
+	u := *req.URL
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
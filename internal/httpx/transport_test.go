@@ -0,0 +1,120 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTransport(t *testing.T) {
+	var gotTraceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTraceparent = r.Header.Get("traceparent")
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	host := "127.0.0.1"
+
+	tests := []struct {
+		name      string
+		path      string
+		cancel    bool
+		wantCode  int
+		wantError string
+	}{
+		{name: "success", path: "/ok", wantCode: http.StatusOK},
+		{name: "non-2xx is not a transport error", path: "/missing", wantCode: http.StatusNotFound},
+		{name: "canceled", path: "/ok", cancel: true, wantError: "canceled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := otelt.InstallMetrics(t)
+			exp := otelt.InstallTracing(t)
+			gotTraceparent = ""
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.cancel {
+				cancel()
+			} else {
+				defer cancel()
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+tt.path+"?key=secret", nil)
+			if err != nil {
+				t.Fatalf("NewRequest() unexpected error: %v", err)
+			}
+
+			client := &http.Client{Transport: NewTransport(nil)}
+			resp, err := client.Do(req)
+			if tt.wantError == "" {
+				if err != nil {
+					t.Fatalf("Do() unexpected error: %v", err)
+				}
+				_ = resp.Body.Close()
+			} else if err == nil || !errors.Is(err, context.Canceled) {
+				t.Fatalf("Do() expected context.Canceled, got %v", err)
+			}
+			if req.Header.Get("traceparent") != "" {
+				t.Error("caller's request was modified")
+			}
+
+			spans := exp.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("expected 1 span, got %d", len(spans))
+			}
+			span := spans[0]
+			if span.SpanKind != trace.SpanKindClient {
+				t.Errorf("span kind mismatch: got %v, want %v", span.SpanKind, trace.SpanKindClient)
+			}
+			for _, kv := range span.Attributes {
+				if kv.Key == "url.full" {
+					if u, _ := url.Parse(kv.Value.AsString()); u.RawQuery != "" {
+						t.Errorf("url.full leaks the query string: %q", kv.Value.AsString())
+					}
+				}
+			}
+
+			dps := collectSum(t, reader, "http.client.requests")
+			if len(dps) != 1 || dps[0].Value != 1 {
+				t.Fatalf("request data points mismatch: got %+v, want a single point with value 1", dps)
+			}
+			attrs := dps[0].Attributes
+			if peer, _ := attrs.Value("net.peer.name"); peer.AsString() != host {
+				t.Errorf("net.peer.name mismatch: got %q, want %q", peer.AsString(), host)
+			}
+			errs := collectSum(t, reader, "http.client.errors")
+
+			if tt.wantError != "" {
+				if errType, _ := attrs.Value("error.type"); errType.AsString() != tt.wantError {
+					t.Errorf("error.type mismatch: got %q, want %q", errType.AsString(), tt.wantError)
+				}
+				if len(errs) != 1 {
+					t.Errorf("expected 1 error series, got %d", len(errs))
+				}
+				return
+			}
+
+			if status, _ := attrs.Value("http.status_code"); status.AsInt64() != int64(tt.wantCode) {
+				t.Errorf("http.status_code mismatch: got %d, want %d", status.AsInt64(), tt.wantCode)
+			}
+			if len(errs) != 0 {
+				t.Errorf("expected no error series, got %+v", errs)
+			}
+			want := "00-" + span.SpanContext.TraceID().String() + "-" + span.SpanContext.SpanID().String() + "-01"
+			if gotTraceparent != want {
+				t.Errorf("traceparent mismatch: got %q, want %q", gotTraceparent, want)
+			}
+			if hs := collectHistogram(t, reader, "http.client.duration"); len(hs) != 1 || hs[0].Count != 1 {
+				t.Errorf("duration data points mismatch: got %+v", hs)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package httpx
+
+import (
+	"slices"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// viewSpec collects the view options given for one instrument, so they end
+// up in a single view: the SDK turns every matching view into a separate
+// stream.
+type viewSpec struct {
+	instrument  string
+	aggregation sdkmetric.Aggregation
+	dropped     []attribute.Key
+	rename      string
+}
+
+// WithHistogramBuckets sets the bucket boundaries of the named histogram,
+// replacing the ones it was created with.
+func WithHistogramBuckets(instrument string, boundaries ...float64) TelemetryOption {
+	return func(c *telemetryConfig) {
+		c.view(instrument).aggregation = sdkmetric.AggregationExplicitBucketHistogram{
+			Boundaries: slices.Sorted(slices.Values(boundaries)),
+		}
+	}
+}
+
+// WithExponentialLatency aggregates http.server.request.duration into a
+// base-2 exponential histogram of at most maxSize buckets (160 when zero),
+// whose resolution adapts to the latencies actually seen. InitPrometheus
+// ignores it and keeps the explicit buckets: Prometheus would expose it as a
+// native histogram, leaving text scrapes with its count and sum only.
+func WithExponentialLatency(maxSize int) TelemetryOption {
+	if maxSize <= 0 {
+		maxSize = 160
+	}
+	return func(c *telemetryConfig) {
+		c.view("http.server.request.duration").aggregation = sdkmetric.AggregationBase2ExponentialHistogram{
+			MaxSize:  int32(maxSize),
+			MaxScale: 20,
+		}
+	}
+}
+
+// WithDroppedAttributes removes the given attribute keys from the named
+// instrument, merging the series that only differed by them.
+func WithDroppedAttributes(instrument string, keys ...attribute.Key) TelemetryOption {
+	return func(c *telemetryConfig) {
+		v := c.view(instrument)
+		v.dropped = append(v.dropped, keys...)
+	}
+}
+
+// WithRenamedInstrument exports the instrument named old as new.
+func WithRenamedInstrument(old, new string) TelemetryOption {
+	return func(c *telemetryConfig) { c.view(old).rename = new }
+}
+
+func (c *telemetryConfig) view(instrument string) *viewSpec {
+	for _, v := range c.views {
+		if v.instrument == instrument {
+			return v
+		}
+	}
+	v := &viewSpec{instrument: instrument}
+	c.views = append(c.views, v)
+	return v
+}
+
+// metricViews returns the views to install on the meter provider, whatever
+// reader it exports through. Exponential aggregations are left out for
+// readers needing explicit buckets.
+func (c *telemetryConfig) metricViews() []sdkmetric.View {
+	views := make([]sdkmetric.View, 0, len(c.views))
+	for _, v := range c.views {
+		var mask sdkmetric.Stream
+		mask.Name = v.rename
+		mask.Aggregation = v.aggregation
+		if _, ok := v.aggregation.(sdkmetric.AggregationBase2ExponentialHistogram); ok && c.explicitHists {
+			mask.Aggregation = nil
+		}
+		if len(v.dropped) > 0 {
+			mask.AttributeFilter = attribute.NewDenyKeysFilter(v.dropped...)
+		}
+		views = append(views, sdkmetric.NewView(sdkmetric.Instrument{Name: v.instrument}, mask))
+	}
+	return views
+}
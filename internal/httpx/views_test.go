@@ -0,0 +1,151 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestInitTelemetry_Views(t *testing.T) {
+	ctx := context.Background()
+	prevMP, prevTP := otel.GetMeterProvider(), otel.GetTracerProvider()
+	t.Cleanup(func() {
+		otel.SetMeterProvider(prevMP)
+		otel.SetTracerProvider(prevTP)
+	})
+
+	reader := sdkmetric.NewManualReader()
+	shutdown, err := InitTelemetry(ctx, "acai-test",
+		WithMetricReader(reader),
+		WithHistogramBuckets("http.server.request.duration", 1, 0.1),
+		WithDroppedAttributes("http.server.request.duration", "http.status_code"),
+		WithDroppedAttributes("http.server.requests", "http.status_code", "http.method"),
+		WithRenamedInstrument("http.server.errors", "http.server.failures"),
+	)
+	if err != nil {
+		t.Fatalf("InitTelemetry() unexpected error: %v", err)
+	}
+	defer func() { _ = shutdown(ctx) }()
+
+	handler := MetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("fail") {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?fail", nil))
+
+	t.Run("buckets and dropped attributes on one instrument", func(t *testing.T) {
+		m, ok := collectMetric(t, reader, "http.server.request.duration")
+		if !ok {
+			t.Fatal("duration histogram not collected")
+		}
+		dps := m.Data.(metricdata.Histogram[float64]).DataPoints
+		if len(dps) != 1 {
+			t.Fatalf("expected the status codes to merge into one series, got %d", len(dps))
+		}
+		if got := dps[0].Bounds; !slices.Equal(got, []float64{0.1, 1}) {
+			t.Errorf("bounds mismatch: got %v", got)
+		}
+		if _, ok := dps[0].Attributes.Value("http.status_code"); ok {
+			t.Error("http.status_code was not dropped")
+		}
+	})
+
+	t.Run("dropped attributes", func(t *testing.T) {
+		dps := collectSum(t, reader, "http.server.requests")
+		if len(dps) != 1 || dps[0].Value != 2 {
+			t.Fatalf("expected a single series counting both requests, got %+v", dps)
+		}
+		if _, ok := dps[0].Attributes.Value("http.route"); !ok {
+			t.Error("kept attribute http.route is missing")
+		}
+	})
+
+	t.Run("renamed instrument", func(t *testing.T) {
+		if _, ok := collectMetric(t, reader, "http.server.errors"); ok {
+			t.Error("instrument still exported under its old name")
+		}
+		if dps := collectSum(t, reader, "http.server.failures"); len(dps) != 1 || dps[0].Value != 1 {
+			t.Errorf("renamed instrument data points mismatch: %+v", dps)
+		}
+	})
+}
+
+func TestInitTelemetry_ExponentialLatency(t *testing.T) {
+	ctx := context.Background()
+	prevMP, prevTP := otel.GetMeterProvider(), otel.GetTracerProvider()
+	t.Cleanup(func() {
+		otel.SetMeterProvider(prevMP)
+		otel.SetTracerProvider(prevTP)
+	})
+
+	reader := sdkmetric.NewManualReader()
+	shutdown, err := InitTelemetry(ctx, "acai-test", WithMetricReader(reader), WithExponentialLatency(64))
+	if err != nil {
+		t.Fatalf("InitTelemetry() unexpected error: %v", err)
+	}
+	defer func() { _ = shutdown(ctx) }()
+
+	MetricsMiddleware(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	m, ok := collectMetric(t, reader, "http.server.request.duration")
+	if !ok {
+		t.Fatal("duration histogram not collected")
+	}
+	hist, ok := m.Data.(metricdata.ExponentialHistogram[float64])
+	if !ok {
+		t.Fatalf("expected an exponential histogram, got %T", m.Data)
+	}
+	if len(hist.DataPoints) != 1 || hist.DataPoints[0].Count != 1 {
+		t.Fatalf("unexpected data points: %+v", hist.DataPoints)
+	}
+	if got := len(hist.DataPoints[0].PositiveBucket.Counts); got > 64 {
+		t.Errorf("bucket count %d exceeds the max size", got)
+	}
+
+	if m, _ := collectMetric(t, reader, "http.server.duration.ms"); m.Data == nil {
+		t.Error("millisecond histogram not collected")
+	} else if _, ok := m.Data.(metricdata.Histogram[float64]); !ok {
+		t.Errorf("millisecond histogram should keep explicit buckets, got %T", m.Data)
+	}
+}
+
+func BenchmarkHistogramRecord(b *testing.B) {
+	aggregations := []struct {
+		name string
+		agg  sdkmetric.Aggregation
+	}{
+		{name: "explicit", agg: sdkmetric.AggregationExplicitBucketHistogram{Boundaries: latencyBuckets}},
+		{name: "exponential", agg: sdkmetric.AggregationBase2ExponentialHistogram{MaxSize: 160, MaxScale: 20}},
+	}
+
+	for _, a := range aggregations {
+		b.Run(a.name, func(b *testing.B) {
+			mp := sdkmetric.NewMeterProvider(
+				sdkmetric.WithReader(sdkmetric.NewManualReader()),
+				sdkmetric.WithView(sdkmetric.NewView(sdkmetric.Instrument{Name: "latency"}, sdkmetric.Stream{Aggregation: a.agg})),
+			)
+			defer func() { _ = mp.Shutdown(context.Background()) }()
+			hist, err := mp.Meter("bench").Float64Histogram("latency")
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			ctx := context.Background()
+			b.ReportAllocs()
+			i := 0
+			for b.Loop() {
+				// Latencies between 1ms and ~1s.
+				hist.Record(ctx, 0.001*float64(1+i%1000))
+				i++
+			}
+		})
+	}
+}
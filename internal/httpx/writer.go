@@ -0,0 +1,188 @@
+package httpx
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// statusCapturingWriter records the status and body size written by the
+// handler so middlewares can read them once the handler returns.
+type statusCapturingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	hijacked    bool
+	written     int64
+	firstByte   time.Time // when the handler first wrote or flushed anything
+
+	ctx         context.Context // the request's, once watchContext was called
+	lateStart   bool            // the response started after ctx was canceled
+	writeFailed bool
+}
+
+// watchContext makes w note whether the response begins after ctx was
+// canceled.
+func (w *statusCapturingWriter) watchContext(ctx context.Context) {
+	if w.ctx == nil {
+		w.ctx = ctx
+	}
+}
+
+// brokenOff reports whether the response can't have reached the client in
+// full: it never started, started after the request context was canceled,
+// or a write failed.
+func (w *statusCapturingWriter) brokenOff() bool {
+	return !w.wroteHeader || w.lateStart || w.writeFailed
+}
+
+// noteFirstByte records the time of the first write.
+func (w *statusCapturingWriter) noteFirstByte() {
+	if w.firstByte.IsZero() {
+		w.firstByte = time.Now()
+		w.lateStart = w.ctx != nil && w.ctx.Err() != nil
+	}
+}
+
+// timeToFirstByte returns how long after start the handler first wrote,
+// and false if it never did.
+func (w *statusCapturingWriter) timeToFirstByte(start time.Time) (time.Duration, bool) {
+	if w.firstByte.IsZero() {
+		return 0, false
+	}
+	return w.firstByte.Sub(start), true
+}
+
+func (w *statusCapturingWriter) WriteHeader(code int) {
+	w.noteFirstByte()
+	// Informational responses (other than 101 Switching Protocols) may precede
+	// the final status, so they are not recorded.
+	if !w.wroteHeader && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusCapturingWriter) Write(b []byte) (int, error) {
+	w.noteFirstByte()
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	w.writeFailed = w.writeFailed || err != nil
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusCapturingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusCapturingWriter) capturing() *statusCapturingWriter {
+	return w
+}
+
+// capturingWriter is implemented by every writer returned by captureStatus.
+type capturingWriter interface {
+	http.ResponseWriter
+	capturing() *statusCapturingWriter
+}
+
+type flusher struct{ w *statusCapturingWriter }
+
+func (f flusher) Flush() {
+	f.w.noteFirstByte()
+	f.w.wroteHeader = true
+	f.w.ResponseWriter.(http.Flusher).Flush()
+}
+
+type readerFrom struct{ w *statusCapturingWriter }
+
+func (rf readerFrom) ReadFrom(src io.Reader) (int64, error) {
+	rf.w.noteFirstByte()
+	rf.w.wroteHeader = true
+	n, err := rf.w.ResponseWriter.(io.ReaderFrom).ReadFrom(src)
+	rf.w.written += n
+	rf.w.writeFailed = rf.w.writeFailed || err != nil
+	return n, err
+}
+
+type hijacker struct{ w *statusCapturingWriter }
+
+// Hijack hands the connection over to the handler. The request is then
+// reported as 101 Switching Protocols, as it is for WebSocket upgrades.
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		if !h.w.wroteHeader {
+			h.w.status = http.StatusSwitchingProtocols
+			h.w.wroteHeader = true
+		}
+		h.w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// captureStatus wraps w so the response status can be read after the handler
+// returns. It returns the capturing state and the writer to pass on, which
+// implements http.Flusher, io.ReaderFrom and http.Hijacker only when w does.
+// A wrapper installed by an outer middleware is reused rather than wrapped
+// again.
+func captureStatus(w http.ResponseWriter) (*statusCapturingWriter, http.ResponseWriter) {
+	if cw, ok := w.(capturingWriter); ok {
+		return cw.capturing(), w
+	}
+
+	sw := &statusCapturingWriter{ResponseWriter: w, status: http.StatusOK}
+	_, canFlush := w.(http.Flusher)
+	_, canReadFrom := w.(io.ReaderFrom)
+	_, canHijack := w.(http.Hijacker)
+
+	switch {
+	case canFlush && canReadFrom && canHijack:
+		return sw, struct {
+			*statusCapturingWriter
+			flusher
+			readerFrom
+			hijacker
+		}{sw, flusher{sw}, readerFrom{sw}, hijacker{sw}}
+	case canFlush && canReadFrom:
+		return sw, struct {
+			*statusCapturingWriter
+			flusher
+			readerFrom
+		}{sw, flusher{sw}, readerFrom{sw}}
+	case canFlush && canHijack:
+		return sw, struct {
+			*statusCapturingWriter
+			flusher
+			hijacker
+		}{sw, flusher{sw}, hijacker{sw}}
+	case canReadFrom && canHijack:
+		return sw, struct {
+			*statusCapturingWriter
+			readerFrom
+			hijacker
+		}{sw, readerFrom{sw}, hijacker{sw}}
+	case canFlush:
+		return sw, struct {
+			*statusCapturingWriter
+			flusher
+		}{sw, flusher{sw}}
+	case canReadFrom:
+		return sw, struct {
+			*statusCapturingWriter
+			readerFrom
+		}{sw, readerFrom{sw}}
+	case canHijack:
+		return sw, struct {
+			*statusCapturingWriter
+			hijacker
+		}{sw, hijacker{sw}}
+	default:
+		return sw, sw
+	}
+}
@@ -0,0 +1,191 @@
+package httpx
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Neruzzz/acai-travel-challenge/internal/httpx/otelt"
+)
+
+// plainWriter implements nothing beyond http.ResponseWriter.
+type plainWriter struct {
+	header http.Header
+	status int
+	body   strings.Builder
+}
+
+func (w *plainWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = http.Header{}
+	}
+	return w.header
+}
+func (w *plainWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+func (w *plainWriter) WriteHeader(code int)        { w.status = code }
+
+// readerFromWriter additionally implements io.ReaderFrom.
+type readerFromWriter struct {
+	plainWriter
+	readFromCalls int
+}
+
+func (w *readerFromWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.readFromCalls++
+	return io.Copy(&w.body, src)
+}
+
+func TestCaptureStatus_PreservesInterfaces(t *testing.T) {
+	t.Run("plain writer", func(t *testing.T) {
+		_, w := captureStatus(&plainWriter{})
+		if _, ok := w.(http.Flusher); ok {
+			t.Error("wrapped plain writer should not implement http.Flusher")
+		}
+		if _, ok := w.(io.ReaderFrom); ok {
+			t.Error("wrapped plain writer should not implement io.ReaderFrom")
+		}
+	})
+
+	t.Run("flusher", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		sw, w := captureStatus(rec)
+
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("wrapped recorder should implement http.Flusher")
+		}
+		if _, ok := w.(io.ReaderFrom); ok {
+			t.Error("wrapped recorder should not implement io.ReaderFrom")
+		}
+		_, _ = w.Write([]byte("data: 1\n\n"))
+		f.Flush()
+		if !rec.Flushed {
+			t.Error("Flush was not forwarded")
+		}
+		if sw.status != http.StatusOK {
+			t.Errorf("status mismatch: got %d, want %d", sw.status, http.StatusOK)
+		}
+	})
+
+	t.Run("reader from", func(t *testing.T) {
+		inner := &readerFromWriter{}
+		_, w := captureStatus(inner)
+
+		rf, ok := w.(io.ReaderFrom)
+		if !ok {
+			t.Fatal("wrapped writer should implement io.ReaderFrom")
+		}
+		if _, ok := w.(http.Flusher); ok {
+			t.Error("wrapped writer should not implement http.Flusher")
+		}
+		if _, err := rf.ReadFrom(strings.NewReader("payload")); err != nil {
+			t.Fatalf("ReadFrom() unexpected error: %v", err)
+		}
+		if inner.readFromCalls != 1 || inner.body.String() != "payload" {
+			t.Errorf("ReadFrom was not forwarded: calls=%d body=%q", inner.readFromCalls, inner.body.String())
+		}
+	})
+}
+
+func TestCaptureStatus_ReusesOuterWrapper(t *testing.T) {
+	outer, w := captureStatus(httptest.NewRecorder())
+	inner, w2 := captureStatus(w)
+
+	if inner != outer {
+		t.Error("expected the outer capturing state to be reused")
+	}
+	if w2 != w {
+		t.Error("expected the outer writer to be passed through unchanged")
+	}
+}
+
+func TestCaptureStatus_IgnoresInformationalStatus(t *testing.T) {
+	sw, w := captureStatus(httptest.NewRecorder())
+	w.WriteHeader(http.StatusEarlyHints)
+	w.WriteHeader(http.StatusAccepted)
+	w.WriteHeader(http.StatusInternalServerError)
+
+	if sw.status != http.StatusAccepted {
+		t.Errorf("status mismatch: got %d, want %d", sw.status, http.StatusAccepted)
+	}
+}
+
+func TestMetricsMiddleware_StreamingHandlerCanFlush(t *testing.T) {
+	otelt.InstallMetrics(t)
+
+	rec := httptest.NewRecorder()
+	MetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("handler writer does not implement http.Flusher")
+		}
+		_, _ = w.Write([]byte("data: hello\n\n"))
+		f.Flush()
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events", nil))
+
+	if !rec.Flushed {
+		t.Error("Flush was not forwarded")
+	}
+}
+
+func TestMetricsMiddleware_Hijack(t *testing.T) {
+	reader := otelt.InstallMetrics(t)
+
+	done := make(chan struct{})
+	upgrade := MetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Error("handler writer does not implement http.Hijacker")
+			return
+		}
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			t.Errorf("Hijack() unexpected error: %v", err)
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = rw.Flush()
+	}))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		upgrade.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() unexpected error: %v", err)
+	}
+	defer conn.Close()
+	_, _ = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() unexpected error: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status mismatch: got %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	<-done
+
+	dps := collectSum(t, reader, "http.server.requests")
+	if len(dps) != 1 {
+		t.Fatalf("expected 1 series, got %d", len(dps))
+	}
+	if status, _ := dps[0].Attributes.Value("http.status_code"); status.AsInt64() != http.StatusSwitchingProtocols {
+		t.Errorf("http.status_code mismatch: got %d, want %d", status.AsInt64(), http.StatusSwitchingProtocols)
+	}
+	if hijacked, _ := dps[0].Attributes.Value("http.hijacked"); !hijacked.AsBool() {
+		t.Error("expected http.hijacked attribute")
+	}
+	for _, name := range []string{"http.server.request.duration", "http.server.duration.ms"} {
+		if hs := collectHistogram(t, reader, name); len(hs) != 0 {
+			t.Errorf("expected no %s recorded for hijacked connections, got %d points", name, len(hs))
+		}
+	}
+}